@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newADCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ad",
+		Short: "Query Active Directory users, groups and computers",
+		Long:  "Query Active Directory through the ActiveDirectory PowerShell module, which must be installed.",
+	}
+	cmd.AddCommand(
+		newADQueryCmd(g, "users", "user accounts", func(ctx context.Context, inv psbridge.Invoker, q psbridge.ADQuery) (any, []psbridge.ADObject, error) {
+			users, err := psbridge.ADUsers(ctx, inv, q)
+			objects := make([]psbridge.ADObject, len(users))
+			for i, u := range users {
+				objects[i] = u.ADObject
+			}
+			return users, objects, err
+		}),
+		newADQueryCmd(g, "groups", "groups", func(ctx context.Context, inv psbridge.Invoker, q psbridge.ADQuery) (any, []psbridge.ADObject, error) {
+			groups, err := psbridge.ADGroups(ctx, inv, q)
+			objects := make([]psbridge.ADObject, len(groups))
+			for i, gr := range groups {
+				objects[i] = gr.ADObject
+			}
+			return groups, objects, err
+		}),
+		newADQueryCmd(g, "computers", "computer accounts", func(ctx context.Context, inv psbridge.Invoker, q psbridge.ADQuery) (any, []psbridge.ADObject, error) {
+			computers, err := psbridge.ADComputers(ctx, inv, q)
+			objects := make([]psbridge.ADObject, len(computers))
+			for i, c := range computers {
+				objects[i] = c.ADObject
+			}
+			return computers, objects, err
+		}),
+	)
+	return cmd
+}
+
+// newADQueryCmd returns a command listing what query reads: the typed
+// results to print as data, and what they have in common for text
+func newADQueryCmd(g *globals, use, what string, query func(context.Context, psbridge.Invoker, psbridge.ADQuery) (any, []psbridge.ADObject, error)) *cobra.Command {
+	var q psbridge.ADQuery
+	cmd := &cobra.Command{
+		Use:   use + " [IDENTITY]",
+		Short: "List " + what,
+		Example: fmt.Sprintf(`  go-ps-lab2 ad %s --ldap-filter '(description=*)' -o text
+  go-ps-lab2 ad %s --search-base 'OU=Staff,DC=contoso,DC=com' --property whenCreated -o csv`, use, use),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				q.Identity = args[0]
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			results, objects, err := query(ctx, client, q)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd.OutOrStdout(), results, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tSAMACCOUNTNAME\tDISTINGUISHEDNAME")
+				for _, o := range objects {
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", o.Name, o.SamAccountName, o.DistinguishedName)
+				}
+				tw.Flush()
+			})
+		},
+	}
+	cmd.Flags().StringVar(&q.LDAPFilter, "ldap-filter", "", "LDAP filter, e.g. (department=Sales)")
+	cmd.Flags().StringVar(&q.Filter, "filter", "", "PowerShell expression filter, e.g. \"Name -like 'srv*'\"")
+	cmd.Flags().StringVar(&q.SearchBase, "search-base", "", "distinguished name to search under (default: the domain)")
+	cmd.Flags().StringVar(&q.Server, "server", "", "domain or domain controller to ask")
+	cmd.Flags().StringSliceVar(&q.Properties, "property", nil, `more attributes to read ("*" for all)`)
+	cmd.Flags().IntVar(&q.Max, "max", 0, "most objects to read (0: all)")
+	return cmd
+}
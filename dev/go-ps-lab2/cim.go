@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newCimCmd(g *globals) *cobra.Command {
+	var req psbridge.CimRequest
+	cmd := &cobra.Command{
+		Use:   "cim CLASS",
+		Short: "Read CIM instances, such as Win32_BIOS or Win32_LogicalDisk",
+		Example: `  go-ps-lab2 cim Win32_OperatingSystem --property Caption,Version -o text
+  go-ps-lab2 cim Win32_LogicalDisk --filter "DriveType = 3" -o csv`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.Class = args[0]
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			instances, err := psbridge.CimInstances[map[string]any](ctx, client, req)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd.OutOrStdout(), instances, func(w io.Writer) {
+				for i, inst := range instances {
+					if i > 0 {
+						fmt.Fprintln(w)
+					}
+					names := make([]string, 0, len(inst))
+					for name := range inst {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+					for _, name := range names {
+						fmt.Fprintf(tw, "%s\t%v\n", name, inst[name])
+					}
+					tw.Flush()
+				}
+			})
+		},
+	}
+	cmd.Flags().StringVar(&req.Filter, "filter", "", "WQL condition instances must meet")
+	cmd.Flags().StringVar(&req.Namespace, "namespace", "", `CIM namespace (default root\cimv2)`)
+	cmd.Flags().StringSliceVar(&req.Properties, "property", nil, "properties to read (default: all)")
+	return cmd
+}
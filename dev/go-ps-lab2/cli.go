@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/config"
+	"example.com/go-ps-lab2/psbridge/format"
+	"example.com/go-ps-lab2/psbridge/query"
+	"github.com/spf13/cobra"
+)
+
+// outputText is the --output format for people, the rest are encoders
+// from the format package
+const outputText = "text"
+
+// globals are the flags every command shares
+type globals struct {
+	shell   string
+	script  string
+	output  string
+	query   *query.Query
+	timeout time.Duration
+	verbose bool
+	payload int
+	// encoding is --console-encoding
+	encoding  psbridge.OutputEncoding
+	sentinels bool
+	// jsonDepth and checkTruncation are --json-depth and
+	// --check-truncation
+	jsonDepth       int
+	checkTruncation bool
+	// scriptsDir is --scripts-dir, and noIntegrity --no-integrity
+	scriptsDir  string
+	noIntegrity bool
+	// cfg is the config file, which the flags override
+	cfg *config.Config
+
+	// cleanup removes the extracted bundle, if client made one
+	cleanup func()
+}
+
+// close releases what the command left behind
+func (g *globals) close() {
+	if g.cleanup != nil {
+		g.cleanup()
+	}
+}
+
+// newRootCmd builds the command tree around g
+func newRootCmd(g *globals) *cobra.Command {
+	var expr, encoding, configPath, target, endpoint string
+	var signers []string
+	root := &cobra.Command{
+		Use:   "go-ps-lab2",
+		Short: "Run PowerShell operations over the psbridge JSON protocol",
+		// main reports errors, and a failed call isn't a usage problem
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := g.loadConfig(cmd, configPath, target, endpoint, signers, &encoding); err != nil {
+				return err
+			}
+			if _, ok := format.Lookup(g.output); !ok && g.output != outputText {
+				return fmt.Errorf("unknown --output %q: want %s or %s", g.output, outputText, strings.Join(format.Names(), ", "))
+			}
+			if expr != "" {
+				q, err := query.Compile(expr)
+				if err != nil {
+					return fmt.Errorf("bad --query: %w", err)
+				}
+				g.query = q
+			}
+			for _, e := range []psbridge.OutputEncoding{psbridge.EncodingAuto, psbridge.EncodingUTF8, psbridge.EncodingUTF16} {
+				if encoding == e.String() {
+					g.encoding = e
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown --console-encoding %q: want auto, utf8 or utf16", encoding)
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&configPath, "config", "", "config file (default: $"+config.PathEnv+" or config.yaml in the user config dir)")
+	flags.StringVar(&target, "target", "", "run on this host from the config file's targets")
+	flags.StringVar(&endpoint, "endpoint", "", "run operations in this session configuration, such as a JEA endpoint")
+	flags.StringSliceVar(&signers, "signer", nil, "refuse scripts not Authenticode-signed by the certificate with this thumbprint (repeatable)")
+	flags.StringVar(&g.scriptsDir, "scripts-dir", "", "run the bundled scripts deployed in this directory (default: scripts next to the binary, if there)")
+	flags.BoolVar(&g.noIntegrity, "no-integrity", false, "run deployed scripts that don't match the binary's checksums, for development")
+	flags.StringVar(&g.shell, "shell", "", "PowerShell executable (default: discovered)")
+	flags.StringVar(&g.script, "script", "", "script to run (default: the bundled json_echo.ps1)")
+	flags.StringVarP(&g.output, "output", "o", format.JSON, "output format: json, text, csv, yaml or toml")
+	flags.StringVarP(&expr, "query", "q", "", "JMESPath expression selecting what to print, e.g. data[].Name")
+	flags.DurationVar(&g.timeout, "timeout", 0, "give up on each call after this long (0: no limit)")
+	flags.StringVar(&encoding, "console-encoding", psbridge.EncodingAuto.String(), "encoding PowerShell writes its output in: auto (detect), utf8 or utf16")
+	flags.BoolVar(&g.sentinels, "sentinels", false, "mark protocol messages on stdout, for profiles or modules that print to it")
+	flags.IntVar(&g.jsonDepth, "json-depth", 0, "levels of each result PowerShell writes, up to 99 (0: the script's default)")
+	flags.BoolVar(&g.checkTruncation, "check-truncation", false, "fail results that look cut off at the JSON depth")
+	flags.BoolVarP(&g.verbose, "verbose", "v", false, "log protocol traffic and processes to stderr")
+	flags.IntVar(&g.payload, "log-payload", 256, "bytes of each payload to log with -v (-1: all)")
+
+	root.AddCommand(
+		newRunCmd(g),
+		newCmdletCmd(g),
+		newModulesCmd(g),
+		newSessionCmd(g),
+		newServeCmd(g),
+		newPluginCmd(g),
+		newSnapshotCmd(g),
+		newServicesCmd(g),
+		newProcessesCmd(g),
+		newCimCmd(g),
+		newADCmd(g),
+		newProvidersCmd(g),
+		newREPLCmd(g),
+		newTUICmd(g),
+		newGenCmd(g),
+	)
+	return root
+}
+
+// client returns a client for --script, or for the bundled script
+// extracted to a temporary directory removed when the command ends
+func (g *globals) client() (*psbridge.Client, error) {
+	var client *psbridge.Client
+	if g.script != "" {
+		client = psbridge.NewClient(g.script)
+	} else {
+		bundle, err := g.bundle()
+		if err != nil {
+			return nil, err
+		}
+		g.cleanup = func() { bundle.Close() }
+		client = bundle.Client("json_echo.ps1")
+	}
+	if err := g.configure(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// bundle returns the bundled scripts: those deployed in --scripts-dir or
+// next to the binary, checked against the ones it was built with unless
+// --no-integrity, or else copies extracted to a temporary directory
+func (g *globals) bundle() (*psbridge.Bundle, error) {
+	dir := g.scriptsDir
+	if dir == "" {
+		dir = deployedScripts()
+	}
+	if dir == "" {
+		return psbridge.Extract(psbridge.Scripts())
+	}
+	sums := psbridge.BundledChecksums()
+	if g.noIntegrity {
+		sums = nil
+	}
+	return psbridge.Deployed(dir, sums)
+}
+
+// deployedScripts is the scripts directory beside the executable, if the
+// bundled scripts were deployed there
+func deployedScripts() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Join(filepath.Dir(exe), "scripts")
+	if _, err := os.Stat(filepath.Join(dir, "json_echo.ps1")); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// configure applies the config file, then --shell, --console-encoding,
+// --sentinels, --json-depth, --check-truncation and --verbose, to client
+func (g *globals) configure(client *psbridge.Client) error {
+	if err := g.cfg.Apply(client); err != nil {
+		return err
+	}
+	if g.shell != "" {
+		client.Shell = g.shell
+	}
+	client.Encoding = g.encoding
+	client.Sentinels = g.sentinels
+	client.JSONDepth = g.jsonDepth
+	client.CheckTruncation = g.checkTruncation
+	if g.verbose {
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		client.Logger = psbridge.NewSlogLogger(slog.New(handler))
+		client.LogPayload = g.payload
+	}
+	return nil
+}
+
+// loadConfig reads the config file, --config or the default one, and
+// fills in the flags not given from it; encoding is --console-encoding
+func (g *globals) loadConfig(cmd *cobra.Command, path, target, endpoint string, signers []string, encoding *string) error {
+	var err error
+	if path != "" {
+		g.cfg, err = config.Load(path)
+	} else {
+		g.cfg, err = config.LoadDefault()
+	}
+	if err != nil {
+		return err
+	}
+	flags := cmd.Flags()
+	if flags.Changed("target") {
+		g.cfg.Target = target
+	}
+	if flags.Changed("endpoint") {
+		if g.cfg.Endpoint == nil {
+			g.cfg.Endpoint = &config.Endpoint{}
+		}
+		g.cfg.Endpoint.Name = endpoint
+	}
+	if flags.Changed("signer") {
+		g.cfg.Signers = signers
+	}
+	if !flags.Changed("script") {
+		g.script = g.cfg.Script
+	}
+	if !flags.Changed("timeout") {
+		g.timeout = time.Duration(g.cfg.Timeout)
+	}
+	if !flags.Changed("console-encoding") && g.cfg.Encoding != "" {
+		*encoding = g.cfg.Encoding
+	}
+	if !flags.Changed("sentinels") {
+		g.sentinels = g.cfg.Sentinels
+	}
+	if !flags.Changed("json-depth") {
+		g.jsonDepth = g.cfg.JSONDepth
+	}
+	if !flags.Changed("check-truncation") {
+		g.checkTruncation = g.cfg.CheckTruncation
+	}
+	return nil
+}
+
+// poolConfig is the config file's pool, its size --sessions if that was
+// given or the file has none
+func (g *globals) poolConfig(cmd *cobra.Command, sessions int) psbridge.PoolConfig {
+	pc := g.cfg.PoolConfig()
+	if pc.Max == 0 || cmd.Flags().Changed("sessions") {
+		pc.Max = sessions
+	}
+	return pc
+}
+
+// context bounds one call by --timeout and ends it on Ctrl+C. PowerShell
+// runs in its own process group, so the interrupt has to be passed on by
+// cancelling.
+func (g *globals) context() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if g.timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, g.timeout)
+		return ctx, func() { cancel(); stop() }
+	}
+	return ctx, stop
+}
+
+// print writes v with the --output encoder, one line of JSON by default,
+// or calls text for --output text. With --query only what it selects from
+// v is printed, and as text that is a line per value.
+func (g *globals) print(w io.Writer, v any, text func(io.Writer)) error {
+	if g.query != nil {
+		selected, err := g.query.Apply(v)
+		if err != nil {
+			return err
+		}
+		v, text = selected, func(w io.Writer) { printSelected(w, selected) }
+	}
+	if g.output == outputText {
+		text(w)
+		return nil
+	}
+	enc, ok := format.Lookup(g.output)
+	if !ok {
+		return fmt.Errorf("unknown --output %q", g.output)
+	}
+	return enc.Encode(w, v)
+}
+
+// printSelected writes what --query selected for --output text: strings
+// and numbers as they are, a line each for a list of them, and JSON for
+// anything else
+func printSelected(w io.Writer, v any) {
+	list, ok := v.([]any)
+	if !ok {
+		list = []any{v}
+	}
+	for _, e := range list {
+		switch e := e.(type) {
+		case nil:
+		case string:
+			fmt.Fprintln(w, e)
+		case float64, bool:
+			fmt.Fprintln(w, e)
+		default:
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "%s\n", b)
+		}
+	}
+}
+
+// result is a call's outcome in machine-readable form
+type result struct {
+	Op   string          `json:"op,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Error is a PowerShell error with all its details, or any other error
+	// as just a message
+	Error   *psbridge.PSError `json:"error,omitempty"`
+	Streams *streamsOutput    `json:"streams,omitempty"`
+	TookMs  float64           `json:"tookMs,omitempty"`
+}
+
+// streamsOutput is psbridge.Streams with JSON names
+type streamsOutput struct {
+	Verbose     []string            `json:"verbose,omitempty"`
+	Warning     []string            `json:"warning,omitempty"`
+	Debug       []string            `json:"debug,omitempty"`
+	Information []string            `json:"information,omitempty"`
+	Errors      []*psbridge.PSError `json:"errors,omitempty"`
+}
+
+// millis is d in fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// newResult converts what Do returned for op
+func newResult(op string, res *psbridge.Result, err error) *result {
+	out := &result{Op: op}
+	if err != nil {
+		out.Error = errorOutput(err)
+		return out
+	}
+
+	// A large result spilled to disk, leaving Data empty
+	data, err := res.Bytes()
+	if err != nil {
+		out.Error = errorOutput(err)
+		return out
+	}
+	out.Data = data
+	if len(out.Data) == 0 {
+		out.Data = json.RawMessage("null")
+	}
+	s := res.Streams
+	if len(s.Verbose)+len(s.Warning)+len(s.Debug)+len(s.Information)+len(s.Errors) > 0 {
+		out.Streams = &streamsOutput{
+			Verbose:     s.Verbose,
+			Warning:     s.Warning,
+			Debug:       s.Debug,
+			Information: s.Information,
+			Errors:      s.Errors,
+		}
+	}
+	return out
+}
+
+// errorOutput is err as it goes in a result
+func errorOutput(err error) *psbridge.PSError {
+	var psErr *psbridge.PSError
+	if errors.As(err, &psErr) {
+		return psErr
+	}
+	return &psbridge.PSError{Message: err.Error()}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newCmdletCmd(g *globals) *cobra.Command {
+	var params, selected []string
+	var depth int
+	cmd := &cobra.Command{
+		Use:   "cmdlet NAME",
+		Short: "Run one cmdlet with splatted parameters",
+		Long: `Run a single cmdlet or function, without a script of its own, and print its
+output objects. Each --param is NAME=VALUE, where VALUE is read as JSON if it
+parses and as a string otherwise; a bare NAME passes a switch.`,
+		Example: `  go-ps-lab2 cmdlet Get-Service -p Name=WinRM --select Name,Status
+  go-ps-lab2 cmdlet Get-ChildItem -p Path=C:\Windows -p Directory`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			call := psbridge.Cmdlet{Name: args[0], Select: selected, Depth: depth}
+			if len(params) > 0 {
+				call.Parameters = map[string]any{}
+			}
+			for _, p := range params {
+				call.Parameters[parseParam(p)] = paramValue(p)
+			}
+			if err := call.Validate(); err != nil {
+				return err
+			}
+			payload, err := json.Marshal(call)
+			if err != nil {
+				return err
+			}
+
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			start := time.Now()
+			res, err := client.Do(ctx, &psbridge.Call{Op: psbridge.CmdletOp, Data: payload})
+			return g.printResult(cmd.OutOrStdout(), call.Name, res, err, time.Since(start))
+		},
+	}
+	cmd.Flags().StringArrayVarP(&params, "param", "p", nil, "parameter as NAME=VALUE, or NAME for a switch (repeatable)")
+	cmd.Flags().StringSliceVar(&selected, "select", nil, "properties to keep from each output object")
+	cmd.Flags().IntVar(&depth, "depth", 0, "levels of each output object to keep (default 2)")
+	return cmd
+}
+
+// parseParam returns the name of a --param
+func parseParam(p string) string {
+	name, _, _ := strings.Cut(p, "=")
+	return name
+}
+
+// paramValue returns the value of a --param: JSON if it parses, the text
+// otherwise, and true for a bare switch
+func paramValue(p string) any {
+	_, text, ok := strings.Cut(p, "=")
+	if !ok {
+		return true
+	}
+	var v any
+	if err := json.Unmarshal([]byte(text), &v); err == nil {
+		return v
+	}
+	return text
+}
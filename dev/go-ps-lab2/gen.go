@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/gen"
+	"github.com/spf13/cobra"
+)
+
+func newGenCmd(g *globals) *cobra.Command {
+	var pkg, out, sample, data, name string
+	cmd := &cobra.Command{
+		Use:   "gen [script.ps1]",
+		Short: "Generate Go types for a script's classes or an operation's result",
+		Long: `Generate Go structs with json tags, either from the classes, enums and
+[OutputType()] attributes of a script, which is parsed but not run, or from
+the result of running one operation with --sample.`,
+		Example: `  go-ps-lab2 gen scripts/inventory.ps1 --package inventory -w types_gen.go
+  go-ps-lab2 gen --sample providers --name Provider`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				f   *gen.File
+				err error
+			)
+			switch {
+			case len(args) == 1 && sample != "":
+				return errors.New("give a script or --sample, not both")
+			case len(args) == 1:
+				f, err = g.genScript(args[0], pkg)
+			case sample != "":
+				f, err = g.genSample(sample, data, name, pkg)
+			default:
+				return errors.New("give a script to inspect or --sample OPERATION")
+			}
+			if err != nil {
+				return err
+			}
+
+			src, err := f.Bytes()
+			if err != nil {
+				return err
+			}
+			return writeGenerated(cmd.OutOrStdout(), out, src)
+		},
+	}
+	cmd.Flags().StringVar(&pkg, "package", "main", "package of the generated file")
+	cmd.PersistentFlags().StringVarP(&out, "write", "w", "", "file to write (default: stdout)")
+	cmd.Flags().StringVar(&sample, "sample", "", "infer types from the result of this operation")
+	cmd.Flags().StringVarP(&data, "data", "d", "{}", "JSON payload for --sample")
+	cmd.Flags().StringVar(&name, "name", "", "top-level type for --sample (default: <Operation>Result)")
+	cmd.AddCommand(newGenParamsCmd(&out))
+	return cmd
+}
+
+func newGenParamsCmd(out *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "params FILE.go [TYPE...]",
+		Short: "Generate PowerShell param() blocks from Go request structs",
+		Long: `Generate a ConvertFrom-<Type> function for each named struct in a Go file,
+or each struct ending in Request, that binds request data to a param() block
+with the struct's types and validation and returns it for splatting.`,
+		Example: `  go-ps-lab2 gen params requests.go EchoRequest -w requests_gen.ps1`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := gen.ParamsFromGo(args[0], src, args[1:]...)
+			if err != nil {
+				return err
+			}
+			return writeGenerated(cmd.OutOrStdout(), *out, f.Bytes())
+		},
+	}
+}
+
+// writeGenerated writes src to file, or to w without one
+func writeGenerated(w io.Writer, file string, src []byte) error {
+	if file == "" {
+		_, err := w.Write(src)
+		return err
+	}
+	return os.WriteFile(file, src, 0o644)
+}
+
+// genScript generates types from the declarations in script
+func (g *globals) genScript(script, pkg string) (*gen.File, error) {
+	bundle, err := g.bundle()
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+	inspector := bundle.Client("inspect_types.ps1")
+	if err := g.configure(inspector); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := g.context()
+	defer cancel()
+	s, err := gen.Inspect(ctx, inspector, script)
+	if err != nil {
+		return nil, err
+	}
+	return gen.FromScript(pkg, script, s), nil
+}
+
+// genSample runs op once and generates types its result decodes into
+func (g *globals) genSample(op, data, name, pkg string) (*gen.File, error) {
+	if !json.Valid([]byte(data)) {
+		return nil, errors.New("payload is not valid JSON")
+	}
+	if name == "" {
+		name = gen.GoName(op) + "Result"
+	}
+
+	client, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.context()
+	defer cancel()
+	res, err := client.Do(ctx, &psbridge.Call{Op: op, Data: json.RawMessage(data)})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	sample, err := res.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return gen.FromSample(pkg, "a sample of "+op, name, sample)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newModulesCmd(g *globals) *cobra.Command {
+	var install bool
+	var opts psbridge.InstallOptions
+	cmd := &cobra.Command{
+		Use:   "modules NAME[@MINVERSION]...",
+		Short: "Check that PowerShell modules are installed",
+		Long: `Look each module up with Get-Module -ListAvailable and report the newest
+version installed. Fails if any is missing or older than its minimum version,
+unless --install gets it from a repository with Install-Module -Scope CurrentUser.`,
+		Example: `  go-ps-lab2 modules Pester@5.0 Microsoft.PowerShell.Archive
+  go-ps-lab2 modules Pester@5.0 --install --trust`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reqs := make([]psbridge.ModuleRequirement, len(args))
+			for i, arg := range args {
+				name, version, _ := strings.Cut(arg, "@")
+				reqs[i] = psbridge.ModuleRequirement{Name: name, MinimumVersion: version}
+			}
+
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			var report *psbridge.ModuleReport
+			if install {
+				report, err = psbridge.InstallModules(ctx, client, reqs, opts)
+			} else {
+				report, err = psbridge.EnsureModules(ctx, client, reqs)
+			}
+			var modErr *psbridge.ModuleError
+			if err != nil && !errors.As(err, &modErr) {
+				return err
+			}
+
+			perr := g.print(cmd.OutOrStdout(), report.Modules, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tMINIMUM\tINSTALLED\tOK")
+				for _, m := range report.Modules {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", m.Name, m.MinimumVersion, m.Version, m.Satisfied)
+				}
+				tw.Flush()
+			})
+			if perr != nil {
+				return perr
+			}
+			return err
+		},
+	}
+	cmd.Flags().BoolVar(&install, "install", false, "install missing or outdated modules for the current user")
+	cmd.Flags().StringVar(&opts.Repository, "repository", "", "repository to install from (default PSGallery)")
+	cmd.Flags().StringVar(&opts.SourceLocation, "source", "", "URL to register --repository at if it isn't registered")
+	cmd.Flags().BoolVar(&opts.Trust, "trust", false, "install from the repository even though it isn't trusted")
+	return cmd
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"example.com/go-ps-lab2/psbridge/goplugin"
+	"github.com/spf13/cobra"
+)
+
+func newPluginCmd(g *globals) *cobra.Command {
+	var sessions int
+	cmd := &cobra.Command{
+		Use:   "plugin",
+		Short: "Serve operations as a go-plugin plugin, for a host application to start",
+		Long: `Run as a plugin of a host application using HashiCorp's go-plugin: the
+host starts this command, with --script naming what the plugin serves, and
+calls the plugin's operations over gRPC until it unloads it. See
+psbridge/goplugin for the host's side.
+
+Started by hand, without the host's handshake cookie in the environment,
+it refuses to run.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			return goplugin.Serve(goplugin.Config{
+				Client:  client,
+				Pool:    g.poolConfig(cmd, sessions),
+				Timeout: g.timeout,
+			})
+		},
+	}
+	cmd.Flags().IntVar(&sessions, "sessions", 2, "most sessions to run at once")
+	return cmd
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newProcessesCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "processes [NAME...]",
+		Short: "List running processes",
+		Example: `  go-ps-lab2 processes 'pwsh*' -o text
+  go-ps-lab2 processes --query 'max_by(@, &workingSet).name'`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			processes, err := psbridge.Processes(ctx, client, args)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd.OutOrStdout(), processes, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "PID\tNAME\tCPU(S)\tWS(MB)\tOWNER")
+				for _, p := range processes {
+					fmt.Fprintf(tw, "%d\t%s\t%.1f\t%.1f\t%s\n", p.PID, p.Name, p.CPU, float64(p.WorkingSet)/(1<<20), p.Owner)
+				}
+				tw.Flush()
+			})
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "kill PID",
+		Short: "Stop a process at once",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pid, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("bad PID %q", args[0])
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			return psbridge.Kill(ctx, client, pid)
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newProvidersCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "providers",
+		Short: "List PowerShell providers and their drives",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := g.context()
+			defer cancel()
+			providers, err := psbridge.Providers(ctx, client)
+			if err != nil {
+				return err
+			}
+
+			return g.print(cmd.OutOrStdout(), providers, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tDRIVES\tCAPABILITIES")
+				for _, p := range providers {
+					drives := make([]string, len(p.Drives))
+					for i, d := range p.Drives {
+						drives[i] = d.Name
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Name, strings.Join(drives, ","), strings.Join(p.Capabilities, ", "))
+				}
+				tw.Flush()
+			})
+		},
+	}
+	cmd.AddCommand(newProvidersLsCmd(g))
+	return cmd
+}
+
+func newProvidersLsCmd(g *globals) *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "ls PATH",
+		Short: "List the items under a provider path",
+		Example: `  go-ps-lab2 providers ls Env:
+  go-ps-lab2 providers ls HKCU:\Software -o text`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := g.context()
+			defer cancel()
+			items, err := psbridge.ChildItems(ctx, client, args[0], force)
+			if err != nil {
+				return err
+			}
+
+			return g.print(cmd.OutOrStdout(), items, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "TYPE\tNAME\tPATH")
+				for _, it := range items {
+					kind := "item"
+					if it.IsContainer {
+						kind = "container"
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", kind, it.Name, it.Path)
+				}
+				tw.Flush()
+			})
+		},
+	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "include hidden items")
+	return cmd
+}
@@ -0,0 +1,147 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ADOp is the operation the shim queries Active Directory with
+const ADOp = "ad"
+
+// ADModule is the module ADOp needs, part of RSAT on clients and of the
+// AD DS tools on servers. Pass it to WithRequiredModules for sessions to
+// fail up front with *ModuleError where it is missing, rather than each
+// query failing.
+var ADModule = ModuleRequirement{Name: "ActiveDirectory"}
+
+// ADQuery chooses the directory objects to read. Identity, LDAPFilter and
+// Filter take each other's place; with none of them every object of the
+// class is read.
+type ADQuery struct {
+	// Identity is one object's distinguished name, GUID, SID or
+	// sAMAccountName
+	Identity string `json:"identity,omitempty"`
+	// LDAPFilter is an RFC 4515 filter, e.g. (department=Sales)
+	LDAPFilter string `json:"ldapFilter,omitempty"`
+	// Filter is a PowerShell expression filter, e.g. Name -like 'srv*'
+	Filter string `json:"filter,omitempty"`
+	// SearchBase is the distinguished name to search under, default the
+	// domain's root
+	SearchBase string `json:"searchBase,omitempty"`
+	// SearchScope is Base, OneLevel or Subtree, the default
+	SearchScope string `json:"searchScope,omitempty"`
+	// Server is the domain or domain controller to ask, default the
+	// caller's
+	Server string `json:"server,omitempty"`
+	// Properties are more attributes to read, by LDAP display name, into
+	// Properties; "*" reads all of them
+	Properties []string `json:"properties,omitempty"`
+	// Max caps the objects read; 0 reads all of them
+	Max int `json:"max,omitempty"`
+	// Credential, if set, is who to query as
+	Credential *Credential `json:"credential,omitempty"`
+}
+
+// Validate reports a query Get-ADObject can't run
+func (q ADQuery) Validate() error {
+	set := 0
+	for _, s := range []string{q.Identity, q.LDAPFilter, q.Filter} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("psbridge: an AD query takes one of an identity, an LDAP filter or a filter")
+	}
+	if q.Identity != "" && q.Max != 0 {
+		return errors.New("psbridge: an AD identity query can't have a maximum")
+	}
+	return nil
+}
+
+// ADObject is what every directory object has
+type ADObject struct {
+	DistinguishedName string `json:"distinguishedName"`
+	Name              string `json:"name"`
+	ObjectClass       string `json:"objectClass"`
+	ObjectGUID        string `json:"objectGuid"`
+	SID               string `json:"sid,omitempty"`
+	SamAccountName    string `json:"samAccountName,omitempty"`
+	// Properties are the ADQuery.Properties read, by the names asked
+	// for: strings and numbers as they are, dates as RFC 3339 strings,
+	// multi-valued attributes as lists, binary as base64 and anything else
+	// as its string form
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// ADUser is a user account
+type ADUser struct {
+	ADObject
+	UserPrincipalName string    `json:"userPrincipalName,omitempty"`
+	GivenName         string    `json:"givenName,omitempty"`
+	Surname           string    `json:"surname,omitempty"`
+	Mail              string    `json:"mail,omitempty"`
+	Description       string    `json:"description,omitempty"`
+	Enabled           bool      `json:"enabled"`
+	LockedOut         bool      `json:"lockedOut"`
+	PasswordLastSet   time.Time `json:"passwordLastSet,omitzero"`
+	// LastLogon is replicated lazily, so may be up to two weeks behind
+	LastLogon time.Time `json:"lastLogon,omitzero"`
+	// MemberOf are the distinguished names of the groups the user is
+	// directly in, not counting their primary group
+	MemberOf []string `json:"memberOf"`
+}
+
+// ADGroup is a security or distribution group
+type ADGroup struct {
+	ADObject
+	Description string `json:"description,omitempty"`
+	// Scope is DomainLocal, Global or Universal
+	Scope string `json:"scope"`
+	// Category is Security or Distribution
+	Category string `json:"category"`
+	// Members are the distinguished names of the group's direct members
+	Members  []string `json:"members"`
+	MemberOf []string `json:"memberOf"`
+}
+
+// ADComputer is a computer account
+type ADComputer struct {
+	ADObject
+	DNSHostName            string    `json:"dnsHostName,omitempty"`
+	OperatingSystem        string    `json:"operatingSystem,omitempty"`
+	OperatingSystemVersion string    `json:"operatingSystemVersion,omitempty"`
+	Description            string    `json:"description,omitempty"`
+	Enabled                bool      `json:"enabled"`
+	LastLogon              time.Time `json:"lastLogon,omitzero"`
+}
+
+type adRequest struct {
+	// Class is user, group or computer
+	Class string `json:"class"`
+	ADQuery
+}
+
+func queryAD[T any](ctx context.Context, inv Invoker, class string, q ADQuery, opts []CallOption) ([]T, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return InvokeContext[adRequest, []T](ctx, inv, ADOp, adRequest{Class: class, ADQuery: q}, opts...)
+}
+
+// ADUsers reads the user accounts q matches, with Get-ADUser. An identity
+// that matches nothing fails with an ObjectNotFound *PSError.
+func ADUsers(ctx context.Context, inv Invoker, q ADQuery, opts ...CallOption) ([]ADUser, error) {
+	return queryAD[ADUser](ctx, inv, "user", q, opts)
+}
+
+// ADGroups reads the groups q matches, with Get-ADGroup
+func ADGroups(ctx context.Context, inv Invoker, q ADQuery, opts ...CallOption) ([]ADGroup, error) {
+	return queryAD[ADGroup](ctx, inv, "group", q, opts)
+}
+
+// ADComputers reads the computer accounts q matches, with Get-ADComputer
+func ADComputers(ctx context.Context, inv Invoker, q ADQuery, opts ...CallOption) ([]ADComputer, error) {
+	return queryAD[ADComputer](ctx, inv, "computer", q, opts)
+}
@@ -0,0 +1,20 @@
+package psbridge
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setAffinity limits pid to cpus
+func setAffinity(pid int, cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= int(unsafe.Sizeof(set))*8 {
+			return fmt.Errorf("no CPU %d", cpu)
+		}
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(pid, &set)
+}
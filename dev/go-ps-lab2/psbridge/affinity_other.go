@@ -0,0 +1,11 @@
+//go:build unix && !linux
+
+package psbridge
+
+import "errors"
+
+// setAffinity isn't possible here, where processes can't be pinned to
+// CPUs
+func setAffinity(pid int, cpus []int) error {
+	return errors.ErrUnsupported
+}
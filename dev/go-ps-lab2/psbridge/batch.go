@@ -0,0 +1,84 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// BatchOp is the operation the shim serves batches with: its data is a
+// list of {op, data} requests, its result a {data} or {error} per request
+const BatchOp = "batch"
+
+type batchRequest struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+type batchReply struct {
+	Data  json.RawMessage `json:"data"`
+	Error *PSError        `json:"error"`
+}
+
+// BatchResult is the outcome of one request in a batch
+type BatchResult[T any] struct {
+	Value T
+	// Err is the request's own failure, usually a *PSError; the others in
+	// the batch still ran
+	Err error
+}
+
+// InvokeBatch sends every request in reqs to op in a single message, so a
+// Client starts one process for all of them, and returns their results in
+// the same order. The error is for the batch as a whole, such as the
+// process dying; failed requests have their own Err.
+func InvokeBatch[TReq, TResp any](ctx context.Context, inv Invoker, op string, reqs []TReq, opts ...CallOption) ([]BatchResult[TResp], error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	batch := make([]batchRequest, len(reqs))
+	for i, req := range reqs {
+		data, err := MarshalPS(req)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request %d: %w", i, err)
+		}
+		batch[i] = batchRequest{Op: op, Data: data}
+	}
+
+	secrets := SecretKeys(reflect.TypeFor[TReq]())
+	secrets = append(secrets, SecretKeys(reflect.TypeFor[TResp]())...)
+	opts = append(opts[:len(opts):len(opts)], WithSecrets(secrets...))
+
+	res, err := invokeResult[[]batchRequest, []batchReply](ctx, inv, BatchOp, batch, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Each reply's data is decoded on its own, with its binary values
+	// still marked
+	var replies []batchReply
+	data, err := res.Bytes()
+	res.Close()
+	if err == nil {
+		err = json.Unmarshal(data, &replies)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	if len(replies) != len(reqs) {
+		return nil, fmt.Errorf("psbridge: batch of %d requests got %d replies", len(reqs), len(replies))
+	}
+
+	results := make([]BatchResult[TResp], len(reqs))
+	for i, reply := range replies {
+		switch {
+		case reply.Error != nil:
+			results[i].Err = reply.Error
+		case len(reply.Data) > 0:
+			if err := decodeJSON(reply.Data, &results[i].Value, res.numbers); err != nil {
+				results[i].Err = fmt.Errorf("unmarshal response: %w", err)
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,90 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Bytes is binary data, such as file contents. It travels base64-encoded
+// with a type marker, so the script gets a byte[] rather than a string.
+// A byte[] in a script's result comes back in the same form and decodes
+// into Bytes, or into a plain []byte unless the result spilled to disk.
+//
+// Operations must return a byte[] with a leading comma, or inside another
+// object, or PowerShell unrolls it into single bytes.
+type Bytes []byte
+
+type bytesJSON struct {
+	Type   string `json:"$psbridge"`
+	Base64 string `json:"base64"`
+}
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(bytesJSON{Type: "bytes", Base64: base64.StdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON accepts the marked form, a base64 string as encoding/json
+// writes []byte, or an array of numbers as ConvertTo-Json writes byte[]
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	switch data = bytes.TrimSpace(data); {
+	case string(data) == "null":
+		*b = nil
+		return nil
+	case len(data) > 0 && (data[0] == '"' || data[0] == '['):
+		return json.Unmarshal(data, (*[]byte)(b))
+	}
+	var v bytesJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Type != "bytes" {
+		return fmt.Errorf("psbridge: decode bytes: got a %q value", v.Type)
+	}
+	raw, err := base64.StdEncoding.DecodeString(v.Base64)
+	if err != nil {
+		return fmt.Errorf("psbridge: decode bytes: %w", err)
+	}
+	*b = raw
+	return nil
+}
+
+func (b Bytes) psLiteral() string {
+	return "([Convert]::FromBase64String(" + quotePS(base64.StdEncoding.EncodeToString(b)) + "))"
+}
+
+// unwrapBytes turns marked binary values in data into the base64 strings
+// encoding/json expects for []byte, which Bytes accepts too
+func unwrapBytes(data []byte) ([]byte, error) {
+	if !hasTyped(data, "bytes") {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(unwrapValue(v))
+}
+
+func unwrapValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if encoded, ok := v["base64"].(string); ok && v[typeKey] == "bytes" {
+			return encoded
+		}
+		for k, item := range v {
+			v[k] = unwrapValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = unwrapValue(item)
+		}
+	}
+	return v
+}
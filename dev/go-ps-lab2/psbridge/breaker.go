@@ -0,0 +1,205 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a Breaker failing calls fast
+var ErrCircuitOpen = errors.New("psbridge: circuit open")
+
+// BreakerState is where a Breaker's circuit is
+type BreakerState int
+
+const (
+	// BreakerClosed lets calls through, counting failures
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails calls at once
+	BreakerOpen
+	// BreakerHalfOpen lets trial calls through to see whether the backend
+	// is back
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerConfig sets when a Breaker opens and closes. The zero value of
+// each field picks the default noted on it.
+type BreakerConfig struct {
+	// Failures in a row open the circuit; default 5
+	Failures int
+	// OpenFor is how long the circuit stays open before trial calls go
+	// through; default 30s
+	OpenFor time.Duration
+	// Trials are let through half-open, one at a time, and all have to
+	// succeed to close the circuit; any failing opens it again. Default 1.
+	Trials int
+	// Failed decides which errors count against the backend; default
+	// DefaultBreakerFailure
+	Failed func(error) bool
+	// OnStateChange, if set, is called with each change of state. It runs
+	// with the breaker locked, so it mustn't call it.
+	OnStateChange func(from, to BreakerState)
+}
+
+// DefaultBreakerFailure counts what says the backend itself is in trouble:
+// PowerShell not starting or dying, and calls running out of time. Errors
+// the script reported (*PSError), calls their callers canceled and calls
+// a RateLimiter turned away don't count, the backend having been fine for
+// them.
+func DefaultBreakerFailure(err error) bool {
+	var psErr *PSError
+	var timeout *TimeoutError
+	switch {
+	case errors.As(err, &psErr), errors.Is(err, ErrUnknownOperation), errors.Is(err, ErrRateLimited):
+		return false
+	case errors.As(err, &timeout):
+		return timeout.Timeout()
+	case errors.Is(err, context.Canceled):
+		return false
+	}
+	return true
+}
+
+// Breaker is an Invoker that stops calling a backend that keeps failing, so
+// callers fail fast with ErrCircuitOpen rather than queuing for processes
+// that won't start. Wrap each backend, such as a Pool, in one of its own.
+type Breaker struct {
+	next Invoker
+	cfg  BreakerConfig
+
+	mu    sync.Mutex
+	state BreakerState
+	// failures counts failures in a row while closed, and lastErr is the
+	// latest
+	failures int
+	lastErr  error
+	// reopens is when an open circuit goes half-open
+	reopens time.Time
+	// trying is whether a trial call is in flight, and passed how many
+	// trials have succeeded
+	trying bool
+	passed int
+}
+
+// NewBreaker guards inv as cfg says, starting closed
+func NewBreaker(inv Invoker, cfg BreakerConfig) *Breaker {
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+	if cfg.OpenFor <= 0 {
+		cfg.OpenFor = 30 * time.Second
+	}
+	if cfg.Trials <= 0 {
+		cfg.Trials = 1
+	}
+	if cfg.Failed == nil {
+		cfg.Failed = DefaultBreakerFailure
+	}
+	return &Breaker{next: inv, cfg: cfg}
+}
+
+// Do passes call on unless the circuit is open, or half-open with a trial
+// already in flight
+func (b *Breaker) Do(ctx context.Context, call *Call) (*Result, error) {
+	trial, err := b.admit()
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.next.Do(ctx, call)
+	b.settle(trial, err)
+	return res, err
+}
+
+// State reports where the circuit is now
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	return b.state
+}
+
+// admit decides whether a call may go through, and whether it is a trial
+func (b *Breaker) admit() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	switch b.state {
+	case BreakerOpen:
+		return false, fmt.Errorf("%w until %s; the last failure: %v", ErrCircuitOpen, b.reopens.Format(time.StampMilli), b.lastErr)
+	case BreakerHalfOpen:
+		if b.trying {
+			return false, fmt.Errorf("%w: a trial call is in flight; the last failure: %v", ErrCircuitOpen, b.lastErr)
+		}
+		b.trying = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// settle counts how a call that went through ended
+func (b *Breaker) settle(trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := err != nil && b.cfg.Failed(err)
+	// An error the script reported still means the backend answered,
+	// while a call its caller gave up on says nothing either way
+	answered := !failed && !errors.Is(err, context.Canceled)
+	if trial {
+		b.trying = false
+	}
+	switch {
+	case failed:
+		b.lastErr = err
+		b.failures++
+		if trial || b.state == BreakerClosed && b.failures >= b.cfg.Failures {
+			b.open()
+		}
+	case !answered:
+	case b.state == BreakerClosed:
+		b.failures = 0
+	case trial:
+		if b.passed++; b.passed >= b.cfg.Trials {
+			b.failures = 0
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// open opens the circuit for OpenFor
+func (b *Breaker) open() {
+	b.reopens = time.Now().Add(b.cfg.OpenFor)
+	b.setState(BreakerOpen)
+}
+
+// expire moves an open circuit past OpenFor to half-open
+func (b *Breaker) expire() {
+	if b.state == BreakerOpen && !time.Now().Before(b.reopens) {
+		b.passed = 0
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *Breaker) setState(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
+}
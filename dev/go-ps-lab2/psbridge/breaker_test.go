@@ -0,0 +1,159 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	down := fmt.Errorf("start powershell: %w", io.ErrUnexpectedEOF)
+	script := &PSError{Message: "not found"}
+	// wait marks a step that lets OpenFor run out instead of calling
+	wait := errors.New("wait")
+	tests := []struct {
+		name   string
+		cfg    BreakerConfig
+		calls  []error
+		states []BreakerState
+	}{
+		{
+			"opens after failures in a row",
+			BreakerConfig{Failures: 3},
+			[]error{down, down, down},
+			[]BreakerState{BreakerClosed, BreakerClosed, BreakerOpen},
+		},
+		{
+			"a success resets the count",
+			BreakerConfig{Failures: 2},
+			[]error{down, nil, down, down},
+			[]BreakerState{BreakerClosed, BreakerClosed, BreakerClosed, BreakerOpen},
+		},
+		{
+			"script errors don't count",
+			BreakerConfig{Failures: 1},
+			[]error{script, context.Canceled, ErrRateLimited, &TimeoutError{Op: "x", Err: context.Canceled}},
+			[]BreakerState{BreakerClosed, BreakerClosed, BreakerClosed, BreakerClosed},
+		},
+		{
+			"timeouts count",
+			BreakerConfig{Failures: 1},
+			[]error{&TimeoutError{Op: "x", Err: context.DeadlineExceeded}},
+			[]BreakerState{BreakerOpen},
+		},
+		{
+			"a trial closes it",
+			BreakerConfig{Failures: 1, OpenFor: time.Millisecond},
+			[]error{down, wait, nil},
+			[]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed},
+		},
+		{
+			"a failed trial opens it again",
+			BreakerConfig{Failures: 1, OpenFor: time.Millisecond},
+			[]error{down, wait, down},
+			[]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen},
+		},
+		{
+			"every trial has to pass",
+			BreakerConfig{Failures: 1, OpenFor: time.Millisecond, Trials: 2},
+			[]error{down, wait, nil, nil},
+			[]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerHalfOpen, BreakerClosed},
+		},
+		{
+			"a script error passes a trial",
+			BreakerConfig{Failures: 1, OpenFor: time.Millisecond},
+			[]error{down, wait, script},
+			[]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed},
+		},
+		{
+			"a custom Failed",
+			BreakerConfig{Failures: 1, Failed: func(err error) bool { var e *PSError; return errors.As(err, &e) }},
+			[]error{down, script},
+			[]BreakerState{BreakerClosed, BreakerOpen},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var next error
+			b := NewBreaker(InvokerFunc(func(context.Context, *Call) (*Result, error) {
+				if next != nil {
+					return nil, next
+				}
+				return &Result{}, nil
+			}), tt.cfg)
+			for i, err := range tt.calls {
+				if err == wait {
+					time.Sleep(5 * time.Millisecond)
+				} else {
+					next = err
+					if _, got := b.Do(context.Background(), &Call{Op: "x"}); got != err {
+						t.Fatalf("call %d: err = %v, want %v", i, got, err)
+					}
+				}
+				if got := b.State(); got != tt.states[i] {
+					t.Fatalf("after call %d: state %v, want %v", i, got, tt.states[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	calls := 0
+	down := errors.New("down")
+	var changes []string
+	b := NewBreaker(InvokerFunc(func(context.Context, *Call) (*Result, error) {
+		calls++
+		return nil, down
+	}), BreakerConfig{Failures: 1, OnStateChange: func(from, to BreakerState) {
+		changes = append(changes, from.String()+">"+to.String())
+	}})
+	b.Do(context.Background(), &Call{Op: "x"})
+	_, err := b.Do(context.Background(), &Call{Op: "x"})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if calls != 1 {
+		t.Errorf("an open circuit passed a call on: %d calls", calls)
+	}
+	if len(changes) != 1 || changes[0] != "closed>open" {
+		t.Errorf("state changes = %q", changes)
+	}
+}
+
+func TestBreakerOneTrialAtATime(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fail := true
+	b := NewBreaker(InvokerFunc(func(context.Context, *Call) (*Result, error) {
+		if fail {
+			fail = false
+			return nil, errors.New("down")
+		}
+		close(started)
+		<-release
+		return &Result{}, nil
+	}), BreakerConfig{Failures: 1, OpenFor: time.Millisecond})
+	b.Do(context.Background(), &Call{Op: "x"})
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Do(context.Background(), &Call{Op: "x"})
+		done <- err
+	}()
+	<-started
+	if _, err := b.Do(context.Background(), &Call{Op: "x"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second trial: err = %v, want ErrCircuitOpen", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state %v, want closed", got)
+	}
+}
@@ -0,0 +1,94 @@
+package psbridge
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// scripts holds the PowerShell shims that ship with psbridge, such as
+// json_echo.ps1
+//
+//go:embed scripts/*.ps1
+var scripts embed.FS
+
+// Scripts returns the bundled shims, rooted so names have no directory
+func Scripts() fs.FS {
+	sub, err := fs.Sub(scripts, "scripts")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Bundle is a set of scripts extracted from an fs.FS (typically an
+// embed.FS) into a private temp directory, so a binary can carry its .ps1
+// files inside it. Close removes the directory.
+type Bundle struct {
+	dir string
+	// deployed is whether the scripts were in dir already, as with
+	// Deployed, and integrity what it checks them with
+	deployed  bool
+	integrity *Integrity
+}
+
+// Extract writes every file in fsys to a fresh temp directory readable only
+// by the current user
+func Extract(fsys fs.FS) (*Bundle, error) {
+	dir, err := os.MkdirTemp("", "psbridge-")
+	if err != nil {
+		return nil, fmt.Errorf("create script dir: %w", err)
+	}
+	b := &Bundle{dir: dir}
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o600)
+	})
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("extract scripts: %w", err)
+	}
+	return b, nil
+}
+
+// Dir is the directory the scripts were extracted to
+func (b *Bundle) Dir() string { return b.dir }
+
+// Path returns the on-disk path of the script called name, using the same
+// slash-separated name as in the source fs.FS
+func (b *Bundle) Path(name string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(path.Clean(name)))
+}
+
+// Client returns a Client running the extracted script called name
+func (b *Bundle) Client(name string, opts ...Option) *Client {
+	c := NewClient(b.Path(name))
+	c.Integrity = b.integrity
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Close deletes the extracted scripts. Clients and sessions using them must
+// be done first. Deployed scripts are left in place.
+func (b *Bundle) Close() error {
+	if b.deployed {
+		return nil
+	}
+	return os.RemoveAll(b.dir)
+}
@@ -0,0 +1,249 @@
+package psbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheEntries caps a Cache whose config doesn't
+const DefaultCacheEntries = 1024
+
+// CacheConfig chooses what a Cache keeps and for how long
+type CacheConfig struct {
+	// TTLs are the operations to cache and how long each one's results
+	// stay fresh. Operations not listed always go through, so ones with
+	// side effects are never answered from the cache.
+	TTLs map[string]time.Duration
+	// MaxEntries caps the results kept, default DefaultCacheEntries. When
+	// full, the one closest to expiring goes first.
+	MaxEntries int
+	// OnLookup, if set, is called for each call to a cached operation with
+	// whether it was answered from the cache
+	OnLookup func(op string, hit bool)
+}
+
+// ProviderTTLs caches every read-only provider operation for ttl
+func ProviderTTLs(ttl time.Duration) map[string]time.Duration {
+	ttls := map[string]time.Duration{}
+	for _, op := range []string{
+		ProvidersOp, ChildItemsOp, RegistryOp, CertStoresOp, CertificatesOp, EnvOp,
+		AliasesOp, FunctionsOp, VariablesOp, ModulesOp, ServicesOp, CimOp,
+	} {
+		ttls[op] = ttl
+	}
+	return ttls
+}
+
+// Cache is an Invoker that answers repeated queries from memory. A query
+// is an operation with its request data, compared as JSON whatever its key
+// order or spacing. Failed calls aren't cached, nor are calls with their
+// own Env or Dir, and concurrent misses for one query share a single call.
+type Cache struct {
+	next Invoker
+	cfg  CacheConfig
+
+	mu           sync.Mutex
+	entries      map[string]*cacheEntry
+	hits, misses int64
+}
+
+type cacheEntry struct {
+	op      string
+	ready   chan struct{}
+	res     *Result
+	err     error
+	expires time.Time
+}
+
+// CacheStats is a snapshot of a Cache's counters
+type CacheStats struct {
+	Entries int
+	Hits    int64
+	Misses  int64
+}
+
+// NewCache caches the results inv returns as cfg says
+func NewCache(inv Invoker, cfg CacheConfig) *Cache {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultCacheEntries
+	}
+	return &Cache{next: inv, cfg: cfg, entries: map[string]*cacheEntry{}}
+}
+
+// Do answers call from the cache when it can, and from the wrapped Invoker
+// otherwise
+func (c *Cache) Do(ctx context.Context, call *Call) (*Result, error) {
+	ttl := c.cfg.TTLs[call.Op]
+	if ttl <= 0 || len(call.Env) > 0 || call.ReplaceEnv || call.Dir != "" {
+		return c.next.Do(ctx, call)
+	}
+	key := cacheKey(call.Op, call.Data)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		c.hits++
+		c.mu.Unlock()
+		c.lookedUp(call.Op, true)
+		select {
+		case <-e.ready:
+		case <-ctx.Done():
+			return nil, &TimeoutError{Op: call.Op, Err: ctx.Err()}
+		}
+		if e.err != nil {
+			// The call this one waited on failed, perhaps only because its
+			// own context ended, or its result was too big to keep, so run
+			// it again uncached
+			return c.next.Do(ctx, call)
+		}
+		return e.res.clone(), nil
+	}
+	e := &cacheEntry{op: call.Op, ready: make(chan struct{})}
+	c.evict()
+	c.entries[key] = e
+	c.misses++
+	c.mu.Unlock()
+	c.lookedUp(call.Op, false)
+
+	res, err := c.next.Do(ctx, call)
+	c.mu.Lock()
+	switch {
+	case err == nil && !res.Spilled():
+		e.res = res.clone()
+		e.expires = time.Now().Add(ttl)
+	case err == nil:
+		// Kept on disk by the client's limits, so too big to keep here
+		e.err = errNotCached
+	default:
+		e.err = err
+	}
+	if e.err != nil && c.entries[key] == e {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	close(e.ready)
+	return res, err
+}
+
+// errNotCached tells the calls waiting on a result that it wasn't kept
+var errNotCached = errors.New("psbridge: result not cached")
+
+func (c *Cache) lookedUp(op string, hit bool) {
+	if c.cfg.OnLookup != nil {
+		c.cfg.OnLookup(op, hit)
+	}
+}
+
+// evict makes room for one more entry, first by dropping expired ones.
+// c.mu must be held.
+func (c *Cache) evict() {
+	if len(c.entries) < c.cfg.MaxEntries {
+		return
+	}
+	now := time.Now()
+	var soonest string
+	for key, e := range c.entries {
+		if e.expires.IsZero() {
+			// Still in flight
+			continue
+		}
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if soonest == "" || e.expires.Before(c.entries[soonest].expires) {
+			soonest = key
+		}
+	}
+	if len(c.entries) >= c.cfg.MaxEntries && soonest != "" {
+		delete(c.entries, soonest)
+	}
+}
+
+// Invalidate drops the cached result of op with request data, so the next
+// such call runs again
+func (c *Cache) Invalidate(op string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	delete(c.entries, cacheKey(op, b))
+	c.mu.Unlock()
+	return nil
+}
+
+// InvalidateOp drops every cached result of op
+func (c *Cache) InvalidateOp(op string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if e.op == op {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Purge drops everything cached
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	c.entries = map[string]*cacheEntry{}
+	c.mu.Unlock()
+}
+
+// Stats reports the cache's size and how often it has been hit
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+}
+
+// cacheKey identifies a query: op and its data re-encoded with sorted keys,
+// so equal requests match however they were written
+func cacheKey(op string, data json.RawMessage) string {
+	var b strings.Builder
+	b.WriteString(op)
+	b.WriteByte(0)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		b.Write(data)
+		return b.String()
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		b.Write(data)
+		return b.String()
+	}
+	b.Write(canonical)
+	return b.String()
+}
+
+// clone copies an in-memory result, so neither the cache nor its callers
+// see what the other does to theirs
+func (r *Result) clone() *Result {
+	out := &Result{Data: bytes.Clone(r.Data), numbers: r.numbers, strict: r.strict}
+	out.Streams = Streams{
+		Verbose:     slices.Clone(r.Streams.Verbose),
+		Warning:     slices.Clone(r.Streams.Warning),
+		Debug:       slices.Clone(r.Streams.Debug),
+		Information: slices.Clone(r.Streams.Information),
+		Stdout:      slices.Clone(r.Streams.Stdout),
+	}
+	if r.Streams.Errors != nil {
+		out.Streams.Errors = make([]*PSError, len(r.Streams.Errors))
+		for i, e := range r.Streams.Errors {
+			if e != nil {
+				e := *e
+				out.Streams.Errors[i] = &e
+			}
+		}
+	}
+	return out
+}
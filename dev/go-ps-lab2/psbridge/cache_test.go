@@ -0,0 +1,135 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingInvoker answers every call with the same result, counting them
+type countingInvoker struct {
+	calls atomic.Int64
+	err   error
+}
+
+func (c *countingInvoker) Do(ctx context.Context, call *Call) (*Result, error) {
+	c.calls.Add(1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &Result{
+		Data:    json.RawMessage(`{"n":1}`),
+		Streams: Streams{Verbose: []string{"v"}, Errors: []*PSError{{Message: "warned"}}},
+	}, nil
+}
+
+func TestCacheResultsAreCopies(t *testing.T) {
+	c := NewCache(&countingInvoker{}, CacheConfig{TTLs: map[string]time.Duration{"q": time.Minute}})
+	ctx := context.Background()
+	first, err := c.Do(ctx, &Call{Op: "q"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Data[2] = 'X'
+	first.Streams.Verbose[0] = "changed"
+	first.Streams.Errors[0].Message = "changed"
+
+	for range 2 {
+		res, err := c.Do(ctx, &Call{Op: "q"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Data) != `{"n":1}` || res.Streams.Verbose[0] != "v" || res.Streams.Errors[0].Message != "warned" {
+			t.Fatalf("hit sees an earlier caller's changes: %s %v %v", res.Data, res.Streams.Verbose, res.Streams.Errors[0])
+		}
+		res.Data[2] = 'Y'
+		res.Streams.Errors[0].Message = "changed again"
+	}
+}
+
+func TestCacheLookups(t *testing.T) {
+	tests := []struct {
+		name      string
+		first     *Call
+		second    *Call
+		wantCalls int64
+	}{
+		{"same query", &Call{Op: "q", Data: json.RawMessage(`{"a":1,"b":2}`)}, &Call{Op: "q", Data: json.RawMessage(`{ "b": 2, "a": 1 }`)}, 1},
+		{"other data", &Call{Op: "q", Data: json.RawMessage(`{"a":1}`)}, &Call{Op: "q", Data: json.RawMessage(`{"a":2}`)}, 2},
+		{"uncached op", &Call{Op: "write"}, &Call{Op: "write"}, 2},
+		{"own dir", &Call{Op: "q", Dir: "/tmp"}, &Call{Op: "q", Dir: "/tmp"}, 2},
+		{"own env", &Call{Op: "q", Env: map[string]string{"A": "1"}}, &Call{Op: "q", Env: map[string]string{"A": "1"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := &countingInvoker{}
+			c := NewCache(inv, CacheConfig{TTLs: map[string]time.Duration{"q": time.Minute}})
+			for _, call := range []*Call{tt.first, tt.second} {
+				if _, err := c.Do(context.Background(), call); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := inv.calls.Load(); got != tt.wantCalls {
+				t.Errorf("%d calls went through, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCacheExpiryAndInvalidation(t *testing.T) {
+	inv := &countingInvoker{}
+	c := NewCache(inv, CacheConfig{TTLs: map[string]time.Duration{"q": 20 * time.Millisecond, "r": time.Minute}})
+	ctx := context.Background()
+	do := func(op string) {
+		t.Helper()
+		if _, err := c.Do(ctx, &Call{Op: op}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	do("q")
+	do("q")
+	time.Sleep(30 * time.Millisecond)
+	do("q")
+	if got := inv.calls.Load(); got != 2 {
+		t.Errorf("after expiry: %d calls, want 2", got)
+	}
+
+	do("r")
+	c.InvalidateOp("r")
+	do("r")
+	if got := inv.calls.Load(); got != 4 {
+		t.Errorf("after InvalidateOp: %d calls, want 4", got)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 4 {
+		t.Errorf("Stats = %+v, want 1 hit and 4 misses", stats)
+	}
+}
+
+func TestCacheDoesNotKeepFailures(t *testing.T) {
+	boom := errors.New("boom")
+	inv := &countingInvoker{err: boom}
+	c := NewCache(inv, CacheConfig{TTLs: map[string]time.Duration{"q": time.Minute}})
+	for range 2 {
+		if _, err := c.Do(context.Background(), &Call{Op: "q"}); !errors.Is(err, boom) {
+			t.Fatalf("err = %v, want %v", err, boom)
+		}
+	}
+	if got := inv.calls.Load(); got != 2 {
+		t.Errorf("%d calls, want 2", got)
+	}
+}
+
+func TestCacheEvictsWhenFull(t *testing.T) {
+	c := NewCache(&countingInvoker{}, CacheConfig{TTLs: map[string]time.Duration{"q": time.Minute}, MaxEntries: 2})
+	for _, data := range []string{`1`, `2`, `3`} {
+		if _, err := c.Do(context.Background(), &Call{Op: "q", Data: json.RawMessage(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.Stats().Entries; n != 2 {
+		t.Errorf("%d entries, want 2", n)
+	}
+}
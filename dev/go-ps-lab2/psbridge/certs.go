@@ -0,0 +1,114 @@
+package psbridge
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Operations the shim serves for reading the Cert: drive
+const (
+	CertStoresOp   = "cert-stores"
+	CertificatesOp = "certificates"
+)
+
+// CertStore is one certificate store, e.g. LocalMachine\My
+type CertStore struct {
+	// Location is CurrentUser or LocalMachine
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	// Path is the store on the Cert: drive, e.g. Cert:\LocalMachine\My
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// Certificate is one certificate in a store. Raw, the DER encoding of its
+// public part, is only filled in when asked for.
+type Certificate struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	Thumbprint   string    `json:"thumbprint"`
+	SerialNumber string    `json:"serialNumber"`
+	FriendlyName string    `json:"friendlyName,omitempty"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	// KeyUsage are the key usage extension's flags, e.g. DigitalSignature
+	// and KeyEncipherment; empty when there is no such extension
+	KeyUsage []string `json:"keyUsage"`
+	// EnhancedKeyUsage are the extended key usages by name where Windows
+	// knows one, e.g. Server Authentication, or else by OID
+	EnhancedKeyUsage []string `json:"enhancedKeyUsage"`
+	HasPrivateKey    bool     `json:"hasPrivateKey"`
+	// Path is provider-qualified, e.g.
+	// Microsoft.PowerShell.Security\Certificate::LocalMachine\My\<thumbprint>
+	Path string `json:"path"`
+	Raw  Bytes  `json:"raw,omitempty"`
+}
+
+// Expired reports whether the certificate isn't valid after at
+func (c Certificate) Expired(at time.Time) bool {
+	return !at.Before(c.NotAfter)
+}
+
+// ExpiresWithin reports whether the certificate stops being valid within
+// d of at, including if it already has
+func (c Certificate) ExpiresWithin(d time.Duration, at time.Time) bool {
+	return c.Expired(at.Add(d))
+}
+
+// X509 parses Raw
+func (c Certificate) X509() (*x509.Certificate, error) {
+	if len(c.Raw) == 0 {
+		return nil, fmt.Errorf("psbridge: certificate %s was listed without Export", c.Thumbprint)
+	}
+	return x509.ParseCertificate(c.Raw)
+}
+
+// PEM returns Raw PEM-encoded, or nil if it wasn't exported
+func (c Certificate) PEM() []byte {
+	if len(c.Raw) == 0 {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+}
+
+// CertificateOptions choose what Certificates lists
+type CertificateOptions struct {
+	// Recurse lists the stores under path too, so Cert:\ lists everything
+	Recurse bool `json:"recurse"`
+	// Thumbprint lists only the certificate with that thumbprint
+	Thumbprint string `json:"thumbprint,omitempty"`
+	// Export fills in each certificate's Raw
+	Export bool `json:"export"`
+}
+
+type certificatesRequest struct {
+	Path string `json:"path"`
+	CertificateOptions
+}
+
+// CertStores lists the stores of both store locations
+func CertStores(ctx context.Context, inv Invoker, opts ...CallOption) ([]CertStore, error) {
+	return InvokeContext[struct{}, []CertStore](ctx, inv, CertStoresOp, struct{}{}, opts...)
+}
+
+// Certificates lists the certificates at path on the Cert: drive, e.g.
+// Cert:\LocalMachine\My
+func Certificates(ctx context.Context, inv Invoker, path string, o CertificateOptions, opts ...CallOption) ([]Certificate, error) {
+	return InvokeContext[certificatesRequest, []Certificate](ctx, inv, CertificatesOp, certificatesRequest{path, o}, opts...)
+}
+
+// ExportCertificate returns the public part of the certificate with
+// thumbprint in the store at path, DER-encoded
+func ExportCertificate(ctx context.Context, inv Invoker, path, thumbprint string, opts ...CallOption) ([]byte, error) {
+	certs, err := Certificates(ctx, inv, path, CertificateOptions{Thumbprint: thumbprint, Export: true}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("psbridge: no certificate %s in %s", thumbprint, path)
+	}
+	return certs[0].Raw, nil
+}
@@ -0,0 +1,37 @@
+package psbridge
+
+import "context"
+
+// CimOp is the operation the shim reads CIM instances with
+const CimOp = "cim"
+
+// DefaultCimNamespace is the namespace CimInstances reads without one
+const DefaultCimNamespace = `root\cimv2`
+
+// CimRequest chooses the CIM instances to read
+type CimRequest struct {
+	// Class is the CIM class, e.g. Win32_BIOS or Win32_LogicalDisk
+	Class string `json:"class"`
+	// Filter is a WQL condition, e.g. DriveType = 3
+	Filter string `json:"filter,omitempty"`
+	// Namespace defaults to DefaultCimNamespace
+	Namespace string `json:"namespace,omitempty"`
+	// Properties, if set, are the only properties read
+	Properties []string `json:"properties,omitempty"`
+}
+
+// CimInstances reads the instances req names, each decoded into T by its
+// property names. Dates come as RFC 3339 strings, so decode into
+// time.Time, and embedded instances as objects of their own.
+func CimInstances[T any](ctx context.Context, inv Invoker, req CimRequest, opts ...CallOption) ([]T, error) {
+	if req.Namespace == "" {
+		req.Namespace = DefaultCimNamespace
+	}
+	return InvokeContext[CimRequest, []T](ctx, inv, CimOp, req, opts...)
+}
+
+// CimQuery reads the instances of class in namespace that match filter,
+// either of which may be empty, as property maps
+func CimQuery(ctx context.Context, inv Invoker, class, filter, namespace string, opts ...CallOption) ([]map[string]any, error) {
+	return CimInstances[map[string]any](ctx, inv, CimRequest{Class: class, Filter: filter, Namespace: namespace}, opts...)
+}
@@ -0,0 +1,293 @@
+// Package psbridge runs PowerShell scripts from Go and exchanges JSON with
+// them over stdin/stdout.
+package psbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// Request is what we send to PowerShell as JSON
+type Request struct {
+	Name   string `json:"name"`
+	Number int    `json:"number"`
+}
+
+// Response is what we expect back from PowerShell as JSON
+type Response struct {
+	Message string `json:"message"`
+	Name    string `json:"name"`
+	Number  int    `json:"number"`
+}
+
+// Client runs a PowerShell script once per call, writing the request to its
+// stdin and reading reply messages from its stdout
+type Client struct {
+	// Shell is the PowerShell executable. When empty it is located with
+	// FindShell on each start.
+	Shell string
+	// Script is the .ps1 file passed to -File
+	Script string
+	// ScriptText is an inline script body, used instead of reading Script
+	// in ExecEncodedCommand mode
+	ScriptText string
+	// Mode is how the script is passed to PowerShell
+	Mode ExecMode
+	// Flags are the host switches PowerShell starts with
+	Flags HostFlags
+	// Dir is the working directory scripts start in; empty inherits ours
+	Dir string
+	// Endpoint, if set, is the session configuration operations run in
+	Endpoint *Endpoint
+	// Signatures, if set, refuses scripts not signed as it requires
+	Signatures *SignaturePolicy
+	// Integrity, if set, refuses scripts changed since they were deployed
+	Integrity *Integrity
+	// Timeouts bound each call and end idle sessions
+	Timeouts Timeouts
+	// Retry, if set, retries failed calls
+	Retry *RetryPolicy
+	// RateLimiter, if set, paces the processes the client starts
+	RateLimiter *RateLimiter
+	// Isolate makes every session call isolated, as WithIsolation does
+	Isolate bool
+	// Numbers is how numbers in results are decoded into an any
+	Numbers NumberMode
+	// Strict decodes every result strictly, as WithStrict does
+	Strict bool
+	// JSONDepth is how many levels of each result the shim writes; 0 is
+	// DefaultJSONDepth. CheckTruncation fails results that look cut off at
+	// it with *TruncatedError.
+	JSONDepth       int
+	CheckTruncation bool
+	// Framing is requested from each session at start
+	Framing Framing
+	// Transport carries session messages
+	Transport Transport
+	// Console receives the script's own console output when Transport
+	// isn't stdio; nil discards it
+	Console io.Writer
+	// Stderr, if set, receives each line scripts write to stderr while they
+	// run, on top of the copy kept for errors. OnStderr, if set, is called
+	// with each line too. Both are called from the goroutine reading a
+	// process's stderr, so from several at once for calls in parallel.
+	Stderr   io.Writer
+	OnStderr func(StderrEvent)
+	// Logger, if set, is told about requests, responses, stderr and
+	// processes
+	Logger Logger
+	// LogPayload is how many bytes of each payload Logger sees: 0 none, -1
+	// all
+	LogPayload int
+	// SecretPattern matches the JSON keys whose values are redacted from
+	// payloads given to Logger; nil means SecretPattern
+	SecretPattern *regexp.Regexp
+	// Limits bound the output each call holds in memory
+	Limits OutputLimits
+	// Resources bound the CPU and memory each process may take
+	Resources Resources
+	// Encoding is the character set scripts write their output in
+	Encoding OutputEncoding
+	// Sentinels marks messages on stdout apart from noise; see
+	// WithSentinels
+	Sentinels bool
+	// SSH, if set, runs the script on a remote host
+	SSH *SSHHost
+	// Operation is passed to the script as -Operation by Invoke
+	Operation string
+	// Router, if set, is passed to the script as -Router: a script on the
+	// PowerShell host registering more operations, like gen.Router writes
+	Router string
+	// RequiredModules are checked by StartSession before it returns
+	RequiredModules []ModuleRequirement
+	// ModuleInstall, if set, installs unmet RequiredModules
+	ModuleInstall *InstallOptions
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithShell pins the PowerShell executable instead of discovering one
+func WithShell(path string) Option {
+	return func(c *Client) { c.Shell = path }
+}
+
+// NewClient returns a Client that runs script with the echo operation and
+// DefaultHostFlags
+func NewClient(script string, opts ...Option) *Client {
+	c := &Client{
+		Script:    script,
+		Flags:     DefaultHostFlags(),
+		Operation: "echo",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Invoke sends req to the script and decodes what it prints back
+func (c *Client) Invoke(req Request, opts ...CallOption) (Response, error) {
+	return c.InvokeContext(context.Background(), req, opts...)
+}
+
+// InvokeContext is Invoke bounded by ctx. If ctx is done before the script
+// finishes, the process is killed and a *TimeoutError is returned.
+func (c *Client) InvokeContext(ctx context.Context, req Request, opts ...CallOption) (Response, error) {
+	return InvokeContext[Request, Response](ctx, c, c.Operation, req, opts...)
+}
+
+// InvokeBatch sends all of reqs to the script's operation in one process
+func (c *Client) InvokeBatch(ctx context.Context, reqs []Request, opts ...CallOption) ([]BatchResult[Response], error) {
+	return InvokeBatch[Request, Response](ctx, c, c.Operation, reqs, opts...)
+}
+
+// Do runs the script once with call.Op as -Operation and call.Data on stdin
+func (c *Client) Do(ctx context.Context, call *Call) (*Result, error) {
+	res, err := c.Timeouts.run(ctx, call, func(ctx context.Context) (*Result, error) {
+		if c.Retry != nil {
+			return c.Retry.do(ctx, func() (*Result, error) { return c.run(ctx, call) })
+		}
+		return c.run(ctx, call)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTruncated(call.Op, res, c.CheckTruncation, c.JSONDepth); err != nil {
+		return nil, err
+	}
+	res.numbers = c.Numbers
+	res.strict = c.Strict
+	return res, nil
+}
+
+// dir is where call runs: its own directory if it has one, else the
+// client's
+func (c *Client) dir(call *Call) string {
+	if call.Dir != "" {
+		return call.Dir
+	}
+	return c.Dir
+}
+
+// shell returns the pinned executable or discovers one. A remote host's
+// shell can't be discovered from here, so it defaults to pwsh.
+func (c *Client) shell() (string, error) {
+	if c.Shell != "" {
+		return c.Shell, nil
+	}
+	if c.SSH != nil {
+		return "pwsh", nil
+	}
+	return FindShell()
+}
+
+// run starts the script with input on stdin and reads its reply messages
+// from stdout until the result or error arrives
+func (c *Client) run(ctx context.Context, call *Call) (*Result, error) {
+	data := call.Data
+	var key []byte
+	if c.SSH == nil && hasSecureStrings(data) {
+		var err error
+		if key, err = newSealKey(); err != nil {
+			return nil, err
+		}
+		if data, err = seal(data, key); err != nil {
+			return nil, err
+		}
+	}
+	params := []Param{{Name: "Operation", Value: call.Op}}
+	var req *stdinRequest
+	if c.Mode == ExecStdin {
+		var err error
+		if req, err = newStdinRequest(data); err != nil {
+			return nil, err
+		}
+		defer req.remove()
+		params = append(params, req.param)
+	}
+	cmd, err := c.command(ctx, c.dir(call), params...)
+	if err != nil {
+		return nil, err
+	}
+	if len(call.Env) > 0 || call.ReplaceEnv {
+		if c.SSH != nil {
+			return nil, fmt.Errorf("%w: ssh only forwards variables the server accepts; use a session", ErrEnvUnsupported)
+		}
+		cmd.Env = callEnv(call)
+	}
+	if key != nil {
+		cmd.Env = withSealKey(cmd.Env, key)
+	}
+	if req != nil {
+		cmd.Env = req.addTo(cmd.Env)
+	} else {
+		cmd.Stdin = bytes.NewReader(data)
+	}
+
+	h := c.hooks()
+	var stderr bytes.Buffer
+	var flushStderr func()
+	cmd.Stderr, flushStderr = h.stderr(cmd, &stderr)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	if err := c.startCommand(cmd); err != nil {
+		return nil, fmt.Errorf("start powershell: %w", err)
+	}
+	start := time.Now()
+	h.started(cmd, false)
+	h.RequestSent(RequestEvent{Op: call.Op, PID: cmd.Process.Pid, Size: len(call.Data), Payload: h.payload(call.Data, call.Secrets)})
+
+	res, readErr := readReply(&msgReader{r: bufio.NewReader(newTextDecoder(stdout, c.Encoding)), limits: c.Limits, cumulative: true, sentinels: c.Sentinels}, call)
+	var limitErr *OutputLimitError
+	if errors.As(readErr, &limitErr) {
+		// Draining the rest could take forever
+		killTree(cmd)
+	}
+	io.Copy(io.Discard, stdout)
+	waitErr := waitCommand(cmd)
+	flushStderr()
+	h.exited(cmd, false, waitErr)
+
+	res, err = c.result(ctx, cmd, call, res, readErr, waitErr, stderr.Bytes())
+	ev := ResponseEvent{Op: call.Op, PID: cmd.Process.Pid, Duration: time.Since(start), Err: err}
+	if res != nil {
+		ev.Size = int(res.Size())
+		ev.Payload = h.resultPayload(res, call.Secrets)
+	}
+	h.ResponseReceived(ev)
+	return res, err
+}
+
+// result settles what a one-shot run returns from how reading its reply and
+// waiting for it went
+func (c *Client) result(ctx context.Context, cmd *exec.Cmd, call *Call, res *Result, readErr, waitErr error, stderr []byte) (*Result, error) {
+	if err := limitError(readErr, call.Op); err != readErr {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, &TimeoutError{Op: call.Op, Err: ctx.Err()}
+	}
+	// The script exits 1 after reporting a PSError, so it wins over waitErr
+	var psErr *PSError
+	if errors.As(readErr, &psErr) {
+		return nil, protocolError(psErr)
+	}
+	if waitErr != nil {
+		return nil, newExitError(cmd, waitErr, stderr)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("read reply: %w", readErr)
+	}
+	return res, nil
+}
@@ -0,0 +1,396 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// clixmlHeader prefixes CLIXML output from powershell.exe
+const clixmlHeader = "#< CLIXML"
+
+// IsCLIXML reports whether data is a CLIXML payload
+func IsCLIXML(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n\ufeff"), []byte(clixmlHeader))
+}
+
+// DecodeCLIXML decodes a CLIXML document, with or without its "#< CLIXML"
+// line, into plain Go values, one per top-level object:
+//
+//	strings, GUIDs, URIs   string
+//	B                     bool
+//	I16/I32/I64/SB        int64
+//	By/U16/U32/U64        uint64
+//	Db/Sg                 float64
+//	D (decimal)           json.Number
+//	DT                    time.Time
+//	TS                    time.Duration
+//	BA                    []byte
+//	LST/IE/STK/QUE        []any
+//	DCT, objects w/ props map[string]any
+//
+// Objects with neither properties nor a primitive value decode to their
+// ToString text.
+func DecodeCLIXML(data []byte) ([]any, error) {
+	roots, err := parseCLIXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []any
+	for _, root := range roots {
+		// RefIds count from 0 again in each document
+		d := &clixmlDecoder{refs: map[string]any{}}
+		for i := range root.Nodes {
+			n := &root.Nodes[i]
+			// Top-level S nodes tagged with a stream are host output, not objects
+			if n.XMLName.Local == "S" && n.attr("S") != "" {
+				continue
+			}
+			v, err := d.value(n)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// clixmlStream returns the text of the top-level strings tagged for stream
+// (e.g. "Error"), which is how powershell.exe serializes stderr
+func clixmlStream(data []byte, stream string) (string, error) {
+	roots, err := parseCLIXML(data)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, root := range roots {
+		for _, n := range root.Nodes {
+			if n.XMLName.Local == "S" && n.attr("S") == stream {
+				sb.WriteString(decodeCLIXMLString(n.Content))
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// StderrText returns stderr as readable text, unwrapping the CLIXML error
+// records powershell.exe writes there when its output is redirected
+func StderrText(stderr []byte) string {
+	if IsCLIXML(stderr) {
+		if text, err := clixmlStream(stderr, "Error"); err == nil {
+			return strings.TrimSpace(text)
+		}
+	}
+	return string(bytes.TrimSpace(stderr))
+}
+
+// clixmlNode is a generic CLIXML element
+type clixmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr   `xml:",any,attr"`
+	Content string       `xml:",chardata"`
+	Nodes   []clixmlNode `xml:",any"`
+}
+
+func (n *clixmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseCLIXML strips the headers and parses the Objs roots. powershell.exe
+// writes stderr as one document after another, each flushed as it goes,
+// sometimes with a header of its own.
+func parseCLIXML(data []byte) ([]clixmlNode, error) {
+	// Markup can't hold a bare "<", so the header only appears as one
+	data = bytes.ReplaceAll(data, []byte(clixmlHeader), nil)
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var roots []clixmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse clixml: %w", err)
+		}
+		// Whitespace, BOMs and the like between documents
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "Objs" {
+			return nil, fmt.Errorf("parse clixml: unexpected root element %q", start.Name.Local)
+		}
+		var root clixmlNode
+		if err := dec.DecodeElement(&root, &start); err != nil {
+			return nil, fmt.Errorf("parse clixml: %w", err)
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("parse clixml: no Objs element")
+	}
+	return roots, nil
+}
+
+// clixmlDecoder tracks RefIds so Ref elements can point back at earlier objects
+type clixmlDecoder struct {
+	refs map[string]any
+}
+
+func (d *clixmlDecoder) value(n *clixmlNode) (any, error) {
+	text := n.Content
+	switch n.XMLName.Local {
+	case "Nil":
+		return nil, nil
+	case "S", "G", "URI", "XD", "SBK", "Version", "SS":
+		return decodeCLIXMLString(text), nil
+	case "C":
+		code, err := strconv.ParseUint(text, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("clixml char %q: %w", text, err)
+		}
+		return string(rune(code)), nil
+	case "B":
+		return strconv.ParseBool(text)
+	case "I16", "I32", "I64", "SB":
+		return strconv.ParseInt(text, 10, 64)
+	case "By", "U16", "U32", "U64":
+		return strconv.ParseUint(text, 10, 64)
+	case "Db", "Sg":
+		return parseCLIXMLFloat(text)
+	case "D":
+		return json.Number(text), nil
+	case "DT":
+		return parseCLIXMLTime(text)
+	case "TS":
+		return parseCLIXMLDuration(text)
+	case "BA":
+		return base64.StdEncoding.DecodeString(text)
+	case "Ref":
+		id := n.attr("RefId")
+		v, ok := d.refs[id]
+		if !ok {
+			return nil, fmt.Errorf("clixml: unknown RefId %q", id)
+		}
+		return v, nil
+	case "Obj":
+		return d.object(n)
+	}
+	return nil, fmt.Errorf("clixml: unsupported element %q", n.XMLName.Local)
+}
+
+// object decodes an Obj element, preferring in order its collection content,
+// its properties, its primitive value and finally its ToString text
+func (d *clixmlDecoder) object(n *clixmlNode) (any, error) {
+	var (
+		list     []any
+		isList   bool
+		dict     map[string]any
+		props    map[string]any
+		prim     any
+		hasPrim  bool
+		toString string
+	)
+
+	// Register property bags before decoding them so members can refer back
+	// to their own parent
+	id := n.attr("RefId")
+	if id != "" && hasCLIXMLProps(n) {
+		props = map[string]any{}
+		d.refs[id] = props
+	}
+
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		switch child.XMLName.Local {
+		case "TN", "TNRef":
+		case "ToString":
+			toString = decodeCLIXMLString(child.Content)
+		case "LST", "IE", "STK", "QUE":
+			isList = true
+			list = []any{}
+			for j := range child.Nodes {
+				v, err := d.value(&child.Nodes[j])
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+		case "DCT":
+			dict = map[string]any{}
+			for _, en := range child.Nodes {
+				var key, val any
+				for j := range en.Nodes {
+					v, err := d.value(&en.Nodes[j])
+					if err != nil {
+						return nil, err
+					}
+					switch en.Nodes[j].attr("N") {
+					case "Key":
+						key = v
+					case "Value":
+						val = v
+					}
+				}
+				dict[fmt.Sprint(key)] = val
+			}
+		case "Props", "MS":
+			if props == nil {
+				props = map[string]any{}
+			}
+			for j := range child.Nodes {
+				v, err := d.value(&child.Nodes[j])
+				if err != nil {
+					return nil, err
+				}
+				props[decodeCLIXMLString(child.Nodes[j].attr("N"))] = v
+			}
+		default:
+			v, err := d.value(child)
+			if err != nil {
+				return nil, err
+			}
+			prim, hasPrim = v, true
+		}
+	}
+
+	var out any
+	switch {
+	case isList:
+		out = list
+	case dict != nil:
+		out = dict
+	case props != nil:
+		out = props
+	case hasPrim:
+		out = prim
+	default:
+		out = toString
+	}
+
+	if id != "" {
+		d.refs[id] = out
+	}
+	return out, nil
+}
+
+// hasCLIXMLProps reports whether an Obj decodes to a property map
+func hasCLIXMLProps(n *clixmlNode) bool {
+	props := false
+	for _, child := range n.Nodes {
+		switch child.XMLName.Local {
+		case "LST", "IE", "STK", "QUE", "DCT":
+			return false
+		case "Props", "MS":
+			props = true
+		}
+	}
+	return props
+}
+
+var clixmlEscape = regexp.MustCompile(`(_x[0-9A-Fa-f]{4}_)+`)
+
+// decodeCLIXMLString undoes the _xHHHH_ escaping used for control characters
+// and surrogate pairs
+func decodeCLIXMLString(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+	return clixmlEscape.ReplaceAllStringFunc(s, func(run string) string {
+		var units []uint16
+		for i := 0; i+7 <= len(run); i += 7 {
+			u, _ := strconv.ParseUint(run[i+2:i+6], 16, 16)
+			units = append(units, uint16(u))
+		}
+		return string(utf16.Decode(units))
+	})
+}
+
+func parseCLIXMLFloat(s string) (float64, error) {
+	switch s {
+	case "INF", "Infinity":
+		return strconv.ParseFloat("+Inf", 64)
+	case "-INF", "-Infinity":
+		return strconv.ParseFloat("-Inf", 64)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseCLIXMLTime(s string) (time.Time, error) {
+	// DateTimeKind.Unspecified values come without an offset
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("clixml: bad DateTime %q", s)
+}
+
+// parseCLIXMLDuration parses the xs:duration form of a TimeSpan, e.g.
+// "-P1DT2H3M4.5S". Years and months never appear in TimeSpans.
+func parseCLIXMLDuration(s string) (time.Duration, error) {
+	bad := fmt.Errorf("clixml: bad TimeSpan %q", s)
+
+	neg := strings.HasPrefix(s, "-")
+	rest := strings.TrimPrefix(s, "-")
+	if !strings.HasPrefix(rest, "P") {
+		return 0, bad
+	}
+	rest = rest[1:]
+
+	var total time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		i := strings.IndexAny(rest, "DHMS")
+		if i <= 0 {
+			return 0, bad
+		}
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, bad
+		}
+		var unit time.Duration
+		switch {
+		case rest[i] == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case rest[i] == 'H' && inTime:
+			unit = time.Hour
+		case rest[i] == 'M' && inTime:
+			unit = time.Minute
+		case rest[i] == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, bad
+		}
+		total += time.Duration(n * float64(unit))
+		rest = rest[i+1:]
+	}
+
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
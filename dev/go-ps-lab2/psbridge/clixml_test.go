@@ -0,0 +1,89 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const objsOpen = `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">`
+
+func TestStderrText(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   string
+	}{
+		{"plain", "  boom\n", "boom"},
+		{"one document", "#< CLIXML\r\n" + objsOpen + `<S S="Error">boom_x000D__x000A_</S></Objs>`, "boom"},
+		{
+			"documents back to back",
+			"#< CLIXML\r\n" + objsOpen + `<S S="Error">first_x000A_</S></Objs>` + objsOpen + `<S S="Error">second</S></Objs>`,
+			"first\nsecond",
+		},
+		{
+			"a header per document",
+			"#< CLIXML\r\n" + objsOpen + `<S S="Error">first_x000A_</S></Objs>` + "\r\n#< CLIXML\r\n" + objsOpen + `<S S="Verbose">chatter</S><S S="Error">second</S></Objs>`,
+			"first\nsecond",
+		},
+		{"broken falls back to the raw text", "#< CLIXML\r\n<Objs><S>", "#< CLIXML\r\n<Objs><S>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StderrText([]byte(tt.stderr)); got != tt.want {
+				t.Errorf("StderrText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCLIXML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []any
+	}{
+		{"primitives", objsOpen + `<S>a_x0009_b</S><I32>-7</I32><U64>7</U64><B>true</B><Db>1.5</Db><D>1.10</D><Nil /><S S="Output">host text</S></Objs>`,
+			[]any{"a\tb", int64(-7), uint64(7), true, 1.5, json.Number("1.10"), nil}},
+		{"duration", objsOpen + `<TS>-P1DT2H3M4.5S</TS></Objs>`,
+			[]any{-(26*time.Hour + 3*time.Minute + 4500*time.Millisecond)}},
+		{"bytes", objsOpen + `<BA>aGk=</BA></Objs>`, []any{[]byte("hi")}},
+		{"surrogate pair", objsOpen + `<S>_xD83D__xDE00_</S></Objs>`, []any{"\U0001F600"}},
+		{"list", objsOpen + `<Obj RefId="0"><TN RefId="0"><T>System.Object[]</T></TN><LST><I32>1</I32><S>x</S></LST></Obj></Objs>`,
+			[]any{[]any{int64(1), "x"}}},
+		{"dictionary", objsOpen + `<Obj RefId="0"><DCT><En><S N="Key">k</S><I32 N="Value">1</I32></En></DCT></Obj></Objs>`,
+			[]any{map[string]any{"k": int64(1)}}},
+		{"properties and refs", objsOpen + `<Obj RefId="0"><MS><S N="Name">a</S></MS></Obj><Ref RefId="0" /></Objs>`,
+			[]any{map[string]any{"Name": "a"}, map[string]any{"Name": "a"}}},
+		{"ToString only", objsOpen + `<Obj RefId="0"><ToString>text</ToString></Obj></Objs>`, []any{"text"}},
+		{"documents back to back", "#< CLIXML\n" + objsOpen + `<Obj RefId="0"><MS><I32 N="N">1</I32></MS></Obj></Objs>` + objsOpen + `<Obj RefId="0"><MS><I32 N="N">2</I32></MS></Obj><Ref RefId="0" /></Objs>`,
+			[]any{map[string]any{"N": int64(1)}, map[string]any{"N": int64(2)}, map[string]any{"N": int64(2)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCLIXML([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCLIXML = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCLIXMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"",
+		"#< CLIXML\r\n",
+		`<Other />`,
+		objsOpen + `<Ref RefId="9" /></Objs>`,
+		objsOpen + `<Weird>1</Weird></Objs>`,
+		objsOpen + `<I32>x</I32></Objs>`,
+	} {
+		if _, err := DecodeCLIXML([]byte(doc)); err == nil {
+			t.Errorf("DecodeCLIXML(%q) succeeded", doc)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// CmdletOp is the operation the shim runs a single cmdlet with
+const CmdletOp = "cmdlet"
+
+// Cmdlet is one cmdlet call. The shim only runs cmdlets and functions it
+// already has, never scripts or programs, and splats Parameters as a
+// hashtable, so nothing in them is parsed as PowerShell.
+type Cmdlet struct {
+	// Name is the command, optionally module-qualified, e.g.
+	// Microsoft.PowerShell.Management\Get-Service
+	Name string `json:"name"`
+	// Parameters are passed by name; true binds a switch
+	Parameters map[string]any `json:"parameters,omitempty"`
+	// Select keeps only these properties of each output object
+	Select []string `json:"select,omitempty"`
+	// Depth is how many levels of each output object are kept; 0 means 2.
+	// Live .NET objects go much deeper than anyone wants as JSON.
+	Depth int `json:"depth,omitempty"`
+}
+
+var (
+	cmdletName    = regexp.MustCompile(`^(?:[\w.-]+\\)?[\w-]+$`)
+	parameterName = regexp.MustCompile(`^\w+$`)
+)
+
+// Validate checks the names in c are ones the shim accepts
+func (c Cmdlet) Validate() error {
+	if !cmdletName.MatchString(c.Name) {
+		return fmt.Errorf("psbridge: invalid cmdlet name %q", c.Name)
+	}
+	for name := range c.Parameters {
+		if !parameterName.MatchString(name) {
+			return fmt.Errorf("psbridge: %s: invalid parameter name %q", c.Name, name)
+		}
+	}
+	return nil
+}
+
+// RunCmdlet runs cmd and decodes each object it wrote into a T
+func RunCmdlet[T any](ctx context.Context, inv Invoker, cmd Cmdlet, opts ...CallOption) ([]T, error) {
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return InvokeContext[Cmdlet, []T](ctx, inv, CmdletOp, cmd, opts...)
+}
+
+// InvokeCmdlet runs the cmdlet name with params and returns its output
+// objects as a JSON array
+func (c *Client) InvokeCmdlet(ctx context.Context, name string, params map[string]any, opts ...CallOption) (json.RawMessage, error) {
+	out, err := RunCmdlet[json.RawMessage](ctx, c, Cmdlet{Name: name, Parameters: params}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// InvokeCmdlet runs the cmdlet name with params in the session and returns
+// its output objects as a JSON array
+func (s *Session) InvokeCmdlet(ctx context.Context, name string, params map[string]any, opts ...CallOption) (json.RawMessage, error) {
+	out, err := RunCmdlet[json.RawMessage](ctx, s, Cmdlet{Name: name, Parameters: params}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,416 @@
+// Package config loads client settings from a YAML or TOML file, so the
+// PowerShell to run, its host flags, timeouts, pool sizes, logging and
+// remote hosts can change without changing code:
+//
+//	shell: /usr/bin/pwsh
+//	timeout: 30s
+//	op_timeouts:
+//	  cim: 2m
+//	flags:
+//	  no_profile: true
+//	  execution_policy: Bypass
+//	pool:
+//	  max: 4
+//	  idle_timeout: 5m
+//	log:
+//	  level: info
+//	target: build01
+//	targets:
+//	  build01:
+//	    host: build01.example.com
+//	    user: ci
+//
+// Environment variables named in Env override the file, and a program's
+// own flags are meant to override both:
+//
+//	cfg, err := config.LoadDefault()
+//	client := psbridge.NewClient(cfg.Script)
+//	err = cfg.Apply(client)
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is what a config file holds
+type Config struct {
+	// Shell is the PowerShell executable; empty to discover one
+	Shell string `yaml:"shell" toml:"shell"`
+	// Script is the bridge script; empty for the bundled one
+	Script string `yaml:"script" toml:"script"`
+	// Flags are PowerShell's host switches; without them clients keep
+	// psbridge.DefaultHostFlags
+	Flags *HostFlags `yaml:"flags" toml:"flags"`
+	// Timeout bounds each call; zero for no limit
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+	// OpTimeouts override Timeout for the operations they name
+	OpTimeouts map[string]Duration `yaml:"op_timeouts" toml:"op_timeouts"`
+	// SessionIdle ends sessions idle for this long; zero never
+	SessionIdle Duration `yaml:"session_idle" toml:"session_idle"`
+	// SessionStart bounds starting each session; zero for no limit
+	SessionStart Duration `yaml:"session_start" toml:"session_start"`
+	// Encoding is what PowerShell writes its output in: auto, utf8 or
+	// utf16
+	Encoding  string `yaml:"encoding" toml:"encoding"`
+	Sentinels bool   `yaml:"sentinels" toml:"sentinels"`
+	// JSONDepth is how many levels of each result the shim writes, up to
+	// 99; zero for psbridge.DefaultJSONDepth. CheckTruncation fails
+	// results that look cut off at it.
+	JSONDepth       int  `yaml:"json_depth" toml:"json_depth"`
+	CheckTruncation bool `yaml:"check_truncation" toml:"check_truncation"`
+	Pool            Pool `yaml:"pool" toml:"pool"`
+	// RateLimit paces the processes clients start; without it they
+	// start as fast as they are asked to
+	RateLimit *RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	// Endpoint is the session configuration, such as a JEA endpoint,
+	// operations run in; see psbridge.WithEndpoint
+	Endpoint *Endpoint `yaml:"endpoint" toml:"endpoint"`
+	// Resources bound the CPU and memory each PowerShell process may
+	// take; see psbridge.Resources
+	Resources *Resources `yaml:"resources" toml:"resources"`
+	// Signers, if any, are the thumbprints of the certificates scripts
+	// must be Authenticode-signed by; see psbridge.SignaturePolicy
+	Signers []string `yaml:"signers" toml:"signers"`
+	Log     Log      `yaml:"log" toml:"log"`
+	// Target names the entry of Targets scripts run on; empty for this
+	// machine
+	Target  string            `yaml:"target" toml:"target"`
+	Targets map[string]Target `yaml:"targets" toml:"targets"`
+}
+
+// HostFlags are psbridge.HostFlags as a file spells them
+type HostFlags struct {
+	NoProfile       bool   `yaml:"no_profile" toml:"no_profile"`
+	NoLogo          bool   `yaml:"no_logo" toml:"no_logo"`
+	NonInteractive  bool   `yaml:"non_interactive" toml:"non_interactive"`
+	ExecutionPolicy string `yaml:"execution_policy" toml:"execution_policy"`
+}
+
+// Pool sizes session pools; see psbridge.PoolConfig
+type Pool struct {
+	Min          int      `yaml:"min" toml:"min"`
+	Max          int      `yaml:"max" toml:"max"`
+	IdleTimeout  Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	PingInterval Duration `yaml:"ping_interval" toml:"ping_interval"`
+}
+
+// RateLimit is a psbridge.RateLimit as a file spells it
+type RateLimit struct {
+	PerSecond float64 `yaml:"per_second" toml:"per_second"`
+	Burst     int     `yaml:"burst" toml:"burst"`
+	FailFast  bool    `yaml:"fail_fast" toml:"fail_fast"`
+}
+
+// Resources are psbridge.Resources as a file spells them
+type Resources struct {
+	// Priority is idle, below_normal, normal, above_normal or high
+	Priority string `yaml:"priority" toml:"priority"`
+	CPUs     []int  `yaml:"cpus" toml:"cpus"`
+	// Memory is in bytes
+	Memory int64 `yaml:"memory" toml:"memory"`
+}
+
+// Endpoint is a psbridge.Endpoint as a file spells it
+type Endpoint struct {
+	Name     string `yaml:"name" toml:"name"`
+	Computer string `yaml:"computer" toml:"computer"`
+}
+
+// Log sets up logging of protocol traffic and processes to stderr
+type Log struct {
+	// Level is debug, info, warn or error; empty logs nothing
+	Level string `yaml:"level" toml:"level"`
+	// Payload is how many bytes of each payload to log, -1 for all
+	Payload int `yaml:"payload" toml:"payload"`
+}
+
+// Target is a host to run scripts on over ssh; see psbridge.SSHHost
+type Target struct {
+	Host         string   `yaml:"host" toml:"host"`
+	User         string   `yaml:"user" toml:"user"`
+	Port         int      `yaml:"port" toml:"port"`
+	IdentityFile string   `yaml:"identity_file" toml:"identity_file"`
+	Options      []string `yaml:"options" toml:"options"`
+	// Shell is the PowerShell executable on the host, default pwsh
+	Shell string `yaml:"shell" toml:"shell"`
+}
+
+// Duration is a time.Duration written as in Go, e.g. 30s or 1m30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// PathEnv names the config file instead of the well-known paths
+const PathEnv = "PSBRIDGE_CONFIG"
+
+// Env maps the variables overriding settings to what they override
+var Env = map[string]string{
+	"PSBRIDGE_SHELL":         "shell",
+	"PSBRIDGE_SCRIPT":        "script",
+	"PSBRIDGE_TIMEOUT":       "timeout",
+	"PSBRIDGE_SESSION_IDLE":  "session_idle",
+	"PSBRIDGE_SESSION_START": "session_start",
+	"PSBRIDGE_ENCODING":      "encoding",
+	"PSBRIDGE_SENTINELS":     "sentinels",
+	"PSBRIDGE_POOL_MIN":      "pool.min",
+	"PSBRIDGE_POOL_MAX":      "pool.max",
+	"PSBRIDGE_LOG_LEVEL":     "log.level",
+	"PSBRIDGE_TARGET":        "target",
+}
+
+// Paths are where LoadDefault looks for a file, first found wins:
+// config.yaml, config.yml or config.toml in psbridge under the user's
+// config directory, e.g. ~/.config/psbridge/config.yaml on Linux
+func Paths() []string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	dir = filepath.Join(dir, "psbridge")
+	return []string{filepath.Join(dir, "config.yaml"), filepath.Join(dir, "config.yml"), filepath.Join(dir, "config.toml")}
+}
+
+// LoadDefault loads the file PathEnv names, or the first of Paths there
+// is. Without one it returns a Config from the environment alone.
+func LoadDefault() (*Config, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return Load(path)
+	}
+	for _, path := range Paths() {
+		if _, err := os.Stat(path); err == nil {
+			return Load(path)
+		}
+	}
+	cfg := &Config{}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+// Load reads the file at path, as TOML if it ends in .toml and as YAML
+// otherwise, then applies the environment. Unknown keys are an error, so
+// a misspelt setting doesn't go unnoticed.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("psbridge: no config file %s", path)
+		}
+		return nil, err
+	}
+	cfg, err := Parse(data, filepath.Ext(path) == ".toml")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes a config file's contents, TOML or YAML, without looking
+// at the environment
+func Parse(data []byte, isTOML bool) (*Config, error) {
+	cfg := &Config{}
+	if isTOML {
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown setting %s", undecoded[0])
+		}
+		return cfg, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with the variables in Env that are set
+func (c *Config) applyEnv() error {
+	for name, key := range Env {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "shell":
+			c.Shell = v
+		case "script":
+			c.Script = v
+		case "timeout":
+			err = c.Timeout.UnmarshalText([]byte(v))
+		case "session_idle":
+			err = c.SessionIdle.UnmarshalText([]byte(v))
+		case "session_start":
+			err = c.SessionStart.UnmarshalText([]byte(v))
+		case "encoding":
+			c.Encoding = v
+		case "sentinels":
+			c.Sentinels, err = strconv.ParseBool(v)
+		case "pool.min":
+			c.Pool.Min, err = strconv.Atoi(v)
+		case "pool.max":
+			c.Pool.Max, err = strconv.Atoi(v)
+		case "log.level":
+			c.Log.Level = v
+		case "target":
+			c.Target = v
+		}
+		if err != nil {
+			return fmt.Errorf("psbridge: bad %s %q: %w", name, v, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the settings that are one of a few words
+func (c *Config) validate() error {
+	if _, err := c.encoding(); err != nil {
+		return err
+	}
+	if _, err := c.level(); err != nil {
+		return err
+	}
+	if c.Endpoint != nil && c.Endpoint.Name == "" {
+		return errors.New("endpoint has no name")
+	}
+	if r := c.Resources; r != nil && r.Priority != "" {
+		if _, err := psbridge.ParsePriority(r.Priority); err != nil {
+			return err
+		}
+	}
+	if c.JSONDepth < 0 || c.JSONDepth > 99 {
+		return fmt.Errorf("json_depth %d is out of range: want 1 to 99, or 0 for the default", c.JSONDepth)
+	}
+	if c.Target != "" {
+		if _, ok := c.Targets[c.Target]; !ok {
+			return fmt.Errorf("target %q isn't among targets", c.Target)
+		}
+	}
+	return nil
+}
+
+// OutputEncoding is Encoding as a psbridge.OutputEncoding
+func (c *Config) OutputEncoding() psbridge.OutputEncoding {
+	e, _ := c.encoding()
+	return e
+}
+
+func (c *Config) encoding() (psbridge.OutputEncoding, error) {
+	for _, e := range []psbridge.OutputEncoding{psbridge.EncodingAuto, psbridge.EncodingUTF8, psbridge.EncodingUTF16} {
+		if c.Encoding == e.String() || c.Encoding == "" && e == psbridge.EncodingAuto {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown encoding %q: want auto, utf8 or utf16", c.Encoding)
+}
+
+func (c *Config) level() (slog.Level, error) {
+	var level slog.Level
+	if c.Log.Level == "" {
+		return level, nil
+	}
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return level, fmt.Errorf("unknown log level %q: want debug, info, warn or error", c.Log.Level)
+	}
+	return level, nil
+}
+
+// Apply sets client up as the config says: its shell, host flags,
+// timeouts, rate limit, encoding, logging and target. Script isn't applied, being what the
+// client was made for.
+func (c *Config) Apply(client *psbridge.Client) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.Shell != "" {
+		client.Shell = c.Shell
+	}
+	if f := c.Flags; f != nil {
+		client.Flags = psbridge.HostFlags{NoProfile: f.NoProfile, NoLogo: f.NoLogo, NonInteractive: f.NonInteractive, ExecutionPolicy: f.ExecutionPolicy}
+	}
+	client.Timeouts = psbridge.Timeouts{Default: time.Duration(c.Timeout), SessionIdle: time.Duration(c.SessionIdle), SessionStart: time.Duration(c.SessionStart)}
+	for op, d := range c.OpTimeouts {
+		if client.Timeouts.Ops == nil {
+			client.Timeouts.Ops = map[string]time.Duration{}
+		}
+		client.Timeouts.Ops[op] = time.Duration(d)
+	}
+	if r := c.RateLimit; r != nil {
+		client.RateLimiter = psbridge.NewRateLimiter(psbridge.RateLimit{PerSecond: r.PerSecond, Burst: r.Burst, FailFast: r.FailFast})
+	}
+	client.Encoding = c.OutputEncoding()
+	client.Sentinels = c.Sentinels
+	client.JSONDepth = c.JSONDepth
+	if e := c.Endpoint; e != nil {
+		client.Endpoint = &psbridge.Endpoint{Name: e.Name, ComputerName: e.Computer}
+	}
+	client.CheckTruncation = c.CheckTruncation
+	if r := c.Resources; r != nil {
+		client.Resources = psbridge.Resources{CPUs: r.CPUs, Memory: r.Memory}
+		if r.Priority != "" {
+			client.Resources.Priority, _ = psbridge.ParsePriority(r.Priority)
+		}
+	}
+	if len(c.Signers) > 0 {
+		client.Signatures = &psbridge.SignaturePolicy{Thumbprints: c.Signers}
+	}
+	if c.Log.Level != "" {
+		level, _ := c.level()
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+		client.Logger = psbridge.NewSlogLogger(slog.New(handler))
+		client.LogPayload = c.Log.Payload
+	}
+	if c.Target != "" {
+		t := c.Targets[c.Target]
+		client.SSH = &psbridge.SSHHost{Host: t.Host, User: t.User, Port: t.Port, IdentityFile: t.IdentityFile, Options: t.Options}
+		// There is no copy of the script on the host to pass to -File
+		client.Mode = psbridge.ExecEncodedCommand
+		client.Shell = t.Shell
+	}
+	return nil
+}
+
+// PoolConfig is Pool as a psbridge.PoolConfig
+func (c *Config) PoolConfig() psbridge.PoolConfig {
+	return psbridge.PoolConfig{
+		Min:          c.Pool.Min,
+		Max:          c.Pool.Max,
+		IdleTimeout:  time.Duration(c.Pool.IdleTimeout),
+		PingInterval: time.Duration(c.Pool.PingInterval),
+	}
+}
@@ -0,0 +1,197 @@
+package psbridge
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// typeKey marks a JSON object that stands for a typed PowerShell value;
+// the script rebuilds the value before the operation sees its data
+const typeKey = "$psbridge"
+
+// SecureString is a secret a script receives as a System.Security.SecureString.
+// It never prints: String returns Redacted, and the value is redacted from
+// logged payloads whatever the client's SecretPattern.
+//
+// A local process gets it sealed with a key that only that process is
+// given, through its environment, so the plain text isn't in the request
+// at all. SSH and WinRM have no such side channel and send it inside their
+// own encrypted connection.
+type SecureString string
+
+func (s SecureString) String() string   { return Redacted }
+func (s SecureString) GoString() string { return `"` + Redacted + `"` }
+
+// secureJSON is how a SecureString travels: Secret until sealed, then Sealed
+type secureJSON struct {
+	Type   string `json:"$psbridge"`
+	Secret string `json:"secret,omitempty"`
+	Sealed string `json:"sealed,omitempty"`
+}
+
+func (s SecureString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(secureJSON{Type: "securestring", Secret: string(s)})
+}
+
+// UnmarshalJSON accepts the form MarshalJSON writes, or a plain string.
+// Sealed values can't be read back.
+func (s *SecureString) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, (*string)(s))
+	}
+	var v secureJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Sealed != "" {
+		return fmt.Errorf("psbridge: cannot decode a sealed SecureString")
+	}
+	*s = SecureString(v.Secret)
+	return nil
+}
+
+// secureLiteral starts a SecureString's PowerShell form, so runCommand can
+// tell a script holding one
+const secureLiteral = "(ConvertTo-SecureString -String "
+
+func (s SecureString) psLiteral() string {
+	return secureLiteral + quotePS(string(s)) + " -AsPlainText -Force)"
+}
+
+// Credential is a user name and password a script receives as a
+// PSCredential, ready to pass to a cmdlet's -Credential
+type Credential struct {
+	UserName string
+	Password SecureString
+}
+
+type credentialJSON struct {
+	Type     string       `json:"$psbridge"`
+	UserName string       `json:"userName"`
+	Password SecureString `json:"password"`
+}
+
+func (c Credential) MarshalJSON() ([]byte, error) {
+	return json.Marshal(credentialJSON{Type: "credential", UserName: c.UserName, Password: c.Password})
+}
+
+func (c *Credential) UnmarshalJSON(b []byte) error {
+	var v credentialJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = Credential{UserName: v.UserName, Password: v.Password}
+	return nil
+}
+
+func (c Credential) psLiteral() string {
+	return "([pscredential]::new(" + quotePS(c.UserName) + ", " + c.Password.psLiteral() + "))"
+}
+
+// sealKeyEnv passes a process its seal key. The script reads it and
+// removes it from its environment before running anything.
+const sealKeyEnv = "PSBRIDGE_SEAL_KEY"
+
+// newSealKey returns an AES-256 key followed by an HMAC-SHA256 key
+func newSealKey() ([]byte, error) {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("seal key: %w", err)
+	}
+	return key, nil
+}
+
+// withSealKey adds key to env, where a nil env means the inherited one
+func withSealKey(env []string, key []byte) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env, sealKeyEnv+"="+base64.StdEncoding.EncodeToString(key))
+}
+
+// hasTyped is a quick check for marked values of one kind in data
+func hasTyped(data []byte, kind string) bool {
+	return bytes.Contains(data, []byte(`"`+typeKey+`":"`+kind+`"`))
+}
+
+// hasSecureStrings is a quick check for data that seal would change
+func hasSecureStrings(data []byte) bool {
+	return hasTyped(data, "securestring")
+}
+
+// seal replaces the secret of every SecureString in data with its
+// encryption under key. Without a key, data is sent as it is.
+func seal(data []byte, key []byte) ([]byte, error) {
+	if key == nil || !hasSecureStrings(data) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("seal secrets: %w", err)
+	}
+	if err := sealValue(v, key); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func sealValue(v any, key []byte) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if secret, ok := v["secret"].(string); ok && v[typeKey] == "securestring" {
+			sealed, err := sealSecret([]byte(secret), key)
+			if err != nil {
+				return err
+			}
+			delete(v, "secret")
+			v["sealed"] = sealed
+			return nil
+		}
+		for _, item := range v {
+			if err := sealValue(item, key); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := sealValue(item, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sealSecret encrypts with AES-256-CBC and authenticates with HMAC-SHA256,
+// which Windows PowerShell's .NET Framework can undo as well as pwsh:
+// base64 of IV, ciphertext, then the MAC of both
+func sealSecret(plain, key []byte) (string, error) {
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return "", fmt.Errorf("seal secrets: %w", err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	out := make([]byte, aes.BlockSize+len(padded), aes.BlockSize+len(padded)+sha256.Size)
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		return "", fmt.Errorf("seal secrets: %w", err)
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], padded)
+	clear(padded)
+
+	mac := hmac.New(sha256.New, key[32:])
+	mac.Write(out)
+	out = mac.Sum(out)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
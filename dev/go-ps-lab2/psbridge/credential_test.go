@@ -0,0 +1,87 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Scripts holding a SecureString reach PowerShell on stdin, never on its
+// command line
+func TestSecretsOffCommandLine(t *testing.T) {
+	const secret = "hunter2-plain"
+	tmpl, err := ParseTemplate("login", "Connect-Thing -Credential {{ps .}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := map[string]func(*Client) ([]byte, error){
+		"RunScript": func(c *Client) ([]byte, error) {
+			return c.RunScript(context.Background(), struct{ Password SecureString }{secret})
+		},
+		"RunTemplate": func(c *Client) ([]byte, error) {
+			return c.RunTemplate(context.Background(), tmpl, Credential{UserName: "me", Password: secret})
+		},
+	}
+	for _, mode := range []ExecMode{ExecFile, ExecEncodedCommand} {
+		for name, run := range runs {
+			t.Run(mode.String()+"/"+name, func(t *testing.T) {
+				script := filepath.Join(t.TempDir(), "login.ps1")
+				if err := os.WriteFile(script, []byte("param($Password)"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				c := fakeClient(t, "argv", WithExecMode(mode))
+				c.Script = script
+				out, err := run(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got fakeCommandLine
+				if err := json.Unmarshal(out, &got); err != nil {
+					t.Fatalf("%v in %q", err, out)
+				}
+				for _, arg := range got.Args {
+					if strings.Contains(arg, secret) || strings.Contains(decodeArg(arg), secret) {
+						t.Errorf("secret on the command line: %q", arg)
+					}
+				}
+				if !strings.Contains(decodeStdin(t, got.Stdin), secret) {
+					t.Errorf("secret not sent on stdin: %q", got.Stdin)
+				}
+			})
+		}
+	}
+}
+
+// decodeArg is arg decoded as an -EncodedCommand, or "" if it isn't one
+func decodeArg(arg string) string {
+	b, err := base64.StdEncoding.DecodeString(arg)
+	if err != nil || len(b)%2 != 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for i := 0; i < len(b); i += 2 {
+		sb.WriteRune(rune(b[i]) | rune(b[i+1])<<8)
+	}
+	return sb.String()
+}
+
+var stdinScript = regexp.MustCompile(`FromBase64String\('([^']*)'\)`)
+
+// decodeStdin is the script stdinCommand wrote to stdin
+func decodeStdin(t *testing.T, stdin string) string {
+	t.Helper()
+	m := stdinScript.FindStringSubmatch(stdin)
+	if m == nil {
+		return ""
+	}
+	b, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time is a time.Time that reads both ways PowerShell writes dates to
+// JSON: Windows PowerShell's "\/Date(1699999999999)\/", milliseconds since
+// the epoch with an optional offset, and PowerShell 7's ISO 8601 with
+// anything from no fractional seconds to seven digits of them. It writes
+// itself as .NET's round-trip format, which [datetime]::Parse and both
+// ConvertFrom-Jsons read back exactly.
+//
+// time.Time fields decoded by Invoke and the other calls taking Go values
+// accept Windows PowerShell's form too, so Time is for where there is no
+// field to go by, such as values in an any.
+type Time struct{ time.Time }
+
+// psTimeLayout is .NET's round-trip format, "o": seven fractional digits,
+// as many as a DateTime holds, and an offset or Z
+const psTimeLayout = "2006-01-02T15:04:05.0000000Z07:00"
+
+// dotNetDate matches Windows PowerShell's dates; JSON may escape the
+// slashes or not
+var dotNetDate = regexp.MustCompile(`^\\?/Date\((-?\d+)([+-]\d{4})?\)\\?/$`)
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatPSTime(t.Time))
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("psbridge: decode time: %w", err)
+	}
+	v, err := ParseTime(s)
+	if err != nil {
+		return err
+	}
+	t.Time = v
+	return nil
+}
+
+// ParseTime parses a date as PowerShell writes it to JSON or CLIXML: see
+// Time. Times without an offset, DateTimeKind.Unspecified ones, are taken
+// as UTC.
+func ParseTime(s string) (time.Time, error) {
+	if m := dotNetDate.FindStringSubmatch(s); m != nil {
+		ms, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("psbridge: bad date %q", s)
+		}
+		t := time.UnixMilli(ms).UTC()
+		// The offset is the writer's zone, the milliseconds are UTC all the
+		// same
+		if m[2] != "" {
+			hours, _ := strconv.Atoi(m[2][1:3])
+			minutes, _ := strconv.Atoi(m[2][3:])
+			offset := hours*3600 + minutes*60
+			if m[2][0] == '-' {
+				offset = -offset
+			}
+			t = t.In(time.FixedZone("", offset))
+		}
+		return t, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("psbridge: bad date %q", s)
+}
+
+// formatPSTime writes t in .NET's round-trip format, dropping what a
+// DateTime can't hold past 100ns
+func formatPSTime(t time.Time) string {
+	return t.Truncate(100 * time.Nanosecond).Format(psTimeLayout)
+}
+
+// isDotNetDate reports whether s is in Windows PowerShell's date form
+func isDotNetDate(s string) bool {
+	return strings.Contains(s, "Date(") && dotNetDate.MatchString(s)
+}
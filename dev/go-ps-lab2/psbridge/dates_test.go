@@ -0,0 +1,91 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	utc := time.Date(2023, 11, 14, 22, 13, 19, 999_000_000, time.UTC)
+	tests := []struct {
+		in     string
+		want   time.Time
+		offset int
+		err    bool
+	}{
+		{`/Date(1699999999999)/`, utc, 0, false},
+		{`\/Date(1699999999999)\/`, utc, 0, false},
+		{`/Date(1699999999999+0130)/`, utc, 90 * 60, false},
+		{`/Date(1699999999999-0500)/`, utc, -5 * 3600, false},
+		{`/Date(-1000)/`, time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), 0, false},
+		{"2023-11-14T22:13:19Z", utc.Truncate(time.Second), 0, false},
+		{"2023-11-14T22:13:19.999Z", utc, 0, false},
+		{"2023-11-14T22:13:19.9990000+00:00", utc, 0, false},
+		{"2023-11-14T23:13:19.999+01:00", utc, 3600, false},
+		{"2023-11-14T22:13:19.999", utc, 0, false},
+		{"2023-11-14T22:13:19.1234567", time.Date(2023, 11, 14, 22, 13, 19, 123_456_700, time.UTC), 0, false},
+		{"/Date(abc)/", time.Time{}, 0, true},
+		{"/Date(99999999999999999999)/", time.Time{}, 0, true},
+		{"14/11/2023", time.Time{}, 0, true},
+		{"", time.Time{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTime(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if _, offset := got.Zone(); offset != tt.offset {
+				t.Errorf("offset = %d, want %d", offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestTimeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   time.Time
+		want string
+	}{
+		{"utc", time.Date(2023, 11, 14, 22, 13, 19, 0, time.UTC), `"2023-11-14T22:13:19.0000000Z"`},
+		{"past 100ns", time.Date(2023, 11, 14, 22, 13, 19, 123_456_789, time.UTC), `"2023-11-14T22:13:19.1234567Z"`},
+		{"offset", time.Date(2023, 11, 14, 22, 13, 19, 0, time.FixedZone("", -7*3600)), `"2023-11-14T22:13:19.0000000-07:00"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(Time{tt.in})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Fatalf("marshal = %s, want %s", b, tt.want)
+			}
+			var back Time
+			if err := json.Unmarshal(b, &back); err != nil {
+				t.Fatal(err)
+			}
+			if !back.Equal(tt.in.Truncate(100 * time.Nanosecond)) {
+				t.Errorf("round trip = %v, want %v", back, tt.in)
+			}
+		})
+	}
+
+	var tm Time
+	if err := json.Unmarshal([]byte("null"), &tm); err != nil || !tm.IsZero() {
+		t.Errorf("null = %v, %v", tm, err)
+	}
+	if err := json.Unmarshal([]byte("12"), &tm); err == nil {
+		t.Error("a number decoded as a time")
+	}
+}
@@ -0,0 +1,145 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultJSONDepth is how many levels of a result the shim writes unless
+// told otherwise
+const DefaultJSONDepth = 10
+
+// WithJSONDepth sets how many levels of each result the shim writes;
+// ConvertTo-Json writes anything deeper as the object's ToString(), such as
+// "System.Collections.Hashtable" or "@{Name=x}". It takes up to 99. Scripts
+// of a client using it must accept -JsonDepth, as the shim does.
+func WithJSONDepth(depth int) Option {
+	return func(c *Client) { c.JSONDepth = depth }
+}
+
+// WithTruncationCheck fails calls whose results hold what look like the
+// strings ConvertTo-Json leaves past its depth with *TruncatedError
+func WithTruncationCheck() Option {
+	return func(c *Client) { c.CheckTruncation = true }
+}
+
+// TruncatedError reports that a result was cut short by ConvertTo-Json's
+// depth
+type TruncatedError struct {
+	Op string
+	// Depth is the JSON depth the result was written with
+	Depth int
+	// Paths are where in the result the objects were cut, such as
+	// $.items[0].owner
+	Paths []string
+}
+
+func (e *TruncatedError) Error() string {
+	where := e.Paths[0]
+	if len(e.Paths) > 1 {
+		where += fmt.Sprintf(" and %d more", len(e.Paths)-1)
+	}
+	return fmt.Sprintf("psbridge: %s: result truncated at %s past a JSON depth of %d", e.Op, where, e.Depth)
+}
+
+// truncatedString matches what ConvertTo-Json writes for an object past
+// its depth: a PSCustomObject's @{...} or a .NET type name, as most other
+// objects' ToString() is
+var truncatedString = regexp.MustCompile("^(@\\{.*\\}|(System|Microsoft)(\\.[A-Za-z_][\\w`]*)+(\\[[^\\]]*\\])*)$")
+
+// TruncatedPaths lists where the JSON in r holds strings that look like
+// objects ConvertTo-Json cut off. It can't tell them from a script that
+// returns such strings itself, nor see arrays past the depth, which become
+// their items joined by spaces.
+func TruncatedPaths(r io.Reader) ([]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	// Each level open is an array index or an object's key; keyed says
+	// whether the next string is a key
+	type level struct {
+		array bool
+		index int
+		key   string
+		keyed bool
+	}
+	var (
+		stack []level
+		paths []string
+	)
+	path := func() string {
+		var b strings.Builder
+		b.WriteString("$")
+		for _, l := range stack {
+			if l.array {
+				b.WriteString("[" + strconv.Itoa(l.index) + "]")
+			} else {
+				b.WriteString("." + l.key)
+			}
+		}
+		return b.String()
+	}
+	// value moves past a value of the innermost level
+	value := func() {
+		if n := len(stack); n > 0 {
+			if stack[n-1].array {
+				stack[n-1].index++
+			} else {
+				stack[n-1].keyed = false
+			}
+		}
+	}
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return paths, nil
+		}
+		if err != nil {
+			return paths, err
+		}
+		if n := len(stack); n > 0 && !stack[n-1].array && !stack[n-1].keyed {
+			if key, ok := tok.(string); ok {
+				stack[n-1].key = key
+				stack[n-1].keyed = true
+				continue
+			}
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			switch tok {
+			case '[', '{':
+				stack = append(stack, level{array: tok == '['})
+			default:
+				stack = stack[:len(stack)-1]
+				value()
+			}
+		case string:
+			if truncatedString.MatchString(tok) {
+				paths = append(paths, path())
+			}
+			value()
+		default:
+			value()
+		}
+	}
+}
+
+// checkTruncated fails res if checking and it looks truncated
+func checkTruncated(op string, res *Result, check bool, depth int) error {
+	if !check {
+		return nil
+	}
+	paths, err := TruncatedPaths(res.Open())
+	if err != nil || len(paths) == 0 {
+		return nil
+	}
+	if depth <= 0 {
+		depth = DefaultJSONDepth
+	}
+	res.Close()
+	return &TruncatedError{Op: op, Depth: depth, Paths: paths}
+}
@@ -0,0 +1,59 @@
+package psbridge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ErrShellNotFound is returned by FindShell when no PowerShell is
+// installed, wrapped in a *ShellNotFoundError
+var ErrShellNotFound = errors.New("psbridge: no pwsh or powershell executable found")
+
+// ShellNotFoundError reports where FindShell looked and how to install
+// PowerShell on this platform
+type ShellNotFoundError struct {
+	// Searched are the locations tried besides PATH
+	Searched []string
+	// Hint is how to install PowerShell here
+	Hint string
+}
+
+func (e *ShellNotFoundError) Error() string {
+	return fmt.Sprintf("psbridge: PowerShell not installed: no pwsh on PATH or in %d known locations; %s", len(e.Searched), e.Hint)
+}
+
+func (e *ShellNotFoundError) Unwrap() error { return ErrShellNotFound }
+
+// FindShell locates a PowerShell executable. It prefers PowerShell 7 (pwsh)
+// on PATH, then pwsh in its usual install locations (on Linux and macOS
+// also the snap, Homebrew and dotnet tool ones), and finally falls back to
+// Windows PowerShell (powershell.exe). Finding none, it fails with a
+// *ShellNotFoundError.
+func FindShell() (string, error) {
+	if path, err := exec.LookPath("pwsh"); err == nil {
+		return path, nil
+	}
+	known := knownPwshPaths()
+	for _, path := range known {
+		if isFile(path) {
+			return path, nil
+		}
+	}
+	if path, err := exec.LookPath("powershell"); err == nil {
+		return path, nil
+	}
+	windows := knownWindowsPowerShellPaths()
+	for _, path := range windows {
+		if isFile(path) {
+			return path, nil
+		}
+	}
+	return "", &ShellNotFoundError{Searched: append(known, windows...), Hint: installHint()}
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package psbridge
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// knownPwshPaths lists where the packages, snap, Homebrew and the dotnet
+// global tool put pwsh, for when they aren't on PATH, as under launchd,
+// systemd or cron
+func knownPwshPaths() []string {
+	paths := []string{
+		"/usr/bin/pwsh",
+		"/usr/local/bin/pwsh",
+		"/opt/microsoft/powershell/7/pwsh",
+		"/snap/bin/pwsh",
+		// Homebrew on Apple silicon, and on Linux
+		"/opt/homebrew/bin/pwsh",
+		"/home/linuxbrew/.linuxbrew/bin/pwsh",
+		// The macOS .pkg
+		"/usr/local/microsoft/powershell/7/pwsh",
+		"/usr/bin/pwsh-preview",
+		"/opt/microsoft/powershell/7-preview/pwsh",
+		"/snap/bin/pwsh-preview",
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".dotnet", "tools", "pwsh"))
+	}
+	return paths
+}
+
+func knownWindowsPowerShellPaths() []string { return nil }
+
+func installHint() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "install it with: brew install --cask powershell (see https://aka.ms/install-powershell)"
+	case "linux":
+		return "install it with: sudo snap install powershell --classic, or your distribution's package from https://aka.ms/install-powershell"
+	}
+	return "see https://aka.ms/install-powershell"
+}
@@ -0,0 +1,33 @@
+package psbridge
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// knownPwshPaths lists where the PowerShell 7 MSI installs pwsh.exe
+func knownPwshPaths() []string {
+	var paths []string
+	for _, env := range []string{"ProgramFiles", "ProgramW6432", "ProgramFiles(x86)"} {
+		if dir := os.Getenv(env); dir != "" {
+			paths = append(paths,
+				filepath.Join(dir, "PowerShell", "7", "pwsh.exe"),
+				filepath.Join(dir, "PowerShell", "7-preview", "pwsh.exe"),
+			)
+		}
+	}
+	return paths
+}
+
+// knownWindowsPowerShellPaths lists where powershell.exe ships with Windows
+func knownWindowsPowerShellPaths() []string {
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		root = `C:\Windows`
+	}
+	return []string{filepath.Join(root, "System32", "WindowsPowerShell", "v1.0", "powershell.exe")}
+}
+
+func installHint() string {
+	return "install it with: winget install --id Microsoft.PowerShell (see https://aka.ms/install-powershell)"
+}
@@ -0,0 +1,181 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DesiredStateOp is the operation the shim tests and applies desired
+// state with
+const DesiredStateOp = "desired-state"
+
+// DSCResource is one thing a machine should be, described as a DSC
+// resource and its properties. A resource with a Module runs through
+// Invoke-DscResource, which needs PSDesiredStateConfiguration 2 on
+// PowerShell 7. Without one, Registry and Service are checked and set by
+// the shim itself, taking the properties PSDesiredStateConfiguration's
+// resources of those names do, so the same description works either way.
+type DSCResource struct {
+	// ID names the resource in reports, default Name[index]
+	ID string `json:"id"`
+	// Name is the resource, e.g. Registry, Service or File
+	Name string `json:"name"`
+	// Module is the module with the resource, e.g.
+	// PSDesiredStateConfiguration, and ModuleVersion its version, empty for
+	// the newest installed
+	Module        string `json:"module,omitempty"`
+	ModuleVersion string `json:"moduleVersion,omitempty"`
+	// Properties are the resource's key and desired properties
+	Properties map[string]any `json:"properties"`
+}
+
+// RegistryState describes value being set under the key at path, which
+// is created if missing
+func RegistryState(path string, value RegistryValue) DSCResource {
+	var data []string
+	switch value.Kind {
+	case RegString, RegExpandString:
+		data = []string{value.String}
+	case RegMultiString:
+		data = value.Strings
+	case RegDWord, RegQWord:
+		data = []string{strconv.FormatUint(value.Number, 10)}
+	case RegBinary:
+		data = []string{hex.EncodeToString(value.Binary)}
+	}
+	name := value.Name
+	if name == "" {
+		name = "(default)"
+	}
+	return DSCResource{
+		ID:   `Registry:` + path + `\` + name,
+		Name: "Registry",
+		Properties: map[string]any{
+			"Key":       path,
+			"ValueName": value.Name,
+			"ValueType": string(value.Kind),
+			"ValueData": data,
+			"Ensure":    "Present",
+		},
+	}
+}
+
+// ServiceState describes the service name being in status, Running or
+// Stopped, and starting as startType; either may be empty to leave it be
+func ServiceState(name string, status ServiceStatus, startType ServiceStartType) DSCResource {
+	props := map[string]any{"Name": name}
+	if status != "" {
+		props["State"] = string(status)
+	}
+	if startType != "" {
+		props["StartupType"] = string(startType)
+	}
+	return DSCResource{ID: "Service:" + name, Name: "Service", Properties: props}
+}
+
+// DSCResult is how one resource stands
+type DSCResult struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// InDesiredState is whether the resource is as described: when
+	// applying, after it was applied
+	InDesiredState bool `json:"inDesiredState"`
+	// Changed reports that applying the resource changed the machine
+	Changed        bool `json:"changed"`
+	RebootRequired bool `json:"rebootRequired"`
+	// Error is why testing or applying the resource failed. The other
+	// resources are still tested or applied.
+	Error string `json:"error,omitempty"`
+}
+
+func (r DSCResult) String() string {
+	switch {
+	case r.Error != "":
+		return r.ID + ": failed: " + r.Error
+	case !r.InDesiredState:
+		return r.ID + ": not in desired state"
+	case r.Changed:
+		return r.ID + ": changed"
+	}
+	return r.ID + ": in desired state"
+}
+
+// DSCReport is the outcome of TestDesiredState or ApplyDesiredState, one
+// result per resource in the order given
+type DSCReport struct {
+	Resources []DSCResult `json:"resources"`
+}
+
+// Compliant reports whether every resource is in its desired state
+func (r *DSCReport) Compliant() bool {
+	return len(r.Drifted()) == 0
+}
+
+// Drifted returns the results of resources not in their desired state,
+// including those that failed
+func (r *DSCReport) Drifted() []DSCResult {
+	var drifted []DSCResult
+	for _, res := range r.Resources {
+		if !res.InDesiredState || res.Error != "" {
+			drifted = append(drifted, res)
+		}
+	}
+	return drifted
+}
+
+// RebootRequired reports whether any resource applied needs a reboot to
+// take effect
+func (r *DSCReport) RebootRequired() bool {
+	for _, res := range r.Resources {
+		if res.RebootRequired {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *DSCReport) String() string {
+	lines := make([]string, len(r.Resources))
+	for i, res := range r.Resources {
+		lines[i] = res.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type desiredStateRequest struct {
+	Resources []DSCResource `json:"resources"`
+	Apply     bool          `json:"apply,omitempty"`
+}
+
+func desiredState(ctx context.Context, inv Invoker, resources []DSCResource, apply bool, opts []CallOption) (*DSCReport, error) {
+	req := desiredStateRequest{Resources: make([]DSCResource, len(resources)), Apply: apply}
+	for i, r := range resources {
+		if r.Name == "" {
+			return nil, fmt.Errorf("psbridge: desired state resource %d has no name", i)
+		}
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("%s[%d]", r.Name, i)
+		}
+		req.Resources[i] = r
+	}
+	results, err := InvokeContext[desiredStateRequest, []DSCResult](ctx, inv, DesiredStateOp, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DSCReport{Resources: results}, nil
+}
+
+// TestDesiredState reports which resources are in their desired state,
+// changing nothing
+func TestDesiredState(ctx context.Context, inv Invoker, resources []DSCResource, opts ...CallOption) (*DSCReport, error) {
+	return desiredState(ctx, inv, resources, false, opts)
+}
+
+// ApplyDesiredState tests each resource in turn and sets those not in
+// their desired state, so applying twice changes nothing the second time
+func ApplyDesiredState(ctx context.Context, inv Invoker, resources []DSCResource, opts ...CallOption) (*DSCReport, error) {
+	return desiredState(ctx, inv, resources, true, opts)
+}
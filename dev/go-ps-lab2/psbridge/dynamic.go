@@ -0,0 +1,358 @@
+package psbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Value is a result decoded without a Go type to decode it into, for
+// operations whose shape isn't known in advance. It holds nil, a bool, a
+// string, a number as the client's NumberMode decodes it, a []Value or an
+// *Object, and its methods drill into it without panicking: a step that
+// doesn't exist gives the zero Value, whose Exists is false.
+type Value struct {
+	v      any
+	exists bool
+}
+
+// Object is a JSON object in a Value, keeping its keys in the order the
+// script wrote them
+type Object struct {
+	keys   []string
+	values map[string]Value
+}
+
+// InvokeDynamic runs op with req as its payload, as Invoke does, and
+// decodes the result into a Value
+func InvokeDynamic[TReq any](inv Invoker, op string, req TReq, opts ...CallOption) (Value, error) {
+	return InvokeDynamicContext(context.Background(), inv, op, req, opts...)
+}
+
+// InvokeDynamicContext is InvokeDynamic bounded by ctx
+func InvokeDynamicContext[TReq any](ctx context.Context, inv Invoker, op string, req TReq, opts ...CallOption) (Value, error) {
+	res, err := invokeResult[TReq, struct{}](ctx, inv, op, req, opts)
+	if err != nil {
+		return Value{}, err
+	}
+	defer res.Close()
+	v, err := DecodeValue(res.Open(), res.numbers)
+	if err != nil {
+		return Value{}, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return v, nil
+}
+
+// DecodeValue decodes the JSON r holds into a Value with numbers decoded
+// as mode says. Binary values the script marked come out as base64
+// strings, as they do in an any, which Value.Bytes decodes.
+func DecodeValue(r io.Reader, mode NumberMode) (Value, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	v, err := decodeValue(dec, mode)
+	if err != nil {
+		return Value{}, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Value{}, errors.New("psbridge: data after the top-level value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder, mode NumberMode) (Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return Value{}, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			items := []Value{}
+			for dec.More() {
+				item, err := decodeValue(dec, mode)
+				if err != nil {
+					return Value{}, err
+				}
+				items = append(items, item)
+			}
+			_, err := dec.Token()
+			return Value{v: items, exists: true}, err
+		}
+		obj := &Object{values: map[string]Value{}}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return Value{}, err
+			}
+			item, err := decodeValue(dec, mode)
+			if err != nil {
+				return Value{}, err
+			}
+			obj.set(key.(string), item)
+		}
+		if _, err := dec.Token(); err != nil {
+			return Value{}, err
+		}
+		if encoded, ok := obj.values["base64"].v.(string); ok && obj.values[typeKey].v == "bytes" {
+			return Value{v: encoded, exists: true}, nil
+		}
+		return Value{v: obj, exists: true}, nil
+	case json.Number:
+		switch mode {
+		case NumbersFloat:
+			f, err := tok.Float64()
+			if err != nil {
+				return Value{}, err
+			}
+			return Value{v: f, exists: true}, nil
+		case NumbersExact:
+			return Value{v: exactNumber(tok), exists: true}, nil
+		}
+	}
+	return Value{v: tok, exists: true}, nil
+}
+
+func (o *Object) set(key string, v Value) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// Keys are the object's keys in order
+func (o *Object) Keys() []string {
+	return o.keys
+}
+
+// Len is how many keys the object has
+func (o *Object) Len() int {
+	return len(o.keys)
+}
+
+// Get is the value of key, matched exactly or else ignoring case as
+// PowerShell matches property names
+func (o *Object) Get(key string) (Value, bool) {
+	if v, ok := o.values[key]; ok {
+		return v, true
+	}
+	for _, k := range o.keys {
+		if strings.EqualFold(k, key) {
+			return o.values[k], true
+		}
+	}
+	return Value{}, false
+}
+
+// All yields the object's keys and values in order
+func (o *Object) All() iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for _, k := range o.keys {
+			if !yield(k, o.values[k]) {
+				return
+			}
+		}
+	}
+}
+
+// Map is the object as a map[string]any, as Value.Interface makes it
+func (o *Object) Map() map[string]any {
+	m := make(map[string]any, len(o.keys))
+	for k, v := range o.values {
+		m[k] = v.Interface()
+	}
+	return m
+}
+
+// MarshalJSON writes the object with its keys in order
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		item, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(item)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Get follows path into v, each step a string key of an object, as
+// Object.Get matches it, or an int index of an array
+func (v Value) Get(path ...any) Value {
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			obj, ok := v.v.(*Object)
+			if !ok {
+				return Value{}
+			}
+			if v, ok = obj.Get(step); !ok {
+				return Value{}
+			}
+		case int:
+			items, ok := v.v.([]Value)
+			if !ok || step < 0 || step >= len(items) {
+				return Value{}
+			}
+			v = items[step]
+		default:
+			return Value{}
+		}
+	}
+	return v
+}
+
+// Exists reports whether v is there, even if null, rather than the zero
+// Value a missing step gives
+func (v Value) Exists() bool {
+	return v.exists
+}
+
+// IsNull reports whether v is JSON null or missing
+func (v Value) IsNull() bool {
+	return v.v == nil
+}
+
+// Object is v as an object
+func (v Value) Object() (*Object, bool) {
+	obj, ok := v.v.(*Object)
+	return obj, ok
+}
+
+// Items are v's elements if it is an array
+func (v Value) Items() ([]Value, bool) {
+	items, ok := v.v.([]Value)
+	return items, ok
+}
+
+// Len is how many elements or keys v has, or 0 if it is neither an array
+// nor an object
+func (v Value) Len() int {
+	switch x := v.v.(type) {
+	case []Value:
+		return len(x)
+	case *Object:
+		return x.Len()
+	}
+	return 0
+}
+
+// Text is v if it is a string
+func (v Value) Text() (string, bool) {
+	s, ok := v.v.(string)
+	return s, ok
+}
+
+// Bool is v if it is a bool
+func (v Value) Bool() (bool, bool) {
+	b, ok := v.v.(bool)
+	return b, ok
+}
+
+// Int is v if it is a number with an int64 value, whatever the NumberMode
+// decoded it as
+func (v Value) Int() (int64, bool) {
+	switch n := v.v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// Float is v's nearest float64 if it is a number
+func (v Value) Float() (float64, bool) {
+	switch n := v.v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, true
+	case Decimal:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Bytes is v decoded if it is base64, as binary values come out
+func (v Value) Bytes() ([]byte, bool) {
+	s, ok := v.v.(string)
+	if !ok {
+		return nil, false
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	return b, err == nil
+}
+
+// Interface is v as encoding/json decodes into an any, objects becoming
+// map[string]any and arrays []any; numbers stay as the NumberMode has them
+func (v Value) Interface() any {
+	switch x := v.v.(type) {
+	case *Object:
+		return x.Map()
+	case []Value:
+		items := make([]any, len(x))
+		for i, item := range x {
+			items[i] = item.Interface()
+		}
+		return items
+	}
+	return v.v
+}
+
+// Decode unmarshals v into out, as Invoke would have decoded it there, for
+// typing part of a result once it is found. Numbers landing in an any come
+// out as json.Number.
+func (v Value) Decode(out any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(data, out, NumbersJSON)
+}
+
+// MarshalJSON writes v back as JSON, objects with their keys in order
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch x := v.v.(type) {
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return nil, fmt.Errorf("psbridge: can't marshal %v", x)
+		}
+		return []byte(strconv.FormatFloat(x, 'g', -1, 64)), nil
+	case Decimal:
+		return []byte(x.String()), nil
+	}
+	return json.Marshal(v.v)
+}
@@ -0,0 +1,153 @@
+package psbridge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// OutputEncoding is the character set a script writes its stdout in
+type OutputEncoding int
+
+const (
+	// EncodingAuto leaves [Console]::OutputEncoding as the host has it and
+	// detects what arrives: UTF-8, with or without a BOM, or UTF-16 in
+	// either byte order, with or without one, as Windows PowerShell writes
+	// under some hosts
+	EncodingAuto OutputEncoding = iota
+	// EncodingUTF8 makes the script write UTF-8 without a BOM, whatever
+	// the console's code page. Anything outside ASCII, such as a file or
+	// user name, survives only this way on a console set to a legacy code
+	// page.
+	EncodingUTF8
+	// EncodingUTF16 makes the script write UTF-16LE, which Windows calls
+	// Unicode
+	EncodingUTF16
+)
+
+func (e OutputEncoding) String() string {
+	switch e {
+	case EncodingAuto:
+		return "auto"
+	case EncodingUTF8:
+		return "utf8"
+	case EncodingUTF16:
+		return "utf16"
+	}
+	return fmt.Sprintf("OutputEncoding(%d)", int(e))
+}
+
+// WithOutputEncoding has the script set [Console]::OutputEncoding to e on
+// start, and its output read as e. Whatever e, the script reads its stdin
+// as UTF-8, which is what it is sent.
+func WithOutputEncoding(e OutputEncoding) Option {
+	return func(c *Client) { c.Encoding = e }
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textDecoder turns a script's stdout into UTF-8 without a BOM. It looks
+// at the first bytes to tell the encoding, unless told it.
+type textDecoder struct {
+	r        *bufio.Reader
+	encoding OutputEncoding
+	started  bool
+	// order is set for UTF-16 output
+	order binary.ByteOrder
+	// raw passes bytes through untouched, as length-prefixed frames are
+	// written
+	raw bool
+	// out holds UTF-8 decoded but not yet read; carry the bytes of a code
+	// unit or surrogate pair split across reads
+	out   []byte
+	carry []byte
+	buf   []byte
+}
+
+func newTextDecoder(r io.Reader, e OutputEncoding) *textDecoder {
+	return &textDecoder{r: bufio.NewReader(r), encoding: e}
+}
+
+// start drops any byte order mark and settles the encoding
+func (d *textDecoder) start() {
+	d.started = true
+	head, _ := d.r.Peek(3)
+	switch {
+	case bytes.HasPrefix(head, utf8BOM):
+		d.r.Discard(3)
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		d.r.Discard(2)
+		d.order = binary.LittleEndian
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		d.r.Discard(2)
+		d.order = binary.BigEndian
+	case d.encoding == EncodingUTF16:
+		d.order = binary.LittleEndian
+	case d.encoding == EncodingAuto && len(head) >= 2 && head[0] != 0 && head[1] == 0:
+		// ASCII, such as the opening brace, in UTF-16LE
+		d.order = binary.LittleEndian
+	case d.encoding == EncodingAuto && len(head) >= 2 && head[0] == 0 && head[1] != 0:
+		d.order = binary.BigEndian
+	}
+}
+
+// passthrough makes every later byte go through as it is
+func (d *textDecoder) passthrough() { d.raw = true }
+
+func (d *textDecoder) Read(p []byte) (int, error) {
+	if !d.started {
+		d.start()
+	}
+	if len(d.out) > 0 {
+		n := copy(p, d.out)
+		d.out = d.out[n:]
+		return n, nil
+	}
+	if d.order == nil || d.raw {
+		return d.r.Read(p)
+	}
+
+	if d.buf == nil {
+		d.buf = make([]byte, 4096)
+	}
+	for len(d.out) == 0 {
+		n, err := d.r.Read(d.buf)
+		d.decode(append(d.carry, d.buf[:n]...))
+		if err != nil {
+			if len(d.carry) > 0 {
+				d.out = utf8.AppendRune(d.out, utf8.RuneError)
+				d.carry = nil
+			}
+			if len(d.out) == 0 {
+				return 0, err
+			}
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decode converts the whole code units in b, keeping back an odd byte or
+// a high surrogate waiting for its pair
+func (d *textDecoder) decode(b []byte) {
+	units := make([]uint16, 0, len(b)/2)
+	for len(b) >= 2 {
+		units = append(units, d.order.Uint16(b))
+		b = b[2:]
+	}
+	var keep []byte
+	if k := len(units); k > 0 && utf16.IsSurrogate(rune(units[k-1])) && units[k-1] < 0xDC00 {
+		keep = make([]byte, 2)
+		d.order.PutUint16(keep, units[k-1])
+		units = units[:k-1]
+	}
+	d.carry = append(keep, b...)
+	for _, r := range utf16.Decode(units) {
+		d.out = utf8.AppendRune(d.out, r)
+	}
+}
@@ -0,0 +1,54 @@
+package psbridge
+
+import "strings"
+
+// Endpoint is a registered PowerShell session configuration, typically a
+// JEA (Just Enough Administration) endpoint, for operations to run in with
+// only the capabilities its roles grant
+type Endpoint struct {
+	// Name is the configuration's, as Register-PSSessionConfiguration
+	// registered it
+	Name string
+	// ComputerName is where it is registered; empty for this machine
+	ComputerName string
+}
+
+// WithEndpoint runs operations in the session configuration e. The shim
+// opens a remoting session to it at start, failing if it can't, and sends
+// each operation there as the command of the same name with the request's
+// properties as parameters, or for CmdletOp as the command it names. Only
+// HostInfoOp, which then reports the endpoint's constraints, and BatchOp
+// are answered by the shim itself. It needs PowerShell remoting, so WinRM
+// on Windows; a Session saves connecting for every call.
+func WithEndpoint(e Endpoint) Option {
+	return func(c *Client) { c.Endpoint = &e }
+}
+
+// EndpointInfo is what HostInfo reports of a client's Endpoint
+type EndpointInfo struct {
+	Name         string `json:"name"`
+	ComputerName string `json:"computerName"`
+	// LanguageMode is the endpoint's, NoLanguage for most JEA ones
+	LanguageMode string `json:"languageMode"`
+	// Commands are the ones its roles make visible
+	Commands []EndpointCommand `json:"commands"`
+}
+
+// EndpointCommand is a command an endpoint exposes
+type EndpointCommand struct {
+	Name string `json:"name"`
+	// Type is its CommandType, e.g. Cmdlet or Function
+	Type   string `json:"type"`
+	Module string `json:"module,omitempty"`
+}
+
+// Allows reports whether the endpoint exposes the command name, ignoring
+// case as PowerShell does
+func (e *EndpointInfo) Allows(name string) bool {
+	for _, c := range e.Commands {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+	return false
+}
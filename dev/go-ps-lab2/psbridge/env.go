@@ -0,0 +1,125 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrEnvUnsupported is returned for calls with Env that the backend can't
+// deliver
+var ErrEnvUnsupported = errors.New("psbridge: backend can't set the environment for a call")
+
+// WithEnv adds env to the script's environment for one call. Repeated
+// options merge, later ones winning.
+func WithEnv(env map[string]string) CallOption {
+	return func(c *Call) {
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		maps.Copy(c.Env, env)
+	}
+}
+
+// WithOnlyEnv runs one call with exactly env, inheriting nothing. On
+// Windows PowerShell won't start without SystemRoot, so include it.
+// Sessions were started with their environment and reject this.
+func WithOnlyEnv(env map[string]string) CallOption {
+	return func(c *Call) {
+		c.Env = maps.Clone(env)
+		c.ReplaceEnv = true
+	}
+}
+
+// callEnv is the process environment for call, as exec.Cmd.Env wants it
+func callEnv(call *Call) []string {
+	// Non-nil even when empty: a nil Env means inherit
+	env := []string{}
+	if !call.ReplaceEnv {
+		env = os.Environ()
+	}
+	// exec keeps the last value for a repeated key, so appending overrides
+	// the inherited one; sort for a stable order
+	for _, k := range slices.Sorted(maps.Keys(call.Env)) {
+		env = append(env, k+"="+call.Env[k])
+	}
+	return env
+}
+
+// EnvOp is the operation the shim reads the Env: drive with
+const EnvOp = "env"
+
+// Environment is a set of environment variables by name
+type Environment map[string]string
+
+// EnvSnapshot reads the environment inv's script runs with. Taken from a
+// Session before and after a script, two snapshots show what it changed.
+func EnvSnapshot(ctx context.Context, inv Invoker, opts ...CallOption) (Environment, error) {
+	env, err := InvokeContext[struct{}, Environment](ctx, inv, EnvOp, struct{}{}, opts...)
+	if env == nil && err == nil {
+		env = Environment{}
+	}
+	return env, err
+}
+
+// EnvChange is one variable that differs between two snapshots. Old is
+// empty for an added variable, New for a removed one.
+type EnvChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// EnvDiff is what changed from one snapshot to another, each list sorted
+// by name
+type EnvDiff struct {
+	Added   []EnvChange `json:"added,omitempty"`
+	Removed []EnvChange `json:"removed,omitempty"`
+	Changed []EnvChange `json:"changed,omitempty"`
+}
+
+// Diff compares snapshot a with the later b. Names are compared exactly,
+// so on Windows a variable whose name only changed case is removed and
+// added.
+func Diff(a, b Environment) EnvDiff {
+	var d EnvDiff
+	for _, name := range slices.Sorted(maps.Keys(b)) {
+		old, ok := a[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, EnvChange{Name: name, New: b[name]})
+		case old != b[name]:
+			d.Changed = append(d.Changed, EnvChange{Name: name, Old: old, New: b[name]})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(a)) {
+		if _, ok := b[name]; !ok {
+			d.Removed = append(d.Removed, EnvChange{Name: name, Old: a[name]})
+		}
+	}
+	return d
+}
+
+// Empty reports whether the snapshots were the same
+func (d EnvDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// String lists the changes a line each: +NAME=new, -NAME=old, and
+// ~NAME=old -> new
+func (d EnvDiff) String() string {
+	var b strings.Builder
+	for _, c := range d.Added {
+		b.WriteString("+" + c.Name + "=" + c.New + "\n")
+	}
+	for _, c := range d.Removed {
+		b.WriteString("-" + c.Name + "=" + c.Old + "\n")
+	}
+	for _, c := range d.Changed {
+		b.WriteString("~" + c.Name + "=" + c.Old + " -> " + c.New + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrSessionClosed is returned when calling a Session after Close
+var ErrSessionClosed = errors.New("psbridge: session closed")
+
+// ErrUnknownOperation is returned by Registry.Check for operations that
+// were never registered
+var ErrUnknownOperation = errors.New("psbridge: unknown operation")
+
+// TimeoutError reports that a call was cut short because its context hit a
+// deadline or was canceled. The PowerShell process is killed when this happens.
+type TimeoutError struct {
+	// Op is the operation that was running
+	Op string
+	// Err is the context error, context.DeadlineExceeded or context.Canceled
+	Err error
+	// Deadline is when a call that ran out of time had to finish by, and
+	// Source which of its Timeouts set it, with Limit the timeout. Deadline
+	// is zero for errors from elsewhere than a call.
+	Deadline time.Time
+	Limit    time.Duration
+	Source   string
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Deadline.IsZero() {
+		return fmt.Sprintf("psbridge: %s: %v", e.Op, e.Err)
+	}
+	if e.Source == TimeoutContext {
+		return fmt.Sprintf("psbridge: %s: %v (deadline %s from the caller's context)", e.Op, e.Err, e.Deadline.Format(time.StampMilli))
+	}
+	return fmt.Sprintf("psbridge: %s: %v (deadline %s from the %s timeout of %v)", e.Op, e.Err, e.Deadline.Format(time.StampMilli), e.Source, e.Limit)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Timeout reports whether the call ran out of time rather than being canceled
+func (e *TimeoutError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// PSError is a PowerShell ErrorRecord as reported by the script's error
+// envelope
+type PSError struct {
+	// Type is the .NET exception type, e.g. System.IO.FileNotFoundException
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Category is the ErrorCategory name, e.g. ObjectNotFound
+	Category string `json:"category"`
+	// ErrorID is the FullyQualifiedErrorId
+	ErrorID string `json:"errorId"`
+	// TargetObject is the error's target, stringified on the PS side
+	TargetObject string `json:"targetObject,omitempty"`
+
+	ScriptName       string `json:"scriptName,omitempty"`
+	Line             int    `json:"line,omitempty"`
+	Column           int    `json:"column,omitempty"`
+	PositionMessage  string `json:"positionMessage,omitempty"`
+	ScriptStackTrace string `json:"scriptStackTrace,omitempty"`
+}
+
+func (e *PSError) Error() string {
+	msg := "powershell: " + e.Message
+	if e.Type != "" {
+		msg = fmt.Sprintf("powershell: %s: %s", e.Type, e.Message)
+	}
+	if e.ScriptName != "" && e.Line > 0 {
+		msg += fmt.Sprintf(" (at %s:%d:%d)", e.ScriptName, e.Line, e.Column)
+	}
+	return msg
+}
+
+// Exit codes worth telling apart in ExitError.Code
+const (
+	// ExitFailure is what pwsh returns when the script calls exit 1 or ends
+	// on an unhandled error
+	ExitFailure = 1
+	// ExitBadArguments means pwsh rejected its command line, e.g. an
+	// unknown switch or a -File script that doesn't exist
+	ExitBadArguments = 64
+	// ExitScriptNotFound is powershell.exe's code (0xFFFD0000) for a -File
+	// script it couldn't find or load
+	ExitScriptNotFound = -196608
+	// ExitKilled is reported when the process was killed by a signal
+	ExitKilled = -1
+)
+
+// ExitError reports that PowerShell exited non-zero without sending a
+// structured error
+type ExitError struct {
+	Code int
+	// Stderr is everything the process wrote to stderr, CLIXML unwrapped
+	Stderr string
+	// CommandLine is the executable and its arguments, with very long
+	// arguments such as -EncodedCommand payloads shortened
+	CommandLine []string
+	// Err is the local process's exit status; nil when the script ran
+	// remotely
+	Err *exec.ExitError
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("psbridge: powershell exited with code %d", e.Code)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() error {
+	if e.Err == nil {
+		return nil
+	}
+	return e.Err
+}
+
+// maxShownArg is how much of each argument ExitError.CommandLine keeps
+const maxShownArg = 80
+
+// newExitError builds an ExitError for cmd, or wraps err if cmd didn't get
+// as far as exiting
+func newExitError(cmd *exec.Cmd, err error, stderr []byte) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if text := StderrText(stderr); text != "" {
+			return fmt.Errorf("run powershell: %w: %s", err, text)
+		}
+		return fmt.Errorf("run powershell: %w", err)
+	}
+
+	return protocolError(&ExitError{
+		Code:        exitErr.ExitCode(),
+		Stderr:      StderrText(stderr),
+		CommandLine: shortArgs(cmd.Args),
+		Err:         exitErr,
+	})
+}
+
+// shortArgs copies args with the long ones cut to maxShownArg
+func shortArgs(args []string) []string {
+	line := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && strings.EqualFold(args[i-1], "-EncodedCommand") {
+			// The script and its parameters, which may include secrets,
+			// only lightly disguised as base64
+			line[i] = fmt.Sprintf("…(%d bytes)", len(arg))
+			continue
+		}
+		if len(arg) > maxShownArg {
+			arg = fmt.Sprintf("%s…(%d bytes)", arg[:maxShownArg], len(arg))
+		}
+		line[i] = arg
+	}
+	return line
+}
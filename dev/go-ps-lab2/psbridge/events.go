@@ -0,0 +1,145 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// EventsOp is the operation the shim reads event logs with
+const EventsOp = "events"
+
+// EventLevel is an event's severity. Lower is more severe.
+type EventLevel int
+
+// Event levels, as Get-WinEvent numbers them
+const (
+	LevelCritical    EventLevel = 1
+	LevelError       EventLevel = 2
+	LevelWarning     EventLevel = 3
+	LevelInformation EventLevel = 4
+	LevelVerbose     EventLevel = 5
+)
+
+func (l EventLevel) String() string {
+	switch l {
+	case LevelCritical:
+		return "Critical"
+	case LevelError:
+		return "Error"
+	case LevelWarning:
+		return "Warning"
+	case LevelInformation:
+		return "Information"
+	case LevelVerbose:
+		return "Verbose"
+	case 0:
+		// What classic logs write for information
+		return "LogAlways"
+	}
+	return "Level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// EventRecord is one event from an event log
+type EventRecord struct {
+	ID       int        `json:"id"`
+	Level    EventLevel `json:"level"`
+	LogName  string     `json:"logName"`
+	Provider string     `json:"provider"`
+	RecordID int64      `json:"recordId"`
+	Machine  string     `json:"machine"`
+	Time     time.Time  `json:"time"`
+	// Message is the rendered description, empty when the provider's
+	// message files aren't installed
+	Message string `json:"message"`
+	// UserID is the SID the event was logged for, if any
+	UserID string `json:"userId,omitempty"`
+	// Properties is the event's data, in order: strings and numbers as
+	// they are, other values, such as SIDs and GUIDs, as strings
+	Properties []any `json:"properties"`
+}
+
+// EventQuery chooses the events to read. The filters narrow each other.
+type EventQuery struct {
+	// LogName are logs to read, e.g. System or
+	// Microsoft-Windows-PowerShell/Operational
+	LogName []string `json:"logName,omitempty"`
+	// Provider are event providers to keep, e.g. Service Control Manager
+	Provider []string     `json:"provider,omitempty"`
+	Level    []EventLevel `json:"level,omitempty"`
+	IDs      []int        `json:"ids,omitempty"`
+	Since    time.Time    `json:"since,omitzero"`
+	Until    time.Time    `json:"until,omitzero"`
+	// XPath is a query to use instead of the filters above, such as
+	// *[System[EventID=7036]]; it needs LogName
+	XPath string `json:"xpath,omitempty"`
+	// Max caps the events read; 0 reads all of them
+	Max int `json:"max,omitempty"`
+	// Oldest reads oldest first rather than newest first
+	Oldest bool `json:"oldest,omitempty"`
+}
+
+// Validate reports a query Get-WinEvent can't run
+func (q EventQuery) Validate() error {
+	if q.XPath != "" {
+		if len(q.LogName) == 0 {
+			return errors.New("psbridge: an XPath event query needs a log name")
+		}
+		if len(q.Provider)+len(q.Level)+len(q.IDs) > 0 || !q.Since.IsZero() || !q.Until.IsZero() {
+			return errors.New("psbridge: an XPath event query can't have other filters")
+		}
+		return nil
+	}
+	if len(q.LogName)+len(q.Provider) == 0 {
+		return errors.New("psbridge: an event query needs a log name or provider")
+	}
+	return nil
+}
+
+type eventsRequest struct {
+	EventQuery
+	// Stream writes each event as it is read rather than all at the end
+	Stream bool `json:"stream,omitempty"`
+}
+
+// Events reads the events q matches. A query matching none returns an
+// empty list rather than failing. For large queries, QueryEvents.
+func Events(ctx context.Context, inv Invoker, q EventQuery, opts ...CallOption) ([]EventRecord, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return InvokeContext[eventsRequest, []EventRecord](ctx, inv, EventsOp, eventsRequest{EventQuery: q}, opts...)
+}
+
+// EventStream returns the events QueryEvents reads as the script reads
+// them, so neither side holds a large query whole
+type EventStream struct {
+	p *Pipeline[struct{}, EventRecord]
+}
+
+// QueryEvents starts reading the events q matches, in a process of its own
+func QueryEvents(ctx context.Context, c *Client, q EventQuery, opts ...CallOption) (*EventStream, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := StartPipeline[struct{}, EventRecord](ctx, c, EventsOp, eventsRequest{EventQuery: q, Stream: true}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The query takes no input
+	if err := p.CloseSend(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return &EventStream{p: p}, nil
+}
+
+// Recv returns the next event, or io.EOF after the last one
+func (s *EventStream) Recv() (EventRecord, error) { return s.p.Recv() }
+
+// Streams returns what the query wrote to its other streams so far
+func (s *EventStream) Streams() Streams { return s.p.Streams() }
+
+// Close stops the query if it is still running
+func (s *EventStream) Close() error { return s.p.Close() }
@@ -0,0 +1,374 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ExecMode is how a Client hands its script to PowerShell
+type ExecMode int
+
+const (
+	// ExecFile runs Script with -File
+	ExecFile ExecMode = iota
+	// ExecEncodedCommand runs ScriptText (or the contents of Script) with
+	// -EncodedCommand, so no .ps1 has to exist and nothing needs quoting.
+	// The encoded text counts against the OS command-line limit (32K
+	// characters on Windows).
+	ExecEncodedCommand
+	// ExecStdin pipes ScriptText (or the contents of Script) to -Command -,
+	// so neither a .ps1 nor the command-line limit is involved. A call's
+	// request goes in an environment variable instead, or a temp file when
+	// too big for one. Sessions need TransportNamedPipe in this mode, and
+	// pipelines, which feed stdin, can't run at all.
+	ExecStdin
+)
+
+// errStdinTaken is returned for what needs stdin in ExecStdin mode
+var errStdinTaken = errors.New("psbridge: stdin carries the script in stdin exec mode")
+
+func (m ExecMode) String() string {
+	switch m {
+	case ExecFile:
+		return "file"
+	case ExecEncodedCommand:
+		return "encoded-command"
+	case ExecStdin:
+		return "stdin"
+	}
+	return fmt.Sprintf("ExecMode(%d)", int(m))
+}
+
+// WithExecMode selects how the script is passed to PowerShell
+func WithExecMode(mode ExecMode) Option {
+	return func(c *Client) { c.Mode = mode }
+}
+
+// WithScriptText runs text instead of a script file, via -EncodedCommand
+func WithScriptText(text string) Option {
+	return func(c *Client) {
+		c.ScriptText = text
+		c.Mode = ExecEncodedCommand
+	}
+}
+
+// HostFlags are the PowerShell switches every process is started with,
+// ahead of the script
+type HostFlags struct {
+	// NoProfile skips the user's profile scripts
+	NoProfile bool
+	// NoLogo hides the copyright banner
+	NoLogo bool
+	// NonInteractive makes anything that would prompt fail instead
+	NonInteractive bool
+	// ExecutionPolicy, if set, is passed as -ExecutionPolicy, e.g. Bypass.
+	// pwsh ignores it off Windows.
+	ExecutionPolicy string
+}
+
+// DefaultHostFlags keep the machine's profiles and any console prompts out
+// of the script's way
+func DefaultHostFlags() HostFlags {
+	return HostFlags{NoProfile: true, NonInteractive: true}
+}
+
+// WithHostFlags replaces the client's host flags
+func WithHostFlags(f HostFlags) Option {
+	return func(c *Client) { c.Flags = f }
+}
+
+// WithExecutionPolicy sets -ExecutionPolicy, keeping the other host flags
+func WithExecutionPolicy(policy string) Option {
+	return func(c *Client) { c.Flags.ExecutionPolicy = policy }
+}
+
+// args renders the flags as PowerShell arguments
+func (f HostFlags) args() []string {
+	var args []string
+	if f.NoProfile {
+		args = append(args, "-NoProfile")
+	}
+	if f.NoLogo {
+		args = append(args, "-NoLogo")
+	}
+	if f.NonInteractive {
+		args = append(args, "-NonInteractive")
+	}
+	if f.ExecutionPolicy != "" {
+		args = append(args, "-ExecutionPolicy", f.ExecutionPolicy)
+	}
+	return args
+}
+
+// WithWorkingDir starts every script in dir
+func WithWorkingDir(dir string) Option {
+	return func(c *Client) { c.Dir = dir }
+}
+
+// EncodeCommand encodes script the way -EncodedCommand expects: base64 of
+// its UTF-16LE bytes
+func EncodeCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		buf[2*i] = byte(u)
+		buf[2*i+1] = byte(u >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// command builds the PowerShell process running the client's script with
+// params, starting in dir, once admit lets it. The process is killed when
+// ctx ends.
+func (c *Client) command(ctx context.Context, dir string, params ...Param) (*exec.Cmd, error) {
+	if err := c.admit(ctx); err != nil {
+		return nil, err
+	}
+	return c.buildCommand(ctx, dir, params...)
+}
+
+// sessionCommand is command for a session, whose process outlives the ctx
+// bounding its start
+func (c *Client) sessionCommand(ctx context.Context, params ...Param) (*exec.Cmd, error) {
+	if err := c.admit(ctx); err != nil {
+		return nil, err
+	}
+	return c.buildCommand(context.Background(), c.Dir, params...)
+}
+
+// admit waits until the client's RateLimiter lets a process start and
+// checks that its Integrity and SignaturePolicy allow the scripts
+func (c *Client) admit(ctx context.Context) error {
+	if err := c.RateLimiter.Wait(ctx); err != nil {
+		return err
+	}
+	if err := c.Integrity.Check(); err != nil {
+		return err
+	}
+	return c.verifyScripts(ctx)
+}
+
+// buildCommand is command without admit
+func (c *Client) buildCommand(ctx context.Context, dir string, params ...Param) (*exec.Cmd, error) {
+	shell, err := c.shell()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Router != "" {
+		router := c.Router
+		if c.SSH == nil {
+			if router, err = filepath.Abs(router); err != nil {
+				return nil, fmt.Errorf("resolve router: %w", err)
+			}
+		}
+		params = append(params, Param{Name: "Router", Value: router})
+	}
+
+	params = append(params, Param{Name: "Protocol", Value: ProtocolVersion})
+	if c.Sentinels {
+		params = append(params, Param{Name: "Sentinels", Switch: true})
+	}
+	if c.Encoding != EncodingAuto {
+		params = append(params, Param{Name: "ConsoleEncoding", Value: c.Encoding.String()})
+	}
+	if c.JSONDepth > 0 {
+		params = append(params, Param{Name: "JsonDepth", Value: c.JSONDepth})
+	}
+	if e := c.Endpoint; e != nil {
+		params = append(params, Param{Name: "Endpoint", Value: e.Name})
+		if e.ComputerName != "" {
+			params = append(params, Param{Name: "EndpointComputer", Value: e.ComputerName})
+		}
+	}
+
+	// Host flags must come first: everything after -File belongs to the
+	// script
+	args := c.Flags.args()
+	switch c.Mode {
+	case ExecFile:
+		script := c.Script
+		if dir != "" && c.SSH == nil {
+			// A relative path would otherwise resolve against dir
+			abs, err := filepath.Abs(script)
+			if err != nil {
+				return nil, fmt.Errorf("resolve script: %w", err)
+			}
+			script = abs
+		}
+		args = append(args, "-File", script)
+		// -File passes everything as strings, which is all the shim needs
+		for _, p := range params {
+			args = append(args, "-"+p.Name)
+			if !p.Switch {
+				args = append(args, fmt.Sprint(p.Value))
+			}
+		}
+	case ExecEncodedCommand:
+		text, err := c.scriptText()
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := renderParams(params)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-EncodedCommand", EncodeCommand(invokeBlock(text)+rendered))
+	case ExecStdin:
+		if c.SSH != nil {
+			return nil, fmt.Errorf("psbridge: %v exec mode doesn't work over ssh", c.Mode)
+		}
+		text, err := c.scriptText()
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := renderParams(params)
+		if err != nil {
+			return nil, err
+		}
+		cmd := c.process(ctx, dir, shell, append(args, "-Command", "-")...)
+		cmd.Stdin = strings.NewReader(stdinCommand(invokeBlock(text) + rendered))
+		return cmd, nil
+	default:
+		return nil, fmt.Errorf("psbridge: unknown exec mode %v", c.Mode)
+	}
+
+	return c.process(ctx, dir, shell, args...), nil
+}
+
+// stdinCommand is script as a single line for -Command -, which runs what
+// it reads line by line and needs a blank one to end a statement spanning
+// several
+func stdinCommand(script string) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+	return "& ([scriptblock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('" + encoded + "'))))\n"
+}
+
+// requestEnv is the variable a request travels in in ExecStdin mode
+const requestEnv = "PSBRIDGE_REQUEST"
+
+// requestEnvMax is the biggest request put in requestEnv; Windows allows
+// 32767 characters a variable
+const requestEnvMax = 16 << 10
+
+// stdinRequest is where a call's request goes in ExecStdin mode
+type stdinRequest struct {
+	// param tells the shim where to read it
+	param Param
+	// env holds it when small, path when not
+	env  string
+	path string
+}
+
+// newStdinRequest places data for the shim to read
+func newStdinRequest(data []byte) (*stdinRequest, error) {
+	if len(data) <= requestEnvMax {
+		return &stdinRequest{param: Param{Name: "InputFile", Value: "env:" + requestEnv}, env: requestEnv + "=" + string(data)}, nil
+	}
+	f, err := os.CreateTemp("", "psbridge-request-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("request file: %w", err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("request file: %w", err)
+	}
+	return &stdinRequest{param: Param{Name: "InputFile", Value: f.Name()}, path: f.Name()}, nil
+}
+
+// addTo adds the variable, if any, to env, where a nil env means the
+// inherited one
+func (r *stdinRequest) addTo(env []string) []string {
+	if r.env == "" {
+		return env
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	return append(env, r.env)
+}
+
+// remove deletes the temp file, if any
+func (r *stdinRequest) remove() {
+	if r.path != "" {
+		os.Remove(r.path)
+	}
+}
+
+// newCommand is the single place PowerShell processes are created. Each
+// gets its own process tree, which is killed as a whole when ctx ends.
+func newCommand(ctx context.Context, shell string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, shell, args...)
+	startOwnGroup(cmd)
+	cmd.Cancel = func() error { return killTree(cmd) }
+	return cmd
+}
+
+// heapLimitEnv caps .NET's GC heap, in hex bytes
+const heapLimitEnv = "DOTNET_GCHeapHardLimit"
+
+// startCommand starts cmd with the client's Resources applied and contains
+// it, so on Windows the processes it starts can't outlive it
+func (c *Client) startCommand(cmd *exec.Cmd) error {
+	r := c.Resources
+	if r.Memory > 0 && !jobMemory {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, heapLimitEnv+"="+strconv.FormatInt(r.Memory, 16))
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := contain(cmd, r); err != nil {
+		killTree(cmd)
+		waitCommand(cmd)
+		return fmt.Errorf("psbridge: apply resources: %w", err)
+	}
+	return nil
+}
+
+// waitCommand waits for cmd, started by startCommand, to exit
+func waitCommand(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	release(cmd)
+	return err
+}
+
+// scriptText returns the inline script, or reads it from Script
+func (c *Client) scriptText() (string, error) {
+	if c.ScriptText != "" {
+		return c.ScriptText, nil
+	}
+	b, err := os.ReadFile(c.Script)
+	if err != nil {
+		return "", fmt.Errorf("read script: %w", err)
+	}
+	return string(b), nil
+}
+
+// invokeBlock wraps a script body so it runs as a script block, with its
+// param() block still able to bind arguments that follow
+func invokeBlock(text string) string {
+	return "& {\n" + text + "\n}"
+}
+
+// quotePS renders s as a single-quoted PowerShell string literal. Only the
+// quote characters themselves need doubling; PowerShell also treats the
+// typographic single quotes as quotes.
+func quotePS(s string) string {
+	r := strings.NewReplacer("'", "''", "‘", "‘‘", "’", "’’", "‚", "‚‚", "‛", "‛‛")
+	return "'" + r.Replace(s) + "'"
+}
@@ -0,0 +1,106 @@
+package psbridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeShellEnv, when set, makes the test binary play PowerShell running the
+// shim instead of running tests. Its value picks how:
+//
+//	session  answer the protocol: hello, ping and quit, "fail" with a
+//	         PSError, "crash" by exiting 3, "hang" never, and any other op
+//	         with its request data as the result
+//	mute     start but never answer anything
+//	exit     write to stderr and exit 3 at once
+//	argv     print its arguments and stdin as a fakeCommandLine
+const fakeShellEnv = "PSBRIDGE_FAKE_SHELL"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeShellEnv); mode != "" {
+		os.Exit(fakeShell(mode, os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeClient is a client whose PowerShell is the test binary in mode
+func fakeClient(t *testing.T, mode string, opts ...Option) *Client {
+	t.Helper()
+	t.Setenv(fakeShellEnv, mode)
+	return NewClient("bridge.ps1", append([]Option{WithShell(os.Args[0])}, opts...)...)
+}
+
+func fakeShell(mode string, args []string) int {
+	switch mode {
+	case "mute":
+		io.Copy(io.Discard, os.Stdin)
+		time.Sleep(time.Hour)
+		return 0
+	case "exit":
+		fmt.Fprintln(os.Stderr, "fake shell: broken on purpose")
+		return 3
+	case "argv":
+		stdin, _ := io.ReadAll(os.Stdin)
+		json.NewEncoder(os.Stdout).Encode(fakeCommandLine{Args: args, Stdin: string(stdin)})
+		return 0
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(nil, 1<<20)
+	if !slices.Contains(args, "-Session") {
+		// One-shot: the request comes on stdin
+		var req wireRequest
+		if in.Scan() {
+			json.Unmarshal(in.Bytes(), &req)
+		}
+		out.Encode(wireReply{Type: replyResult, Data: orEmpty(req.Data)})
+		return 0
+	}
+
+	for in.Scan() {
+		var req wireRequest
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			return 2
+		}
+		reply := wireReply{ID: req.ID, Type: replyResult}
+		switch req.Op {
+		case opHello:
+			reply.Data, _ = json.Marshal(helloReply{Version: ProtocolVersion, MinVersion: 1})
+		case opPing:
+			reply.Data = json.RawMessage(`{"pong":true}`)
+		case opQuit:
+			out.Encode(wireReply{ID: req.ID, Type: replyResult, Data: json.RawMessage(`{"quit":true}`)})
+			return 0
+		case "fail":
+			reply = wireReply{ID: req.ID, Type: replyError, Error: &PSError{Message: "failed on purpose", ErrorID: "Fake"}}
+		case "crash":
+			return 3
+		case "hang":
+			time.Sleep(time.Hour)
+		default:
+			reply.Data = orEmpty(req.Data)
+		}
+		out.Encode(reply)
+	}
+	return 0
+}
+
+// fakeCommandLine is what the argv fake shell was run with
+type fakeCommandLine struct {
+	Args  []string
+	Stdin string
+}
+
+func orEmpty(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage(`{}`)
+	}
+	return data
+}
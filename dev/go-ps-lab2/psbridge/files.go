@@ -0,0 +1,103 @@
+package psbridge
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// FilesOp is the operation the shim walks a FileSystem tree with
+const FilesOp = "files"
+
+// FileEntry is one file or directory found by ListFiles
+type FileEntry struct {
+	Name string `json:"name"`
+	// Path is the full path, e.g. C:\Windows\notepad.exe
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDirectory"`
+	// Size is in bytes, and 0 for directories
+	Size           int64     `json:"size"`
+	CreationTime   time.Time `json:"creationTime"`
+	LastWriteTime  time.Time `json:"lastWriteTime"`
+	LastAccessTime time.Time `json:"lastAccessTime"`
+	// Attributes are the FileAttributes flags set, e.g. Archive and Hidden
+	Attributes []string `json:"attributes"`
+	Hidden     bool     `json:"hidden"`
+	System     bool     `json:"system"`
+	ReadOnly   bool     `json:"readOnly"`
+}
+
+// FileQuery chooses what ListFiles reports. Globs are PowerShell
+// wildcards matched against entry names, ignoring case.
+type FileQuery struct {
+	// Depth is how many levels of directories below the path to descend
+	// into; 0 lists the path's own entries, negative the whole tree.
+	// Symbolic links and junctions are listed but never followed.
+	Depth int `json:"depth"`
+	// Include, if set, reports only entries matching one of its globs.
+	// Directories are descended into whether or not they match.
+	Include []string `json:"include,omitempty"`
+	// Exclude skips entries matching any of its globs, and doesn't descend
+	// into excluded directories
+	Exclude []string `json:"exclude,omitempty"`
+	// Force includes hidden and system entries
+	Force bool `json:"force"`
+}
+
+type filesRequest struct {
+	Path string `json:"path"`
+	FileQuery
+}
+
+// FileStream returns the entries ListFiles finds as the script finds them,
+// so a huge tree is never held whole on either side. Directories that
+// can't be read are skipped with a warning in Streams.
+type FileStream struct {
+	p *Pipeline[struct{}, FileEntry]
+}
+
+// ListFiles walks the tree at path, which may also be a single file, in a
+// process of its own
+func ListFiles(ctx context.Context, c *Client, path string, q FileQuery, opts ...CallOption) (*FileStream, error) {
+	p, err := StartPipeline[struct{}, FileEntry](ctx, c, FilesOp, filesRequest{path, q}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The walk takes no input
+	if err := p.CloseSend(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return &FileStream{p: p}, nil
+}
+
+// Recv returns the next entry, or io.EOF after the last one
+func (s *FileStream) Recv() (FileEntry, error) { return s.p.Recv() }
+
+// Streams returns what the walk wrote to its other streams so far
+func (s *FileStream) Streams() Streams { return s.p.Streams() }
+
+// Close stops the walk if it is still running
+func (s *FileStream) Close() error { return s.p.Close() }
+
+// WalkFiles calls fn for each entry ListFiles finds, stopping at the first
+// error fn returns
+func WalkFiles(ctx context.Context, c *Client, path string, q FileQuery, fn func(FileEntry) error, opts ...CallOption) error {
+	s, err := ListFiles(ctx, c, path, q, opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for {
+		entry, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package format
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// encodeCSV writes v as a table: a list has a row per element and an
+// object is one row. The columns are every field any row has, in the
+// order they were first seen; a row that isn't an object goes in a
+// "value" column. Nested objects and lists are written as JSON.
+func encodeCSV(w io.Writer, v any) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	rows, ok := t.([]any)
+	if !ok {
+		rows = []any{t}
+	}
+
+	var columns []string
+	index := map[string]int{}
+	column := func(name string) int {
+		i, ok := index[name]
+		if !ok {
+			i = len(columns)
+			index[name] = i
+			columns = append(columns, name)
+		}
+		return i
+	}
+	records := make([][]string, len(rows))
+	for r, row := range rows {
+		obj, ok := row.(object)
+		if !ok {
+			obj = object{{key: "value", value: row}}
+		}
+		record := make([]string, len(columns))
+		for _, f := range obj {
+			i := column(f.key)
+			for len(record) <= i {
+				record = append(record, "")
+			}
+			record[i] = cell(f.value)
+		}
+		records[r] = record
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		for len(record) < len(columns) {
+			record = append(record, "")
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// cell is v as one CSV field
+func cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	var b strings.Builder
+	writeJSON(&b, v)
+	return b.String()
+}
+
+// writeJSON writes a tree back as compact JSON, fields in order
+func writeJSON(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case object:
+		b.WriteByte('{')
+		for i, f := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, f.key)
+			b.WriteByte(':')
+			writeJSON(b, f.value)
+		}
+		b.WriteByte('}')
+	case []any:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, e)
+		}
+		b.WriteByte(']')
+	default:
+		out, _ := json.Marshal(v)
+		b.Write(out)
+	}
+}
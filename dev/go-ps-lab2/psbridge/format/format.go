@@ -0,0 +1,143 @@
+// Package format writes provider views, or any value psbridge returns, as
+// JSON, CSV, YAML or TOML.
+//
+//	services, _ := psbridge.RunCmdlet[Service](ctx, session, cmdlet)
+//	enc, _ := format.Lookup("csv")
+//	enc.Encode(os.Stdout, services)
+//
+// Every encoder sees a value as encoding/json would, so json struct tags,
+// json.RawMessage results and MarshalJSON methods all carry over, and an
+// object's fields keep their order.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Encoder writes values in one format
+type Encoder interface {
+	Encode(w io.Writer, v any) error
+}
+
+// EncoderFunc adapts a function to Encoder
+type EncoderFunc func(w io.Writer, v any) error
+
+// Encode calls f(w, v)
+func (f EncoderFunc) Encode(w io.Writer, v any) error { return f(w, v) }
+
+// Names of the built-in encoders
+const (
+	JSON = "json"
+	CSV  = "csv"
+	YAML = "yaml"
+	TOML = "toml"
+)
+
+var (
+	mu       sync.RWMutex
+	encoders = map[string]Encoder{
+		JSON: EncoderFunc(encodeJSON),
+		CSV:  EncoderFunc(encodeCSV),
+		YAML: EncoderFunc(encodeYAML),
+		TOML: EncoderFunc(encodeTOML),
+	}
+)
+
+// Register makes enc available to Lookup as name, replacing any encoder
+// already registered under it
+func Register(name string, enc Encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	encoders[name] = enc
+}
+
+// Lookup returns the encoder registered as name
+func Lookup(name string) (Encoder, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	enc, ok := encoders[name]
+	return enc, ok
+}
+
+// Names lists the registered encoders, sorted
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// encodeJSON writes v as one line of JSON
+func encodeJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// object is a JSON object with its fields in order
+type object []field
+
+type field struct {
+	key   string
+	value any
+}
+
+// tree returns v as encoding/json sees it: an object, []any, string,
+// json.Number, bool or nil
+func tree(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return decode(dec)
+}
+
+func decode(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{key: key.(string), value: value})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decode(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
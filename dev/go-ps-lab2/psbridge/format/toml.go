@@ -0,0 +1,161 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// encodeTOML writes v as a TOML document. TOML's top level is a table, so
+// a list is written as the array of tables "items" and anything else that
+// isn't an object as the key "value". TOML has no null, so nulls are left
+// out.
+func encodeTOML(w io.Writer, v any) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	root, ok := t.(object)
+	if !ok {
+		key := "value"
+		if _, ok := t.([]any); ok {
+			key = "items"
+		}
+		root = object{{key: key, value: t}}
+	}
+	var b strings.Builder
+	tomlTable(&b, "", nil, root)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// tomlTable writes obj's keys under header, then its tables and arrays of
+// tables, each named by path
+func tomlTable(b *strings.Builder, header string, path []string, obj object) {
+	if header != "" {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(header)
+		b.WriteByte('\n')
+	}
+	for _, f := range obj {
+		if f.value == nil || tomlSection(f.value) {
+			continue
+		}
+		fmt.Fprintf(b, "%s = %s\n", tomlKey(f.key), tomlInline(f.value))
+	}
+	for _, f := range obj {
+		if sub, ok := f.value.(object); ok && len(sub) > 0 {
+			name := append(path[:len(path):len(path)], tomlKey(f.key))
+			tomlTable(b, "["+strings.Join(name, ".")+"]", name, sub)
+		}
+	}
+	for _, f := range obj {
+		if list, ok := f.value.([]any); ok && tomlSection(list) {
+			name := append(path[:len(path):len(path)], tomlKey(f.key))
+			for _, e := range list {
+				tomlTable(b, "[["+strings.Join(name, ".")+"]]", name, e.(object))
+			}
+		}
+	}
+}
+
+// tomlSection reports whether v is written as its own table or array of
+// tables rather than as a key's inline value
+func tomlSection(v any) bool {
+	switch v := v.(type) {
+	case object:
+		return len(v) > 0
+	case []any:
+		if len(v) == 0 {
+			return false
+		}
+		for _, e := range v {
+			if _, ok := e.(object); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// tomlInline is v as a value on one line
+func tomlInline(v any) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return tomlString(v)
+	case object:
+		if len(v) == 0 {
+			return "{}"
+		}
+		parts := make([]string, 0, len(v))
+		for _, f := range v {
+			if f.value != nil {
+				parts = append(parts, tomlKey(f.key)+" = "+tomlInline(f.value))
+			}
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			if e != nil {
+				parts = append(parts, tomlInline(e))
+			}
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return `""`
+}
+
+var tomlBare = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey quotes key unless it is a bare key
+func tomlKey(key string) string {
+	if tomlBare.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+// tomlString is s as a basic string
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
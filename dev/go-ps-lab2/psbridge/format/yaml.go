@@ -0,0 +1,124 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// encodeYAML writes v as a YAML document in block style. Each document
+// starts with "---", so several written to one stream stay apart.
+func encodeYAML(w io.Writer, v any) error {
+	t, err := tree(v)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("---")
+	yamlValue(&b, t, 0)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// yamlValue writes v after a key's colon, or after "---", indenting a
+// block under it by indent
+func yamlValue(b *strings.Builder, v any, indent int) {
+	switch v := v.(type) {
+	case object:
+		if len(v) > 0 {
+			b.WriteByte('\n')
+			yamlObject(b, v, indent, false)
+			return
+		}
+	case []any:
+		if len(v) > 0 {
+			b.WriteByte('\n')
+			yamlList(b, v, indent, false)
+			return
+		}
+	}
+	b.WriteByte(' ')
+	b.WriteString(yamlScalar(v))
+	b.WriteByte('\n')
+}
+
+// yamlObject writes a mapping at indent; inline continues the line a list
+// item's "- " began
+func yamlObject(b *strings.Builder, obj object, indent int, inline bool) {
+	for i, f := range obj {
+		if i > 0 || !inline {
+			b.WriteString(strings.Repeat(" ", indent))
+		}
+		b.WriteString(yamlString(f.key))
+		b.WriteByte(':')
+		yamlValue(b, f.value, indent+2)
+	}
+}
+
+// yamlList writes a sequence at indent, an item per line
+func yamlList(b *strings.Builder, list []any, indent int, inline bool) {
+	for i, e := range list {
+		if i > 0 || !inline {
+			b.WriteString(strings.Repeat(" ", indent))
+		}
+		b.WriteString("- ")
+		switch e := e.(type) {
+		case object:
+			if len(e) > 0 {
+				yamlObject(b, e, indent+2, true)
+				continue
+			}
+		case []any:
+			if len(e) > 0 {
+				yamlList(b, e, indent+2, true)
+				continue
+			}
+		}
+		b.WriteString(yamlScalar(e))
+		b.WriteByte('\n')
+	}
+}
+
+// yamlScalar is v on one line; empty collections are written flow style
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	case object:
+		return "{}"
+	}
+	return "[]"
+}
+
+// yamlPlain matches strings that read back as themselves unquoted
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_/\\][^\x00-\x1f\x7f:#,\[\]{}"'&*!|>%@` + "`" + `]*$`)
+
+// yamlReserved are words YAML 1.1 readers take for booleans or null
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"y": true, "n": true, "null": true,
+}
+
+// yamlString writes s plain when that is unambiguous, and double-quoted,
+// with JSON's escapes, otherwise
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !strings.HasSuffix(s, " ") && !yamlReserved[strings.ToLower(s)] {
+		return s
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,102 @@
+package psbridge
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Framing is how session messages are delimited on the wire
+type Framing int
+
+const (
+	// FramingNDJSON is one JSON document per line
+	FramingNDJSON Framing = iota
+	// FramingLengthPrefixed puts a 4-byte big-endian length before each
+	// JSON document, so embedded newlines or oddly chunked output can't
+	// shift message boundaries
+	FramingLengthPrefixed
+)
+
+func (f Framing) String() string {
+	switch f {
+	case FramingNDJSON:
+		return "ndjson"
+	case FramingLengthPrefixed:
+		return "length"
+	}
+	return fmt.Sprintf("Framing(%d)", int(f))
+}
+
+// WithFraming asks sessions to switch to f after starting. Scripts without
+// CapLengthFraming keep using NDJSON; Session.Framing reports what was
+// agreed.
+func WithFraming(f Framing) Option {
+	return func(c *Client) { c.Framing = f }
+}
+
+// maxFrameSize guards against reading a garbage length prefix, such as
+// stray text on stdout, as a huge allocation
+const maxFrameSize = 256 << 20
+
+// opFraming is the built-in op that switches a session's framing
+const opFraming = "framing"
+
+// negotiateFraming asks the freshly started session for want. It runs
+// before the reader starts, so it talks NDJSON directly.
+func (s *Session) negotiateFraming(want Framing) error {
+	data, _ := json.Marshal(map[string]string{"framing": want.String()})
+	line, err := json.Marshal(wireRequest{Op: opFraming, Data: data})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		return s.processError("negotiate framing", s.exitCause(err))
+	}
+
+	res, err := readReply(s.stdout, nil)
+	if err != nil {
+		var psErr *PSError
+		if errors.As(err, &psErr) {
+			// An older script without the op; stay on NDJSON
+			return nil
+		}
+		return s.processError("negotiate framing", s.exitCause(err))
+	}
+
+	var agreed struct {
+		Framing string `json:"framing"`
+	}
+	if err := json.Unmarshal(res.Data, &agreed); err != nil {
+		return fmt.Errorf("unmarshal framing reply: %w", err)
+	}
+	if agreed.Framing != want.String() {
+		return fmt.Errorf("psbridge: asked for %s framing, script chose %q", want, agreed.Framing)
+	}
+	s.framing = want
+	return nil
+}
+
+// writeMessage sends one request document in the session's framing
+func (s *Session) writeMessage(msg []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.framing == FramingLengthPrefixed {
+		frame := make([]byte, 4, 4+len(msg))
+		binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+		_, err := s.stdin.Write(append(frame, msg...))
+		return err
+	}
+	_, err := s.stdin.Write(append(msg, '\n'))
+	return err
+}
+
+// readMessage reads one reply document in the session's framing
+func (s *Session) readMessage() (message, error) {
+	if s.framing == FramingLengthPrefixed {
+		return s.stdout.frame()
+	}
+	return s.stdout.line()
+}
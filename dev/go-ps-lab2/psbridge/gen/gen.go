@@ -0,0 +1,215 @@
+// Package gen writes Go types matching what PowerShell operations return,
+// either from the class definitions in a script or from a sample result.
+package gen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+// Script is what Inspect found in a script
+type Script struct {
+	Classes []Class  `json:"classes"`
+	Enums   []string `json:"enums"`
+	// OutputTypes are the type names from [OutputType()] attributes
+	OutputTypes []string `json:"outputTypes"`
+}
+
+// Class is a PowerShell class and its public instance properties
+type Class struct {
+	Name       string     `json:"name"`
+	Properties []Property `json:"properties"`
+}
+
+// Property is a class property with its declared type name, "object" when
+// untyped
+type Property struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Inspect parses the script at path with PowerShell and reports its types.
+// The script isn't run. inspector is a client for the bundled
+// inspect_types.ps1.
+func Inspect(ctx context.Context, inspector *psbridge.Client, path string) (*Script, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := inspector.RunScript(ctx, struct {
+		Path string `ps:"Path"`
+	}{abs})
+	if err != nil {
+		return nil, err
+	}
+
+	var s Script
+	if err := json.Unmarshal(bytes.TrimSpace(out), &s); err != nil {
+		return nil, fmt.Errorf("decode inspection of %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// File is Go source being generated
+type File struct {
+	Package string
+	// Source names what the types were generated from, for the header
+	Source string
+
+	decls   []string
+	imports map[string]bool
+}
+
+// Bytes returns the formatted source
+func (f *File) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by go-ps-lab2 gen from %s; DO NOT EDIT.\n\n", f.Source)
+	fmt.Fprintf(&b, "package %s\n\n", f.Package)
+	if len(f.imports) > 0 {
+		b.WriteString("import (\n")
+		for _, imp := range slices.Sorted(maps.Keys(f.imports)) {
+			fmt.Fprintf(&b, "\t%q\n", imp)
+		}
+		b.WriteString(")\n\n")
+	}
+	for _, d := range f.decls {
+		b.WriteString(d)
+		b.WriteString("\n")
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+func (f *File) use(imp string) {
+	if f.imports == nil {
+		f.imports = map[string]bool{}
+	}
+	f.imports[imp] = true
+}
+
+// FromScript generates a struct per class and an int type per enum.
+// Properties whose type is another class of the script refer to its
+// struct.
+func FromScript(pkg, source string, s *Script) *File {
+	f := &File{Package: pkg, Source: source}
+	known := map[string]bool{}
+	for _, c := range s.Classes {
+		known[strings.ToLower(c.Name)] = true
+	}
+	for _, e := range s.Enums {
+		known[strings.ToLower(e)] = true
+	}
+
+	for _, e := range s.Enums {
+		f.decls = append(f.decls, fmt.Sprintf("// %s is the PowerShell enum %s, which ConvertTo-Json writes as its number\ntype %s int\n", GoName(e), e, GoName(e)))
+	}
+	for _, c := range s.Classes {
+		var b strings.Builder
+		fmt.Fprintf(&b, "// %s is the PowerShell class %s", GoName(c.Name), c.Name)
+		if slices.ContainsFunc(s.OutputTypes, func(t string) bool { return strings.EqualFold(t, c.Name) }) {
+			b.WriteString(", declared as an output type")
+		}
+		fmt.Fprintf(&b, "\ntype %s struct {\n", GoName(c.Name))
+		for _, p := range c.Properties {
+			fmt.Fprintf(&b, "\t%s %s `json:%q`\n", GoName(p.Name), f.goType(p.Type, known), p.Name)
+		}
+		b.WriteString("}\n")
+		f.decls = append(f.decls, b.String())
+	}
+	return f
+}
+
+// goType maps a PowerShell type name to Go
+func (f *File) goType(ps string, known map[string]bool) string {
+	ps = strings.TrimSpace(ps)
+	if elem, ok := strings.CutSuffix(ps, "[]"); ok {
+		return "[]" + f.goType(elem, known)
+	}
+	// Generic collections of one element type: List[T], IList[T], ...
+	if open := strings.IndexByte(ps, '['); open > 0 && strings.HasSuffix(ps, "]") {
+		outer := strings.ToLower(ps[:open])
+		args := ps[open+1 : len(ps)-1]
+		switch {
+		case strings.HasSuffix(outer, "list"), strings.HasSuffix(outer, "collection"),
+			strings.HasSuffix(outer, "enumerable"), strings.HasSuffix(outer, "hashset"):
+			return "[]" + f.goType(args, known)
+		case strings.HasSuffix(outer, "dictionary"):
+			if _, v, ok := strings.Cut(args, ","); ok {
+				return "map[string]" + f.goType(v, known)
+			}
+		case strings.HasSuffix(outer, "nullable"):
+			return "*" + f.goType(args, known)
+		}
+		return "any"
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(ps, "System."))
+	switch name {
+	case "string", "char", "guid", "uri", "version":
+		return "string"
+	case "bool", "boolean", "switch", "switchparameter":
+		return "bool"
+	case "int", "int32", "int16", "short", "byte", "sbyte", "uint16", "ushort":
+		return "int"
+	case "long", "int64", "uint32", "uint", "uint64", "ulong":
+		return "int64"
+	case "double", "float", "single", "decimal":
+		return "float64"
+	case "datetime", "datetimeoffset":
+		f.use("time")
+		return "time.Time"
+	case "timespan":
+		// ConvertTo-Json writes a TimeSpan as an object of its parts
+		return "map[string]any"
+	case "hashtable", "collections.hashtable", "pscustomobject", "psobject", "management.automation.psobject":
+		return "map[string]any"
+	case "object", "":
+		return "any"
+	}
+	if known[strings.ToLower(ps)] {
+		return GoName(ps)
+	}
+	return "any"
+}
+
+// GoName turns a PowerShell name into an exported Go identifier
+func GoName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "T" + name
+	}
+	for _, initialism := range []string{"Id", "Url", "Uri", "Json", "Xml", "Http", "Api"} {
+		if rest, ok := strings.CutSuffix(name, initialism); ok {
+			name = rest + strings.ToUpper(initialism)
+		}
+	}
+	return name
+}
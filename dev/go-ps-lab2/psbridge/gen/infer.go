@@ -0,0 +1,211 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// kind is the JSON type of the values seen at one place in a sample
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	kindTime
+	kindObject
+	kindArray
+	kindMixed
+)
+
+// shape is what every value seen at one place in the sample had in common
+type shape struct {
+	kind kind
+	// fields keeps object keys in first-seen order
+	fields []string
+	props  map[string]*shape
+	elem   *shape
+}
+
+// FromSample generates types that a sample result, such as one printed by
+// run, decodes into. The top-level type is called name; nested objects get
+// types named after their path.
+func FromSample(pkg, source, name string, sample []byte) (*File, error) {
+	dec := json.NewDecoder(bytes.NewReader(sample))
+	dec.UseNumber()
+	root, err := readShape(dec)
+	if err != nil {
+		return nil, fmt.Errorf("read sample: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("read sample: more than one JSON value")
+	}
+
+	f := &File{Package: pkg, Source: source}
+	name = GoName(name)
+	switch root.kind {
+	case kindObject:
+		f.emitStruct(name, root)
+	case kindArray:
+		if root.elem != nil && root.elem.kind == kindObject {
+			at := len(f.decls)
+			f.emitStruct(name, root.elem)
+			f.decls[at] = fmt.Sprintf("// The sample is a list: decode it into []%s\n", name) + f.decls[at]
+			break
+		}
+		f.decls = append(f.decls, fmt.Sprintf("type %s %s\n", name, f.shapeType(name, root)))
+	default:
+		f.decls = append(f.decls, fmt.Sprintf("type %s %s\n", name, f.shapeType(name, root)))
+	}
+	return f, nil
+}
+
+// readShape reads one value from dec
+func readShape(dec *json.Decoder) (*shape, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case nil:
+		return &shape{kind: kindNull}, nil
+	case bool:
+		return &shape{kind: kindBool}, nil
+	case json.Number:
+		if strings.ContainsAny(t.String(), ".eE") {
+			return &shape{kind: kindFloat}, nil
+		}
+		return &shape{kind: kindInt}, nil
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return &shape{kind: kindTime}, nil
+		}
+		return &shape{kind: kindString}, nil
+	case json.Delim:
+		switch t {
+		case '{':
+			s := &shape{kind: kindObject, props: map[string]*shape{}}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key := keyTok.(string)
+				v, err := readShape(dec)
+				if err != nil {
+					return nil, err
+				}
+				s.add(key, v)
+			}
+			_, err := dec.Token()
+			return s, err
+		case '[':
+			s := &shape{kind: kindArray}
+			for dec.More() {
+				v, err := readShape(dec)
+				if err != nil {
+					return nil, err
+				}
+				s.elem = merge(s.elem, v)
+			}
+			_, err := dec.Token()
+			return s, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected token %v", tok)
+}
+
+// add records key's value, merging with what was there
+func (s *shape) add(key string, v *shape) {
+	if old, ok := s.props[key]; ok {
+		s.props[key] = merge(old, v)
+		return
+	}
+	s.fields = append(s.fields, key)
+	s.props[key] = v
+}
+
+// merge combines two shapes seen at the same place. null merges into
+// anything; ints widen to floats and times to strings.
+func merge(a, b *shape) *shape {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.kind == kindNull:
+		return b
+	case b.kind == kindNull:
+		return a
+	case a.kind == b.kind:
+		switch a.kind {
+		case kindObject:
+			for _, k := range b.fields {
+				a.add(k, b.props[k])
+			}
+		case kindArray:
+			a.elem = merge(a.elem, b.elem)
+		}
+		return a
+	case isNumber(a.kind) && isNumber(b.kind):
+		return &shape{kind: kindFloat}
+	case isText(a.kind) && isText(b.kind):
+		return &shape{kind: kindString}
+	}
+	return &shape{kind: kindMixed}
+}
+
+func isNumber(k kind) bool { return k == kindInt || k == kindFloat }
+func isText(k kind) bool   { return k == kindString || k == kindTime }
+
+// emitStruct declares a struct called name for an object shape
+func (f *File) emitStruct(name string, s *shape) {
+	// Reserve this declaration's place so it comes before the types of its
+	// fields
+	at := len(f.decls)
+	f.decls = append(f.decls, "")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "type %s struct {\n", name)
+	for _, key := range s.fields {
+		field := GoName(key)
+		fmt.Fprintf(&b, "\t%s %s `json:%q`\n", field, f.shapeType(name+field, s.props[key]), key)
+	}
+	b.WriteString("}\n")
+	f.decls[at] = b.String()
+}
+
+// shapeType is the Go type for s, declaring a struct called name for it if
+// it is an object
+func (f *File) shapeType(name string, s *shape) string {
+	if s == nil {
+		return "any"
+	}
+	switch s.kind {
+	case kindBool:
+		return "bool"
+	case kindInt:
+		return "int64"
+	case kindFloat:
+		return "float64"
+	case kindString:
+		return "string"
+	case kindTime:
+		f.use("time")
+		return "time.Time"
+	case kindObject:
+		f.emitStruct(name, s)
+		return name
+	case kindArray:
+		return "[]" + f.shapeType(name+"Item", s.elem)
+	}
+	return "any"
+}
@@ -0,0 +1,320 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PSFile is PowerShell source being generated
+type PSFile struct {
+	// Source names what the functions were generated from, for the header
+	Source string
+
+	funcs []string
+}
+
+// Bytes returns the script
+func (f *PSFile) Bytes() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Code generated by go-ps-lab2 gen from %s; DO NOT EDIT.\n", f.Source)
+	for _, fn := range f.funcs {
+		b.WriteString("\n")
+		b.WriteString(fn)
+	}
+	return b.Bytes()
+}
+
+// ParamsFromGo generates a PowerShell function for each of the named
+// struct types in the Go file src, or for every struct whose name ends
+// in Request when none are named.
+//
+// ConvertFrom-<Type> binds the properties of a request's data, as
+// ConvertFrom-Json returns it, to a param() block mirroring the struct and
+// returns them as a hashtable for splatting:
+//
+//	$request = $Data | ConvertFrom-EchoRequest
+//	Invoke-Echo @request
+//
+// Parameters match the ps tag (or field name) and take the json key as an
+// alias. Fields are mandatory unless they are pointers or omitempty in
+// their json tag. The ps tag also takes validation options, which
+// MarshalParams ignores:
+//
+//	Mode  string `ps:",set=fast|full"`  // [ValidateSet('fast', 'full')]
+//	Depth int    `ps:",range=1:10"`     // [ValidateRange(1, 10)]
+//	Path  string `ps:",notempty"`       // [ValidateNotNullOrEmpty()]
+func ParamsFromGo(source string, src []byte, types ...string) (*PSFile, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, source, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	decls := map[string]*ast.TypeSpec{}
+	var order []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok {
+			decls[spec.Name.Name] = spec
+			order = append(order, spec.Name.Name)
+		}
+		return true
+	})
+
+	if len(types) == 0 {
+		for _, name := range order {
+			if _, ok := decls[name].Type.(*ast.StructType); ok && strings.HasSuffix(name, "Request") {
+				types = append(types, name)
+			}
+		}
+		if len(types) == 0 {
+			return nil, fmt.Errorf("%s: no struct types ending in Request", source)
+		}
+	}
+
+	f := &PSFile{Source: source}
+	r := resolver{decls: decls}
+	for _, name := range types {
+		spec, ok := decls[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: no type %s", source, name)
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not a struct", source, name)
+		}
+		fn, err := r.function(name, st)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		f.funcs = append(f.funcs, fn)
+	}
+	return f, nil
+}
+
+// resolver maps Go types in one file to PowerShell
+type resolver struct {
+	decls map[string]*ast.TypeSpec
+}
+
+// psParam is one generated parameter
+type psParam struct {
+	name, key string
+	typ       string
+	mandatory bool
+	attrs     []string
+}
+
+func (r resolver) function(name string, st *ast.StructType) (string, error) {
+	var params []psParam
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			return "", fmt.Errorf("%s: embedded fields aren't supported", name)
+		}
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			raw, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return "", fmt.Errorf("%s: bad tag %s", name, field.Tag.Value)
+			}
+			tag = reflect.StructTag(raw)
+		}
+
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			p, ok, err := r.param(ident.Name, field.Type, tag)
+			if err != nil {
+				return "", fmt.Errorf("%s.%s: %w", name, ident.Name, err)
+			}
+			if ok {
+				params = append(params, p)
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Bind request data to the parameters of %s and return them for splatting\n", name)
+	fmt.Fprintf(&b, "function ConvertFrom-%s {\n", name)
+	b.WriteString("    [CmdletBinding()]\n    param(\n")
+	for i, p := range params {
+		if i > 0 {
+			b.WriteString(",\n\n")
+		}
+		if p.mandatory {
+			b.WriteString("        [Parameter(Mandatory = $true, ValueFromPipelineByPropertyName = $true)]\n")
+		} else {
+			b.WriteString("        [Parameter(ValueFromPipelineByPropertyName = $true)]\n")
+		}
+		if !strings.EqualFold(p.key, p.name) {
+			fmt.Fprintf(&b, "        [Alias(%s)]\n", quote(p.key))
+		}
+		for _, attr := range p.attrs {
+			fmt.Fprintf(&b, "        %s\n", attr)
+		}
+		b.WriteString("        ")
+		if p.typ != "" {
+			b.WriteString(p.typ + " ")
+		}
+		b.WriteString("$" + p.name)
+	}
+	if len(params) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("    )\n\n")
+	b.WriteString("    process {\n")
+	b.WriteString("        $request = @{}\n")
+	b.WriteString("        foreach ($key in $PSBoundParameters.Keys) {\n")
+	b.WriteString("            $request[$key] = $PSBoundParameters[$key]\n")
+	b.WriteString("        }\n")
+	b.WriteString("        $request\n")
+	b.WriteString("    }\n}\n")
+	return b.String(), nil
+}
+
+// param describes one field, or reports false for fields neither side sees
+func (r resolver) param(field string, expr ast.Expr, tag reflect.StructTag) (psParam, bool, error) {
+	key, jsonOpts, _ := strings.Cut(tag.Get("json"), ",")
+	psName, psOpts := cutTag(tag.Get("ps"))
+	if key == "-" || psName == "-" {
+		return psParam{}, false, nil
+	}
+	if key == "" {
+		key = field
+	}
+	if psName == "" {
+		psName = field
+	}
+
+	p := psParam{name: psName, key: key, mandatory: !slices.Contains(strings.Split(jsonOpts, ","), "omitempty")}
+	pointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		p.mandatory, pointer = false, true
+		expr = star.X
+	}
+	typ, nullable := r.psType(expr)
+	p.typ = typ
+	// A nil pointer is sent as null, which value types won't take
+	if pointer && !nullable && typ != "[string]" {
+		p.typ = "[Nullable[" + strings.Trim(typ, "[]") + "]]"
+	}
+
+	// checked is set once a validator already decides about empty values
+	checked := false
+	for _, opt := range psOpts {
+		name, arg, _ := strings.Cut(opt, "=")
+		switch name {
+		case "switch":
+			// An absent switch is false, Go's zero value
+			p.typ = "[switch]"
+			p.mandatory = false
+		case "set":
+			values := strings.Split(arg, "|")
+			for i, v := range values {
+				values[i] = quote(v)
+			}
+			p.attrs = append(p.attrs, "[ValidateSet("+strings.Join(values, ", ")+")]")
+			checked = true
+		case "range":
+			lo, hi, ok := strings.Cut(arg, ":")
+			if !ok || !isNumeric(lo) || !isNumeric(hi) {
+				return psParam{}, false, fmt.Errorf("range wants min:max, got %q", arg)
+			}
+			p.attrs = append(p.attrs, fmt.Sprintf("[ValidateRange(%s, %s)]", lo, hi))
+		case "notempty":
+			checked = true
+			p.attrs = append(p.attrs, "[ValidateNotNullOrEmpty()]")
+		}
+	}
+
+	// Go's zero values are valid requests, but a mandatory parameter
+	// rejects empty strings and arrays, and null, unless told otherwise
+	if p.mandatory && !checked {
+		switch {
+		case p.typ == "[string]":
+			p.attrs = append(p.attrs, "[AllowEmptyString()]")
+		case strings.HasSuffix(p.typ, "[]]"):
+			p.attrs = append(p.attrs, "[AllowNull()]", "[AllowEmptyCollection()]")
+		case nullable:
+			p.attrs = append(p.attrs, "[AllowNull()]")
+		}
+	}
+	return p, true, nil
+}
+
+// psType is the type constraint for a Go type, empty for none, and
+// whether its JSON can be null
+func (r resolver) psType(expr ast.Expr) (string, bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "[string]", false
+		case "bool":
+			return "[bool]", false
+		case "int", "int8", "int16", "int32", "uint8", "uint16", "byte", "rune":
+			return "[int]", false
+		case "int64", "uint", "uint32", "uint64", "uintptr":
+			return "[long]", false
+		case "float32", "float64":
+			return "[double]", false
+		case "any":
+			return "", true
+		}
+		if spec, ok := r.decls[t.Name]; ok {
+			if _, isStruct := spec.Type.(*ast.StructType); isStruct {
+				return "[psobject]", true
+			}
+			return r.psType(spec.Type)
+		}
+		return "", true
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			return "[datetime]", false
+		}
+		return "", true
+	case *ast.ArrayType:
+		// []byte travels as a base64 string
+		if elem, ok := t.Elt.(*ast.Ident); ok && (elem.Name == "byte" || elem.Name == "uint8") {
+			return "[string]", true
+		}
+		elem, _ := r.psType(t.Elt)
+		if elem == "" {
+			elem = "[object]"
+		}
+		return "[" + strings.Trim(elem, "[]") + "[]]", true
+	case *ast.MapType:
+		// ConvertFrom-Json returns objects, not hashtables
+		return "[psobject]", true
+	case *ast.StarExpr:
+		typ, _ := r.psType(t.X)
+		return typ, true
+	}
+	return "", true
+}
+
+// cutTag splits a ps tag like parseTag in psbridge
+func cutTag(tag string) (string, []string) {
+	name, rest, _ := strings.Cut(tag, ",")
+	if rest == "" {
+		return name, nil
+	}
+	return name, strings.Split(rest, ",")
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// quote renders s as a single-quoted PowerShell string
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,72 @@
+package gen
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+// Router generates the script a client loads with psbridge.WithRouter:
+// it dot-sources each include, relative to itself, for the handler
+// functions, then registers every operation in reg with the shim. Run it
+// from a small program under go:generate, since the registry only exists
+// at run time:
+//
+//	f := gen.Router("ops.go", ops, "handlers.ps1")
+//	os.WriteFile("router.ps1", f.Bytes(), 0o644)
+func Router(source string, reg *psbridge.Registry, include ...string) *PSFile {
+	f := &PSFile{Source: source}
+	if len(include) > 0 {
+		var b strings.Builder
+		for _, path := range include {
+			fmt.Fprintf(&b, ". (Join-Path $PSScriptRoot %s)\n", quote(path))
+		}
+		f.funcs = append(f.funcs, b.String())
+	}
+
+	for _, op := range reg.Operations() {
+		var b strings.Builder
+		fmt.Fprintf(&b, "# %s takes %s and returns %s\n", op.Name, typeName(op.Request), typeName(op.Response))
+		if keys := jsonKeys(op.Request); len(keys) > 0 {
+			fmt.Fprintf(&b, "#   data: %s\n", strings.Join(keys, ", "))
+		}
+		fmt.Fprintf(&b, "Register-BridgeOperation -Name %s -Handler %s\n", quote(op.Name), quote(op.Handler))
+		f.funcs = append(f.funcs, b.String())
+	}
+	return f
+}
+
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return "nothing"
+	}
+	return t.String()
+}
+
+// jsonKeys lists the top-level keys a struct type marshals to
+func jsonKeys(t reflect.Type) []string {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
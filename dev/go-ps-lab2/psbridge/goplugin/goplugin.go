@@ -0,0 +1,134 @@
+// Package goplugin serves psbridge as a plugin for HashiCorp's go-plugin,
+// so a host application loads PowerShell-backed operations the way it
+// loads any other plugin: it starts the plugin binary, which shakes hands
+// and then answers the gRPC service psbridge.v1.Bridge, and kills or shuts
+// it down to unload it.
+//
+// The host's side is ordinary go-plugin code, with this package's Plugin
+// in its plugin set:
+//
+//	client := plugin.NewClient(&plugin.ClientConfig{
+//		HandshakeConfig:  goplugin.Handshake,
+//		Plugins:          plugin.PluginSet{goplugin.PluginName: &goplugin.Plugin{}},
+//		Cmd:              exec.Command("go-ps-lab2", "--script", "tools.ps1", "plugin"),
+//		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+//		AutoMTLS:         true,
+//	})
+//	rpc, err := client.Client()
+//	...
+//	raw, err := rpc.Dispense(goplugin.PluginName)
+//	bridge := raw.(psbridgev1.BridgeClient)
+//
+// Each plugin is a process with its own pool of sessions running its own
+// script, so plugins share no PowerShell state, and one crashing or being
+// unloaded leaves the others running. The plugin speaks only the gRPC
+// protocol.
+package goplugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/grpcserver"
+	"example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+)
+
+// The handshake a host configures to load this plugin. A binary started
+// without the cookie isn't started by a host, and refuses to serve.
+const (
+	MagicCookieKey   = "PSBRIDGE_PLUGIN"
+	MagicCookieValue = "5b0e3e7a-powershell-bridge"
+	// ProtocolVersion is the version of the plugin's API, the Bridge
+	// service, that hosts ask for
+	ProtocolVersion = 1
+	// PluginName names the plugin in the host's plugin set
+	PluginName = "psbridge"
+)
+
+// Handshake is the HandshakeConfig of hosts loading the plugin
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  ProtocolVersion,
+	MagicCookieKey:   MagicCookieKey,
+	MagicCookieValue: MagicCookieValue,
+}
+
+// defaultStartTimeout bounds a session's start when the client doesn't,
+// so a PowerShell that hangs fails the plugin rather than the host's
+// handshake waiting on it
+const defaultStartTimeout = 30 * time.Second
+
+// ErrNotPlugin is returned by Serve when the binary was started by hand
+// rather than by a host
+var ErrNotPlugin = errors.New("psbridge: this is a go-plugin plugin, for a host application to start; it isn't meant to run by hand")
+
+// Config tunes Serve
+type Config struct {
+	// Client runs the plugin's calls; its script holds what the plugin
+	// serves, typically with a Router. Sessions it starts are bounded by
+	// Timeouts.SessionStart, 30s if it is zero.
+	Client *psbridge.Client
+	// Pool sizes the plugin's sessions. Min defaults to 1, so a plugin
+	// whose PowerShell won't start fails before its handshake.
+	Pool psbridge.PoolConfig
+	// Timeout bounds each call; zero for none
+	Timeout time.Duration
+}
+
+// Plugin is the go-plugin plugin: in the plugin, serving Bridge, and in
+// the host, where Dispense gives a psbridgev1.BridgeClient
+type Plugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	// Bridge answers calls in the plugin; hosts leave it nil
+	Bridge *grpcserver.Server
+}
+
+func (p *Plugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	p.Bridge.Register(s)
+	return nil
+}
+
+func (p *Plugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return psbridgev1.NewBridgeClient(conn), nil
+}
+
+// Serve runs the plugin until the host shuts it down or kills it. It
+// fails without serving if the pool's first sessions don't start.
+func Serve(cfg Config) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return ErrNotPlugin
+	}
+	client := cfg.Client
+	if client.Timeouts.SessionStart == 0 {
+		bounded := *client
+		bounded.Timeouts.SessionStart = defaultStartTimeout
+		client = &bounded
+	}
+	if cfg.Pool.Min == 0 {
+		cfg.Pool.Min = 1
+	}
+	pool, err := client.NewPool(cfg.Pool)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	serve(grpcserver.NewServer(pool, grpcserver.Config{Client: client, Timeout: cfg.Timeout}), nil)
+	return nil
+}
+
+// serve hands the process to go-plugin, or with test runs it in process
+func serve(srv *grpcserver.Server, test *plugin.ServeTestConfig) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig:  Handshake,
+		VersionedPlugins: map[int]plugin.PluginSet{ProtocolVersion: {PluginName: &Plugin{Bridge: srv}}},
+		GRPCServer: func(opts []grpc.ServerOption) *grpc.Server {
+			return grpc.NewServer(append(opts, grpc.MaxRecvMsgSize(grpcserver.MaxMessageSize))...)
+		},
+		Test: test,
+	})
+}
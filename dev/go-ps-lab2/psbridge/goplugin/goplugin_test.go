@@ -0,0 +1,109 @@
+package goplugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/grpcserver"
+	"example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1"
+	"example.com/go-ps-lab2/psbridge/pstest"
+	"github.com/hashicorp/go-plugin"
+)
+
+func TestServeNotPlugin(t *testing.T) {
+	t.Setenv(MagicCookieKey, "")
+	if err := Serve(Config{Client: psbridge.NewClient("tools.ps1")}); !errors.Is(err, ErrNotPlugin) {
+		t.Errorf("err = %v, want ErrNotPlugin", err)
+	}
+}
+
+// serveWithin runs Serve, failing the test if it takes longer than d
+func serveWithin(t *testing.T, d time.Duration, cfg Config) error {
+	t.Helper()
+	t.Setenv(MagicCookieKey, MagicCookieValue)
+	done := make(chan error, 1)
+	go func() { done <- Serve(cfg) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		t.Fatal("Serve hung on a PowerShell that won't start")
+		return nil
+	}
+}
+
+func TestServeShellMissing(t *testing.T) {
+	client := psbridge.NewClient("tools.ps1", psbridge.WithShell(filepath.Join(t.TempDir(), "no-pwsh")))
+	if err := serveWithin(t, 10*time.Second, Config{Client: client}); err == nil {
+		t.Error("Serve succeeded without a shell")
+	}
+}
+
+func TestServeShellHangs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script for PowerShell")
+	}
+	shell := filepath.Join(t.TempDir(), "pwsh")
+	if err := os.WriteFile(shell, []byte("#!/bin/sh\nexec sleep 3600\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	client := psbridge.NewClient("tools.ps1", psbridge.WithShell(shell))
+	client.Timeouts.SessionStart = 100 * time.Millisecond
+	err := serveWithin(t, 10*time.Second, Config{Client: client})
+	var timeout *psbridge.TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Errorf("err = %v, want a *TimeoutError", err)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	fake := pstest.NewFake()
+	fake.Respond("services", []string{"Spooler"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reattach := make(chan *plugin.ReattachConfig, 1)
+	closed := make(chan struct{})
+	go serve(grpcserver.NewServer(fake, grpcserver.Config{}), &plugin.ServeTestConfig{Context: ctx, ReattachConfigCh: reattach, CloseCh: closed})
+	defer func() {
+		cancel()
+		<-closed
+	}()
+
+	var config *plugin.ReattachConfig
+	select {
+	case config = <-reattach:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the plugin didn't start")
+	}
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          plugin.PluginSet{PluginName: &Plugin{}},
+		Reattach:         config,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+	})
+	defer client.Kill()
+	rpc, err := client.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Ping(); err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	raw, err := rpc.Dispense(PluginName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := raw.(psbridgev1.BridgeClient).Invoke(context.Background(), &psbridgev1.InvokeRequest{Op: "services"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `["Spooler"]` {
+		t.Errorf("Data = %s", res.Data)
+	}
+}
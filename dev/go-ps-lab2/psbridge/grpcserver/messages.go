@@ -0,0 +1,97 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"example.com/go-ps-lab2/psbridge"
+	pb "example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1"
+)
+
+// Conversions between bridge.proto's messages and psbridge's types
+
+// call makes the psbridge call req asks for
+func call(req *pb.InvokeRequest) (*psbridge.Call, error) {
+	if err := checkRequest(req); err != nil {
+		return nil, err
+	}
+	c := &psbridge.Call{Op: req.Op, Data: data(req)}
+	for _, opt := range options(req) {
+		opt(c)
+	}
+	return c, nil
+}
+
+// checkRequest reports what is wrong with req, if anything
+func checkRequest(req *pb.InvokeRequest) error {
+	if req.Op == "" {
+		return badRequest{errors.New("InvokeRequest has no op")}
+	}
+	if len(req.Data) > 0 && !json.Valid(req.Data) {
+		return badRequest{fmt.Errorf("InvokeRequest data for %s isn't JSON", req.Op)}
+	}
+	return nil
+}
+
+// data is the request's payload, nil for none
+func data(req *pb.InvokeRequest) json.RawMessage {
+	if len(req.Data) == 0 {
+		return nil
+	}
+	return json.RawMessage(req.Data)
+}
+
+// options are the call options the request asks for
+func options(req *pb.InvokeRequest) []psbridge.CallOption {
+	var opts []psbridge.CallOption
+	if req.Dir != "" {
+		opts = append(opts, psbridge.WithCallWorkingDir(req.Dir))
+	}
+	if len(req.Env) > 0 {
+		opts = append(opts, psbridge.WithEnv(req.Env))
+	}
+	return opts
+}
+
+func toResponse(data []byte, streams psbridge.Streams) *pb.InvokeResponse {
+	return &pb.InvokeResponse{Data: data, Streams: toStreams(streams)}
+}
+
+func toStreams(s psbridge.Streams) *pb.Streams {
+	out := &pb.Streams{
+		Verbose:     s.Verbose,
+		Warning:     s.Warning,
+		Debug:       s.Debug,
+		Information: s.Information,
+		Stdout:      s.Stdout,
+	}
+	for _, err := range s.Errors {
+		out.Errors = append(out.Errors, toError(err))
+	}
+	return out
+}
+
+func toError(err *psbridge.PSError) *pb.Error {
+	return &pb.Error{
+		Type:             err.Type,
+		Message:          err.Message,
+		Category:         err.Category,
+		ErrorId:          err.ErrorID,
+		TargetObject:     err.TargetObject,
+		ScriptStackTrace: err.ScriptStackTrace,
+	}
+}
+
+func toProgress(p psbridge.ProgressRecord) *pb.Progress {
+	return &pb.Progress{
+		ActivityId:       int32(p.ActivityID),
+		ParentActivityId: int32(p.ParentActivityID),
+		Activity:         p.Activity,
+		Status:           p.Status,
+		CurrentOperation: p.CurrentOperation,
+		PercentComplete:  int32(p.PercentComplete),
+		SecondsRemaining: int32(p.SecondsRemaining),
+		Completed:        p.Completed,
+	}
+}
@@ -0,0 +1,838 @@
+// The invoke API of a host running psbridge, served by package grpcserver.
+// Requests and results travel as JSON, exactly as the Go API passes them to
+// the shim, so any operation it serves can be called without a message
+// type of its own.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: bridge.proto
+
+package psbridgev1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type InvokeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// op is the operation, e.g. "services"
+	Op string `protobuf:"bytes,1,opt,name=op,proto3" json:"op,omitempty"`
+	// data is the request as JSON; empty for none
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// dir is the working directory for the call
+	Dir string `protobuf:"bytes,3,opt,name=dir,proto3" json:"dir,omitempty"`
+	// env is set in the script's environment for the call
+	Env           map[string]string `protobuf:"bytes,4,rep,name=env,proto3" json:"env,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvokeRequest) Reset() {
+	*x = InvokeRequest{}
+	mi := &file_bridge_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvokeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvokeRequest) ProtoMessage() {}
+
+func (x *InvokeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvokeRequest.ProtoReflect.Descriptor instead.
+func (*InvokeRequest) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *InvokeRequest) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *InvokeRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *InvokeRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+func (x *InvokeRequest) GetEnv() map[string]string {
+	if x != nil {
+		return x.Env
+	}
+	return nil
+}
+
+type InvokeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// data is the result as JSON
+	Data          []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Streams       *Streams `protobuf:"bytes,2,opt,name=streams,proto3" json:"streams,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvokeResponse) Reset() {
+	*x = InvokeResponse{}
+	mi := &file_bridge_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvokeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvokeResponse) ProtoMessage() {}
+
+func (x *InvokeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvokeResponse.ProtoReflect.Descriptor instead.
+func (*InvokeResponse) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InvokeResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *InvokeResponse) GetStreams() *Streams {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
+// What the operation wrote to PowerShell's other streams
+type Streams struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Verbose     []string               `protobuf:"bytes,1,rep,name=verbose,proto3" json:"verbose,omitempty"`
+	Warning     []string               `protobuf:"bytes,2,rep,name=warning,proto3" json:"warning,omitempty"`
+	Debug       []string               `protobuf:"bytes,3,rep,name=debug,proto3" json:"debug,omitempty"`
+	Information []string               `protobuf:"bytes,4,rep,name=information,proto3" json:"information,omitempty"`
+	// errors are non-terminating errors, e.g. from Write-Error
+	Errors []*Error `protobuf:"bytes,5,rep,name=errors,proto3" json:"errors,omitempty"`
+	// stdout is text written outside the protocol, a line each
+	Stdout        []string `protobuf:"bytes,6,rep,name=stdout,proto3" json:"stdout,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Streams) Reset() {
+	*x = Streams{}
+	mi := &file_bridge_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Streams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Streams) ProtoMessage() {}
+
+func (x *Streams) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Streams.ProtoReflect.Descriptor instead.
+func (*Streams) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Streams) GetVerbose() []string {
+	if x != nil {
+		return x.Verbose
+	}
+	return nil
+}
+
+func (x *Streams) GetWarning() []string {
+	if x != nil {
+		return x.Warning
+	}
+	return nil
+}
+
+func (x *Streams) GetDebug() []string {
+	if x != nil {
+		return x.Debug
+	}
+	return nil
+}
+
+func (x *Streams) GetInformation() []string {
+	if x != nil {
+		return x.Information
+	}
+	return nil
+}
+
+func (x *Streams) GetErrors() []*Error {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+func (x *Streams) GetStdout() []string {
+	if x != nil {
+		return x.Stdout
+	}
+	return nil
+}
+
+// A PowerShell error. A call failing with one also sends it, encoded, in
+// the psbridge-error-bin trailer.
+type Error struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// type is the .NET exception type
+	Type    string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// category is the ErrorCategory name, e.g. ObjectNotFound
+	Category string `protobuf:"bytes,3,opt,name=category,proto3" json:"category,omitempty"`
+	// error_id is the FullyQualifiedErrorId
+	ErrorId          string `protobuf:"bytes,4,opt,name=error_id,json=errorId,proto3" json:"error_id,omitempty"`
+	TargetObject     string `protobuf:"bytes,5,opt,name=target_object,json=targetObject,proto3" json:"target_object,omitempty"`
+	ScriptStackTrace string `protobuf:"bytes,6,opt,name=script_stack_trace,json=scriptStackTrace,proto3" json:"script_stack_trace,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_bridge_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Error) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Error) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *Error) GetErrorId() string {
+	if x != nil {
+		return x.ErrorId
+	}
+	return ""
+}
+
+func (x *Error) GetTargetObject() string {
+	if x != nil {
+		return x.TargetObject
+	}
+	return ""
+}
+
+func (x *Error) GetScriptStackTrace() string {
+	if x != nil {
+		return x.ScriptStackTrace
+	}
+	return ""
+}
+
+// One Write-Progress update
+type Progress struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	ActivityId       int32                  `protobuf:"varint,1,opt,name=activity_id,json=activityId,proto3" json:"activity_id,omitempty"`
+	ParentActivityId int32                  `protobuf:"varint,2,opt,name=parent_activity_id,json=parentActivityId,proto3" json:"parent_activity_id,omitempty"`
+	Activity         string                 `protobuf:"bytes,3,opt,name=activity,proto3" json:"activity,omitempty"`
+	Status           string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	CurrentOperation string                 `protobuf:"bytes,5,opt,name=current_operation,json=currentOperation,proto3" json:"current_operation,omitempty"`
+	// percent_complete is -1 when the script didn't report one
+	PercentComplete int32 `protobuf:"varint,6,opt,name=percent_complete,json=percentComplete,proto3" json:"percent_complete,omitempty"`
+	// seconds_remaining is -1 when the script didn't report one
+	SecondsRemaining int32 `protobuf:"varint,7,opt,name=seconds_remaining,json=secondsRemaining,proto3" json:"seconds_remaining,omitempty"`
+	Completed        bool  `protobuf:"varint,8,opt,name=completed,proto3" json:"completed,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Progress) Reset() {
+	*x = Progress{}
+	mi := &file_bridge_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Progress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Progress) ProtoMessage() {}
+
+func (x *Progress) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Progress.ProtoReflect.Descriptor instead.
+func (*Progress) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Progress) GetActivityId() int32 {
+	if x != nil {
+		return x.ActivityId
+	}
+	return 0
+}
+
+func (x *Progress) GetParentActivityId() int32 {
+	if x != nil {
+		return x.ParentActivityId
+	}
+	return 0
+}
+
+func (x *Progress) GetActivity() string {
+	if x != nil {
+		return x.Activity
+	}
+	return ""
+}
+
+func (x *Progress) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Progress) GetCurrentOperation() string {
+	if x != nil {
+		return x.CurrentOperation
+	}
+	return ""
+}
+
+func (x *Progress) GetPercentComplete() int32 {
+	if x != nil {
+		return x.PercentComplete
+	}
+	return 0
+}
+
+func (x *Progress) GetSecondsRemaining() int32 {
+	if x != nil {
+		return x.SecondsRemaining
+	}
+	return 0
+}
+
+func (x *Progress) GetCompleted() bool {
+	if x != nil {
+		return x.Completed
+	}
+	return false
+}
+
+type InvokeEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*InvokeEvent_Progress
+	//	*InvokeEvent_Result
+	Event         isInvokeEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InvokeEvent) Reset() {
+	*x = InvokeEvent{}
+	mi := &file_bridge_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvokeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvokeEvent) ProtoMessage() {}
+
+func (x *InvokeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvokeEvent.ProtoReflect.Descriptor instead.
+func (*InvokeEvent) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *InvokeEvent) GetEvent() isInvokeEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *InvokeEvent) GetProgress() *Progress {
+	if x != nil {
+		if x, ok := x.Event.(*InvokeEvent_Progress); ok {
+			return x.Progress
+		}
+	}
+	return nil
+}
+
+func (x *InvokeEvent) GetResult() *InvokeResponse {
+	if x != nil {
+		if x, ok := x.Event.(*InvokeEvent_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+type isInvokeEvent_Event interface {
+	isInvokeEvent_Event()
+}
+
+type InvokeEvent_Progress struct {
+	Progress *Progress `protobuf:"bytes,1,opt,name=progress,proto3,oneof"`
+}
+
+type InvokeEvent_Result struct {
+	Result *InvokeResponse `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*InvokeEvent_Progress) isInvokeEvent_Event() {}
+
+func (*InvokeEvent_Result) isInvokeEvent_Event() {}
+
+type PipelineInput struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Input:
+	//
+	//	*PipelineInput_Request
+	//	*PipelineInput_Item
+	Input         isPipelineInput_Input `protobuf_oneof:"input"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PipelineInput) Reset() {
+	*x = PipelineInput{}
+	mi := &file_bridge_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PipelineInput) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PipelineInput) ProtoMessage() {}
+
+func (x *PipelineInput) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PipelineInput.ProtoReflect.Descriptor instead.
+func (*PipelineInput) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PipelineInput) GetInput() isPipelineInput_Input {
+	if x != nil {
+		return x.Input
+	}
+	return nil
+}
+
+func (x *PipelineInput) GetRequest() *InvokeRequest {
+	if x != nil {
+		if x, ok := x.Input.(*PipelineInput_Request); ok {
+			return x.Request
+		}
+	}
+	return nil
+}
+
+func (x *PipelineInput) GetItem() []byte {
+	if x != nil {
+		if x, ok := x.Input.(*PipelineInput_Item); ok {
+			return x.Item
+		}
+	}
+	return nil
+}
+
+type isPipelineInput_Input interface {
+	isPipelineInput_Input()
+}
+
+type PipelineInput_Request struct {
+	// request is the first message, and only the first
+	Request *InvokeRequest `protobuf:"bytes,1,opt,name=request,proto3,oneof"`
+}
+
+type PipelineInput_Item struct {
+	// item is one input object as JSON
+	Item []byte `protobuf:"bytes,2,opt,name=item,proto3,oneof"`
+}
+
+func (*PipelineInput_Request) isPipelineInput_Input() {}
+
+func (*PipelineInput_Item) isPipelineInput_Input() {}
+
+type PipelineOutput struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Output:
+	//
+	//	*PipelineOutput_Item
+	//	*PipelineOutput_Progress
+	//	*PipelineOutput_Streams
+	Output        isPipelineOutput_Output `protobuf_oneof:"output"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PipelineOutput) Reset() {
+	*x = PipelineOutput{}
+	mi := &file_bridge_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PipelineOutput) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PipelineOutput) ProtoMessage() {}
+
+func (x *PipelineOutput) ProtoReflect() protoreflect.Message {
+	mi := &file_bridge_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PipelineOutput.ProtoReflect.Descriptor instead.
+func (*PipelineOutput) Descriptor() ([]byte, []int) {
+	return file_bridge_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PipelineOutput) GetOutput() isPipelineOutput_Output {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *PipelineOutput) GetItem() []byte {
+	if x != nil {
+		if x, ok := x.Output.(*PipelineOutput_Item); ok {
+			return x.Item
+		}
+	}
+	return nil
+}
+
+func (x *PipelineOutput) GetProgress() *Progress {
+	if x != nil {
+		if x, ok := x.Output.(*PipelineOutput_Progress); ok {
+			return x.Progress
+		}
+	}
+	return nil
+}
+
+func (x *PipelineOutput) GetStreams() *Streams {
+	if x != nil {
+		if x, ok := x.Output.(*PipelineOutput_Streams); ok {
+			return x.Streams
+		}
+	}
+	return nil
+}
+
+type isPipelineOutput_Output interface {
+	isPipelineOutput_Output()
+}
+
+type PipelineOutput_Item struct {
+	// item is one output object as JSON
+	Item []byte `protobuf:"bytes,1,opt,name=item,proto3,oneof"`
+}
+
+type PipelineOutput_Progress struct {
+	Progress *Progress `protobuf:"bytes,2,opt,name=progress,proto3,oneof"`
+}
+
+type PipelineOutput_Streams struct {
+	// streams is sent last, once the pipeline has ended
+	Streams *Streams `protobuf:"bytes,3,opt,name=streams,proto3,oneof"`
+}
+
+func (*PipelineOutput_Item) isPipelineOutput_Output() {}
+
+func (*PipelineOutput_Progress) isPipelineOutput_Output() {}
+
+func (*PipelineOutput_Streams) isPipelineOutput_Output() {}
+
+var File_bridge_proto protoreflect.FileDescriptor
+
+const file_bridge_proto_rawDesc = "" +
+	"\n" +
+	"\fbridge.proto\x12\vpsbridge.v1\"\xb4\x01\n" +
+	"\rInvokeRequest\x12\x0e\n" +
+	"\x02op\x18\x01 \x01(\tR\x02op\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x10\n" +
+	"\x03dir\x18\x03 \x01(\tR\x03dir\x125\n" +
+	"\x03env\x18\x04 \x03(\v2#.psbridge.v1.InvokeRequest.EnvEntryR\x03env\x1a6\n" +
+	"\bEnvEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"T\n" +
+	"\x0eInvokeResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12.\n" +
+	"\astreams\x18\x02 \x01(\v2\x14.psbridge.v1.StreamsR\astreams\"\xb9\x01\n" +
+	"\aStreams\x12\x18\n" +
+	"\averbose\x18\x01 \x03(\tR\averbose\x12\x18\n" +
+	"\awarning\x18\x02 \x03(\tR\awarning\x12\x14\n" +
+	"\x05debug\x18\x03 \x03(\tR\x05debug\x12 \n" +
+	"\vinformation\x18\x04 \x03(\tR\vinformation\x12*\n" +
+	"\x06errors\x18\x05 \x03(\v2\x12.psbridge.v1.ErrorR\x06errors\x12\x16\n" +
+	"\x06stdout\x18\x06 \x03(\tR\x06stdout\"\xbf\x01\n" +
+	"\x05Error\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1a\n" +
+	"\bcategory\x18\x03 \x01(\tR\bcategory\x12\x19\n" +
+	"\berror_id\x18\x04 \x01(\tR\aerrorId\x12#\n" +
+	"\rtarget_object\x18\x05 \x01(\tR\ftargetObject\x12,\n" +
+	"\x12script_stack_trace\x18\x06 \x01(\tR\x10scriptStackTrace\"\xb0\x02\n" +
+	"\bProgress\x12\x1f\n" +
+	"\vactivity_id\x18\x01 \x01(\x05R\n" +
+	"activityId\x12,\n" +
+	"\x12parent_activity_id\x18\x02 \x01(\x05R\x10parentActivityId\x12\x1a\n" +
+	"\bactivity\x18\x03 \x01(\tR\bactivity\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12+\n" +
+	"\x11current_operation\x18\x05 \x01(\tR\x10currentOperation\x12)\n" +
+	"\x10percent_complete\x18\x06 \x01(\x05R\x0fpercentComplete\x12+\n" +
+	"\x11seconds_remaining\x18\a \x01(\x05R\x10secondsRemaining\x12\x1c\n" +
+	"\tcompleted\x18\b \x01(\bR\tcompleted\"\x82\x01\n" +
+	"\vInvokeEvent\x123\n" +
+	"\bprogress\x18\x01 \x01(\v2\x15.psbridge.v1.ProgressH\x00R\bprogress\x125\n" +
+	"\x06result\x18\x02 \x01(\v2\x1b.psbridge.v1.InvokeResponseH\x00R\x06resultB\a\n" +
+	"\x05event\"f\n" +
+	"\rPipelineInput\x126\n" +
+	"\arequest\x18\x01 \x01(\v2\x1a.psbridge.v1.InvokeRequestH\x00R\arequest\x12\x14\n" +
+	"\x04item\x18\x02 \x01(\fH\x00R\x04itemB\a\n" +
+	"\x05input\"\x97\x01\n" +
+	"\x0ePipelineOutput\x12\x14\n" +
+	"\x04item\x18\x01 \x01(\fH\x00R\x04item\x123\n" +
+	"\bprogress\x18\x02 \x01(\v2\x15.psbridge.v1.ProgressH\x00R\bprogress\x120\n" +
+	"\astreams\x18\x03 \x01(\v2\x14.psbridge.v1.StreamsH\x00R\astreamsB\b\n" +
+	"\x06output2\xdc\x01\n" +
+	"\x06Bridge\x12A\n" +
+	"\x06Invoke\x12\x1a.psbridge.v1.InvokeRequest\x1a\x1b.psbridge.v1.InvokeResponse\x12F\n" +
+	"\fInvokeStream\x12\x1a.psbridge.v1.InvokeRequest\x1a\x18.psbridge.v1.InvokeEvent0\x01\x12G\n" +
+	"\bPipeline\x12\x1a.psbridge.v1.PipelineInput\x1a\x1b.psbridge.v1.PipelineOutput(\x010\x01B7Z5example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1b\x06proto3"
+
+var (
+	file_bridge_proto_rawDescOnce sync.Once
+	file_bridge_proto_rawDescData []byte
+)
+
+func file_bridge_proto_rawDescGZIP() []byte {
+	file_bridge_proto_rawDescOnce.Do(func() {
+		file_bridge_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_bridge_proto_rawDesc), len(file_bridge_proto_rawDesc)))
+	})
+	return file_bridge_proto_rawDescData
+}
+
+var file_bridge_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_bridge_proto_goTypes = []any{
+	(*InvokeRequest)(nil),  // 0: psbridge.v1.InvokeRequest
+	(*InvokeResponse)(nil), // 1: psbridge.v1.InvokeResponse
+	(*Streams)(nil),        // 2: psbridge.v1.Streams
+	(*Error)(nil),          // 3: psbridge.v1.Error
+	(*Progress)(nil),       // 4: psbridge.v1.Progress
+	(*InvokeEvent)(nil),    // 5: psbridge.v1.InvokeEvent
+	(*PipelineInput)(nil),  // 6: psbridge.v1.PipelineInput
+	(*PipelineOutput)(nil), // 7: psbridge.v1.PipelineOutput
+	nil,                    // 8: psbridge.v1.InvokeRequest.EnvEntry
+}
+var file_bridge_proto_depIdxs = []int32{
+	8,  // 0: psbridge.v1.InvokeRequest.env:type_name -> psbridge.v1.InvokeRequest.EnvEntry
+	2,  // 1: psbridge.v1.InvokeResponse.streams:type_name -> psbridge.v1.Streams
+	3,  // 2: psbridge.v1.Streams.errors:type_name -> psbridge.v1.Error
+	4,  // 3: psbridge.v1.InvokeEvent.progress:type_name -> psbridge.v1.Progress
+	1,  // 4: psbridge.v1.InvokeEvent.result:type_name -> psbridge.v1.InvokeResponse
+	0,  // 5: psbridge.v1.PipelineInput.request:type_name -> psbridge.v1.InvokeRequest
+	4,  // 6: psbridge.v1.PipelineOutput.progress:type_name -> psbridge.v1.Progress
+	2,  // 7: psbridge.v1.PipelineOutput.streams:type_name -> psbridge.v1.Streams
+	0,  // 8: psbridge.v1.Bridge.Invoke:input_type -> psbridge.v1.InvokeRequest
+	0,  // 9: psbridge.v1.Bridge.InvokeStream:input_type -> psbridge.v1.InvokeRequest
+	6,  // 10: psbridge.v1.Bridge.Pipeline:input_type -> psbridge.v1.PipelineInput
+	1,  // 11: psbridge.v1.Bridge.Invoke:output_type -> psbridge.v1.InvokeResponse
+	5,  // 12: psbridge.v1.Bridge.InvokeStream:output_type -> psbridge.v1.InvokeEvent
+	7,  // 13: psbridge.v1.Bridge.Pipeline:output_type -> psbridge.v1.PipelineOutput
+	11, // [11:14] is the sub-list for method output_type
+	8,  // [8:11] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_bridge_proto_init() }
+func file_bridge_proto_init() {
+	if File_bridge_proto != nil {
+		return
+	}
+	file_bridge_proto_msgTypes[5].OneofWrappers = []any{
+		(*InvokeEvent_Progress)(nil),
+		(*InvokeEvent_Result)(nil),
+	}
+	file_bridge_proto_msgTypes[6].OneofWrappers = []any{
+		(*PipelineInput_Request)(nil),
+		(*PipelineInput_Item)(nil),
+	}
+	file_bridge_proto_msgTypes[7].OneofWrappers = []any{
+		(*PipelineOutput_Item)(nil),
+		(*PipelineOutput_Progress)(nil),
+		(*PipelineOutput_Streams)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_bridge_proto_rawDesc), len(file_bridge_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_bridge_proto_goTypes,
+		DependencyIndexes: file_bridge_proto_depIdxs,
+		MessageInfos:      file_bridge_proto_msgTypes,
+	}.Build()
+	File_bridge_proto = out.File
+	file_bridge_proto_goTypes = nil
+	file_bridge_proto_depIdxs = nil
+}
@@ -0,0 +1,212 @@
+// The invoke API of a host running psbridge, served by package grpcserver.
+// Requests and results travel as JSON, exactly as the Go API passes them to
+// the shim, so any operation it serves can be called without a message
+// type of its own.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: bridge.proto
+
+package psbridgev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Bridge_Invoke_FullMethodName       = "/psbridge.v1.Bridge/Invoke"
+	Bridge_InvokeStream_FullMethodName = "/psbridge.v1.Bridge/InvokeStream"
+	Bridge_Pipeline_FullMethodName     = "/psbridge.v1.Bridge/Pipeline"
+)
+
+// BridgeClient is the client API for Bridge service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BridgeClient interface {
+	// Invoke runs one operation and returns its result
+	Invoke(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (*InvokeResponse, error)
+	// InvokeStream runs one operation, sending its progress as it arrives
+	// and its result last
+	InvokeStream(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InvokeEvent], error)
+	// Pipeline streams items into an operation's pipeline and its output
+	// back an object at a time. The first message is the request, every
+	// later one an item; closing the send side ends the input.
+	Pipeline(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PipelineInput, PipelineOutput], error)
+}
+
+type bridgeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBridgeClient(cc grpc.ClientConnInterface) BridgeClient {
+	return &bridgeClient{cc}
+}
+
+func (c *bridgeClient) Invoke(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (*InvokeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InvokeResponse)
+	err := c.cc.Invoke(ctx, Bridge_Invoke_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bridgeClient) InvokeStream(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InvokeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Bridge_ServiceDesc.Streams[0], Bridge_InvokeStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[InvokeRequest, InvokeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bridge_InvokeStreamClient = grpc.ServerStreamingClient[InvokeEvent]
+
+func (c *bridgeClient) Pipeline(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PipelineInput, PipelineOutput], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Bridge_ServiceDesc.Streams[1], Bridge_Pipeline_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PipelineInput, PipelineOutput]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bridge_PipelineClient = grpc.BidiStreamingClient[PipelineInput, PipelineOutput]
+
+// BridgeServer is the server API for Bridge service.
+// All implementations must embed UnimplementedBridgeServer
+// for forward compatibility.
+type BridgeServer interface {
+	// Invoke runs one operation and returns its result
+	Invoke(context.Context, *InvokeRequest) (*InvokeResponse, error)
+	// InvokeStream runs one operation, sending its progress as it arrives
+	// and its result last
+	InvokeStream(*InvokeRequest, grpc.ServerStreamingServer[InvokeEvent]) error
+	// Pipeline streams items into an operation's pipeline and its output
+	// back an object at a time. The first message is the request, every
+	// later one an item; closing the send side ends the input.
+	Pipeline(grpc.BidiStreamingServer[PipelineInput, PipelineOutput]) error
+	mustEmbedUnimplementedBridgeServer()
+}
+
+// UnimplementedBridgeServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBridgeServer struct{}
+
+func (UnimplementedBridgeServer) Invoke(context.Context, *InvokeRequest) (*InvokeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Invoke not implemented")
+}
+func (UnimplementedBridgeServer) InvokeStream(*InvokeRequest, grpc.ServerStreamingServer[InvokeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method InvokeStream not implemented")
+}
+func (UnimplementedBridgeServer) Pipeline(grpc.BidiStreamingServer[PipelineInput, PipelineOutput]) error {
+	return status.Errorf(codes.Unimplemented, "method Pipeline not implemented")
+}
+func (UnimplementedBridgeServer) mustEmbedUnimplementedBridgeServer() {}
+func (UnimplementedBridgeServer) testEmbeddedByValue()                {}
+
+// UnsafeBridgeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BridgeServer will
+// result in compilation errors.
+type UnsafeBridgeServer interface {
+	mustEmbedUnimplementedBridgeServer()
+}
+
+func RegisterBridgeServer(s grpc.ServiceRegistrar, srv BridgeServer) {
+	// If the following call pancis, it indicates UnimplementedBridgeServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Bridge_ServiceDesc, srv)
+}
+
+func _Bridge_Invoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BridgeServer).Invoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bridge_Invoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BridgeServer).Invoke(ctx, req.(*InvokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bridge_InvokeStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InvokeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BridgeServer).InvokeStream(m, &grpc.GenericServerStream[InvokeRequest, InvokeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bridge_InvokeStreamServer = grpc.ServerStreamingServer[InvokeEvent]
+
+func _Bridge_Pipeline_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BridgeServer).Pipeline(&grpc.GenericServerStream[PipelineInput, PipelineOutput]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bridge_PipelineServer = grpc.BidiStreamingServer[PipelineInput, PipelineOutput]
+
+// Bridge_ServiceDesc is the grpc.ServiceDesc for Bridge service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Bridge_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "psbridge.v1.Bridge",
+	HandlerType: (*BridgeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Invoke",
+			Handler:    _Bridge_Invoke_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "InvokeStream",
+			Handler:       _Bridge_InvokeStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Pipeline",
+			Handler:       _Bridge_Pipeline_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "bridge.proto",
+}
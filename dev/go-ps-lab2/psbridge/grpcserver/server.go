@@ -0,0 +1,383 @@
+// Package grpcserver serves the psbridge invoke API over gRPC, as the
+// service psbridge.v1.Bridge in bridge.proto, so programs in other
+// languages or on other hosts can run operations against a host with
+// PowerShell. Requests and results are JSON, as the shim takes them.
+//
+// The server is grpc-go's, with the code protoc-gen-go generates from
+// bridge.proto in package psbridgev1; clients in Go use the same package,
+// and other languages generate theirs from bridge.proto as usual:
+//
+//	srv := grpcserver.NewServer(pool, grpcserver.Config{Client: client, Token: token, TLS: tlsConfig})
+//	l, err := net.Listen("tcp", ":50051")
+//	...
+//	log.Fatal(srv.Serve(l))
+//
+// Without TLS it serves HTTP/2 in cleartext, which grpc-go clients reach
+// with insecure credentials, and then only on loopback if calls carry a
+// token.
+package grpcserver
+
+//go:generate buf generate
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	pb "example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Service is the full name of the service in bridge.proto
+const Service = "psbridge.v1.Bridge"
+
+// MaxMessageSize is the largest request message a Server reads, raised
+// from grpc-go's default of 4 MiB since requests carry whole JSON
+// payloads. A grpc.Server that Register puts Bridge on wants it too, as
+// grpc.MaxRecvMsgSize.
+const MaxMessageSize = 64 << 20
+
+// errorTrailer carries a PSError a call failed with, encoded as an Error
+const errorTrailer = "psbridge-error-bin"
+
+// Config tunes a Server
+type Config struct {
+	// Client runs Pipeline calls, each in a process of its own; without
+	// one, Pipeline is unimplemented
+	Client *psbridge.Client
+	// Token, if set, is the bearer token every call must send in its
+	// authorization metadata
+	Token string
+	// Timeout bounds each call that doesn't set a shorter deadline; zero
+	// for none
+	Timeout time.Duration
+	// TLS, if set, is what Serve serves with. A server with a Token needs
+	// it to listen anywhere but loopback, the token being a password.
+	TLS *tls.Config
+}
+
+// Server answers Bridge calls from an Invoker
+type Server struct {
+	pb.UnimplementedBridgeServer
+
+	inv  psbridge.Invoker
+	cfg  Config
+	grpc *grpc.Server
+}
+
+// NewServer makes a Server running calls on inv, typically a Pool
+func NewServer(inv psbridge.Invoker, cfg Config) *Server {
+	s := &Server{inv: inv, cfg: cfg}
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(MaxMessageSize)}
+	if cfg.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg.TLS)))
+	}
+	s.grpc = grpc.NewServer(opts...)
+	s.Register(s.grpc)
+	return s
+}
+
+// Register serves Bridge on r as well, such as a grpc.Server with other
+// services of its own. Config.TLS is then r's business; the token and
+// timeout still apply.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	pb.RegisterBridgeServer(r, s)
+}
+
+// Serve answers calls on l until Shutdown. It refuses to serve a Token in
+// cleartext beyond loopback.
+func (s *Server) Serve(l net.Listener) error {
+	if s.cfg.Token != "" && s.cfg.TLS == nil && !loopback(l.Addr()) {
+		return fmt.Errorf("psbridge: serving gRPC with a token on %s needs TLS", l.Addr())
+	}
+	return s.grpc.Serve(l)
+}
+
+// Shutdown stops serving, letting calls in flight finish until ctx is done
+// and then cancelling them
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// loopback reports whether addr only takes local connections
+func loopback(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	}
+	return false
+}
+
+// badRequest is an error in the request itself rather than the call
+type badRequest struct{ error }
+
+// begin checks a call's token and bounds it by Config.Timeout
+func (s *Server) begin(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if s.cfg.Token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var got string
+		var ok bool
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			got, ok = strings.CutPrefix(auth[0], "Bearer ")
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.Token)) != 1 {
+			return nil, nil, status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+		}
+	}
+	if s.cfg.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+// Invoke runs one operation and returns its result
+func (s *Server) Invoke(ctx context.Context, req *pb.InvokeRequest) (*pb.InvokeResponse, error) {
+	ctx, cancel, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	res, err := s.invoke(ctx, req, nil)
+	if err != nil {
+		return nil, toStatus(err, func(md metadata.MD) { grpc.SetTrailer(ctx, md) })
+	}
+	return res, nil
+}
+
+// InvokeStream runs one operation, sending its progress as it arrives and
+// its result last
+func (s *Server) InvokeStream(req *pb.InvokeRequest, st grpc.ServerStreamingServer[pb.InvokeEvent]) error {
+	ctx, cancel, err := s.begin(st.Context())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	send := newSender(st.Send)
+	defer send.finish()
+
+	res, err := s.invoke(ctx, req, func(p psbridge.ProgressRecord) {
+		send.send(&pb.InvokeEvent{Event: &pb.InvokeEvent_Progress{Progress: toProgress(p)}})
+	})
+	if err != nil {
+		return toStatus(err, st.SetTrailer)
+	}
+	return send.send(&pb.InvokeEvent{Event: &pb.InvokeEvent_Result{Result: res}})
+}
+
+// invoke runs req on the invoker, reporting progress if asked to
+func (s *Server) invoke(ctx context.Context, req *pb.InvokeRequest, progress func(psbridge.ProgressRecord)) (*pb.InvokeResponse, error) {
+	call, err := call(req)
+	if err != nil {
+		return nil, err
+	}
+	call.Progress = progress
+	res, err := s.inv.Do(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	data, err := res.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return toResponse(data, res.Streams), nil
+}
+
+// Pipeline streams items into an operation's pipeline and its output back.
+// One goroutine feeds what the client sends into the pipeline while this
+// one sends its output back.
+func (s *Server) Pipeline(st grpc.BidiStreamingServer[pb.PipelineInput, pb.PipelineOutput]) error {
+	ctx, cancel, err := s.begin(st.Context())
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	err = s.pipeline(ctx, st)
+	return toStatus(err, st.SetTrailer)
+}
+
+func (s *Server) pipeline(ctx context.Context, st grpc.BidiStreamingServer[pb.PipelineInput, pb.PipelineOutput]) error {
+	if s.cfg.Client == nil {
+		return status.Error(codes.Unimplemented, "this server runs no pipelines")
+	}
+	in, err := st.Recv()
+	if err != nil {
+		return err
+	}
+	req := in.GetRequest()
+	if req == nil {
+		return badRequest{errors.New("the first PipelineInput must be the request")}
+	}
+	if err := checkRequest(req); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	send := newSender(st.Send)
+	defer send.finish()
+	opts := append(options(req), psbridge.WithProgress(func(p psbridge.ProgressRecord) {
+		send.send(&pb.PipelineOutput{Output: &pb.PipelineOutput_Progress{Progress: toProgress(p)}})
+	}))
+	p, err := psbridge.StartPipeline[json.RawMessage, json.RawMessage](ctx, s.cfg.Client, req.Op, data(req), opts...)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	// feedErr is why feeding the pipeline stopped, if it wasn't the end of
+	// the client's input; the pipeline is then cancelled
+	var feedErr error
+	var feedMu sync.Mutex
+	go func() {
+		if err := feed(st, p); err != nil {
+			feedMu.Lock()
+			feedErr = err
+			feedMu.Unlock()
+			cancel()
+		}
+	}()
+
+	for {
+		item, err := p.Recv()
+		if err == io.EOF {
+			return send.send(&pb.PipelineOutput{Output: &pb.PipelineOutput_Streams{Streams: toStreams(p.Streams())}})
+		}
+		if err != nil {
+			feedMu.Lock()
+			defer feedMu.Unlock()
+			return cmp.Or(feedErr, err)
+		}
+		if err := send.send(&pb.PipelineOutput{Output: &pb.PipelineOutput_Item{Item: item}}); err != nil {
+			return err
+		}
+	}
+}
+
+// feed sends the items the client streams into p until it closes its side
+func feed(st grpc.BidiStreamingServer[pb.PipelineInput, pb.PipelineOutput], p *psbridge.Pipeline[json.RawMessage, json.RawMessage]) error {
+	for {
+		in, err := st.Recv()
+		if err == io.EOF {
+			return p.CloseSend()
+		}
+		if err != nil {
+			return err
+		}
+		item, ok := in.Input.(*pb.PipelineInput_Item)
+		if !ok {
+			return badRequest{errors.New("only the first PipelineInput may be a request")}
+		}
+		if !json.Valid(item.Item) {
+			return badRequest{errors.New("PipelineInput item isn't JSON")}
+		}
+		if err := p.Send(item.Item); err != nil {
+			return err
+		}
+	}
+}
+
+// sender serialises a stream's sends, which progress makes from other
+// goroutines, and drops any once the call is finished
+type sender[T any] struct {
+	mu       sync.Mutex
+	fn       func(*T) error
+	finished bool
+}
+
+func newSender[T any](fn func(*T) error) *sender[T] {
+	return &sender[T]{fn: fn}
+}
+
+func (s *sender[T]) send(msg *T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return nil
+	}
+	return s.fn(msg)
+}
+
+func (s *sender[T]) finish() {
+	s.mu.Lock()
+	s.finished = true
+	s.mu.Unlock()
+}
+
+// toStatus maps how a call ended to a gRPC status, giving a PSError to
+// trailer as well
+func toStatus(err error, trailer func(metadata.MD)) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var psErr *psbridge.PSError
+	if errors.As(err, &psErr) {
+		if b, merr := proto.Marshal(toError(psErr)); merr == nil {
+			trailer(metadata.Pairs(errorTrailer, string(b)))
+		}
+	}
+	return status.Error(code(err), err.Error())
+}
+
+// code is the status code for an error a call failed with
+func code(err error) codes.Code {
+	var bad badRequest
+	var timeout *psbridge.TimeoutError
+	var psErr *psbridge.PSError
+	var exitErr *psbridge.ExitError
+	switch {
+	case errors.As(err, &bad):
+		return codes.InvalidArgument
+	case errors.As(err, &timeout) && errors.Is(timeout.Err, context.Canceled), errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.As(err, &timeout), errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, psbridge.ErrRateLimited):
+		return codes.ResourceExhausted
+	case errors.As(err, &psErr) && psErr.Category == "ObjectNotFound":
+		return codes.NotFound
+	case errors.As(err, &psErr) && psErr.Category == "PermissionDenied":
+		return codes.PermissionDenied
+	case errors.As(err, &psErr) && psErr.Category == "InvalidArgument":
+		return codes.InvalidArgument
+	case errors.Is(err, psbridge.ErrPoolClosed), errors.Is(err, psbridge.ErrSessionClosed), errors.Is(err, psbridge.ErrShellNotFound),
+		errors.Is(err, psbridge.ErrCircuitOpen):
+		return codes.Unavailable
+	case errors.As(err, &exitErr):
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,175 @@
+package grpcserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/grpcserver"
+	pb "example.com/go-ps-lab2/psbridge/grpcserver/psbridgev1"
+	"example.com/go-ps-lab2/psbridge/pstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// serve runs a Server for inv on loopback and returns a client of it
+func serve(t *testing.T, inv psbridge.Invoker, cfg grpcserver.Config) pb.BridgeClient {
+	t.Helper()
+	srv := grpcserver.NewServer(inv, cfg)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewBridgeClient(conn)
+}
+
+func TestInvoke(t *testing.T) {
+	fake := pstest.NewFake()
+	fake.Reply("services", pstest.Response{
+		Data:    []string{"Spooler"},
+		Streams: psbridge.Streams{Warning: []string{"careful"}, Errors: []*psbridge.PSError{{Message: "one failed"}}},
+	})
+	fake.Fail("missing", &psbridge.PSError{Message: "no such service", Category: "ObjectNotFound", ErrorID: "NoService"})
+	client := serve(t, fake, grpcserver.Config{})
+	ctx := context.Background()
+
+	res, err := client.Invoke(ctx, &pb.InvokeRequest{Op: "services", Data: []byte(`{"name":"S*"}`), Dir: `C:\`, Env: map[string]string{"A": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `["Spooler"]` || res.Streams.Warning[0] != "careful" || res.Streams.Errors[0].Message != "one failed" {
+		t.Errorf("response = %v", res)
+	}
+	call := fake.CallsTo("services")[0]
+	if string(call.Data) != `{"name":"S*"}` || call.Dir != `C:\` || call.Env["A"] != "1" {
+		t.Errorf("call = %+v", call)
+	}
+
+	var trailer metadata.MD
+	_, err = client.Invoke(ctx, &pb.InvokeRequest{Op: "missing"}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("err = %v, want NotFound", err)
+	}
+	bin := trailer.Get("psbridge-error-bin")
+	var psErr pb.Error
+	if len(bin) != 1 || proto.Unmarshal([]byte(bin[0]), &psErr) != nil || psErr.ErrorId != "NoService" {
+		t.Errorf("error trailer = %q", bin)
+	}
+}
+
+func TestInvokeBadRequest(t *testing.T) {
+	client := serve(t, pstest.NewFake(), grpcserver.Config{})
+	tests := []*pb.InvokeRequest{
+		{},
+		{Op: "x", Data: []byte("{not json")},
+	}
+	for _, req := range tests {
+		if _, err := client.Invoke(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Invoke(%v): err = %v, want InvalidArgument", req, err)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	fake := pstest.NewFake()
+	fake.Respond("x", map[string]int{})
+	client := serve(t, fake, grpcserver.Config{Token: "secret"})
+	tests := []struct {
+		auth string
+		want codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"Bearer wrong", codes.Unauthenticated},
+		{"secret", codes.Unauthenticated},
+		{"Bearer secret", codes.OK},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.auth != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.auth)
+		}
+		if _, err := client.Invoke(ctx, &pb.InvokeRequest{Op: "x"}); status.Code(err) != tt.want {
+			t.Errorf("authorization %q: err = %v, want %v", tt.auth, err, tt.want)
+		}
+	}
+}
+
+func TestServeRefusesCleartextToken(t *testing.T) {
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	srv := grpcserver.NewServer(pstest.NewFake(), grpcserver.Config{Token: "secret"})
+	if err := srv.Serve(l); err == nil || !strings.Contains(err.Error(), "needs TLS") {
+		t.Errorf("err = %v, want refusing to send the token in cleartext", err)
+	}
+}
+
+func TestInvokeStream(t *testing.T) {
+	fake := pstest.NewFake()
+	fake.Reply("copy", pstest.Response{
+		Data:     map[string]int{"copied": 2},
+		Progress: []psbridge.ProgressRecord{{Activity: "Copying", PercentComplete: 50}, {Activity: "Copying", Completed: true}},
+	})
+	client := serve(t, fake, grpcserver.Config{})
+	st, err := client.InvokeStream(context.Background(), &pb.InvokeRequest{Op: "copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*pb.InvokeEvent
+	for {
+		ev, err := st.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 || events[0].GetProgress().GetPercentComplete() != 50 || !events[1].GetProgress().GetCompleted() {
+		t.Fatalf("events = %v", events)
+	}
+	if string(events[2].GetResult().GetData()) != `{"copied":2}` {
+		t.Errorf("result = %v", events[2])
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	fake := pstest.NewFake()
+	fake.Reply("slow", pstest.Response{Data: 1, Delay: time.Minute})
+	client := serve(t, fake, grpcserver.Config{Timeout: 20 * time.Millisecond})
+	if _, err := client.Invoke(context.Background(), &pb.InvokeRequest{Op: "slow"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestPipelineUnimplemented(t *testing.T) {
+	client := serve(t, pstest.NewFake(), grpcserver.Config{})
+	st, err := client.Pipeline(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := json.Marshal(map[string]string{})
+	st.Send(&pb.PipelineInput{Input: &pb.PipelineInput_Request{Request: &pb.InvokeRequest{Op: "x", Data: req}}})
+	if _, err := st.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Errorf("err = %v, want Unimplemented without a Client", err)
+	}
+}
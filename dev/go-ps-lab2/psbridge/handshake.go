@@ -0,0 +1,138 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ProtocolVersion is the version of the message protocol this package
+// speaks. Every process is started with it as -Protocol, and a session
+// exchanges it with the script in a handshake before anything else, so a
+// script and client that don't match fail at once with *ProtocolError.
+const ProtocolVersion = 1
+
+// Capabilities a script or client may have beyond the base protocol
+const (
+	CapLengthFraming   = "length-framing"
+	CapSentinels       = "sentinels"
+	CapConsoleEncoding = "console-encoding"
+	CapPipeline        = "pipeline"
+	CapSealedSecrets   = "sealed-secrets"
+	CapHostInfo        = "host-info"
+	CapResetState      = "reset-state"
+	CapSessionVars     = "session-variables"
+)
+
+// clientCapabilities are what this package can do, sent in the handshake
+var clientCapabilities = []string{CapLengthFraming, CapSentinels, CapConsoleEncoding, CapPipeline, CapSealedSecrets, CapHostInfo, CapResetState, CapSessionVars}
+
+// opHello is the session loop's handshake op
+const opHello = "hello"
+
+// errorIDProtocol is the error ID of the script refusing a -Protocol it
+// doesn't speak
+const errorIDProtocol = "ProtocolVersionMismatch"
+
+// ProtocolError reports that a script speaks a different protocol version
+// from this package, typically a script copied from an older release
+type ProtocolError struct {
+	// Client is ProtocolVersion
+	Client int
+	// Script is the script's version, 0 for a script from before versions
+	// were exchanged, or -1 when it only said it won't speak Client
+	Script int
+	// Err is how the mismatch showed: the script's refusal as a *PSError,
+	// or the *ExitError from PowerShell rejecting -Protocol
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	script := fmt.Sprintf("script speaks %d", e.Script)
+	switch e.Script {
+	case 0:
+		script = "script predates the handshake"
+	case -1:
+		script = "script refused it"
+	}
+	return fmt.Sprintf("psbridge: protocol version mismatch: client speaks %d, %s; use the script bundled with this package", e.Client, script)
+}
+
+func (e *ProtocolError) Unwrap() error { return e.Err }
+
+// protocolError recognises a script that doesn't speak ProtocolVersion in
+// err, as it failed a call or start, and otherwise returns err
+func protocolError(err error) error {
+	if _, ok := err.(*ProtocolError); ok {
+		return err
+	}
+	var psErr *PSError
+	if errors.As(err, &psErr) && psErr.ErrorID == errorIDProtocol {
+		return &ProtocolError{Client: ProtocolVersion, Script: -1, Err: err}
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && strings.Contains(exitErr.Stderr, "parameter name 'Protocol'") {
+		return &ProtocolError{Client: ProtocolVersion, Err: err}
+	}
+	return err
+}
+
+type helloRequest struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type helloReply struct {
+	Version int `json:"version"`
+	// MinVersion is the oldest client version the script still serves
+	MinVersion   int      `json:"minVersion"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// handshake exchanges versions and capabilities with a freshly started
+// session. Like negotiateFraming it runs before the reader starts.
+func (s *Session) handshake() error {
+	data, _ := json.Marshal(helloRequest{Version: ProtocolVersion, Capabilities: clientCapabilities})
+	line, err := json.Marshal(wireRequest{Op: opHello, Data: data})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		return s.processError("handshake", s.exitCause(err))
+	}
+
+	res, err := readReply(s.stdout, nil)
+	if err != nil {
+		var protoErr *ProtocolError
+		var psErr *PSError
+		switch {
+		case errors.As(err, &protoErr):
+			return err
+		case errors.As(err, &psErr):
+			// A script that took -Protocol but has no hello op
+			return &ProtocolError{Client: ProtocolVersion, Err: err}
+		}
+		return s.processError("handshake", s.exitCause(err))
+	}
+	var hello helloReply
+	if err := json.Unmarshal(res.Data, &hello); err != nil {
+		return fmt.Errorf("unmarshal handshake reply: %w", err)
+	}
+	// A newer script may still serve this client; an older one can't
+	if ProtocolVersion < hello.MinVersion || ProtocolVersion > hello.Version {
+		return &ProtocolError{Client: ProtocolVersion, Script: hello.Version}
+	}
+	s.capabilities = hello.Capabilities
+	return nil
+}
+
+// Capabilities are what the session's script said it can do in the
+// handshake, such as CapLengthFraming
+func (s *Session) Capabilities() []string { return slices.Clone(s.capabilities) }
+
+// HasCapability reports whether the session's script has capability
+func (s *Session) HasCapability(capability string) bool {
+	return slices.Contains(s.capabilities, capability)
+}
@@ -0,0 +1,113 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HostInfoOp is the operation the shim describes its PowerShell with
+const HostInfoOp = "host-info"
+
+// ErrUnsupported is wrapped by HostInfo.Require for a feature the host
+// lacks
+var ErrUnsupported = errors.New("psbridge: not supported by this PowerShell")
+
+// Feature is a language or cmdlet feature not every PowerShell has
+type Feature string
+
+// Features the shim probes for. They are found by parsing or by looking
+// at the cmdlet, not by version, so a host that backports one reports it.
+const (
+	// FeatureTernary is $a ? $b : $c, from 7.0
+	FeatureTernary Feature = "ternary"
+	// FeaturePipelineChain is a && b and a || b, from 7.0
+	FeaturePipelineChain Feature = "pipeline-chain"
+	// FeatureNullCoalescing is $a ?? $b and $a ??= $b, from 7.0
+	FeatureNullCoalescing Feature = "null-coalescing"
+	// FeatureCleanBlock is a function's clean block, from 7.3
+	FeatureCleanBlock Feature = "clean-block"
+	// FeatureJSONAsArray is ConvertTo-Json -AsArray, from 6.2
+	FeatureJSONAsArray Feature = "json-as-array"
+	// FeatureJSONAsHashtable is ConvertFrom-Json -AsHashtable, from 6.0
+	FeatureJSONAsHashtable Feature = "json-as-hashtable"
+	// FeatureForEachParallel is ForEach-Object -Parallel, from 7.0
+	FeatureForEachParallel Feature = "foreach-parallel"
+)
+
+// HostInfo describes the PowerShell running a script, from
+// $PSVersionTable and probes of what it supports
+type HostInfo struct {
+	// Edition is Core for PowerShell 6 and later, Desktop for Windows
+	// PowerShell
+	Edition string `json:"edition"`
+	// Version is PSVersion, e.g. 7.4.1 or 5.1.22621.2506
+	Version string `json:"version"`
+	// OS is the operating system, e.g. Microsoft Windows 10.0.22631 or
+	// Linux 6.5.0-21-generic #21-Ubuntu
+	OS string `json:"os"`
+	// Platform is Win32NT or Unix
+	Platform string `json:"platform"`
+	// CLRVersion is the .NET runtime's version
+	CLRVersion string `json:"clrVersion"`
+	Is64Bit    bool   `json:"is64Bit"`
+	// LanguageMode is FullLanguage unless the host is locked down, e.g.
+	// ConstrainedLanguage under application control
+	LanguageMode string `json:"languageMode"`
+	// Features are those the host has
+	Features []Feature `json:"features"`
+	// Endpoint describes the client's Endpoint, if it has one; the rest is
+	// the shim's own PowerShell
+	Endpoint *EndpointInfo `json:"endpoint,omitempty"`
+}
+
+func (h *HostInfo) String() string {
+	return fmt.Sprintf("PowerShell %s %s on %s", h.Edition, h.Version, h.OS)
+}
+
+// AtLeast reports whether the host's version is major.minor or later
+func (h *HostInfo) AtLeast(major, minor int) bool {
+	hostMajor, hostMinor := h.version()
+	return hostMajor > major || hostMajor == major && hostMinor >= minor
+}
+
+// version parses the major and minor parts of Version, ignoring any
+// prerelease label
+func (h *HostInfo) version() (major, minor int) {
+	parts := strings.SplitN(strings.SplitN(h.Version, "-", 2)[0], ".", 3)
+	major, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// Windows reports whether the host runs on Windows
+func (h *HostInfo) Windows() bool { return h.Platform == "Win32NT" }
+
+// Supports reports whether the host has f
+func (h *HostInfo) Supports(f Feature) bool { return slices.Contains(h.Features, f) }
+
+// Require returns an error wrapping ErrUnsupported unless the host has
+// every one of fs
+func (h *HostInfo) Require(fs ...Feature) error {
+	for _, f := range fs {
+		if !h.Supports(f) {
+			return fmt.Errorf("%w: %s needs %s", ErrUnsupported, h, f)
+		}
+	}
+	return nil
+}
+
+// GetHostInfo asks inv's PowerShell to describe itself. A Session has
+// already asked, at start; see Session.HostInfo.
+func GetHostInfo(ctx context.Context, inv Invoker, opts ...CallOption) (*HostInfo, error) {
+	info, err := InvokeContext[struct{}, *HostInfo](ctx, inv, HostInfoOp, struct{}{}, opts...)
+	if err == nil && info == nil {
+		err = errors.New("psbridge: no host info returned")
+	}
+	return info, err
+}
@@ -0,0 +1,112 @@
+package psbridge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// ErrTampered is wrapped by the errors for deployed scripts that don't
+// match their checksums
+var ErrTampered = errors.New("psbridge: script doesn't match its checksum")
+
+// Checksums are the SHA-256 sums of a set of scripts, by the same
+// slash-separated names as in an fs.FS
+type Checksums map[string][sha256.Size]byte
+
+// ChecksumsOf sums every file in fsys
+func ChecksumsOf(fsys fs.FS) (Checksums, error) {
+	sums := Checksums{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sums[name] = sha256.Sum256(data)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sum scripts: %w", err)
+	}
+	return sums, nil
+}
+
+// bundledChecksums are the sums of Scripts(), which are embedded when the
+// binary is built
+var bundledChecksums = sync.OnceValue(func() Checksums {
+	sums, err := ChecksumsOf(Scripts())
+	if err != nil {
+		panic(err)
+	}
+	return sums
+})
+
+// BundledChecksums are the sums of the scripts this binary was built with,
+// for checking copies of them deployed alongside it
+func BundledChecksums() Checksums {
+	return maps.Clone(bundledChecksums())
+}
+
+// Integrity checks the scripts in Dir against Sums before each process a
+// client starts, so one edited since it was deployed is refused. Every
+// check hashes every file again: a size and modification time are kept
+// by whoever edits a file as easily as they edit it.
+type Integrity struct {
+	Dir  string
+	Sums Checksums
+}
+
+// WithIntegrity refuses to start scripts while any in dir doesn't match
+// sums
+func WithIntegrity(dir string, sums Checksums) Option {
+	return func(c *Client) { c.Integrity = &Integrity{Dir: dir, Sums: sums} }
+}
+
+// Check reports the first script that is missing or doesn't match its sum
+func (i *Integrity) Check() error {
+	if i == nil {
+		return nil
+	}
+	names := make([]string, 0, len(i.Sums))
+	for name := range i.Sums {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(i.Dir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrTampered, name, err)
+		}
+		want := i.Sums[name]
+		if got := sha256.Sum256(data); got != want {
+			return fmt.Errorf("%w: %s has SHA-256 %s, want %s", ErrTampered, name, hex.EncodeToString(got[:]), hex.EncodeToString(want[:]))
+		}
+	}
+	return nil
+}
+
+// Deployed is a Bundle over scripts already in dir, such as copies of
+// Scripts() shipped next to the binary, checked against sums now and by
+// its clients before each process. Pass nil sums to skip the check, while
+// developing the scripts. Close leaves the directory be.
+func Deployed(dir string, sums Checksums) (*Bundle, error) {
+	b := &Bundle{dir: dir, deployed: true}
+	if sums != nil {
+		b.integrity = &Integrity{Dir: dir, Sums: sums}
+		if err := b.integrity.Check(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,48 @@
+package psbridge
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tools.ps1")
+	if err := os.WriteFile(path, []byte("Get-Date"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := ChecksumsOf(fstest.MapFS{"tools.ps1": {Data: []byte("Get-Date")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Integrity{Dir: dir, Sums: sums}
+	if err := i.Check(); err != nil {
+		t.Fatalf("untouched: %v", err)
+	}
+
+	// An edit keeping the size and modification time is still caught
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("Get-Evil"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Check(); !errors.Is(err, ErrTampered) {
+		t.Errorf("same size and time = %v, want ErrTampered", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Check(); !errors.Is(err, ErrTampered) {
+		t.Errorf("missing = %v, want ErrTampered", err)
+	}
+}
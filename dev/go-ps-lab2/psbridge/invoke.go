@@ -0,0 +1,203 @@
+package psbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Call is one operation to run, with its payload already encoded as JSON
+type Call struct {
+	Op   string
+	Data json.RawMessage
+
+	// Progress, if set, is called for each Write-Progress record while the
+	// operation runs
+	Progress func(ProgressRecord)
+	// Stream, if set, is called with an Event for each record the
+	// operation writes to its verbose, warning, debug, information and
+	// error streams, as it arrives. Invokers that only have the records at
+	// the end, in Result.Streams, don't call it.
+	Stream func(Event)
+
+	// Env is set in the script's environment for this call, on top of what
+	// it inherits, or instead of it with ReplaceEnv
+	Env        map[string]string
+	ReplaceEnv bool
+
+	// Dir, if set, is the working directory for this call instead of the
+	// client's
+	Dir string
+
+	// Timeout, if set, bounds this call instead of the client's Timeouts
+	Timeout time.Duration
+
+	// Isolate, for a session, undoes what the call leaves behind in it
+	// once it is done; see Session.ResetState
+	Isolate bool
+
+	// Strict fails the call if its result doesn't fit its Go type
+	// exactly; see Strict
+	Strict bool
+
+	// Secrets are JSON keys, besides the client's SecretPattern, whose
+	// values are redacted from logged payloads
+	Secrets []string
+}
+
+// CallOption tweaks a single call
+type CallOption func(*Call)
+
+// WithProgress registers fn to receive progress records as they arrive
+func WithProgress(fn func(ProgressRecord)) CallOption {
+	return func(c *Call) { c.Progress = fn }
+}
+
+// WithCallWorkingDir runs one call in dir. A session moves there for the
+// call and back afterwards.
+func WithCallWorkingDir(dir string) CallOption {
+	return func(c *Call) { c.Dir = dir }
+}
+
+// newCall builds a Call for op and applies opts to it
+func newCall(op string, data json.RawMessage, opts []CallOption) *Call {
+	call := &Call{Op: op, Data: data}
+	for _, opt := range opts {
+		opt(call)
+	}
+	return call
+}
+
+// Result is what an operation sent back, still encoded as JSON, along with
+// anything it wrote to the other PowerShell streams
+type Result struct {
+	// Data is nil for a result bigger than OutputLimits.Memory, which is
+	// kept in a temp file instead; see Open
+	Data    json.RawMessage
+	Streams Streams
+
+	spill *spillSection
+	// numbers is how the client that ran it decodes numbers
+	numbers NumberMode
+	// strict is whether it is decoded strictly
+	strict bool
+}
+
+// Spilled reports whether the result's data is on disk rather than in Data
+func (r *Result) Spilled() bool { return r.spill != nil }
+
+// Size is the length of the result's JSON
+func (r *Result) Size() int64 {
+	if r.spill != nil {
+		return r.spill.n
+	}
+	return int64(len(r.Data))
+}
+
+// Open returns the result's JSON, read from disk if it spilled
+func (r *Result) Open() io.Reader {
+	if r.spill != nil {
+		return io.NewSectionReader(r.spill.file.f, r.spill.off, r.spill.n)
+	}
+	return bytes.NewReader(r.Data)
+}
+
+// Bytes returns the result's JSON, loading it into memory if it spilled
+func (r *Result) Bytes() ([]byte, error) {
+	if r.spill == nil {
+		return r.Data, nil
+	}
+	return io.ReadAll(r.Open())
+}
+
+// Close deletes a spilled result's temp file. It does nothing for results
+// in memory, so it is always safe to call; Invoke calls it itself.
+func (r *Result) Close() error {
+	if r.spill == nil {
+		return nil
+	}
+	return r.spill.file.remove()
+}
+
+// decode unmarshals the result into v and releases it
+func (r *Result) decode(v any) error {
+	if r.spill == nil {
+		if r.strict {
+			if err := checkStrict(r.Data, reflect.TypeOf(v)); err != nil {
+				return err
+			}
+		}
+		return decodeJSON(r.Data, v, r.numbers)
+	}
+	defer r.Close()
+	if r.strict || tagged(reflect.TypeOf(v)) {
+		// Matching ps tags and the like takes the whole result in memory
+		data, err := io.ReadAll(r.Open())
+		if err != nil {
+			return err
+		}
+		if r.strict {
+			if err := checkStrict(data, reflect.TypeOf(v)); err != nil {
+				return err
+			}
+		}
+		return decodeJSON(data, v, r.numbers)
+	}
+	dec := json.NewDecoder(r.Open())
+	if r.numbers != NumbersFloat {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if r.numbers == NumbersExact {
+		exactNumbers(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// Invoker runs calls against some PowerShell backend. Client and Session
+// both implement it.
+type Invoker interface {
+	Do(ctx context.Context, call *Call) (*Result, error)
+}
+
+// Invoke runs op with req as its payload and decodes the result into TResp
+func Invoke[TReq, TResp any](inv Invoker, op string, req TReq, opts ...CallOption) (TResp, error) {
+	return InvokeContext[TReq, TResp](context.Background(), inv, op, req, opts...)
+}
+
+// InvokeContext is Invoke bounded by ctx
+func InvokeContext[TReq, TResp any](ctx context.Context, inv Invoker, op string, req TReq, opts ...CallOption) (TResp, error) {
+	var resp TResp
+	res, err := invokeResult[TReq, TResp](ctx, inv, op, req, opts)
+	if err != nil {
+		return resp, err
+	}
+	if err := res.decode(&resp); err != nil {
+		return resp, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return resp, nil
+}
+
+// invokeResult runs op with req as its payload, keeping secrets of both
+// types out of the logs, and returns the result undecoded
+func invokeResult[TReq, TResp any](ctx context.Context, inv Invoker, op string, req TReq, opts []CallOption) (*Result, error) {
+	data, err := MarshalPS(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	call := newCall(op, data, opts)
+	call.Secrets = append(call.Secrets, SecretKeys(reflect.TypeFor[TReq]())...)
+	call.Secrets = append(call.Secrets, SecretKeys(reflect.TypeFor[TResp]())...)
+	res, err := inv.Do(ctx, call)
+	if err == nil && call.Strict {
+		res.strict = true
+	}
+	return res, err
+}
@@ -0,0 +1,85 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// CallResult is the outcome of one call in InvokeAll
+type CallResult struct {
+	// Op is the call's, for telling the results apart
+	Op     string
+	Result *Result
+	Err    error
+}
+
+// CallResults are the outcomes of InvokeAll's calls, in the same order
+type CallResults []CallResult
+
+// Err joins the errors of the calls that failed, each naming the call's
+// index and op, or is nil if none did
+func (rs CallResults) Err() error {
+	var errs []error
+	for i, r := range rs {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("call %d (%s): %w", i, r.Op, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InvokeAll runs calls on inv with at most limit in flight at once and
+// returns their outcomes in the order of calls; one failing doesn't stop
+// the others. A limit of 0 is a *Pool's Max sessions, or GOMAXPROCS for
+// other invokers. Calls not yet started when ctx is done fail with its
+// error.
+func InvokeAll(ctx context.Context, inv Invoker, calls []Call, limit int) CallResults {
+	if limit <= 0 {
+		limit = runtime.GOMAXPROCS(0)
+		if p, ok := inv.(*Pool); ok {
+			limit = p.cfg.Max
+		}
+	}
+	results := make(CallResults, len(calls))
+	for i, call := range calls {
+		results[i].Op = call.Op
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := range calls {
+		// select picks either when both are ready
+		if ctx.Err() == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(calls); j++ {
+				results[j].Err = err
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			// A copy, so calls is only read
+			call := calls[i]
+			results[i].Result, results[i].Err = inv.Do(ctx, &call)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// InvokeAll runs calls on the pool's sessions, as many at once as it has,
+// and returns their outcomes in order
+func (p *Pool) InvokeAll(ctx context.Context, calls []Call) CallResults {
+	return InvokeAll(ctx, p, calls, p.cfg.Max)
+}
@@ -0,0 +1,437 @@
+package psbridge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// OutputLimits bound how much of a script's output a call holds in memory
+type OutputLimits struct {
+	// Memory is the size beyond which a reply message goes to a temp file
+	// instead of memory. A result that big comes back with a nil Data:
+	// read it with Result.Open or Result.Bytes and delete the file with
+	// Result.Close. Zero keeps everything in memory.
+	Memory int64
+	// Max is the most stdout a one-shot call may produce, or the largest
+	// message a session may send. A call that goes over is killed and fails
+	// with *OutputLimitError; a session is then unusable. Zero means no
+	// limit.
+	Max int64
+}
+
+// WithOutputLimits sets the client's output limits
+func WithOutputLimits(l OutputLimits) Option {
+	return func(c *Client) { c.Limits = l }
+}
+
+// OutputLimitError reports that a script wrote more than OutputLimits.Max
+type OutputLimitError struct {
+	// Op is the operation that was running, when known
+	Op    string
+	Limit int64
+}
+
+func (e *OutputLimitError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("psbridge: powershell output exceeds the %d byte limit", e.Limit)
+	}
+	return fmt.Sprintf("psbridge: %s: output exceeds the %d byte limit", e.Op, e.Limit)
+}
+
+// limitError names op in a limit error from reading, and passes other
+// errors through
+func limitError(err error, op string) error {
+	var limitErr *OutputLimitError
+	if errors.As(err, &limitErr) && limitErr.Op == "" {
+		return &OutputLimitError{Op: op, Limit: limitErr.Limit}
+	}
+	return err
+}
+
+// msgReader reads reply messages within limits
+type msgReader struct {
+	r      *bufio.Reader
+	limits OutputLimits
+	// cumulative makes Max cover everything read, as for a one-shot call,
+	// rather than each message
+	cumulative bool
+	read       int64
+	// sentinels takes only lines marked as messages by the script's
+	// -Sentinels; see WithSentinels
+	sentinels bool
+	// noise, if set, is given each line that holds no message
+	noise func(string)
+}
+
+// message is one reply document, in memory or spilled to disk
+type message struct {
+	b     []byte
+	spill *spillFile
+}
+
+// take counts n more bytes against Max
+func (m *msgReader) take(n int) error {
+	m.read += int64(n)
+	if m.limits.Max > 0 && m.read > m.limits.Max {
+		return &OutputLimitError{Limit: m.limits.Max}
+	}
+	return nil
+}
+
+// line reads the next newline-terminated message, accepting a final line
+// without one and passing over lines of noise
+func (m *msgReader) line() (message, error) {
+	for {
+		msg, err := m.rawLine()
+		if err != nil {
+			return message{}, err
+		}
+		ok, err := msg.extract(m.sentinels)
+		if err != nil {
+			msg.discard()
+			return message{}, err
+		}
+		if ok {
+			return msg, nil
+		}
+		if m.noise != nil {
+			m.noise(msg.text())
+		}
+		msg.discard()
+	}
+}
+
+// rawLine reads one line as it is
+func (m *msgReader) rawLine() (message, error) {
+	if !m.cumulative {
+		m.read = 0
+	}
+	w := &spillWriter{memory: m.limits.Memory}
+	for {
+		chunk, err := m.r.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if lerr := m.take(len(chunk)); lerr != nil {
+				w.discard()
+				return message{}, lerr
+			}
+			if _, werr := w.Write(chunk); werr != nil {
+				w.discard()
+				return message{}, werr
+			}
+		}
+		switch {
+		case err == nil:
+			return w.message(), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && w.n > 0:
+			return w.message(), nil
+		case errors.Is(err, io.EOF):
+			return message{}, io.ErrUnexpectedEOF
+		}
+		w.discard()
+		return message{}, err
+	}
+}
+
+// frame reads one length-prefixed message
+func (m *msgReader) frame() (message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(m.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return message{}, io.ErrUnexpectedEOF
+		}
+		return message{}, err
+	}
+
+	n := int64(binary.BigEndian.Uint32(header[:]))
+	if n > maxFrameSize {
+		return message{}, fmt.Errorf("psbridge: frame of %d bytes exceeds limit; is something else writing to stdout?", n)
+	}
+	if m.limits.Max > 0 && n > m.limits.Max {
+		return message{}, &OutputLimitError{Limit: m.limits.Max}
+	}
+
+	w := &spillWriter{memory: m.limits.Memory}
+	if _, err := io.CopyN(w, m.r, n); err != nil {
+		w.discard()
+		return message{}, err
+	}
+	return w.message(), nil
+}
+
+// spillWriter keeps what is written in memory up to memory bytes, then
+// moves it all to a temp file
+type spillWriter struct {
+	memory int64
+	buf    bytes.Buffer
+	f      *os.File
+	n      int64
+}
+
+func (w *spillWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.f == nil {
+		if w.memory <= 0 || int64(w.buf.Len()+len(p)) <= w.memory {
+			return w.buf.Write(p)
+		}
+		f, err := os.CreateTemp("", "psbridge-reply-*.json")
+		if err != nil {
+			return 0, fmt.Errorf("spill reply: %w", err)
+		}
+		w.f = f
+		if _, err := f.Write(w.buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("spill reply: %w", err)
+		}
+		w.buf = bytes.Buffer{}
+	}
+	n, err := w.f.Write(p)
+	if err != nil {
+		err = fmt.Errorf("spill reply: %w", err)
+	}
+	return n, err
+}
+
+func (w *spillWriter) message() message {
+	if w.f == nil {
+		return message{b: w.buf.Bytes()}
+	}
+	return message{spill: &spillFile{f: w.f, size: w.n}}
+}
+
+func (w *spillWriter) discard() {
+	if w.f != nil {
+		(&spillFile{f: w.f}).remove()
+	}
+}
+
+// spillFile is a reply message on disk, the size bytes from off
+type spillFile struct {
+	f         *os.File
+	off, size int64
+	once      sync.Once
+}
+
+func (s *spillFile) remove() error {
+	var err error
+	s.once.Do(func() {
+		s.f.Close()
+		err = os.Remove(s.f.Name())
+	})
+	return err
+}
+
+// bytes reads the whole message back
+func (s *spillFile) bytes() ([]byte, error) {
+	b := make([]byte, s.size)
+	if _, err := s.f.ReadAt(b, s.off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read spilled reply: %w", err)
+	}
+	return b, nil
+}
+
+// head returns up to n bytes from the start of the message
+func (m message) head(n int) []byte {
+	if m.spill == nil {
+		return m.b[:min(n, len(m.b))]
+	}
+	b := make([]byte, min(int64(n), m.spill.size))
+	k, _ := m.spill.f.ReadAt(b, m.spill.off)
+	return b[:k]
+}
+
+// tail returns up to n bytes from the end of the message
+func (m message) tail(n int) []byte {
+	if m.spill == nil {
+		return m.b[max(len(m.b)-n, 0):]
+	}
+	k := min(int64(n), m.spill.size)
+	b := make([]byte, k)
+	got, _ := m.spill.f.ReadAt(b, m.spill.off+m.spill.size-k)
+	return b[:got]
+}
+
+// size is the message's length in bytes
+func (m message) size() int64 {
+	if m.spill == nil {
+		return int64(len(m.b))
+	}
+	return m.spill.size
+}
+
+// slice narrows the message to its bytes from start to end
+func (m *message) slice(start, end int64) {
+	if m.spill == nil {
+		m.b = m.b[start:end]
+		return
+	}
+	m.spill.off += start
+	m.spill.size = end - start
+}
+
+// discard drops a message that won't be decoded
+func (m message) discard() {
+	if m.spill != nil {
+		m.spill.remove()
+	}
+}
+
+// all returns the whole message in memory, removing any spill file
+func (m message) all() ([]byte, error) {
+	if m.spill == nil {
+		return m.b, nil
+	}
+	defer m.spill.remove()
+	return m.spill.bytes()
+}
+
+// reply decodes the message. A spilled result keeps its data on disk,
+// found by scanning the envelope; anything else spilled is read back,
+// since only results are expected to be that big.
+func (m message) reply() (*wireReply, error) {
+	var reply wireReply
+	if m.spill == nil {
+		if err := json.Unmarshal(m.b, &reply); err != nil {
+			return nil, fmt.Errorf("unmarshal reply: %w", err)
+		}
+		return &reply, nil
+	}
+
+	data, envelope, err := m.spill.scan()
+	if err != nil {
+		m.spill.remove()
+		return nil, fmt.Errorf("unmarshal reply: %w", err)
+	}
+	if err := json.Unmarshal(envelope, &reply); err != nil {
+		m.spill.remove()
+		return nil, fmt.Errorf("unmarshal reply: %w", err)
+	}
+	if reply.Type != replyResult || data == nil {
+		b, err := m.all()
+		if err != nil {
+			return nil, err
+		}
+		reply = wireReply{}
+		if err := json.Unmarshal(b, &reply); err != nil {
+			return nil, fmt.Errorf("unmarshal reply: %w", err)
+		}
+		return &reply, nil
+	}
+	reply.spill = data
+	return &reply, nil
+}
+
+// scan walks the message's top-level object, returning where its data
+// value lies and the other fields re-encoded as a small envelope
+func (s *spillFile) scan() (*spillSection, []byte, error) {
+	dec := json.NewDecoder(io.NewSectionReader(s.f, s.off, s.size))
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("reply is not an object")
+	}
+
+	var data *spillSection
+	rest := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+		if key != "data" {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return nil, nil, err
+			}
+			rest[key] = v
+			continue
+		}
+
+		// The offset after the key is before the colon and any spacing
+		start := s.off + dec.InputOffset()
+		if err := skipValue(dec); err != nil {
+			return nil, nil, err
+		}
+		end := s.off + dec.InputOffset()
+		start, err = s.valueStart(start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = &spillSection{file: s, off: start, n: end - start}
+	}
+
+	envelope, err := json.Marshal(rest)
+	return data, envelope, err
+}
+
+// valueStart finds where a value begins after the key ending at from
+func (s *spillFile) valueStart(from, to int64) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(s.f, from, to-from))
+	for off := from; ; off++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ':', ' ', '\t', '\r', '\n':
+			continue
+		}
+		return off, nil
+	}
+}
+
+// skipValue reads past one value without keeping it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// spillSection is a spilled result's data within its message file
+type spillSection struct {
+	file   *spillFile
+	off, n int64
+}
+
+// capWriter passes at most max bytes to w, calling over once when a write
+// would exceed that
+type capWriter struct {
+	w    io.Writer
+	max  int64
+	n    int64
+	over func()
+	hit  bool
+}
+
+func (c *capWriter) Write(p []byte) (int, error) {
+	if c.max > 0 && c.n+int64(len(p)) > c.max {
+		if !c.hit {
+			c.hit = true
+			c.over()
+		}
+		return 0, &OutputLimitError{Limit: c.max}
+	}
+	c.n += int64(len(p))
+	return c.w.Write(p)
+}
@@ -0,0 +1,169 @@
+package psbridge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMsgReaderLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		limits     OutputLimits
+		cumulative bool
+		want       []string
+		noise      []string
+		err        error
+	}{
+		{"messages", "{\"a\":1}\n{\"b\":2}\n", OutputLimits{}, false, []string{"{\"a\":1}\n", "{\"b\":2}\n"}, nil, io.ErrUnexpectedEOF},
+		{"no final newline", `{"a":1}`, OutputLimits{}, false, []string{`{"a":1}`}, nil, io.ErrUnexpectedEOF},
+		{"noise", "hello\n{\"a\":1}\n", OutputLimits{}, false, []string{"{\"a\":1}\n"}, []string{"hello"}, io.ErrUnexpectedEOF},
+		{"empty", "", OutputLimits{}, false, nil, nil, io.ErrUnexpectedEOF},
+		{"max per message", "{\"a\":1}\n{\"b\":22}\n", OutputLimits{Max: 8}, false, []string{"{\"a\":1}\n"}, nil, &OutputLimitError{Limit: 8}},
+		{"max cumulative", "{\"a\":1}\n{\"b\":2}\n", OutputLimits{Max: 12}, true, []string{"{\"a\":1}\n"}, nil, &OutputLimitError{Limit: 12}},
+		{"max counts noise", strings.Repeat("x", 20) + "\n{}\n", OutputLimits{Max: 12}, true, nil, nil, &OutputLimitError{Limit: 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var noise []string
+			m := &msgReader{
+				r:          bufio.NewReaderSize(strings.NewReader(tt.in), 16),
+				limits:     tt.limits,
+				cumulative: tt.cumulative,
+				noise:      func(s string) { noise = append(noise, s) },
+			}
+			var got []string
+			for {
+				msg, err := m.line()
+				if err != nil {
+					var limitErr *OutputLimitError
+					if errors.As(tt.err, &limitErr) {
+						if !errors.As(err, &limitErr) || limitErr.Limit != tt.limits.Max {
+							t.Errorf("err = %v, want %v", err, tt.err)
+						}
+					} else if !errors.Is(err, tt.err) {
+						t.Errorf("err = %v, want %v", err, tt.err)
+					}
+					break
+				}
+				b, err := msg.all()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(b))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if strings.Join(noise, "|") != strings.Join(tt.noise, "|") {
+				t.Errorf("noise = %q, want %q", noise, tt.noise)
+			}
+		})
+	}
+}
+
+func TestMsgReaderFrame(t *testing.T) {
+	frame := func(s string) string {
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(s)))
+		return string(header[:]) + s
+	}
+	tests := []struct {
+		name   string
+		in     string
+		limits OutputLimits
+		want   string
+		err    bool
+	}{
+		{"frame", frame(`{"a":1}`), OutputLimits{}, `{"a":1}`, false},
+		{"over max", frame(`{"a":1}`), OutputLimits{Max: 4}, "", true},
+		{"short header", "\x00\x00", OutputLimits{}, "", true},
+		{"short body", frame(`{"a":1}`)[:6], OutputLimits{}, "", true},
+		{"garbage length", "\xff\xff\xff\xff{}", OutputLimits{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &msgReader{r: bufio.NewReader(strings.NewReader(tt.in)), limits: tt.limits}
+			msg, err := m.frame()
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %q, want an error", msg.b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg.b) != tt.want {
+				t.Errorf("got %q, want %q", msg.b, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpilledReply(t *testing.T) {
+	data := `{"items":[` + strings.Repeat(`"xxxxxxxxxx",`, 100) + `"end"]}`
+	tests := []struct {
+		name     string
+		in       string
+		want     string
+		typ      string
+		spilled  bool
+		errorMsg string
+	}{
+		{"result", `{"id":"7","type":"result","data": ` + data + "}\n", data, replyResult, true, ""},
+		{"data first", `{"data":` + data + `,"type":"result","id":"7"}` + "\n", data, replyResult, true, ""},
+		{"error read back", `{"id":"7","type":"error","error":{"message":"` + strings.Repeat("e", 1000) + `"}}` + "\n", "", replyError, false, strings.Repeat("e", 1000)},
+		{"small", `{"id":"7","type":"result","data":{}}` + "\n", `{}`, replyResult, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &msgReader{r: bufio.NewReader(strings.NewReader(tt.in)), limits: OutputLimits{Memory: 256}}
+			msg, err := m.line()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var name string
+			if msg.spill != nil {
+				name = msg.spill.f.Name()
+			}
+			reply, err := msg.reply()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.ID != "7" || reply.Type != tt.typ {
+				t.Errorf("envelope = %q %q, want 7 %q", reply.ID, reply.Type, tt.typ)
+			}
+			if (reply.spill != nil) != tt.spilled {
+				t.Fatalf("spilled = %v, want %v", reply.spill != nil, tt.spilled)
+			}
+			if tt.errorMsg != "" && (reply.Error == nil || reply.Error.Message != tt.errorMsg) {
+				t.Errorf("error = %+v", reply.Error)
+			}
+			if tt.want == "" {
+				return
+			}
+			res := &Result{Data: reply.Data, spill: reply.spill}
+			got, err := res.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("data = %.40q..., want %.40q...", got, tt.want)
+			}
+			if err := res.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if name != "" {
+				if _, err := os.Stat(name); !os.IsNotExist(err) {
+					t.Errorf("spill file %s left behind: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package psbridge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Logger is told about what a Client and its Sessions send, receive and
+// start. Methods are called synchronously from the goroutine doing the work,
+// so they should be quick; embed NopLogger to implement only some.
+type Logger interface {
+	RequestSent(RequestEvent)
+	ResponseReceived(ResponseEvent)
+	StderrLine(StderrEvent)
+	ProcessEvent(ProcessEvent)
+}
+
+// RequestEvent is one request written to a script
+type RequestEvent struct {
+	Op string
+	// ID is the session correlation ID; empty for one-shot calls
+	ID  string
+	PID int
+	// Size is the payload's length in bytes; Payload is as much of it as
+	// the client's payload limit allows
+	Size    int
+	Payload string
+}
+
+// ResponseEvent is the end of one call: its result or error
+type ResponseEvent struct {
+	Op       string
+	ID       string
+	PID      int
+	Duration time.Duration
+	Size     int
+	Payload  string
+	Err      error
+}
+
+// StderrEvent is one line a process wrote to stderr
+type StderrEvent struct {
+	PID  int
+	Line string
+}
+
+// ProcessEventKind says what happened to a process
+type ProcessEventKind int
+
+const (
+	ProcessStarted ProcessEventKind = iota
+	ProcessExited
+)
+
+func (k ProcessEventKind) String() string {
+	switch k {
+	case ProcessStarted:
+		return "started"
+	case ProcessExited:
+		return "exited"
+	}
+	return fmt.Sprintf("ProcessEventKind(%d)", int(k))
+}
+
+// ProcessEvent is a PowerShell process starting or exiting
+type ProcessEvent struct {
+	Kind ProcessEventKind
+	PID  int
+	// CommandLine is set for ProcessStarted, shortened like
+	// ExitError.CommandLine
+	CommandLine []string
+	// Session reports whether the process is a long-lived session
+	Session bool
+	// ExitCode and Err are set for ProcessExited
+	ExitCode int
+	Err      error
+}
+
+// NopLogger ignores every event
+type NopLogger struct{}
+
+func (NopLogger) RequestSent(RequestEvent)       {}
+func (NopLogger) ResponseReceived(ResponseEvent) {}
+func (NopLogger) StderrLine(StderrEvent)         {}
+func (NopLogger) ProcessEvent(ProcessEvent)      {}
+
+// Tee sends every event to each of loggers in turn
+func Tee(loggers ...Logger) Logger {
+	return tee(loggers)
+}
+
+type tee []Logger
+
+func (t tee) RequestSent(ev RequestEvent) {
+	for _, l := range t {
+		l.RequestSent(ev)
+	}
+}
+
+func (t tee) ResponseReceived(ev ResponseEvent) {
+	for _, l := range t {
+		l.ResponseReceived(ev)
+	}
+}
+
+func (t tee) StderrLine(ev StderrEvent) {
+	for _, l := range t {
+		l.StderrLine(ev)
+	}
+}
+
+func (t tee) ProcessEvent(ev ProcessEvent) {
+	for _, l := range t {
+		l.ProcessEvent(ev)
+	}
+}
+
+// WithLogger sends the client's events to l. Payloads are left out unless
+// WithLogPayload allows them.
+func WithLogger(l Logger) Option {
+	return func(c *Client) { c.Logger = l }
+}
+
+// WithLogPayload includes up to max bytes of each payload in request and
+// response events; -1 includes them whole
+func WithLogPayload(max int) Option {
+	return func(c *Client) { c.LogPayload = max }
+}
+
+// WithStderr copies each line scripts write to stderr to w as it is
+// written, and still keeps it for errors; see Client.Stderr
+func WithStderr(w io.Writer) Option {
+	return func(c *Client) { c.Stderr = w }
+}
+
+// WithStderrFunc calls fn with each line scripts write to stderr as it is
+// written; see Client.OnStderr
+func WithStderrFunc(fn func(StderrEvent)) Option {
+	return func(c *Client) { c.OnStderr = fn }
+}
+
+// hooks is the client's Logger, never nil, with its payload limit and
+// where else stderr goes
+type hooks struct {
+	Logger
+	limit   int
+	secrets *regexp.Regexp
+
+	stderrTo io.Writer
+	onStderr func(StderrEvent)
+}
+
+func (c *Client) hooks() hooks {
+	h := hooks{Logger: NopLogger{}, stderrTo: c.Stderr, onStderr: c.OnStderr}
+	if c.Logger != nil {
+		h.Logger, h.limit, h.secrets = c.Logger, c.LogPayload, c.SecretPattern
+	}
+	return h
+}
+
+// payload is as much of b as the limit allows, with secrets, including
+// the given keys, redacted
+func (h hooks) payload(b []byte, keys []string) string {
+	if h.limit == 0 {
+		return ""
+	}
+	b = Secrets{Pattern: h.secrets, Keys: keys}.Redact(b)
+	switch {
+	case h.limit < 0 || len(b) <= h.limit:
+		return string(b)
+	case h.limit == 0:
+		return ""
+	}
+	return fmt.Sprintf("%s…(%d bytes)", b[:h.limit], len(b))
+}
+
+// resultPayload is payload for a result. One that spilled to disk is too
+// big to log, and its start alone can't be vetted for secrets.
+func (h hooks) resultPayload(res *Result, keys []string) string {
+	if !res.Spilled() {
+		return h.payload(res.Data, keys)
+	}
+	if h.limit == 0 {
+		return ""
+	}
+	return fmt.Sprintf("…(%d bytes on disk)", res.Size())
+}
+
+// started reports that cmd is running
+func (h hooks) started(cmd *exec.Cmd, session bool) {
+	h.ProcessEvent(ProcessEvent{
+		Kind:        ProcessStarted,
+		PID:         cmd.Process.Pid,
+		CommandLine: shortArgs(cmd.Args),
+		Session:     session,
+	})
+}
+
+// exited reports that cmd is gone, with what Wait returned
+func (h hooks) exited(cmd *exec.Cmd, session bool, err error) {
+	ev := ProcessEvent{Kind: ProcessExited, PID: cmd.Process.Pid, Session: session, Err: err}
+	if cmd.ProcessState != nil {
+		ev.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	h.ProcessEvent(ev)
+}
+
+// stderr returns where cmd's stderr should go: buf, and a line at a time
+// the logger's StderrLine and the client's Stderr and OnStderr, whichever
+// are listening. flush must be called once cmd is done.
+func (h hooks) stderr(cmd *exec.Cmd, buf io.Writer) (w io.Writer, flush func()) {
+	_, nop := h.Logger.(NopLogger)
+	if nop && h.stderrTo == nil && h.onStderr == nil {
+		return buf, func() {}
+	}
+	lines := &lineWriter{fn: func(line string) {
+		// Start sets Process before it starts copying stderr
+		ev := StderrEvent{PID: cmd.Process.Pid, Line: line}
+		h.StderrLine(ev)
+		if h.stderrTo != nil {
+			io.WriteString(h.stderrTo, line+"\n")
+		}
+		if h.onStderr != nil {
+			h.onStderr(ev)
+		}
+	}}
+	return io.MultiWriter(buf, lines), lines.Flush
+}
+
+// lineWriter hands each complete line written to it to fn, and keeps a
+// partial last line until Flush
+type lineWriter struct {
+	mu      sync.Mutex
+	partial []byte
+	fn      func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush reports a final line that didn't end in a newline
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.fn(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// SlogLogger logs events to l: requests and responses at debug level,
+// process events at info, stderr at warn, failed calls at error
+type SlogLogger struct {
+	L *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to l, or to slog.Default if l is
+// nil
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{L: l}
+}
+
+func (s *SlogLogger) RequestSent(ev RequestEvent) {
+	attrs := []slog.Attr{slog.String("op", ev.Op), slog.Int("pid", ev.PID), slog.Int("size", ev.Size)}
+	if ev.ID != "" {
+		attrs = append(attrs, slog.String("id", ev.ID))
+	}
+	if ev.Payload != "" {
+		attrs = append(attrs, slog.String("payload", ev.Payload))
+	}
+	s.L.LogAttrs(context.Background(), slog.LevelDebug, "psbridge request", attrs...)
+}
+
+func (s *SlogLogger) ResponseReceived(ev ResponseEvent) {
+	attrs := []slog.Attr{slog.String("op", ev.Op), slog.Int("pid", ev.PID), slog.Duration("duration", ev.Duration)}
+	if ev.ID != "" {
+		attrs = append(attrs, slog.String("id", ev.ID))
+	}
+	if ev.Err != nil {
+		attrs = append(attrs, slog.Any("error", ev.Err))
+		s.L.LogAttrs(context.Background(), slog.LevelError, "psbridge call failed", attrs...)
+		return
+	}
+	attrs = append(attrs, slog.Int("size", ev.Size))
+	if ev.Payload != "" {
+		attrs = append(attrs, slog.String("payload", ev.Payload))
+	}
+	s.L.LogAttrs(context.Background(), slog.LevelDebug, "psbridge response", attrs...)
+}
+
+func (s *SlogLogger) StderrLine(ev StderrEvent) {
+	s.L.LogAttrs(context.Background(), slog.LevelWarn, "psbridge stderr", slog.Int("pid", ev.PID), slog.String("line", ev.Line))
+}
+
+func (s *SlogLogger) ProcessEvent(ev ProcessEvent) {
+	attrs := []slog.Attr{slog.Int("pid", ev.PID), slog.Bool("session", ev.Session)}
+	switch ev.Kind {
+	case ProcessStarted:
+		attrs = append(attrs, slog.Any("cmd", ev.CommandLine))
+	case ProcessExited:
+		attrs = append(attrs, slog.Int("exitCode", ev.ExitCode))
+		if ev.Err != nil {
+			attrs = append(attrs, slog.Any("error", ev.Err))
+		}
+	}
+	s.L.LogAttrs(context.Background(), slog.LevelInfo, "psbridge process "+ev.Kind.String(), attrs...)
+}
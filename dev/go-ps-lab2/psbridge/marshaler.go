@@ -0,0 +1,322 @@
+package psbridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Wire is a value as it travels between Go and a script
+type Wire struct {
+	// JSON is the value as JSON
+	JSON json.RawMessage
+	// Bytes, if set instead, travel as Bytes do, so the script gets a
+	// byte[]. A byte[] in a result arrives here too.
+	Bytes []byte
+	// Type, if set, is the .NET type the shim converts JSON to before the
+	// script sees it, as PowerShell casts: "System.TimeSpan" from
+	// "00:05:00", "System.Net.IPAddress" from "10.0.0.1", or an enum such as
+	// "System.IO.FileAttributes" from 34 or "Archive, Hidden".
+	Type string
+}
+
+// Marshaler is implemented by types choosing how they travel to scripts,
+// in place of whatever encoding/json makes of them
+type Marshaler interface {
+	MarshalPS() (Wire, error)
+}
+
+// Unmarshaler is implemented by types decoding themselves from results,
+// given the value as the script wrote it; a null is passed as JSON too
+type Unmarshaler interface {
+	UnmarshalPS(Wire) error
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+)
+
+// isCustom reports whether t or *t implements either interface
+func isCustom(t reflect.Type) bool {
+	return isMarshaler(t) || isUnmarshaler(t)
+}
+
+func isMarshaler(t reflect.Type) bool {
+	return t.Implements(marshalerType) || t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(marshalerType)
+}
+
+func isUnmarshaler(t reflect.Type) bool {
+	return t.Implements(unmarshalerType) || t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(unmarshalerType)
+}
+
+// node is w as it goes in a request's JSON
+func (w Wire) node() (any, error) {
+	if w.Bytes != nil {
+		return map[string]any{typeKey: "bytes", "base64": base64.StdEncoding.EncodeToString(w.Bytes)}, nil
+	}
+	var v any
+	if len(w.JSON) > 0 {
+		var err error
+		if v, err = decodeTree(w.JSON); err != nil {
+			return nil, err
+		}
+	}
+	if w.Type != "" {
+		return map[string]any{typeKey: "as", "type": w.Type, "value": v}, nil
+	}
+	return v, nil
+}
+
+// wireOf is node, part of a result, as a Wire
+func wireOf(node any) (Wire, error) {
+	if m, ok := node.(map[string]any); ok && m[typeKey] == "bytes" {
+		if encoded, ok := m["base64"].(string); ok {
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return Wire{}, fmt.Errorf("psbridge: decode bytes: %w", err)
+			}
+			return Wire{Bytes: raw}, nil
+		}
+	}
+	data, err := json.Marshal(unwrapValue(node))
+	return Wire{JSON: data}, err
+}
+
+// marshalCustom replaces what the Marshalers within v made encoding/json
+// write in node with what they write themselves
+func marshalCustom(node any, v reflect.Value) (any, error) {
+	if !v.IsValid() || !shapeOf(v.Type()).custom {
+		return node, nil
+	}
+	if isMarshaler(v.Type()) {
+		var m Marshaler
+		switch {
+		case v.Kind() == reflect.Pointer:
+			// A nil one is null already
+			if !v.IsNil() && v.Type().Implements(marshalerType) {
+				m = v.Interface().(Marshaler)
+			}
+		case v.Type().Implements(marshalerType):
+			m = v.Interface().(Marshaler)
+		case v.CanAddr():
+			m = v.Addr().Interface().(Marshaler)
+		}
+		if m != nil {
+			w, err := m.MarshalPS()
+			if err != nil {
+				return nil, fmt.Errorf("psbridge: marshal %s: %w", v.Type(), err)
+			}
+			return w.node()
+		}
+	}
+	var err error
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return marshalCustom(node, v.Elem())
+		}
+	case reflect.Struct:
+		m, ok := node.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, f := range psFieldsOf(v.Type()) {
+			key := f.key
+			if len(f.names) > 0 {
+				key = f.names[0]
+			}
+			item, ok := m[key]
+			if !ok {
+				continue
+			}
+			fv, ferr := v.FieldByIndexErr(f.index)
+			if ferr != nil {
+				continue
+			}
+			if m[key], err = marshalCustom(item, fv); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items, _ := node.([]any)
+		for i := 0; i < len(items) && i < v.Len(); i++ {
+			if items[i], err = marshalCustom(items[i], v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Map:
+		m, _ := node.(map[string]any)
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for k, item := range m {
+			old := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+			if !old.IsValid() {
+				continue
+			}
+			// A copy, so methods on the pointer are found too
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(old)
+			if m[k], err = marshalCustom(item, elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return node, nil
+}
+
+// stripCustom is node, a value of type t, with the values of Unmarshalers
+// within it null, so encoding/json leaves them be
+func stripCustom(node any, t reflect.Type) any {
+	if !shapeOf(t).custom {
+		return node
+	}
+	if isUnmarshaler(t) {
+		return nil
+	}
+	t = derefType(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		out := make(map[string]any, len(m))
+		for k, item := range m {
+			out[k] = item
+		}
+		for _, f := range psFieldsOf(t) {
+			if item, ok := out[f.key]; ok {
+				out[f.key] = stripCustom(item, f.typ)
+			}
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		items, ok := node.([]any)
+		if !ok {
+			return node
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			out[i] = stripCustom(item, t.Elem())
+		}
+		return out
+	case reflect.Map:
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		out := make(map[string]any, len(m))
+		for k, item := range m {
+			out[k] = stripCustom(item, t.Elem())
+		}
+		return out
+	}
+	return node
+}
+
+// unmarshalCustom hands the Unmarshalers within v, which encoding/json has
+// decoded the rest of, their part of node
+func unmarshalCustom(node any, v reflect.Value) error {
+	if !shapeOf(v.Type()).custom {
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		// encoding/json leaves a null pointer nil without asking it
+		if node == nil {
+			return nil
+		}
+		if v.IsNil() {
+			if !v.CanSet() {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().Implements(unmarshalerType) {
+			return callUnmarshaler(node, v)
+		}
+		return unmarshalCustom(node, v.Elem())
+	}
+	if isUnmarshaler(v.Type()) {
+		if !v.CanAddr() {
+			return nil
+		}
+		return callUnmarshaler(node, v.Addr())
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		for _, f := range psFieldsOf(v.Type()) {
+			item, ok := m[f.key]
+			if !ok {
+				continue
+			}
+			fv, ok := fieldAlloc(v, f.index)
+			if !ok {
+				continue
+			}
+			if err := unmarshalCustom(item, fv); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items, _ := node.([]any)
+		for i := 0; i < len(items) && i < v.Len(); i++ {
+			if err := unmarshalCustom(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, _ := node.(map[string]any)
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		for k, item := range m {
+			key := reflect.ValueOf(k).Convert(v.Type().Key())
+			// Map values aren't addressable, so decode a copy and put it back
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if old := v.MapIndex(key); old.IsValid() {
+				elem.Set(old)
+			}
+			if err := unmarshalCustom(item, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+	return nil
+}
+
+func callUnmarshaler(node any, ptr reflect.Value) error {
+	w, err := wireOf(node)
+	if err != nil {
+		return err
+	}
+	if err := ptr.Interface().(Unmarshaler).UnmarshalPS(w); err != nil {
+		return fmt.Errorf("psbridge: unmarshal %s: %w", ptr.Type().Elem(), err)
+	}
+	return nil
+}
+
+// fieldAlloc is the field at index within v, allocating the embedded
+// structs on the way that encoding/json left nil
+func fieldAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,218 @@
+// Package metrics exports psbridge activity as Prometheus metrics.
+//
+// A Collector is both a psbridge.Logger, which counts calls as they finish,
+// and a prometheus.Collector, which reads pool occupancy when scraped:
+//
+//	m := metrics.NewCollector()
+//	prometheus.MustRegister(m)
+//	client.Logger = m
+//	pool, _ := client.NewPool(psbridge.PoolConfig{Max: 4, OnWait: m.PoolWait("default")})
+//	m.WatchPool("default", pool)
+//	cache := psbridge.NewCache(pool, psbridge.CacheConfig{TTLs: ttls, OnLookup: m.CacheLookup("default")})
+//	m.WatchCache("default", cache)
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Failure classes for the failures counter's class label
+const (
+	ClassPowerShell = "powershell"
+	ClassTimeout    = "timeout"
+	ClassExit       = "exit"
+	ClassClosed     = "closed"
+	ClassOther      = "other"
+)
+
+// ErrorClass sorts err into one of the failure classes
+func ErrorClass(err error) string {
+	var psErr *psbridge.PSError
+	var timeoutErr *psbridge.TimeoutError
+	var exitErr *psbridge.ExitError
+	switch {
+	case errors.As(err, &psErr):
+		return ClassPowerShell
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.As(err, &exitErr):
+		return ClassExit
+	case errors.Is(err, psbridge.ErrSessionClosed), errors.Is(err, psbridge.ErrPoolClosed):
+		return ClassClosed
+	}
+	return ClassOther
+}
+
+// Collector gathers psbridge metrics. Create it with NewCollector.
+type Collector struct {
+	psbridge.NopLogger
+
+	invocations *prometheus.CounterVec
+	failures    *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	processes   *prometheus.CounterVec
+	poolWait    *prometheus.HistogramVec
+	cacheLookup *prometheus.CounterVec
+
+	poolSessions *prometheus.Desc
+	poolMax      *prometheus.Desc
+	poolWaiting  *prometheus.Desc
+	cacheSize    *prometheus.Desc
+
+	mu     sync.Mutex
+	pools  map[string]*psbridge.Pool
+	caches map[string]*psbridge.Cache
+}
+
+// NewCollector returns a Collector with metrics named psbridge_*
+func NewCollector() *Collector {
+	const ns = "psbridge"
+	return &Collector{
+		invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "invocations_total",
+			Help:      "Calls finished, by operation.",
+		}, []string{"op"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "invocation_failures_total",
+			Help:      "Calls that failed, by operation and failure class.",
+		}, []string{"op", "class"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "invocation_duration_seconds",
+			Help:      "Round-trip time of calls, from request written to reply read.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"op"}),
+		processes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "processes_started_total",
+			Help:      "PowerShell processes started, one-shot or session.",
+		}, []string{"session"}),
+		poolWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "pool_wait_seconds",
+			Help:      "Time Get spent queued for a free session slot.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"pool"}),
+		cacheLookup: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "cache_lookups_total",
+			Help:      "Calls to cached operations, by whether the cache answered them.",
+		}, []string{"cache", "op", "result"}),
+
+		poolSessions: prometheus.NewDesc(ns+"_pool_sessions",
+			"Live sessions in a pool, by state.", []string{"pool", "state"}, nil),
+		poolMax: prometheus.NewDesc(ns+"_pool_max_sessions",
+			"Configured cap on a pool's live sessions.", []string{"pool"}, nil),
+		poolWaiting: prometheus.NewDesc(ns+"_pool_waiting",
+			"Gets queued for a session.", []string{"pool"}, nil),
+		cacheSize: prometheus.NewDesc(ns+"_cache_entries",
+			"Results held by a cache.", []string{"cache"}, nil),
+
+		pools:  map[string]*psbridge.Pool{},
+		caches: map[string]*psbridge.Cache{},
+	}
+}
+
+// ResponseReceived counts a finished call
+func (c *Collector) ResponseReceived(ev psbridge.ResponseEvent) {
+	c.invocations.WithLabelValues(ev.Op).Inc()
+	c.latency.WithLabelValues(ev.Op).Observe(ev.Duration.Seconds())
+	if ev.Err != nil {
+		c.failures.WithLabelValues(ev.Op, ErrorClass(ev.Err)).Inc()
+	}
+}
+
+// ProcessEvent counts process starts
+func (c *Collector) ProcessEvent(ev psbridge.ProcessEvent) {
+	if ev.Kind == psbridge.ProcessStarted {
+		c.processes.WithLabelValues(strconv.FormatBool(ev.Session)).Inc()
+	}
+}
+
+// WatchPool reports p's occupancy under name on each scrape. A nil p stops
+// reporting name.
+func (c *Collector) WatchPool(name string, p *psbridge.Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p == nil {
+		delete(c.pools, name)
+		return
+	}
+	c.pools[name] = p
+}
+
+// PoolWait returns a PoolConfig.OnWait that records queue time under name
+func (c *Collector) PoolWait(name string) func(time.Duration) {
+	h := c.poolWait.WithLabelValues(name)
+	return func(d time.Duration) { h.Observe(d.Seconds()) }
+}
+
+// CacheLookup returns a CacheConfig.OnLookup that counts hits and misses
+// under name
+func (c *Collector) CacheLookup(name string) func(op string, hit bool) {
+	return func(op string, hit bool) {
+		result := "miss"
+		if hit {
+			result = "hit"
+		}
+		c.cacheLookup.WithLabelValues(name, op, result).Inc()
+	}
+}
+
+// WatchCache reports ca's size under name on each scrape. A nil ca stops
+// reporting name.
+func (c *Collector) WatchCache(name string, ca *psbridge.Cache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ca == nil {
+		delete(c.caches, name)
+		return
+	}
+	c.caches[name] = ca
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.invocations.Describe(ch)
+	c.failures.Describe(ch)
+	c.latency.Describe(ch)
+	c.processes.Describe(ch)
+	c.poolWait.Describe(ch)
+	c.cacheLookup.Describe(ch)
+	ch <- c.poolSessions
+	ch <- c.poolMax
+	ch <- c.poolWaiting
+	ch <- c.cacheSize
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.invocations.Collect(ch)
+	c.failures.Collect(ch)
+	c.latency.Collect(ch)
+	c.processes.Collect(ch)
+	c.poolWait.Collect(ch)
+	c.cacheLookup.Collect(ch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, p := range c.pools {
+		st := p.Stats()
+		ch <- prometheus.MustNewConstMetric(c.poolSessions, prometheus.GaugeValue, float64(st.Idle), name, "idle")
+		ch <- prometheus.MustNewConstMetric(c.poolSessions, prometheus.GaugeValue, float64(st.InUse), name, "in_use")
+		ch <- prometheus.MustNewConstMetric(c.poolMax, prometheus.GaugeValue, float64(st.Max), name)
+		ch <- prometheus.MustNewConstMetric(c.poolWaiting, prometheus.GaugeValue, float64(st.Waiting), name)
+	}
+	for name, ca := range c.caches {
+		ch <- prometheus.MustNewConstMetric(c.cacheSize, prometheus.GaugeValue, float64(ca.Stats().Entries), name)
+	}
+}
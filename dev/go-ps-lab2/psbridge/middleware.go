@@ -0,0 +1,35 @@
+package psbridge
+
+import "context"
+
+// InvokerFunc is a function as an Invoker
+type InvokerFunc func(ctx context.Context, call *Call) (*Result, error)
+
+// Do calls f
+func (f InvokerFunc) Do(ctx context.Context, call *Call) (*Result, error) {
+	return f(ctx, call)
+}
+
+// Middleware wraps an Invoker with something done around each call that
+// goes through it, such as logging, auth or checking payloads:
+//
+//	func audit(next psbridge.Invoker) psbridge.Invoker {
+//		return psbridge.InvokerFunc(func(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+//			log.Printf("calling %s", call.Op)
+//			return next.Do(ctx, call)
+//		})
+//	}
+//
+// schema.Registry's Wrap is one already, and the other wrappers become one
+// in a closure, such as func(next Invoker) Invoker { return NewCache(next,
+// cfg) }.
+type Middleware func(next Invoker) Invoker
+
+// Chain wraps inv in mw, the first outermost, so each call goes through
+// mw[0], then mw[1] and so on before reaching inv
+func Chain(inv Invoker, mw ...Middleware) Invoker {
+	for i := len(mw) - 1; i >= 0; i-- {
+		inv = mw[i](inv)
+	}
+	return inv
+}
@@ -0,0 +1,179 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ModulesOp is the operation the shim reports installed modules with
+const ModulesOp = "modules"
+
+type modulesRequest struct {
+	Modules []ModuleRequirement `json:"modules"`
+}
+
+// WithRequiredModules makes StartSession check for modules before handing
+// a session out, failing with *ModuleError if any are unmet
+func WithRequiredModules(reqs ...ModuleRequirement) Option {
+	return func(c *Client) { c.RequiredModules = append(c.RequiredModules, reqs...) }
+}
+
+// ModuleRequirement is a module an operation needs
+type ModuleRequirement struct {
+	Name string `json:"name"`
+	// MinimumVersion is the oldest acceptable version, e.g. "2.1"; empty
+	// accepts any
+	MinimumVersion string `json:"minimumVersion,omitempty"`
+}
+
+// ModuleStatus is what the host has of one required module
+type ModuleStatus struct {
+	Name           string `json:"name"`
+	MinimumVersion string `json:"minimumVersion,omitempty"`
+	// Version is the newest version installed, empty when there is none
+	Version string `json:"version,omitempty"`
+	// Path is where the newest version lives
+	Path string `json:"path,omitempty"`
+	// Installed lists every version found, newest first
+	Installed []string `json:"installed"`
+	Satisfied bool     `json:"satisfied"`
+	// InstallError is why InstallModules couldn't install it
+	InstallError string `json:"installError,omitempty"`
+}
+
+func (s ModuleStatus) String() string {
+	want := s.Name
+	if s.MinimumVersion != "" {
+		want += " " + s.MinimumVersion
+	}
+	switch {
+	case s.Satisfied:
+		return want + ": " + s.Version
+	case s.InstallError != "":
+		return want + ": install failed: " + s.InstallError
+	case s.Version == "":
+		return want + ": missing"
+	}
+	return want + ": found " + s.Version
+}
+
+// ModuleReport is the outcome of EnsureModules, one status per
+// requirement in the order given
+type ModuleReport struct {
+	Modules []ModuleStatus
+}
+
+// Unmet returns the requirements the host doesn't satisfy
+func (r *ModuleReport) Unmet() []ModuleStatus {
+	var unmet []ModuleStatus
+	for _, m := range r.Modules {
+		if !m.Satisfied {
+			unmet = append(unmet, m)
+		}
+	}
+	return unmet
+}
+
+// ModuleError reports required modules that are missing or too old
+type ModuleError struct {
+	Unmet []ModuleStatus
+}
+
+func (e *ModuleError) Error() string {
+	parts := make([]string, len(e.Unmet))
+	for i, m := range e.Unmet {
+		parts[i] = m.String()
+	}
+	return "psbridge: required modules unavailable: " + strings.Join(parts, ", ")
+}
+
+// EnsureModules checks Get-Module -ListAvailable on inv's host against
+// reqs. The report covers every requirement; the error is a *ModuleError
+// when any is unmet, or whatever stopped the check.
+func EnsureModules(ctx context.Context, inv Invoker, reqs []ModuleRequirement, opts ...CallOption) (*ModuleReport, error) {
+	modules, err := InvokeContext[modulesRequest, []ModuleStatus](ctx, inv, ModulesOp, modulesRequest{reqs}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(modules) != len(reqs) {
+		return nil, fmt.Errorf("psbridge: checked %d modules, got %d statuses", len(reqs), len(modules))
+	}
+	report := &ModuleReport{Modules: modules}
+	if unmet := report.Unmet(); len(unmet) > 0 {
+		return report, &ModuleError{Unmet: unmet}
+	}
+	return report, nil
+}
+
+// InstallModulesOp is the operation the shim installs modules with
+const InstallModulesOp = "install-modules"
+
+// InstallOptions configure installing missing modules with
+// Install-Module -Scope CurrentUser
+type InstallOptions struct {
+	// Repository is where modules come from; empty means PSGallery
+	Repository string `json:"repository,omitempty"`
+	// SourceLocation, if set, registers Repository at this URL when it
+	// isn't registered yet
+	SourceLocation string `json:"sourceLocation,omitempty"`
+	// Trust installs from a repository not marked trusted, which would
+	// otherwise need a prompt the non-interactive host can't show. On
+	// Windows PowerShell it also bootstraps the NuGet provider.
+	Trust bool `json:"trust,omitempty"`
+}
+
+// WithModuleInstall makes StartSession install unmet RequiredModules
+// instead of failing straight away
+func WithModuleInstall(opts InstallOptions) Option {
+	return func(c *Client) { c.ModuleInstall = &opts }
+}
+
+type installRequest struct {
+	InstallOptions
+	Modules []ModuleRequirement `json:"modules"`
+}
+
+type installOutcome struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// InstallModules is EnsureModules that installs what is missing or too
+// old before reporting. The report is of the host after installing, with
+// InstallError set on the modules that failed.
+func InstallModules(ctx context.Context, inv Invoker, reqs []ModuleRequirement, install InstallOptions, opts ...CallOption) (*ModuleReport, error) {
+	report, err := EnsureModules(ctx, inv, reqs, opts...)
+	var modErr *ModuleError
+	if !errors.As(err, &modErr) {
+		return report, err
+	}
+
+	missing := make([]ModuleRequirement, len(modErr.Unmet))
+	for i, m := range modErr.Unmet {
+		missing[i] = ModuleRequirement{Name: m.Name, MinimumVersion: m.MinimumVersion}
+	}
+	outcomes, err := InvokeContext[installRequest, []installOutcome](ctx, inv, InstallModulesOp, installRequest{install, missing}, opts...)
+	if err != nil {
+		return report, err
+	}
+	failed := map[string]string{}
+	for _, o := range outcomes {
+		if o.Error != "" {
+			failed[o.Name] = o.Error
+		}
+	}
+
+	report, err = EnsureModules(ctx, inv, reqs, opts...)
+	if report == nil {
+		return nil, err
+	}
+	for i := range report.Modules {
+		report.Modules[i].InstallError = failed[report.Modules[i].Name]
+	}
+	if errors.As(err, &modErr) {
+		return report, &ModuleError{Unmet: report.Unmet()}
+	}
+	return report, err
+}
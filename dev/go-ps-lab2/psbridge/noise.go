@@ -0,0 +1,86 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// The markers a script started with -Sentinels puts around each message
+const (
+	sentinelStart = "<<<JSON"
+	sentinelEnd   = "JSON>>>"
+)
+
+// WithSentinels has the script mark each message it writes as
+// <<<JSON{...}JSON>>>, and everything else on stdout taken as noise, even
+// text that looks like JSON. Without it a line is a message if it starts
+// with {, or ends with one after other text on the line, as Write-Host
+// -NoNewline leaves it; other lines are noise. Either way noise from a
+// one-shot call or pipeline is kept in Streams.Stdout, and a session's is
+// dropped. Length-prefixed framing has no noise, so ignores this.
+func WithSentinels() Option {
+	return func(c *Client) { c.Sentinels = true }
+}
+
+// extractWindow is how far into a line the start of its message is looked
+// for
+const extractWindow = 4096
+
+// extract narrows m to the message in a line of stdout, reporting false
+// for a line of noise
+func (m *message) extract(sentinels bool) (bool, error) {
+	head := m.head(extractWindow)
+	// Windows PowerShell's -OutputFormat XML, read whole by readReply
+	if IsCLIXML(head) {
+		return true, nil
+	}
+
+	if sentinels {
+		start := bytes.Index(head, []byte(sentinelStart))
+		if start < 0 {
+			return false, nil
+		}
+		tail := m.tail(len(sentinelEnd) + 64)
+		end := bytes.LastIndex(tail, []byte(sentinelEnd))
+		if end < 0 {
+			return false, errors.New("psbridge: message has no " + sentinelEnd + " end marker")
+		}
+		m.slice(int64(start+len(sentinelStart)), m.size()-int64(len(tail)-end))
+		return true, nil
+	}
+
+	// A handler setting [Console]::OutputEncoding to UTF-8 makes Windows
+	// PowerShell write a BOM ahead of the next line
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, utf8BOM), " \t")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		m.slice(int64(len(head)-len(trimmed)), m.size())
+		return true, nil
+	}
+	if m.spill != nil {
+		return false, nil
+	}
+	// Text ahead of the message on its line: take it from the first brace
+	// the rest parses from, trying a few
+	for i, tries := bytes.IndexByte(m.b, '{'), 0; i >= 0 && tries < 16; tries++ {
+		if json.Valid(m.b[i:]) {
+			m.b = m.b[i:]
+			return true, nil
+		}
+		next := bytes.IndexByte(m.b[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false, nil
+}
+
+// text is a noise line as a string, cut short if it was spilled
+func (m message) text() string {
+	if m.spill != nil {
+		return strings.TrimRight(string(m.head(extractWindow)), "\r\n") + "..."
+	}
+	return strings.TrimRight(string(m.b), "\r\n")
+}
@@ -0,0 +1,50 @@
+package psbridge
+
+import (
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		sentinels bool
+		want      string
+		ok        bool
+		err       bool
+	}{
+		{"message", `{"a":1}` + "\n", false, `{"a":1}` + "\n", true, false},
+		{"indented", "  \t{\"a\":1}\n", false, "{\"a\":1}\n", true, false},
+		{"bom", "\xEF\xBB\xBF{\"a\":1}\n", false, "{\"a\":1}\n", true, false},
+		{"after write-host", `progress: 50%{"a":1}`, false, `{"a":1}`, true, false},
+		{"braces in the noise", `set {x} to {"a":{"b":1}}`, false, `{"a":{"b":1}}`, true, false},
+		{"noise", "WARNING: something\n", false, "", false, false},
+		{"unbalanced noise", "a { b\n", false, "", false, false},
+		{"clixml", "#< CLIXML\n", false, "#< CLIXML\n", true, false},
+		{"sentinels", `<<<JSON{"a":1}JSON>>>` + "\r\n", true, `{"a":1}`, true, false},
+		{"sentinels after noise", `text <<<JSON{"a":1}JSON>>>` + "\n", true, `{"a":1}`, true, false},
+		{"sentinels ignore json", `{"a":1}` + "\n", true, "", false, false},
+		{"sentinels unterminated", `<<<JSON{"a":1}` + "\n", true, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := message{b: []byte(tt.line)}
+			ok, err := m.extract(tt.sentinels)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && string(m.b) != tt.want {
+				t.Errorf("message = %q, want %q", m.b, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoiseText(t *testing.T) {
+	if got := (message{b: []byte("WARNING: x\r\n")}).text(); got != "WARNING: x" {
+		t.Errorf("text = %q", got)
+	}
+}
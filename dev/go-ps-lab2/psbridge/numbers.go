@@ -0,0 +1,231 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// NumberMode is how a client decodes the numbers in results that land in
+// an any, such as the values of a map[string]any. Typed fields aren't
+// affected: an int64 field gets every digit anyway, and json.Number,
+// *big.Int and Decimal fields keep them whatever the mode.
+type NumberMode int
+
+const (
+	// NumbersFloat decodes them as float64, as encoding/json does, which
+	// loses digits past 2^53 and rounds decimals
+	NumbersFloat NumberMode = iota
+	// NumbersJSON decodes them as json.Number, their digits as written
+	NumbersJSON
+	// NumbersExact decodes integers as int64, or *big.Int when they don't
+	// fit, and others as Decimal
+	NumbersExact
+)
+
+// WithNumbers sets how the client decodes numbers in untyped results
+func WithNumbers(mode NumberMode) Option {
+	return func(c *Client) { c.Numbers = mode }
+}
+
+// decodeJSON unmarshals data, JSON from a script, into v with numbers
+// decoded as mode says
+func decodeJSON(data []byte, v any, mode NumberMode) error {
+	t := reflect.TypeOf(v)
+	shape := shapeOf(t)
+	if shape.custom {
+		return decodeCustom(data, v, mode)
+	}
+	data, err := unwrapBytes(data)
+	if err != nil {
+		return err
+	}
+	// Dates need changing only in Windows PowerShell's form
+	if shape.names || shape.times && bytes.Contains(data, []byte("Date(")) {
+		if data, err = remapPS(data, t, false); err != nil {
+			return err
+		}
+	}
+	return decodeNumbers(data, v, mode)
+}
+
+// decodeCustom is decodeJSON for a v with Unmarshalers within, which get
+// their values as the script wrote them and the rest of v what
+// encoding/json makes of the rest
+func decodeCustom(data []byte, v any, mode NumberMode) error {
+	t := reflect.TypeOf(v)
+	tree, err := decodeTree(data)
+	if err != nil {
+		return err
+	}
+	tree = walkPS(tree, t, false)
+	plain, err := json.Marshal(unwrapValue(stripCustom(tree, t)))
+	if err != nil {
+		return err
+	}
+	if err := decodeNumbers(plain, v, mode); err != nil {
+		return err
+	}
+	return unmarshalCustom(tree, reflect.ValueOf(v))
+}
+
+// decodeNumbers unmarshals data into v with numbers decoded as mode says
+func decodeNumbers(data []byte, v any, mode NumberMode) error {
+	if mode == NumbersFloat {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if mode == NumbersExact {
+		exactNumbers(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// exactNumbers replaces the json.Numbers held in the interfaces within v
+// with int64s, *big.Ints and Decimals
+func exactNumbers(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			exactNumbers(v.Elem())
+		}
+	case reflect.Interface:
+		if !v.IsNil() && v.CanSet() {
+			v.Set(reflect.ValueOf(exactValue(v.Interface())))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				exactNumbers(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			exactNumbers(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Interface {
+			for _, k := range v.MapKeys() {
+				if e := v.MapIndex(k); e.Kind() == reflect.Pointer || e.Kind() == reflect.Map || e.Kind() == reflect.Slice {
+					exactNumbers(e)
+				}
+			}
+			return
+		}
+		for _, k := range v.MapKeys() {
+			if e := v.MapIndex(k); !e.IsNil() {
+				v.SetMapIndex(k, reflect.ValueOf(exactValue(e.Interface())))
+			}
+		}
+	}
+}
+
+// exactValue is v, as decoded into an any, with its numbers made exact
+func exactValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		return exactNumber(v)
+	case map[string]any:
+		for k, item := range v {
+			v[k] = exactValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = exactValue(item)
+		}
+	}
+	return v
+}
+
+func exactNumber(n json.Number) any {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if i, ok := new(big.Int).SetString(n.String(), 10); ok {
+		return i
+	}
+	return Decimal(n)
+}
+
+// Decimal is a decimal number kept as its digits, such as a [decimal] from
+// PowerShell, so money and the like survive the trip both ways. A script
+// receives it as a [decimal] rather than the double ConvertFrom-Json
+// would make of a plain number.
+type Decimal string
+
+// decimalSyntax is a JSON number
+var decimalSyntax = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d+)?$`)
+
+// ParseDecimal checks that s is a number
+func ParseDecimal(s string) (Decimal, error) {
+	if !decimalSyntax.MatchString(s) {
+		return "", fmt.Errorf("psbridge: bad decimal %q", s)
+	}
+	return Decimal(s), nil
+}
+
+func (d Decimal) String() string {
+	if d == "" {
+		return "0"
+	}
+	return string(d)
+}
+
+// Rat is the decimal's exact value
+func (d Decimal) Rat() (*big.Rat, bool) {
+	return new(big.Rat).SetString(d.String())
+}
+
+// Float64 is the decimal's nearest float64
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(d.String(), 64)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if _, err := ParseDecimal(d.String()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{typeKey: "decimal", "value": d.String()})
+}
+
+// UnmarshalJSON accepts a JSON number, as ConvertTo-Json writes a
+// [decimal], a string holding one, or the marked form MarshalJSON writes
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case string(data) == "null":
+		return nil
+	case len(data) > 0 && data[0] == '{':
+		var v struct {
+			Type  string `json:"$psbridge"`
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if v.Type != "decimal" {
+			return fmt.Errorf("psbridge: decode decimal: got a %q value", v.Type)
+		}
+		data = []byte(v.Value)
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	v, err := ParseDecimal(string(data))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
@@ -0,0 +1,90 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeJSONNumbers(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	const data = `{"small":42,"big":123456789012345678901234567890,"frac":0.1,"list":[9007199254740993]}`
+	tests := []struct {
+		mode NumberMode
+		want map[string]any
+	}{
+		{NumbersFloat, map[string]any{"small": 42.0, "big": 1.2345678901234568e29, "frac": 0.1, "list": []any{9007199254740992.0}}},
+		{NumbersJSON, map[string]any{"small": json.Number("42"), "big": json.Number("123456789012345678901234567890"), "frac": json.Number("0.1"), "list": []any{json.Number("9007199254740993")}}},
+		{NumbersExact, map[string]any{"small": int64(42), "big": big1, "frac": Decimal("0.1"), "list": []any{int64(9007199254740993)}}},
+	}
+	for _, tt := range tests {
+		var got map[string]any
+		if err := decodeJSON([]byte(data), &got, tt.mode); err != nil {
+			t.Fatalf("mode %d: %v", tt.mode, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mode %d: got %#v, want %#v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestExactNumbersInStructs(t *testing.T) {
+	type inner struct{ V any }
+	var got struct {
+		ID    int64
+		Any   any
+		Ptr   *inner
+		Items []inner
+		Typed map[string]*inner
+	}
+	data := `{"ID":9007199254740993,"Any":1.5,"Ptr":{"V":7},"Items":[{"V":8}],"Typed":{"k":{"V":9}}}`
+	if err := decodeJSON([]byte(data), &got, NumbersExact); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 9007199254740993 || got.Any != Decimal("1.5") || got.Ptr.V != int64(7) || got.Items[0].V != int64(8) || got.Typed["k"].V != int64(9) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Decimal
+		err  bool
+	}{
+		{`12.50`, "12.50", false},
+		{`"-0.001"`, "-0.001", false},
+		{`{"$psbridge":"decimal","value":"1e3"}`, "1e3", false},
+		{`{"$psbridge":"bytes","value":"AA=="}`, "", true},
+		{`"12,5"`, "", true},
+		{`"01"`, "", true},
+		{`null`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var d Decimal
+			err := json.Unmarshal([]byte(tt.in), &d)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if d != tt.want {
+				t.Errorf("got %q, want %q", d, tt.want)
+			}
+		})
+	}
+
+	b, err := json.Marshal(Decimal("0.30"))
+	if err != nil || string(b) != `{"$psbridge":"decimal","value":"0.30"}` {
+		t.Errorf("marshal = %s, %v", b, err)
+	}
+	if _, err := json.Marshal(Decimal("abc")); err == nil {
+		t.Error("marshaled a bad decimal")
+	}
+	if r, ok := Decimal("0.30").Rat(); !ok || r.Cmp(big.NewRat(3, 10)) != 0 {
+		t.Errorf("Rat = %v, %v", r, ok)
+	}
+	if Decimal("").String() != "0" {
+		t.Error("zero Decimal isn't 0")
+	}
+}
@@ -0,0 +1,132 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// PageOp is the operation the shim pages other operations' results with:
+// its data is the operation to run with a page size and continuation
+// token, its result one page of items and the token for the next
+const PageOp = "page"
+
+// DefaultPageSize is the page size for a PageQuery without one
+const DefaultPageSize = 500
+
+// PageQuery chooses how a result is split into pages
+type PageQuery struct {
+	// Size is the most items in a page, default DefaultPageSize
+	Size int
+	// Token resumes from a page another Pager reached; see Pager.Token
+	Token string
+	// Field pages the list in this field of an operation that returns a
+	// single object, e.g. "values" for RegistryOp. Without it the result
+	// itself must be the list.
+	Field string
+}
+
+type pageRequest struct {
+	Op    string          `json:"op"`
+	Data  json.RawMessage `json:"data"`
+	Size  int             `json:"size"`
+	Token string          `json:"token,omitempty"`
+	Field string          `json:"field,omitempty"`
+}
+
+type pageReply[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next"`
+	Total int    `json:"total"`
+}
+
+// Page is one chunk of a paged result
+type Page[T any] struct {
+	Items []T
+	// Total is how many items the whole result has
+	Total int
+}
+
+// Pager reads a result a page at a time, so no one message carries all of
+// it:
+//
+//	pages := psbridge.Paginate[psbridge.Cmdlet, Event](ctx, session, psbridge.CmdletOp, cmd, psbridge.PageQuery{Size: 1000})
+//	for page, ok := pages.Next(); ok; page, ok = pages.Next() {
+//		...
+//	}
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+//
+// A Session keeps the full result between pages, so the operation runs
+// once. A Client starts a process per page, which runs the operation again
+// and skips to where the last page ended, so the result may shift if the
+// state it reads changes meanwhile.
+type Pager[T any] struct {
+	ctx  context.Context
+	inv  Invoker
+	req  pageRequest
+	opts []CallOption
+	done bool
+	err  error
+}
+
+// Paginate pages the result of op with req through inv. Nothing is sent
+// until the first call to Next.
+func Paginate[TReq, T any](ctx context.Context, inv Invoker, op string, req TReq, q PageQuery, opts ...CallOption) *Pager[T] {
+	p := &Pager[T]{ctx: ctx, inv: inv}
+	data, err := MarshalPS(req)
+	if err != nil {
+		p.err, p.done = fmt.Errorf("marshal request: %w", err), true
+		return p
+	}
+	if q.Size <= 0 {
+		q.Size = DefaultPageSize
+	}
+	p.req = pageRequest{Op: op, Data: data, Size: q.Size, Token: q.Token, Field: q.Field}
+	secrets := SecretKeys(reflect.TypeFor[TReq]())
+	secrets = append(secrets, SecretKeys(reflect.TypeFor[T]())...)
+	p.opts = append(opts[:len(opts):len(opts)], WithSecrets(secrets...))
+	return p
+}
+
+// Next fetches the next page. ok is false once the result is exhausted or
+// a call failed; Err tells which.
+func (p *Pager[T]) Next() (page Page[T], ok bool) {
+	if p.done {
+		return page, false
+	}
+	reply, err := InvokeContext[pageRequest, pageReply[T]](p.ctx, p.inv, PageOp, p.req, p.opts...)
+	if err != nil {
+		p.err, p.done = err, true
+		return page, false
+	}
+	if reply.Next == "" {
+		p.done = true
+	}
+	p.req.Token = reply.Next
+	return Page[T]{Items: reply.Items, Total: reply.Total}, true
+}
+
+// Err is the error that ended paging, if any
+func (p *Pager[T]) Err() error { return p.err }
+
+// Token identifies the page the next call to Next fetches, for a later
+// Paginate to resume from with PageQuery.Token. It is empty once the
+// result is exhausted.
+func (p *Pager[T]) Token() string {
+	if p.done {
+		return ""
+	}
+	return p.req.Token
+}
+
+// All reads every page and returns their items together
+func (p *Pager[T]) All() ([]T, error) {
+	var items []T
+	for page, ok := p.Next(); ok; page, ok = p.Next() {
+		items = append(items, page.Items...)
+	}
+	return items, p.Err()
+}
@@ -0,0 +1,302 @@
+package psbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Param is one named argument for a script's param() block
+type Param struct {
+	Name string
+	// Value is rendered as a PowerShell literal; slices become arrays and
+	// maps become hashtables
+	Value any
+	// Switch params are passed bare, as -Name, and Value is ignored
+	Switch bool
+}
+
+// MarshalParams converts the exported fields of struct v into script
+// parameters. Fields are named after the field unless a ps tag says
+// otherwise:
+//
+//	Path    string   `ps:"LiteralPath"`   // -LiteralPath:'C:\x'
+//	Names   []string                      // -Names:@('a', 'b')
+//	Force   bool     `ps:",switch"`       // -Force when true, left out when false
+//	Depth   int      `ps:",omitempty"`    // left out when zero
+//	Skipped string   `ps:"-"`
+//
+// Plain bool fields are passed as -Name:$true or -Name:$false, which binds to
+// both [bool] and [switch] parameters. Nil pointers are left out.
+func MarshalParams(v any) ([]Param, error) {
+	if params, ok := v.([]Param); ok {
+		return params, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("psbridge: params must be a struct, got %s", rv.Type())
+	}
+
+	var params []Param
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts := parseTag(field.Tag.Get("ps"))
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Pointer && fv.IsNil() {
+			continue
+		}
+		if opts.has("omitempty") && fv.IsZero() {
+			continue
+		}
+
+		if opts.has("switch") {
+			if reflect.Indirect(fv).Kind() != reflect.Bool {
+				return nil, fmt.Errorf("psbridge: switch field %s must be a bool", field.Name)
+			}
+			if reflect.Indirect(fv).Bool() {
+				params = append(params, Param{Name: name, Switch: true})
+			}
+			continue
+		}
+
+		params = append(params, Param{Name: name, Value: fv.Interface()})
+	}
+	return params, nil
+}
+
+// RunScript runs the client's script as an ordinary PowerShell script, not
+// through the JSON protocol, binding params (a struct for MarshalParams or a
+// []Param) to its param() block. It returns whatever the script printed.
+//
+// The call is always sent as PowerShell code, with -EncodedCommand or on
+// stdin in ExecStdin mode or when a param holds a SecureString, so arrays,
+// switches and typed values bind the same way they would from a
+// PowerShell prompt.
+func (c *Client) RunScript(ctx context.Context, params any) ([]byte, error) {
+	ps, err := MarshalParams(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Integrity.Check(); err != nil {
+		return nil, err
+	}
+	if err := c.verifyScripts(ctx); err != nil {
+		return nil, err
+	}
+
+	var script string
+	switch c.Mode {
+	case ExecFile:
+		path, err := filepath.Abs(c.Script)
+		if err != nil {
+			return nil, fmt.Errorf("resolve script: %w", err)
+		}
+		script = "& " + quotePS(path)
+	case ExecEncodedCommand, ExecStdin:
+		text, err := c.scriptText()
+		if err != nil {
+			return nil, err
+		}
+		script = invokeBlock(text)
+	default:
+		return nil, fmt.Errorf("psbridge: unknown exec mode %v", c.Mode)
+	}
+
+	args, err := renderParams(ps)
+	if err != nil {
+		return nil, err
+	}
+	return c.runCommand(ctx, script+args)
+}
+
+// runCommand runs script with -EncodedCommand, or on stdin in ExecStdin
+// mode or if it holds a SecureString, and returns its stdout
+func (c *Client) runCommand(ctx context.Context, script string) ([]byte, error) {
+	return c.runCode(ctx, script, c.Mode == ExecStdin || strings.Contains(script, secureLiteral))
+}
+
+// runCode runs script with -EncodedCommand, or on stdin if viaStdin, once
+// the client's RateLimiter lets it, and returns its stdout
+func (c *Client) runCode(ctx context.Context, script string, viaStdin bool) ([]byte, error) {
+	if err := c.RateLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	shell, err := c.shell()
+	if err != nil {
+		return nil, err
+	}
+
+	args := c.Flags.args()
+	if viaStdin {
+		args = append(args, "-Command", "-")
+	} else {
+		args = append(args, "-EncodedCommand", EncodeCommand(script))
+	}
+	cmd := c.process(ctx, c.Dir, shell, args...)
+	if viaStdin {
+		cmd.Stdin = strings.NewReader(stdinCommand(script))
+	}
+	var stdout, stderr bytes.Buffer
+	capped := &capWriter{w: &stdout, max: c.Limits.Max, over: func() { killTree(cmd) }}
+	cmd.Stdout = capped
+	cmd.Stderr = &stderr
+
+	err = c.startCommand(cmd)
+	if err == nil {
+		err = waitCommand(cmd)
+	}
+	if err != nil {
+		if capped.hit {
+			return nil, &OutputLimitError{Op: "script", Limit: c.Limits.Max}
+		}
+		if ctx.Err() != nil {
+			return nil, &TimeoutError{Op: "script", Err: ctx.Err()}
+		}
+		return nil, newExitError(cmd, err, stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+// renderParams renders params as PowerShell command arguments, each with a
+// leading space, e.g. " -Path:'C:\x' -Force"
+func renderParams(params []Param) (string, error) {
+	var sb strings.Builder
+	for _, p := range params {
+		sb.WriteString(" -")
+		sb.WriteString(p.Name)
+		if p.Switch {
+			continue
+		}
+		lit, err := psLiteral(reflect.ValueOf(p.Value))
+		if err != nil {
+			return "", fmt.Errorf("param %s: %w", p.Name, err)
+		}
+		// The colon form keeps $false bound to switch parameters and stops
+		// negative numbers reading as parameter names
+		sb.WriteString(":")
+		sb.WriteString(lit)
+	}
+	return sb.String(), nil
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+// psLiteraler is a value with its own PowerShell form, like SecureString
+type psLiteraler interface{ psLiteral() string }
+
+// psLiteral renders v as PowerShell source that evaluates to the same value
+func psLiteral(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "$null", nil
+	}
+	if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface && v.CanInterface() {
+		if lit, ok := v.Interface().(psLiteraler); ok {
+			return lit.psLiteral(), nil
+		}
+	}
+	if v.Type().Implements(textMarshalerType) && !(v.Kind() == reflect.Pointer && v.IsNil()) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return quotePS(string(text)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return "$null", nil
+		}
+		return psLiteral(v.Elem())
+	case reflect.String:
+		return quotePS(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return "$true", nil
+		}
+		return "$false", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "@()", nil
+		}
+		items := make([]string, v.Len())
+		for i := range items {
+			item, err := psLiteral(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "@(" + strings.Join(items, ", ") + ")", nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("map keys must be strings, got %s", v.Type().Key())
+		}
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		entries := make([]string, len(keys))
+		for i, k := range keys {
+			val, err := psLiteral(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())))
+			if err != nil {
+				return "", err
+			}
+			entries[i] = quotePS(k) + " = " + val
+		}
+		return "@{" + strings.Join(entries, "; ") + "}", nil
+	}
+	return "", fmt.Errorf("cannot pass %s to PowerShell", v.Type())
+}
+
+// tagOptions are the comma-separated options after a ps tag's name
+type tagOptions []string
+
+func parseTag(tag string) (string, tagOptions) {
+	name, rest, _ := strings.Cut(tag, ",")
+	if rest == "" {
+		return name, nil
+	}
+	return name, strings.Split(rest, ",")
+}
+
+func (o tagOptions) has(opt string) bool {
+	for _, s := range o {
+		if s == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package psbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Pipeline feeds values into one operation's PowerShell pipeline and
+// returns its output an object at a time, so neither side holds the whole
+// set. It runs in a process of its own, started with -Pipeline.
+//
+// The operation's handler receives the items as pipeline input, so it
+// handles them in a process block:
+//
+//	function Invoke-ResizeOperation {
+//	    param([Parameter(ValueFromPipeline = $true)] $Item, $Data)
+//	    process { ... }
+//	}
+//
+// Send and CloseSend may be called from one goroutine while another calls
+// Recv. Feeding a pipeline without reading its output stalls once the
+// pipes fill up.
+type Pipeline[TIn, TOut any] struct {
+	op      string
+	numbers NumberMode
+	strict  bool
+	cmd     *exec.Cmd
+	hooks   hooks
+	start   time.Time
+	ctx     context.Context
+
+	sealKey []byte
+	sendMu  sync.Mutex
+	stdin   io.WriteCloser
+
+	stdout      *msgReader
+	b           replyBuilder
+	stderr      bytes.Buffer
+	flushStderr func()
+
+	// err is what Recv returns once the pipeline is over, io.EOF when it
+	// ran to the end
+	err  error
+	once sync.Once
+}
+
+// StartPipeline starts op in pipeline mode with data as its request, for
+// items to be streamed to it with Send. Over SSH, SecureStrings in items
+// travel unsealed, as for any call.
+func StartPipeline[TIn, TOut any](ctx context.Context, c *Client, op string, data any, opts ...CallOption) (*Pipeline[TIn, TOut], error) {
+	first, err := MarshalPS(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	call := newCall(op, first, opts)
+	if (len(call.Env) > 0 || call.ReplaceEnv) && c.SSH != nil {
+		return nil, fmt.Errorf("%w: ssh only forwards variables the server accepts; use a session", ErrEnvUnsupported)
+	}
+
+	if c.Mode == ExecStdin {
+		return nil, fmt.Errorf("%w, so pipelines can't run", errStdinTaken)
+	}
+
+	cmd, err := c.command(ctx, c.dir(call), Param{Name: "Operation", Value: op}, Param{Name: "Pipeline", Switch: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(call.Env) > 0 || call.ReplaceEnv {
+		cmd.Env = callEnv(call)
+	}
+	p := &Pipeline[TIn, TOut]{op: op, numbers: c.Numbers, strict: c.Strict, cmd: cmd, hooks: c.hooks(), ctx: ctx}
+	p.b = *newReplyBuilder(call)
+	if c.SSH == nil {
+		if p.sealKey, err = newSealKey(); err != nil {
+			return nil, err
+		}
+		cmd.Env = withSealKey(cmd.Env, p.sealKey)
+	}
+	cmd.Stderr, p.flushStderr = p.hooks.stderr(cmd, &p.stderr)
+
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	p.stdout = &msgReader{r: bufio.NewReader(newTextDecoder(stdout, c.Encoding)), limits: c.Limits, sentinels: c.Sentinels}
+	p.stdout.noise = func(line string) { p.b.res.Streams.Stdout = append(p.b.res.Streams.Stdout, line) }
+
+	if err := c.startCommand(cmd); err != nil {
+		return nil, fmt.Errorf("start powershell: %w", err)
+	}
+	p.start = time.Now()
+	p.hooks.started(cmd, false)
+	p.hooks.RequestSent(RequestEvent{Op: op, PID: cmd.Process.Pid, Size: len(first), Payload: p.hooks.payload(first, call.Secrets)})
+
+	if err := p.write(first); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Send writes item to the pipeline's input
+func (p *Pipeline[TIn, TOut]) Send(item TIn) error {
+	data, err := MarshalPS(item)
+	if err != nil {
+		return fmt.Errorf("marshal item: %w", err)
+	}
+	return p.write(data)
+}
+
+func (p *Pipeline[TIn, TOut]) write(data []byte) error {
+	data, err := seal(data, p.sealKey)
+	if err != nil {
+		return err
+	}
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("send to %s: %w", p.op, err)
+	}
+	return nil
+}
+
+// CloseSend ends the input, letting the operation finish once it has
+// handled what it got
+func (p *Pipeline[TIn, TOut]) CloseSend() error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.stdin.Close()
+}
+
+// Recv returns the next object the operation wrote. After the last one it
+// returns io.EOF, or the error that stopped the pipeline: a *PSError for a
+// terminating error, a *TimeoutError if ctx ended first.
+func (p *Pipeline[TIn, TOut]) Recv() (TOut, error) {
+	var item TOut
+	if p.err != nil {
+		return item, p.err
+	}
+	for {
+		msg, err := p.stdout.line()
+		if err != nil {
+			return item, p.finish(limitError(err, p.op))
+		}
+		reply, err := msg.reply()
+		if err != nil {
+			return item, p.finish(err)
+		}
+		if reply.Type == replyItem {
+			if p.strict {
+				if err := checkStrict(reply.Data, reflect.TypeOf(&item)); err != nil {
+					return item, fmt.Errorf("unmarshal item: %w", err)
+				}
+			}
+			if err := decodeJSON(reply.Data, &item, p.numbers); err != nil {
+				return item, fmt.Errorf("unmarshal item: %w", err)
+			}
+			return item, nil
+		}
+		if done, err := p.b.add(reply); done {
+			if err == nil {
+				err = io.EOF
+			}
+			return item, p.finish(err)
+		}
+	}
+}
+
+// Streams returns what the operation wrote to its other streams so far.
+// It mustn't be called while Recv is running.
+func (p *Pipeline[TIn, TOut]) Streams() Streams { return p.b.res.Streams }
+
+// finish waits for the process and settles the pipeline's final error
+// from how its output ended, err
+func (p *Pipeline[TIn, TOut]) finish(err error) error {
+	p.once.Do(func() {
+		p.CloseSend()
+		if err != io.EOF {
+			// Nothing more will be read, so don't let it block on output
+			killTree(p.cmd)
+		}
+		io.Copy(io.Discard, p.stdout.r)
+		waitErr := waitCommand(p.cmd)
+		p.flushStderr()
+		p.hooks.exited(p.cmd, false, waitErr)
+
+		var limitErr *OutputLimitError
+		var psErr *PSError
+		switch {
+		case err == errPipelineClosed, errors.As(err, &limitErr):
+		case p.ctx.Err() != nil:
+			err = &TimeoutError{Op: p.op, Err: p.ctx.Err()}
+		case errors.As(err, &psErr):
+		case waitErr != nil:
+			err = newExitError(p.cmd, waitErr, p.stderr.Bytes())
+		case err != io.EOF:
+			err = fmt.Errorf("read reply: %w", err)
+		}
+		p.err = err
+		ev := ResponseEvent{Op: p.op, PID: p.cmd.Process.Pid, Duration: time.Since(p.start)}
+		if err != io.EOF {
+			ev.Err = err
+		}
+		p.hooks.ResponseReceived(ev)
+	})
+	return p.err
+}
+
+// Close stops the pipeline, killing the process if it is still running.
+// It is safe to call after Recv returned io.EOF.
+func (p *Pipeline[TIn, TOut]) Close() error {
+	err := p.finish(errPipelineClosed)
+	if err == io.EOF || err == errPipelineClosed {
+		return nil
+	}
+	return err
+}
+
+var errPipelineClosed = errors.New("psbridge: pipeline closed")
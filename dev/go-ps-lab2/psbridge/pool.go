@@ -0,0 +1,427 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolClosed is returned when using a Pool after Close
+var ErrPoolClosed = errors.New("psbridge: pool closed")
+
+// PoolConfig sizes a Pool
+type PoolConfig struct {
+	// Min sessions are started up front and kept warm
+	Min int
+	// Max caps the number of live sessions; Get blocks while all are
+	// checked out. Zero means Min, or 1 if Min is zero too.
+	Max int
+	// IdleTimeout closes idle sessions above Min after this long. Zero
+	// keeps them forever.
+	IdleTimeout time.Duration
+	// HealthCheck vets an idle session before it is handed out; a session
+	// that fails is closed and replaced. The default only checks that the
+	// process is still running.
+	HealthCheck func(ctx context.Context, s *Session) error
+	// PingInterval pings idle sessions this often and closes the ones that
+	// don't answer within PingTimeout (default 5s). Zero disables pinging.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	// CloseTimeout is how long a session leaving the pool gets to finish
+	// and exit before it is killed (default 5s)
+	CloseTimeout time.Duration
+	// OnWait, if set, is called by each Get that obtained a slot with how
+	// long it queued for it
+	OnWait func(time.Duration)
+}
+
+// PingHealthCheck is a PoolConfig.HealthCheck that pings each session
+// before handing it out
+func PingHealthCheck(timeout time.Duration) func(context.Context, *Session) error {
+	return func(ctx context.Context, s *Session) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		_, err := s.Ping(ctx)
+		return err
+	}
+}
+
+// Pool keeps several warm sessions of one Client so goroutines can run
+// calls in parallel. Check sessions out with Get and back in with Put, or
+// just use Do.
+type Pool struct {
+	client *Client
+	cfg    PoolConfig
+
+	// slots holds one token per session that may be checked out
+	slots chan struct{}
+
+	// waiting counts Gets queued for a slot
+	waiting atomic.Int64
+
+	mu     sync.Mutex
+	idle   []idleSession
+	live   int
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type idleSession struct {
+	s        *Session
+	since    time.Time
+	lastPing time.Time
+}
+
+// PoolStats is a snapshot of a Pool's occupancy
+type PoolStats struct {
+	Idle  int
+	InUse int
+	// Max is the configured cap on live sessions
+	Max int
+	// Waiting is how many Gets are queued for a session
+	Waiting int
+}
+
+// NewPool starts cfg.Min sessions of c
+func (c *Client) NewPool(cfg PoolConfig) (*Pool, error) {
+	if cfg.Max <= 0 {
+		cfg.Max = max(cfg.Min, 1)
+	}
+	if cfg.Min > cfg.Max {
+		cfg.Min = cfg.Max
+	}
+
+	p := &Pool{
+		client: c,
+		cfg:    cfg,
+		slots:  make(chan struct{}, cfg.Max),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for range cfg.Max {
+		p.slots <- struct{}{}
+	}
+
+	for range cfg.Min {
+		s, err := c.StartSession()
+		if err != nil {
+			// Not Close, which waits for maintain to stop, and it hasn't
+			// started
+			for _, is := range p.idle {
+				p.closeSession(is.s)
+			}
+			return nil, err
+		}
+		p.live++
+		p.idle = append(p.idle, idleSession{s: s, since: time.Now()})
+	}
+
+	go p.maintain()
+	return p, nil
+}
+
+// Get checks out a healthy session, starting one if none are idle. It
+// blocks while Max sessions are checked out.
+func (p *Pool) Get(ctx context.Context) (*Session, error) {
+	start := time.Now()
+	p.waiting.Add(1)
+	select {
+	case <-p.slots:
+		p.waiting.Add(-1)
+	case <-ctx.Done():
+		p.waiting.Add(-1)
+		return nil, &TimeoutError{Op: "pool checkout", Err: ctx.Err()}
+	case <-p.stop:
+		p.waiting.Add(-1)
+		return nil, ErrPoolClosed
+	}
+	if p.cfg.OnWait != nil {
+		p.cfg.OnWait(time.Since(start))
+	}
+
+	for {
+		s, ok, err := p.popIdle()
+		if err != nil {
+			p.slots <- struct{}{}
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if p.check(ctx, s) == nil {
+			return s, nil
+		}
+		p.discard(s)
+	}
+
+	s, err := p.client.StartSessionContext(ctx)
+	if err != nil {
+		p.slots <- struct{}{}
+		return nil, err
+	}
+	p.mu.Lock()
+	p.live++
+	p.mu.Unlock()
+	return s, nil
+}
+
+// Put checks s back in. Broken sessions are closed instead of reused.
+func (p *Pool) Put(s *Session) {
+	defer func() { p.slots <- struct{}{} }()
+
+	if s.healthy() != nil {
+		p.discard(s)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.discard(s)
+		return
+	}
+	p.idle = append(p.idle, idleSession{s: s, since: time.Now()})
+	p.mu.Unlock()
+}
+
+// Do runs call on a pooled session. The client's Timeouts count the wait
+// for a session as part of the call.
+func (p *Pool) Do(ctx context.Context, call *Call) (*Result, error) {
+	return p.client.Timeouts.run(ctx, call, func(ctx context.Context) (*Result, error) {
+		s, err := p.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer p.Put(s)
+		return s.roundTrip(ctx, call)
+	})
+}
+
+// Stats reports how many sessions are idle and checked out
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Idle:    len(p.idle),
+		InUse:   p.live - len(p.idle),
+		Max:     p.cfg.Max,
+		Waiting: int(p.waiting.Load()),
+	}
+}
+
+// Close stops the pool and closes its idle sessions. Sessions still checked
+// out are closed when they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	<-p.done
+
+	var errs []error
+	for _, is := range idle {
+		errs = append(errs, p.discard(is.s))
+	}
+	return errors.Join(errs...)
+}
+
+// popIdle takes the most recently used idle session, which is the most
+// likely to still be warm
+func (p *Pool) popIdle() (*Session, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, false, ErrPoolClosed
+	}
+	if len(p.idle) == 0 {
+		return nil, false, nil
+	}
+	is := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return is.s, true, nil
+}
+
+func (p *Pool) check(ctx context.Context, s *Session) error {
+	if err := s.healthy(); err != nil {
+		return err
+	}
+	if p.cfg.HealthCheck != nil {
+		return p.cfg.HealthCheck(ctx, s)
+	}
+	return nil
+}
+
+// discard closes a session that is leaving the pool
+func (p *Pool) discard(s *Session) error {
+	p.mu.Lock()
+	p.live--
+	p.mu.Unlock()
+	return p.closeSession(s)
+}
+
+// closeSession closes s within CloseTimeout
+func (p *Pool) closeSession(s *Session) error {
+	timeout := p.cfg.CloseTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Close(ctx)
+}
+
+// maintain evicts long-idle sessions and keeps Min sessions warm
+func (p *Pool) maintain() {
+	defer close(p.done)
+
+	interval := time.Minute
+	if p.cfg.IdleTimeout > 0 {
+		interval = min(interval, p.cfg.IdleTimeout/2)
+	}
+	if p.cfg.PingInterval > 0 {
+		interval = min(interval, p.cfg.PingInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, s := range p.expired() {
+			p.discard(s)
+		}
+		if p.cfg.PingInterval > 0 {
+			p.pingIdle()
+		}
+		p.topUp()
+	}
+}
+
+// expired removes and returns idle sessions past IdleTimeout, or dead ones,
+// never dropping below Min live sessions for the timeout
+func (p *Pool) expired() []*Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []*Session
+	kept := p.idle[:0]
+	live := p.live
+	for _, is := range p.idle {
+		dead := is.s.healthy() != nil
+		stale := p.cfg.IdleTimeout > 0 && time.Since(is.since) > p.cfg.IdleTimeout && live > p.cfg.Min
+		if dead || stale {
+			out = append(out, is.s)
+			live--
+			continue
+		}
+		kept = append(kept, is)
+	}
+	p.idle = kept
+	return out
+}
+
+// pingIdle pings idle sessions that are due, borrowing a free slot for each
+// so Get can't start extra sessions meanwhile
+func (p *Pool) pingIdle() {
+	timeout := p.cfg.PingTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	for {
+		select {
+		case <-p.slots:
+		default:
+			return
+		}
+
+		is, ok := p.popDue()
+		if !ok {
+			p.slots <- struct{}{}
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := is.s.Ping(ctx)
+		cancel()
+
+		if err != nil {
+			p.discard(is.s)
+			p.slots <- struct{}{}
+			continue
+		}
+
+		p.mu.Lock()
+		closed := p.closed
+		if !closed {
+			is.lastPing = time.Now()
+			p.idle = append([]idleSession{is}, p.idle...)
+		}
+		p.mu.Unlock()
+		if closed {
+			p.discard(is.s)
+		}
+		p.slots <- struct{}{}
+	}
+}
+
+// popDue removes an idle session that hasn't been pinged for PingInterval
+func (p *Pool) popDue() (idleSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return idleSession{}, false
+	}
+	for i, is := range p.idle {
+		last := is.since
+		if is.lastPing.After(last) {
+			last = is.lastPing
+		}
+		if time.Since(last) >= p.cfg.PingInterval {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return is, true
+		}
+	}
+	return idleSession{}, false
+}
+
+// topUp starts sessions until Min are live
+func (p *Pool) topUp() {
+	for {
+		p.mu.Lock()
+		if p.closed || p.live >= p.cfg.Min {
+			p.mu.Unlock()
+			return
+		}
+		p.live++
+		p.mu.Unlock()
+
+		s, err := p.client.StartSession()
+		p.mu.Lock()
+		if err != nil || p.closed {
+			p.live--
+			p.mu.Unlock()
+			if s != nil {
+				p.closeSession(s)
+			}
+			return
+		}
+		p.idle = append(p.idle, idleSession{s: s, since: time.Now()})
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,136 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewPoolStartFailure(t *testing.T) {
+	c := NewClient("bridge.ps1", WithShell(filepath.Join(t.TempDir(), "no-pwsh")))
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.NewPool(PoolConfig{Min: 2})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("NewPool succeeded without a shell")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("NewPool hung after a session failed to start")
+	}
+}
+
+func TestPool(t *testing.T) {
+	p, err := fakeClient(t, "session").NewPool(PoolConfig{Min: 1, Max: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	if got := p.Stats(); got != (PoolStats{Idle: 1, Max: 2}) {
+		t.Errorf("fresh pool: %+v", got)
+	}
+	res, err := p.Do(ctx, &Call{Op: "echo", Data: json.RawMessage(`{"a":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `{"a":1}` {
+		t.Errorf("Data = %s", res.Data)
+	}
+
+	a, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("one session checked out twice")
+	}
+	if got := p.Stats(); got != (PoolStats{InUse: 2, Max: 2}) {
+		t.Errorf("all checked out: %+v", got)
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	var timeout *TimeoutError
+	if _, err := p.Get(short); !errors.As(err, &timeout) {
+		t.Errorf("Get past Max: err = %v, want a *TimeoutError", err)
+	}
+
+	// A session that died is closed when it comes back, not reused
+	b.Do(ctx, &Call{Op: "crash"})
+	for b.healthy() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	p.Put(b)
+	p.Put(a)
+	if got := p.Stats(); got != (PoolStats{Idle: 1, Max: 2}) {
+		t.Errorf("after Put: %+v", got)
+	}
+	if s, err := p.Get(ctx); err != nil || s != a {
+		t.Errorf("Get = %p, %v, want the healthy session %p", s, err, a)
+	} else {
+		p.Put(s)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, err := fakeClient(t, "session").NewPool(PoolConfig{Min: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Get after Close: err = %v, want ErrPoolClosed", err)
+	}
+	// Checked out during Close, it is closed as it comes back
+	p.Put(out)
+	if out.healthy() == nil {
+		t.Error("session put back after Close is still running")
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	checks := 0
+	p, err := fakeClient(t, "session").NewPool(PoolConfig{Min: 1, HealthCheck: func(ctx context.Context, s *Session) error {
+		checks++
+		if checks == 1 {
+			return errors.New("unwell")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	first := p.Stats()
+	s, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(s)
+	if checks != 1 || first.Idle != 1 {
+		t.Errorf("checks = %d, idle before = %d", checks, first.Idle)
+	}
+	if _, err := p.Do(context.Background(), &Call{Op: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	if checks != 2 {
+		t.Errorf("checks = %d, want the returned session checked", checks)
+	}
+}
@@ -0,0 +1,53 @@
+package psbridge
+
+import (
+	"context"
+	"time"
+)
+
+// Operations the shim serves for processes
+const (
+	ProcessesOp = "processes"
+	KillOp      = "kill"
+)
+
+// Process is one running process. Fields the caller may not read, such as
+// another user's start time or path without elevation, are left empty.
+type Process struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+	// CPU is the processor time used, in seconds
+	CPU float64 `json:"cpu"`
+	// WorkingSet is the physical memory in use, in bytes
+	WorkingSet int64     `json:"workingSet"`
+	StartTime  time.Time `json:"startTime"`
+	Path       string    `json:"path,omitempty"`
+	// Owner is the user the process runs as, e.g. CONTOSO\alice
+	Owner string `json:"owner,omitempty"`
+}
+
+// CPUTime is CPU as a Duration
+func (p Process) CPUTime() time.Duration {
+	return time.Duration(p.CPU * float64(time.Second))
+}
+
+type processesRequest struct {
+	Names []string `json:"names,omitempty"`
+}
+
+type killRequest struct {
+	PID int `json:"pid"`
+}
+
+// Processes lists the processes whose names match any of names, which may
+// hold wildcards, or every process without any. A name without wildcards
+// that matches nothing fails with an ObjectNotFound *PSError.
+func Processes(ctx context.Context, inv Invoker, names []string, opts ...CallOption) ([]Process, error) {
+	return InvokeContext[processesRequest, []Process](ctx, inv, ProcessesOp, processesRequest{Names: names}, opts...)
+}
+
+// Kill stops the process pid at once, without letting it clean up
+func Kill(ctx context.Context, inv Invoker, pid int, opts ...CallOption) error {
+	_, err := InvokeContext[killRequest, struct{}](ctx, inv, KillOp, killRequest{PID: pid}, opts...)
+	return err
+}
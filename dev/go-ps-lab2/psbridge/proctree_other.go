@@ -0,0 +1,34 @@
+//go:build !unix && !windows
+
+package psbridge
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func startOwnGroup(cmd *exec.Cmd) {}
+
+const jobMemory = false
+
+// contain can't apply any Resources here
+func contain(cmd *exec.Cmd, r Resources) error {
+	if r.Priority != PriorityNormal || len(r.CPUs) > 0 {
+		return errors.ErrUnsupported
+	}
+	return nil
+}
+
+func release(cmd *exec.Cmd) {}
+
+func terminate(cmd *exec.Cmd) error {
+	return errors.ErrUnsupported
+}
+
+// killTree can only kill cmd itself here
+func killTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
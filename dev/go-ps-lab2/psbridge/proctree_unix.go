@@ -0,0 +1,68 @@
+//go:build unix
+
+package psbridge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// startOwnGroup makes cmd the leader of a new process group, which every
+// process it starts joins unless it asks otherwise
+func startOwnGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// jobMemory is whether Resources.Memory is applied by the Job Object
+const jobMemory = false
+
+// contain applies r to cmd, just started; the process group started with
+// it keeps track of its children. Processes cmd starts take on its
+// priority and CPUs, so the moment before r applies matters little.
+func contain(cmd *exec.Cmd, r Resources) error {
+	pid := cmd.Process.Pid
+	if n := r.Priority.nice(); n != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, n); err != nil {
+			return fmt.Errorf("set priority %v: %w", r.Priority, err)
+		}
+	}
+	if len(r.CPUs) > 0 {
+		if err := setAffinity(pid, r.CPUs); err != nil {
+			return fmt.Errorf("set CPUs %v: %w", r.CPUs, err)
+		}
+	}
+	return nil
+}
+
+func release(cmd *exec.Cmd) {}
+
+// terminate sends SIGTERM to cmd's process group
+func terminate(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return os.ErrProcessDone
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killTree kills cmd's process group, so children the script started, such
+// as with Start-Process, die with it
+func killTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		// The whole group is gone already
+		return os.ErrProcessDone
+	}
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package psbridge
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// startOwnGroup does nothing on Windows, where contain puts the process in
+// a Job Object instead
+func startOwnGroup(cmd *exec.Cmd) {}
+
+// jobs holds the Job Object of each running command, by its *exec.Cmd
+var jobs sync.Map
+
+// jobMemory is whether Resources.Memory is applied by the Job Object
+const jobMemory = true
+
+// priorityClasses are the Windows classes of each Priority
+var priorityClasses = map[Priority]uint32{
+	PriorityIdle:        windows.IDLE_PRIORITY_CLASS,
+	PriorityBelowNormal: windows.BELOW_NORMAL_PRIORITY_CLASS,
+	PriorityAboveNormal: windows.ABOVE_NORMAL_PRIORITY_CLASS,
+	PriorityHigh:        windows.HIGH_PRIORITY_CLASS,
+}
+
+// contain puts cmd, just started, in a Job Object of its own that kills
+// every process in it when its handle closes: by release once cmd exits,
+// or by Windows if we crash first. Children the script starts join it, so
+// none outlive it, and share the limits r sets on it. One started in the
+// moment before cmd joins escapes, though PowerShell is still starting up
+// then. Without a job, where Windows refuses one, killTree falls back to
+// taskkill, unless r needs the job.
+func contain(cmd *exec.Cmd, r Resources) error {
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE},
+	}
+	basic := &info.BasicLimitInformation
+	if class, ok := priorityClasses[r.Priority]; ok {
+		basic.LimitFlags |= windows.JOB_OBJECT_LIMIT_PRIORITY_CLASS
+		basic.PriorityClass = class
+	}
+	for _, cpu := range r.CPUs {
+		if cpu < 0 || cpu >= int(unsafe.Sizeof(basic.Affinity))*8 {
+			return fmt.Errorf("no CPU %d", cpu)
+		}
+		basic.LimitFlags |= windows.JOB_OBJECT_LIMIT_AFFINITY
+		basic.Affinity |= 1 << cpu
+	}
+	if r.Memory > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = uintptr(r.Memory)
+	}
+
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return containErr(r, err)
+	}
+	_, err = windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if err == nil {
+		var process windows.Handle
+		process, err = windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+		if err == nil {
+			err = windows.AssignProcessToJobObject(job, process)
+			windows.CloseHandle(process)
+		}
+	}
+	if err != nil {
+		windows.CloseHandle(job)
+		return containErr(r, err)
+	}
+	jobs.Store(cmd, job)
+	return nil
+}
+
+// containErr is the error contain returns for err: nothing, unless r
+// can't do without the job
+func containErr(r Resources, err error) error {
+	if r.isZero() {
+		return nil
+	}
+	return fmt.Errorf("job object: %w", err)
+}
+
+// release closes cmd's Job Object, once cmd has exited, killing what it
+// left running
+func release(cmd *exec.Cmd) {
+	if job, ok := jobs.LoadAndDelete(cmd); ok {
+		windows.CloseHandle(job.(windows.Handle))
+	}
+}
+
+// terminate isn't possible on Windows, which has no SIGTERM to send a
+// console process
+func terminate(cmd *exec.Cmd) error {
+	return errors.ErrUnsupported
+}
+
+// killTree kills cmd and every process descended from it, so children the
+// script started, such as with Start-Process, die with it
+func killTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if job, ok := jobs.Load(cmd); ok {
+		if err := windows.TerminateJobObject(job.(windows.Handle), 1); err == nil {
+			return nil
+		}
+	}
+	// taskkill /T follows parent process IDs down the tree, which needs
+	// the root alive, so it runs before the fallback kill
+	tk := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	if err := tk.Run(); err == nil {
+		return nil
+	}
+	err := cmd.Process.Kill()
+	if err != nil && cmd.ProcessState != nil {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package psbridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// wireRequest is one line Go writes to a script's stdin. ID is echoed on
+// every reply so a session can have several requests in flight.
+type wireRequest struct {
+	ID   string          `json:"id,omitempty"`
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Env is set for the duration of the request and restored afterwards
+	Env map[string]string `json:"env,omitempty"`
+	// Dir is the location for the duration of the request
+	Dir string `json:"dir,omitempty"`
+	// Isolate resets the session's state once the request is done
+	Isolate bool `json:"isolate,omitempty"`
+}
+
+// wireReply is one line a script writes back on stdout. A call produces any
+// number of stream lines followed by exactly one result or error line.
+type wireReply struct {
+	ID       string          `json:"id,omitempty"`
+	Type     string          `json:"type"`
+	Stream   string          `json:"stream,omitempty"`
+	Message  string          `json:"message,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Error    *PSError        `json:"error,omitempty"`
+	Progress *ProgressRecord `json:"progress,omitempty"`
+
+	// spill is where a result too big for memory was left instead of Data
+	spill *spillSection
+}
+
+// Operations the session loop answers itself rather than dispatching
+const (
+	opPing = "ping"
+	// opQuit asks the loop to exit once the requests before it are done
+	opQuit = "quit"
+	// opReset puts the session's state back as it was before the first
+	// request
+	opReset = "reset"
+	// opSetVariable sets a global variable in the session
+	opSetVariable = "set-variable"
+)
+
+// Reply types
+const (
+	replyResult = "result"
+	replyError  = "error"
+	replyStream = "stream"
+	// replyItem is one output object of a Pipeline, ahead of its result
+	replyItem = "item"
+)
+
+// Stream names carried by stream replies
+const (
+	streamVerbose     = "verbose"
+	streamWarning     = "warning"
+	streamDebug       = "debug"
+	streamInformation = "information"
+	streamError       = "error"
+	streamProgress    = "progress"
+)
+
+// Streams holds everything a call wrote to the non-output PowerShell streams,
+// in the order it was written
+type Streams struct {
+	Verbose     []string
+	Warning     []string
+	Debug       []string
+	Information []string
+	// Errors are non-terminating errors, e.g. from Write-Error
+	Errors []*PSError
+	// Stdout is what the script wrote to stdout outside the protocol, such
+	// as module banners or Write-Host from a profile, a line each
+	Stdout []string
+}
+
+// ProgressRecord is one Write-Progress update
+type ProgressRecord struct {
+	ActivityID       int    `json:"activityId"`
+	ParentActivityID int    `json:"parentActivityId"`
+	Activity         string `json:"activity"`
+	Status           string `json:"status"`
+	CurrentOperation string `json:"currentOperation,omitempty"`
+	// PercentComplete is -1 when the script didn't report one
+	PercentComplete int `json:"percentComplete"`
+	// SecondsRemaining is -1 when the script didn't report one
+	SecondsRemaining int  `json:"secondsRemaining"`
+	Completed        bool `json:"completed"`
+}
+
+// readReply reads lines from r until the call's result or error, handing
+// progress and stream records to call's hooks as they arrive; call may be
+// nil. A terminating PowerShell error is returned as a *PSError.
+func readReply(m *msgReader, call *Call) (*Result, error) {
+	b := newReplyBuilder(call)
+	// Noise is the call's only while it runs, as a session reads its
+	// framing reply this way too
+	prev := m.noise
+	m.noise = func(line string) { b.res.Streams.Stdout = append(b.res.Streams.Stdout, line) }
+	defer func() { m.noise = prev }()
+	for {
+		msg, err := m.line()
+		if err != nil {
+			return nil, err
+		}
+
+		// Windows PowerShell asked for -OutputFormat XML answers in CLIXML
+		// rather than protocol lines, so take the rest of the output as
+		// the result
+		if IsCLIXML(msg.head(64)) {
+			line, err := msg.all()
+			if err != nil {
+				return nil, err
+			}
+			return readCLIXMLReply(line, m)
+		}
+
+		reply, err := msg.reply()
+		if err != nil {
+			return nil, err
+		}
+
+		if done, err := b.add(reply); done {
+			if err != nil {
+				return nil, err
+			}
+			return &b.res, nil
+		}
+	}
+}
+
+// ReadReplies reads one call's reply messages from r, as written by the
+// bundled script in one-shot mode. It is for backends that run the script
+// somewhere other than a local process.
+func ReadReplies(r io.Reader, call *Call) (*Result, error) {
+	return readReply(&msgReader{r: bufio.NewReader(newTextDecoder(r, EncodingAuto))}, call)
+}
+
+// replyBuilder accumulates one call's replies into its Result
+type replyBuilder struct {
+	res        Result
+	onProgress func(ProgressRecord)
+	onStream   func(Event)
+}
+
+// newReplyBuilder builds call's Result, calling its hooks; call may be nil
+func newReplyBuilder(call *Call) *replyBuilder {
+	if call == nil {
+		return &replyBuilder{}
+	}
+	return &replyBuilder{onProgress: call.Progress, onStream: call.Stream}
+}
+
+// add takes one reply. done reports whether it finished the call, in which
+// case err is the call's error, if any.
+func (b *replyBuilder) add(reply *wireReply) (done bool, err error) {
+	switch reply.Type {
+	case replyResult:
+		b.res.Data = reply.Data
+		b.res.spill = reply.spill
+		return true, nil
+	case replyError:
+		if reply.Error == nil {
+			return true, errors.New("powershell: error reply without details")
+		}
+		return true, protocolError(reply.Error)
+	case replyStream:
+		if reply.Stream == streamProgress {
+			if b.onProgress != nil && reply.Progress != nil {
+				b.onProgress(*reply.Progress)
+			}
+			return false, nil
+		}
+		b.res.Streams.add(reply)
+		if b.onStream != nil {
+			if ev, ok := streamEvent(reply); ok {
+				b.onStream(ev)
+			}
+		}
+		return false, nil
+	}
+	return true, fmt.Errorf("unexpected reply type %q", reply.Type)
+}
+
+// readCLIXMLReply decodes first plus the remainder of r as one CLIXML
+// document and re-encodes it as JSON: a single object as itself, several as
+// an array
+func readCLIXMLReply(first []byte, m *msgReader) (*Result, error) {
+	var r io.Reader = m.r
+	if m.limits.Max > 0 {
+		// One byte past the limit is enough to know it was broken
+		r = io.LimitReader(m.r, max(m.limits.Max-m.read, 0)+1)
+	}
+	rest, err := io.ReadAll(r)
+	if err == nil {
+		err = m.take(len(rest))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := DecodeCLIXML(append(first, rest...))
+	if err != nil {
+		return nil, err
+	}
+
+	var v any = values
+	if len(values) == 1 {
+		v = values[0]
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("re-encode clixml: %w", err)
+	}
+	return &Result{Data: data}, nil
+}
+
+// add files a stream reply under the right stream
+func (s *Streams) add(reply *wireReply) {
+	switch reply.Stream {
+	case streamVerbose:
+		s.Verbose = append(s.Verbose, reply.Message)
+	case streamWarning:
+		s.Warning = append(s.Warning, reply.Message)
+	case streamDebug:
+		s.Debug = append(s.Debug, reply.Message)
+	case streamInformation:
+		s.Information = append(s.Information, reply.Message)
+	case streamError:
+		if reply.Error != nil {
+			s.Errors = append(s.Errors, reply.Error)
+		} else {
+			s.Errors = append(s.Errors, &PSError{Message: reply.Message})
+		}
+	}
+}
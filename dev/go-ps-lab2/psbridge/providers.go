@@ -0,0 +1,66 @@
+package psbridge
+
+import (
+	"context"
+	"slices"
+)
+
+// Operations the shim serves for browsing providers
+const (
+	ProvidersOp  = "providers"
+	ChildItemsOp = "childitems"
+)
+
+// Provider is one registered PowerShell provider, as Get-PSProvider
+// describes it
+type Provider struct {
+	Name string `json:"name"`
+	// Module is the module or snap-in that registered it, e.g.
+	// Microsoft.PowerShell.Core
+	Module string `json:"module,omitempty"`
+	// Capabilities are the ProviderCapabilities flags it has, e.g.
+	// ShouldProcess and Credentials
+	Capabilities []string `json:"capabilities"`
+	Drives       []Drive  `json:"drives"`
+	// Home is the provider's ~ location, empty for most providers
+	Home string `json:"home,omitempty"`
+}
+
+// Has reports whether the provider has the capability named c
+func (p Provider) Has(c string) bool {
+	return slices.Contains(p.Capabilities, c)
+}
+
+// Drive is one of a provider's drives
+type Drive struct {
+	Name string `json:"name"`
+	// Root is what the drive maps to, e.g. C:\ or HKEY_CURRENT_USER
+	Root        string `json:"root"`
+	Description string `json:"description,omitempty"`
+}
+
+// ChildItem is one item under a provider path, as Get-ChildItem lists it
+type ChildItem struct {
+	Name string `json:"name"`
+	// Path is provider-qualified, e.g.
+	// Microsoft.PowerShell.Core\FileSystem::C:\Windows
+	Path        string `json:"path"`
+	Provider    string `json:"provider"`
+	IsContainer bool   `json:"isContainer"`
+}
+
+type childItemsRequest struct {
+	Path  string `json:"path"`
+	Force bool   `json:"force"`
+}
+
+// Providers lists the providers registered on inv's host
+func Providers(ctx context.Context, inv Invoker, opts ...CallOption) ([]Provider, error) {
+	return InvokeContext[struct{}, []Provider](ctx, inv, ProvidersOp, struct{}{}, opts...)
+}
+
+// ChildItems lists the items under path, which may be on any provider's
+// drive, e.g. Env: or HKCU:\Software. Force includes hidden items.
+func ChildItems(ctx context.Context, inv Invoker, path string, force bool, opts ...CallOption) ([]ChildItem, error) {
+	return InvokeContext[childItemsRequest, []ChildItem](ctx, inv, ChildItemsOp, childItemsRequest{path, force}, opts...)
+}
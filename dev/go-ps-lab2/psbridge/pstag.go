@@ -0,0 +1,307 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// psField is a field of a struct as its JSON object has it
+type psField struct {
+	key   string
+	names []string
+	typ   reflect.Type
+	// required is whether it is tagged psbridge:"required"
+	required bool
+	// skip is whether it is tagged ps:"-", kept from PowerShell
+	skip bool
+	// index is the field's, for reflect.Value.FieldByIndex
+	index []int
+}
+
+// psFields caches each struct type's fields
+var psFields sync.Map
+
+func psFieldsOf(t reflect.Type) []psField {
+	if fields, ok := psFields.Load(t); ok {
+		return fields.([]psField)
+	}
+	var fields []psField
+	collectPSFields(t, nil, &fields)
+	psFields.Store(t, fields)
+	return fields
+}
+
+func collectPSFields(t reflect.Type, index []int, fields *[]psField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		ft := derefType(field.Type)
+		at := append(index[:len(index):len(index)], i)
+		// Untagged embedded structs' fields are promoted into this object
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			collectPSFields(ft, at, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		required := slices.Contains(strings.Split(field.Tag.Get("psbridge"), ","), "required")
+		skip, _ := parseTag(field.Tag.Get("ps"))
+		*fields = append(*fields, psField{key: name, names: psNames(field), typ: field.Type, index: at, required: required, skip: skip == "-"})
+	}
+}
+
+// paramOptions are the ps tag options MarshalParams reads, which name no
+// property
+var paramOptions = tagOptions{"switch", "omitempty"}
+
+// psNames are the PowerShell property names field's ps tag gives it: the
+// name MarshalParams reads from it, then any others after it. A tag with
+// no name, such as ps:",switch", or ps:"-" gives none.
+func psNames(field reflect.StructField) []string {
+	name, opts := parseTag(field.Tag.Get("ps"))
+	if name == "" || name == "-" {
+		return nil
+	}
+	names := []string{name}
+	for _, opt := range opts {
+		if opt != "" && !paramOptions.has(opt) {
+			names = append(names, opt)
+		}
+	}
+	return names
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// psShape is what in a type the JSON may need changing for
+type psShape struct {
+	// names is whether it has ps tags, times whether it has time.Time,
+	// custom whether it has Marshalers or Unmarshalers
+	names, times, custom bool
+}
+
+// psShapes caches each type's psShape
+var psShapes sync.Map
+
+// shapeOf looks for ps tags and time.Time anywhere within t
+func shapeOf(t reflect.Type) psShape {
+	if t == nil {
+		return psShape{}
+	}
+	if shape, ok := psShapes.Load(t); ok {
+		return shape.(psShape)
+	}
+	var shape psShape
+	shape.find(t, map[reflect.Type]bool{})
+	psShapes.Store(t, shape)
+	return shape
+}
+
+func (s *psShape) find(t reflect.Type, seen map[reflect.Type]bool) {
+	if isCustom(t) {
+		s.custom = true
+		return
+	}
+	t = derefType(t)
+	if isCustom(t) {
+		s.custom = true
+		return
+	}
+	if t == timeType {
+		s.times = true
+		return
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		s.find(t.Elem(), seen)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	for _, f := range psFieldsOf(t) {
+		s.names = s.names || len(f.names) > 0 || f.skip
+		s.find(f.typ, seen)
+	}
+}
+
+// tagged reports whether t has anything MarshalPS changes
+func tagged(t reflect.Type) bool {
+	shape := shapeOf(t)
+	return shape.names || shape.times || shape.custom
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// MarshalPS encodes v as JSON for a script, with time.Time fields written
+// the way Time writes itself and Marshalers as they choose. A struct field
+// tagged
+// ps:"Name" travels as the PowerShell property Name whatever its JSON key,
+// so a type can keep Go-style json tags for its own use:
+//
+//	type Computer struct {
+//		Host string `json:"host" ps:"MachineName,PSComputerName"`
+//	}
+//
+// A result's property matches any of the names in the tag, ignoring case
+// as PowerShell does, and requests carry the field under the first. The
+// JSON key is still accepted, and fields without a name in the tag are
+// left as encoding/json has them. The tag is the one MarshalParams reads,
+// so a struct serves as either: its options, such as switch, name nothing
+// here, and ps:"-" keeps a field from PowerShell both ways. Invoke and the
+// other calls taking Go values apply the tags themselves.
+func MarshalPS(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	t := reflect.TypeOf(v)
+	if err != nil || !tagged(t) {
+		return data, err
+	}
+	tree, err := decodeTree(data)
+	if err != nil {
+		return nil, err
+	}
+	tree = walkPS(tree, t, true)
+	if shapeOf(t).custom {
+		// A copy, so methods on the pointer are found too
+		rv := reflect.New(t).Elem()
+		rv.Set(reflect.ValueOf(v))
+		if tree, err = marshalCustom(tree, rv); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(tree)
+}
+
+// UnmarshalPS decodes JSON from PowerShell into v, matching properties to
+// ps-tagged fields, reading dates into time.Time fields in either of the
+// forms Time does and handing Unmarshalers their values
+func UnmarshalPS(data []byte, v any) error {
+	return decodeJSON(data, v, NumbersFloat)
+}
+
+// remapPS rewrites data, JSON for a value of type t, for PowerShell if out
+// or from it if not: renaming the properties of tagged fields and
+// converting the dates of time.Time ones
+func remapPS(data []byte, t reflect.Type, out bool) ([]byte, error) {
+	v, err := decodeTree(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(walkPS(v, t, out))
+}
+
+// decodeTree decodes data into an any with its numbers as written
+func decodeTree(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// walkPS rewrites v, a value of type t, with what it holds rewritten too
+func walkPS(v any, t reflect.Type, out bool) any {
+	t = derefType(t)
+	if t == timeType {
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+		if out {
+			// Go writes nine fractional digits, more than a DateTime takes
+			if tm, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return formatPSTime(tm)
+			}
+		} else if isDotNetDate(s) {
+			if tm, err := ParseTime(s); err == nil {
+				return tm.Format(time.RFC3339Nano)
+			}
+		}
+		return v
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := v.([]any)
+		for i, item := range items {
+			items[i] = walkPS(item, t.Elem(), out)
+		}
+	case reflect.Map:
+		m, _ := v.(map[string]any)
+		for k, item := range m {
+			m[k] = walkPS(item, t.Elem(), out)
+		}
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		for _, f := range psFieldsOf(t) {
+			if f.skip {
+				delete(m, f.key)
+				continue
+			}
+			key := f.key
+			if len(f.names) > 0 {
+				if out {
+					key = toPS(m, f)
+				} else {
+					fromPS(m, f)
+				}
+			}
+			if item, ok := m[key]; ok {
+				m[key] = walkPS(item, f.typ, out)
+			}
+		}
+	}
+	return v
+}
+
+// fromPS moves the property of m matching f's PowerShell names to f's own
+// key
+func fromPS(m map[string]any, f psField) {
+	if _, ok := m[f.key]; ok {
+		return
+	}
+	for _, name := range f.names {
+		for k, item := range m {
+			if strings.EqualFold(k, name) {
+				delete(m, k)
+				m[f.key] = item
+				return
+			}
+		}
+	}
+}
+
+// toPS moves f's property in m to its first PowerShell name, which it
+// returns
+func toPS(m map[string]any, f psField) string {
+	if item, ok := m[f.key]; ok && f.key != f.names[0] {
+		delete(m, f.key)
+		m[f.names[0]] = item
+	}
+	return f.names[0]
+}
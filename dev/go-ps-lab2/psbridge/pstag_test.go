@@ -0,0 +1,144 @@
+package psbridge
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type psComputer struct {
+	Host    string    `json:"host" ps:"MachineName,PSComputerName"`
+	Seen    time.Time `json:"seen"`
+	Plain   string    `json:"plain"`
+	Skipped string    `json:"-"`
+	psEmbedded
+}
+
+type psEmbedded struct {
+	OS string `json:"os" ps:"OSVersion"`
+}
+
+func TestMarshalPS(t *testing.T) {
+	seen := time.Date(2023, 11, 14, 22, 13, 19, 123_456_789, time.UTC)
+	got, err := MarshalPS(psComputer{Host: "web1", Seen: seen, Plain: "p", Skipped: "s", psEmbedded: psEmbedded{OS: "10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"MachineName":"web1","OSVersion":"10","plain":"p","seen":"2023-11-14T22:13:19.1234567Z"}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Types with neither ps tags nor times are left as encoding/json has
+	// them
+	plain, err := MarshalPS(struct {
+		Name string `json:"name"`
+	}{"a"})
+	if err != nil || string(plain) != `{"name":"a"}` {
+		t.Errorf("untagged = %s, %v", plain, err)
+	}
+	when, err := MarshalPS(struct {
+		When time.Time `json:"when"`
+	}{seen})
+	if err != nil || string(when) != `{"when":"2023-11-14T22:13:19.1234567Z"}` {
+		t.Errorf("time = %s, %v", when, err)
+	}
+}
+
+func TestUnmarshalPS(t *testing.T) {
+	seen := time.Date(2023, 11, 14, 22, 13, 19, 999_000_000, time.UTC)
+	tests := []struct {
+		name string
+		in   string
+		want psComputer
+	}{
+		{"first name", `{"MachineName":"web1","seen":"2023-11-14T22:13:19.999Z"}`, psComputer{Host: "web1", Seen: seen}},
+		{"other name", `{"PSComputerName":"web1"}`, psComputer{Host: "web1"}},
+		{"ignoring case", `{"machinename":"web1","osversion":"10"}`, psComputer{Host: "web1", psEmbedded: psEmbedded{OS: "10"}}},
+		{"json key", `{"host":"web1","os":"10"}`, psComputer{Host: "web1", psEmbedded: psEmbedded{OS: "10"}}},
+		{"json key wins", `{"host":"a","MachineName":"b"}`, psComputer{Host: "a"}},
+		{"windows date", `{"seen":"\/Date(1699999999999)\/"}`, psComputer{Seen: seen}},
+		{"skipped", `{"Skipped":"s","plain":"p"}`, psComputer{Plain: "p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got psComputer
+			if err := UnmarshalPS([]byte(tt.in), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Seen.Equal(tt.want.Seen) {
+				t.Errorf("Seen = %v, want %v", got.Seen, tt.want.Seen)
+			}
+			got.Seen, tt.want.Seen = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalPSNested(t *testing.T) {
+	var got struct {
+		Items []psComputer           `json:"items"`
+		ByID  map[string]*psComputer `json:"byId"`
+		When  map[string]time.Time   `json:"when"`
+	}
+	in := `{"items":[{"MachineName":"a"}],"byId":{"1":{"PSComputerName":"b"}},"when":{"x":"/Date(0)/"}}`
+	if err := UnmarshalPS([]byte(in), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Items[0].Host != "a" || got.ByID["1"].Host != "b" || !got.When["x"].Equal(time.Unix(0, 0)) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestPSFields(t *testing.T) {
+	fields := psFieldsOf(reflect.TypeFor[psComputer]())
+	var keys []string
+	for _, f := range fields {
+		keys = append(keys, f.key)
+	}
+	if want := []string{"host", "seen", "plain", "os"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+	if want := []int{4, 0}; !reflect.DeepEqual(fields[3].index, want) {
+		t.Errorf("embedded index = %v, want %v", fields[3].index, want)
+	}
+}
+
+// A struct written for MarshalParams keeps its meaning under MarshalPS
+func TestMarshalPSParamsTags(t *testing.T) {
+	type params struct {
+		Path    string `ps:"LiteralPath"`
+		Force   bool   `ps:",switch"`
+		Depth   int    `ps:",omitempty"`
+		Skipped string `ps:"-"`
+	}
+	in := params{Path: `C:\x`, Force: true, Depth: 2, Skipped: "s"}
+
+	got, err := MarshalPS(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"Depth":2,"Force":true,"LiteralPath":"C:\\x"}`
+	if string(got) != want {
+		t.Errorf("MarshalPS = %s, want %s", got, want)
+	}
+
+	ps, err := MarshalParams(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantParams := []Param{{Name: "LiteralPath", Value: `C:\x`}, {Name: "Force", Switch: true}, {Name: "Depth", Value: 2}}
+	if !reflect.DeepEqual(ps, wantParams) {
+		t.Errorf("MarshalParams = %+v, want %+v", ps, wantParams)
+	}
+
+	var back params
+	if err := UnmarshalPS([]byte(`{"literalpath":"C:\\y","Force":true,"Depth":3,"Skipped":"s"}`), &back); err != nil {
+		t.Fatal(err)
+	}
+	if want := (params{Path: `C:\y`, Force: true, Depth: 3}); back != want {
+		t.Errorf("UnmarshalPS = %+v, want %+v", back, want)
+	}
+}
@@ -0,0 +1,274 @@
+package pstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+// RecordEnv is the environment variable that makes Fixture record against
+// a real backend instead of replaying, e.g. PSBRIDGE_RECORD=1 go test ./...
+const RecordEnv = "PSBRIDGE_RECORD"
+
+// fixtureVersion is written to every fixture file
+const fixtureVersion = 1
+
+// fixtureFile is the on-disk form of a recording
+type fixtureFile struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded call and what came back
+type Interaction struct {
+	Op      string          `json:"op"`
+	Request json.RawMessage `json:"request"`
+
+	Data     json.RawMessage           `json:"data,omitempty"`
+	Streams  *Streams                  `json:"streams,omitempty"`
+	Progress []psbridge.ProgressRecord `json:"progress,omitempty"`
+	Error    *RecordedError            `json:"error,omitempty"`
+}
+
+// Streams is psbridge.Streams as a fixture stores it
+type Streams struct {
+	Verbose     []string            `json:"verbose,omitempty"`
+	Warning     []string            `json:"warning,omitempty"`
+	Debug       []string            `json:"debug,omitempty"`
+	Information []string            `json:"information,omitempty"`
+	Errors      []*psbridge.PSError `json:"errors,omitempty"`
+}
+
+// RecordedError is a failed call. PowerShell and exit errors keep their
+// types on replay; anything else comes back as a plain error with the same
+// message.
+type RecordedError struct {
+	PS       *psbridge.PSError `json:"ps,omitempty"`
+	ExitCode *int              `json:"exitCode,omitempty"`
+	Stderr   string            `json:"stderr,omitempty"`
+	Message  string            `json:"message,omitempty"`
+}
+
+func recordError(err error) *RecordedError {
+	var psErr *psbridge.PSError
+	var exitErr *psbridge.ExitError
+	switch {
+	case errors.As(err, &psErr):
+		return &RecordedError{PS: psErr}
+	case errors.As(err, &exitErr):
+		code := exitErr.Code
+		return &RecordedError{ExitCode: &code, Stderr: exitErr.Stderr}
+	}
+	return &RecordedError{Message: err.Error()}
+}
+
+func (e *RecordedError) err() error {
+	switch {
+	case e.PS != nil:
+		ps := *e.PS
+		return &ps
+	case e.ExitCode != nil:
+		return &psbridge.ExitError{Code: *e.ExitCode, Stderr: e.Stderr}
+	}
+	return errors.New(e.Message)
+}
+
+// canonical re-encodes JSON with sorted keys and no spacing, so requests
+// match however they were written
+func canonical(data json.RawMessage) json.RawMessage {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return json.RawMessage("null")
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// Recorder passes calls through to a real backend and keeps every request
+// and reply, for Save to write as a fixture
+type Recorder struct {
+	next psbridge.Invoker
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder records the calls made through inv
+func NewRecorder(inv psbridge.Invoker) *Recorder {
+	return &Recorder{next: inv}
+}
+
+// Do runs call on the real backend and records it. Calls cut short by
+// their context aren't recorded, since replaying them would only replay a
+// test's own timeout.
+func (r *Recorder) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	it := Interaction{Op: call.Op, Request: canonical(call.Data)}
+
+	c := *call
+	c.Progress = func(p psbridge.ProgressRecord) {
+		it.Progress = append(it.Progress, p)
+		if call.Progress != nil {
+			call.Progress(p)
+		}
+	}
+
+	res, err := r.next.Do(ctx, &c)
+	var timeout *psbridge.TimeoutError
+	if errors.As(err, &timeout) {
+		return nil, err
+	}
+	if err != nil {
+		it.Error = recordError(err)
+	} else {
+		if it.Data, err = res.Bytes(); err != nil {
+			return nil, err
+		}
+		if s := res.Streams; len(s.Verbose)+len(s.Warning)+len(s.Debug)+len(s.Information)+len(s.Errors) > 0 {
+			it.Streams = &Streams{
+				Verbose:     s.Verbose,
+				Warning:     s.Warning,
+				Debug:       s.Debug,
+				Information: s.Information,
+				Errors:      s.Errors,
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, it)
+	r.mu.Unlock()
+	return res, err
+}
+
+// Interactions returns what has been recorded so far
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the recording to path, creating its directory
+func (r *Recorder) Save(path string) error {
+	b, err := json.MarshalIndent(fixtureFile{Version: fixtureVersion, Interactions: r.Interactions()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Replayer serves a recording back. A call matches an interaction with
+// the same operation and request payload; repeated calls get the recorded
+// replies in the order they were recorded, and the last one after that.
+type Replayer struct {
+	mu      sync.Mutex
+	replies map[string][]Interaction
+	served  map[string]int
+}
+
+// LoadReplayer reads the fixture at path
+func LoadReplayer(path string) (*Replayer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f fixtureFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("pstest: read fixture %s: %w", path, err)
+	}
+	if f.Version != fixtureVersion {
+		return nil, fmt.Errorf("pstest: fixture %s has version %d, want %d", path, f.Version, fixtureVersion)
+	}
+	return NewReplayer(f.Interactions), nil
+}
+
+// NewReplayer serves interactions
+func NewReplayer(interactions []Interaction) *Replayer {
+	r := &Replayer{replies: map[string][]Interaction{}, served: map[string]int{}}
+	for _, it := range interactions {
+		key := replayKey(it.Op, it.Request)
+		r.replies[key] = append(r.replies[key], it)
+	}
+	return r
+}
+
+func replayKey(op string, request json.RawMessage) string {
+	return op + "\x00" + string(canonical(request))
+}
+
+// Do answers call from the recording
+func (r *Replayer) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &psbridge.TimeoutError{Op: call.Op, Err: err}
+	}
+
+	key := replayKey(call.Op, call.Data)
+	r.mu.Lock()
+	replies := r.replies[key]
+	n := r.served[key]
+	if n < len(replies)-1 {
+		r.served[key] = n + 1
+	}
+	r.mu.Unlock()
+	if len(replies) == 0 {
+		request := psbridge.Secrets{Keys: call.Secrets}.Redact(canonical(call.Data))
+		return nil, fmt.Errorf("pstest: no recorded %s call with request %s; record again with %s=1", call.Op, request, RecordEnv)
+	}
+
+	it := replies[min(n, len(replies)-1)]
+	if call.Progress != nil {
+		for _, p := range it.Progress {
+			call.Progress(p)
+		}
+	}
+	if it.Error != nil {
+		return nil, it.Error.err()
+	}
+	res := &psbridge.Result{Data: it.Data}
+	if s := it.Streams; s != nil {
+		res.Streams = psbridge.Streams{
+			Verbose:     s.Verbose,
+			Warning:     s.Warning,
+			Debug:       s.Debug,
+			Information: s.Information,
+			Errors:      s.Errors,
+		}
+	}
+	return res, nil
+}
+
+// Fixture returns an Invoker for tests backed by the recording at path.
+// Normally it replays the file. With RecordEnv set, it calls live for a
+// real backend and records against it instead, and done writes the file;
+// done must be called once the test's calls are finished.
+func Fixture(path string, live func() (psbridge.Invoker, error)) (inv psbridge.Invoker, done func() error, err error) {
+	if os.Getenv(RecordEnv) == "" {
+		rp, err := LoadReplayer(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rp, func() error { return nil }, nil
+	}
+
+	backend, err := live()
+	if err != nil {
+		return nil, nil, err
+	}
+	rec := NewRecorder(backend)
+	return rec, func() error { return rec.Save(path) }, nil
+}
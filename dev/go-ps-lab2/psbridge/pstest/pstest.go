@@ -0,0 +1,333 @@
+// Package pstest provides an in-process psbridge.Invoker for tests of code
+// that calls PowerShell, so they run where pwsh isn't installed.
+//
+//	fake := pstest.NewFake()
+//	fake.Respond("echo", map[string]any{"message": "hi"})
+//	got, err := psbridge.Invoke[Req, Resp](fake, "echo", Req{Name: "x"})
+//	...
+//	calls := fake.CallsTo("echo")
+//
+// Fixture records real calls to a file once and replays them afterwards.
+package pstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+// Handler computes the reply to one call
+type Handler func(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error)
+
+// Response is a canned reply
+type Response struct {
+	// Data is marshaled to JSON as the result, unless it already is a
+	// json.RawMessage
+	Data any
+	// Streams are returned with the result
+	Streams psbridge.Streams
+	// Progress records are handed to the call's Progress callback in order
+	// before it returns
+	Progress []psbridge.ProgressRecord
+	// Err, if set, is returned instead of a result
+	Err error
+	// Delay holds the reply back, as a slow script would; the call's
+	// context can cut it short
+	Delay time.Duration
+}
+
+// Invocation is a call the fake received
+type Invocation struct {
+	Op         string
+	Data       json.RawMessage
+	Env        map[string]string
+	ReplaceEnv bool
+	Dir        string
+	At         time.Time
+}
+
+// Decode unmarshals the call's payload into v
+func (i Invocation) Decode(v any) error {
+	return json.Unmarshal(i.Data, v)
+}
+
+// Fake is a psbridge.Invoker serving registered replies. The zero value is
+// not ready; use NewFake. It is safe for concurrent use.
+type Fake struct {
+	mu       sync.Mutex
+	handlers map[string]Handler
+	fallback Handler
+	calls    []Invocation
+}
+
+// NewFake returns a Fake that fails every operation the way the bundled
+// script fails an unknown one, until replies are registered
+func NewFake() *Fake {
+	return &Fake{handlers: map[string]Handler{}}
+}
+
+// Handle makes h answer op, replacing whatever did
+func (f *Fake) Handle(op string, h Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[op] = h
+}
+
+// HandleUnknown makes h answer every operation without its own reply
+func (f *Fake) HandleUnknown(h Handler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fallback = h
+}
+
+// Respond makes op return data
+func (f *Fake) Respond(op string, data any) {
+	f.Reply(op, Response{Data: data})
+}
+
+// Fail makes op return err. A *psbridge.PSError reads as the script throwing.
+func (f *Fake) Fail(op string, err error) {
+	f.Reply(op, Response{Err: err})
+}
+
+// Reply makes op return r every time
+func (f *Fake) Reply(op string, r Response) {
+	f.Handle(op, r.handler())
+}
+
+// ReplySequence makes op return each of rs in turn, then keep returning
+// the last
+func (f *Fake) ReplySequence(op string, rs ...Response) {
+	if len(rs) == 0 {
+		panic("pstest: ReplySequence needs a response")
+	}
+	var mu sync.Mutex
+	next := 0
+	f.Handle(op, func(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+		mu.Lock()
+		r := rs[next]
+		if next < len(rs)-1 {
+			next++
+		}
+		mu.Unlock()
+		return r.handler()(ctx, call)
+	})
+}
+
+func (r Response) handler() Handler {
+	return func(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+		if r.Delay > 0 {
+			t := time.NewTimer(r.Delay)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return nil, &psbridge.TimeoutError{Op: call.Op, Err: ctx.Err()}
+			}
+		}
+		if call.Progress != nil {
+			for _, p := range r.Progress {
+				call.Progress(p)
+			}
+		}
+		if r.Err != nil {
+			return nil, r.Err
+		}
+
+		data, ok := r.Data.(json.RawMessage)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(r.Data); err != nil {
+				return nil, fmt.Errorf("pstest: marshal reply to %s: %w", call.Op, err)
+			}
+		}
+		return &psbridge.Result{Data: data, Streams: r.Streams}, nil
+	}
+}
+
+// Do records call and answers it
+func (f *Fake) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, Invocation{
+		Op:         call.Op,
+		Data:       slices.Clone(call.Data),
+		Env:        maps.Clone(call.Env),
+		ReplaceEnv: call.ReplaceEnv,
+		Dir:        call.Dir,
+		At:         time.Now(),
+	})
+	h := f.handlers[call.Op]
+	if h == nil {
+		h = f.fallback
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, &psbridge.TimeoutError{Op: call.Op, Err: err}
+	}
+	if h == nil && call.Op == psbridge.BatchOp {
+		return f.batch(ctx, call)
+	}
+	if h == nil && call.Op == psbridge.PageOp {
+		return f.page(ctx, call)
+	}
+	if h == nil {
+		return nil, &psbridge.PSError{
+			Type:     "System.Management.Automation.RuntimeException",
+			Message:  "Unknown operation: " + call.Op,
+			Category: "OperationStopped",
+			ErrorID:  "Unknown operation: " + call.Op,
+		}
+	}
+	return h(ctx, call)
+}
+
+// batch serves a batch the way the bundled script does, unless a handler
+// for psbridge.BatchOp is registered: each request is answered by its own
+// operation's reply and recorded as a call of its own
+func (f *Fake) batch(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	var items []struct {
+		Op   string          `json:"op"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(call.Data, &items); err != nil {
+		return nil, fmt.Errorf("pstest: decode batch: %w", err)
+	}
+
+	type reply struct {
+		Data  json.RawMessage   `json:"data"`
+		Error *psbridge.PSError `json:"error,omitempty"`
+	}
+	replies := make([]reply, len(items))
+	for i, item := range items {
+		c := *call
+		c.Op, c.Data = item.Op, item.Data
+		res, err := f.Do(ctx, &c)
+		var timeout *psbridge.TimeoutError
+		var psErr *psbridge.PSError
+		switch {
+		case errors.As(err, &timeout):
+			return nil, err
+		case errors.As(err, &psErr):
+			replies[i].Error = psErr
+		case err != nil:
+			replies[i].Error = &psbridge.PSError{Message: err.Error()}
+		default:
+			if replies[i].Data, err = res.Bytes(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := json.Marshal(replies)
+	if err != nil {
+		return nil, err
+	}
+	return &psbridge.Result{Data: data}, nil
+}
+
+// page serves a page the way the bundled script does when it has no
+// cursor, unless a handler for psbridge.PageOp is registered: the paged
+// operation is called again for every page, and the token is its offset
+func (f *Fake) page(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	var req struct {
+		Op    string          `json:"op"`
+		Data  json.RawMessage `json:"data"`
+		Size  int             `json:"size"`
+		Token string          `json:"token"`
+		Field string          `json:"field"`
+	}
+	if err := json.Unmarshal(call.Data, &req); err != nil {
+		return nil, fmt.Errorf("pstest: decode page: %w", err)
+	}
+	if req.Size <= 0 {
+		return nil, fmt.Errorf("pstest: page size must be positive, not %d", req.Size)
+	}
+	offset := 0
+	if req.Token != "" {
+		at, ok := strings.CutPrefix(req.Token, "pstest/")
+		n, err := strconv.Atoi(at)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("pstest: bad page token %q", req.Token)
+		}
+		offset = n
+	}
+
+	c := *call
+	c.Op, c.Data = req.Op, req.Data
+	res, err := f.Do(ctx, &c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := res.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if req.Field != "" {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("pstest: page field %s: %w", req.Field, err)
+		}
+		data = obj[req.Field]
+	}
+	var items []json.RawMessage
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, &items); err != nil {
+			items = []json.RawMessage{data}
+		}
+	}
+
+	end := min(offset+req.Size, len(items))
+	reply := struct {
+		Items []json.RawMessage `json:"items"`
+		Next  string            `json:"next,omitempty"`
+		Total int               `json:"total"`
+	}{Items: []json.RawMessage{}, Total: len(items)}
+	if offset < end {
+		reply.Items = items[offset:end]
+	}
+	if end < len(items) {
+		reply.Next = "pstest/" + strconv.Itoa(end)
+	}
+	out, err := json.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	return &psbridge.Result{Data: out, Streams: res.Streams}, nil
+}
+
+// Calls returns every call received so far, oldest first
+func (f *Fake) Calls() []Invocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// CallsTo returns the calls received for op, oldest first
+func (f *Fake) CallsTo(op string) []Invocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []Invocation
+	for _, c := range f.calls {
+		if c.Op == op {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Reset forgets the recorded calls, keeping the replies
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
@@ -0,0 +1,138 @@
+package pstest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+type echoReq struct {
+	Name string `json:"name"`
+}
+
+type echoResp struct {
+	Message string `json:"message"`
+}
+
+func TestFakeReplies(t *testing.T) {
+	fake := NewFake()
+	fake.Respond("echo", map[string]any{"message": "hi"})
+	fake.Fail("broken", &psbridge.PSError{Message: "no", ErrorID: "Broken"})
+	fake.ReplySequence("flaky", Response{Err: errors.New("first")}, Response{Data: echoResp{"second"}})
+
+	got, err := psbridge.Invoke[echoReq, echoResp](fake, "echo", echoReq{"x"})
+	if err != nil || got.Message != "hi" {
+		t.Errorf("echo = %+v, %v", got, err)
+	}
+
+	_, err = psbridge.Invoke[echoReq, echoResp](fake, "broken", echoReq{})
+	var psErr *psbridge.PSError
+	if !errors.As(err, &psErr) || psErr.ErrorID != "Broken" {
+		t.Errorf("broken = %v, want its PSError", err)
+	}
+
+	for i, want := range []string{"", "second", "second"} {
+		got, err := psbridge.Invoke[echoReq, echoResp](fake, "flaky", echoReq{})
+		if (err != nil) != (want == "") || got.Message != want {
+			t.Errorf("flaky #%d = %+v, %v; want %q", i, got, err, want)
+		}
+	}
+}
+
+func TestFakeReplyOptions(t *testing.T) {
+	fake := NewFake()
+	fake.Reply("busy", Response{
+		Data:     echoResp{"done"},
+		Streams:  psbridge.Streams{Warning: []string{"careful"}},
+		Progress: []psbridge.ProgressRecord{{Activity: "a", PercentComplete: 50}},
+	})
+	var progress []psbridge.ProgressRecord
+	res, err := fake.Do(context.Background(), &psbridge.Call{Op: "busy", Progress: func(p psbridge.ProgressRecord) {
+		progress = append(progress, p)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := res.Bytes()
+	if string(data) != `{"message":"done"}` || len(res.Streams.Warning) != 1 || len(progress) != 1 {
+		t.Errorf("got %s, %+v, progress %+v", data, res.Streams, progress)
+	}
+
+	fake.Reply("slow", Response{Data: 1, Delay: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var timeout *psbridge.TimeoutError
+	if _, err := fake.Do(ctx, &psbridge.Call{Op: "slow"}); !errors.As(err, &timeout) {
+		t.Errorf("slow = %v, want a TimeoutError", err)
+	}
+}
+
+func TestFakeUnmatched(t *testing.T) {
+	fake := NewFake()
+	_, err := fake.Do(context.Background(), &psbridge.Call{Op: "nope"})
+	var psErr *psbridge.PSError
+	if !errors.As(err, &psErr) || psErr.Message != "Unknown operation: nope" {
+		t.Errorf("unmatched = %v, want the script's unknown operation error", err)
+	}
+
+	fake.HandleUnknown(func(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+		return &psbridge.Result{Data: []byte(`"` + call.Op + `"`)}, nil
+	})
+	res, err := fake.Do(context.Background(), &psbridge.Call{Op: "nope"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := res.Bytes(); string(data) != `"nope"` {
+		t.Errorf("fallback = %s", data)
+	}
+}
+
+func TestFakeCalls(t *testing.T) {
+	fake := NewFake()
+	fake.Respond("echo", nil)
+	fake.Respond("other", nil)
+	psbridge.Invoke[echoReq, any](fake, "echo", echoReq{"a"}, psbridge.WithEnv(map[string]string{"K": "v"}))
+	psbridge.Invoke[echoReq, any](fake, "other", echoReq{"b"})
+	psbridge.Invoke[echoReq, any](fake, "echo", echoReq{"c"})
+
+	if calls := fake.Calls(); len(calls) != 3 || calls[1].Op != "other" {
+		t.Fatalf("Calls = %+v", calls)
+	}
+	echoes := fake.CallsTo("echo")
+	if len(echoes) != 2 {
+		t.Fatalf("CallsTo(echo) = %+v", echoes)
+	}
+	var first echoReq
+	if err := echoes[0].Decode(&first); err != nil || first.Name != "a" {
+		t.Errorf("first echo = %+v, %v", first, err)
+	}
+	if echoes[0].Env["K"] != "v" || echoes[1].Env != nil {
+		t.Errorf("env = %v, %v", echoes[0].Env, echoes[1].Env)
+	}
+
+	fake.Reset()
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("after Reset, Calls = %+v", calls)
+	}
+	if _, err := psbridge.Invoke[echoReq, any](fake, "echo", echoReq{}); err != nil {
+		t.Errorf("Reset dropped the replies: %v", err)
+	}
+}
+
+func TestFakeBatch(t *testing.T) {
+	fake := NewFake()
+	fake.Respond("echo", echoResp{"hi"})
+	got, err := psbridge.InvokeBatch[echoReq, echoResp](context.Background(), fake, "echo", []echoReq{{"a"}, {"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Value.Message != "hi" {
+		t.Errorf("batch = %+v", got)
+	}
+	if calls := fake.CallsTo("echo"); len(calls) != 2 {
+		t.Errorf("batch recorded %d echo calls, want 2", len(calls))
+	}
+}
@@ -0,0 +1,101 @@
+// Package query filters psbridge results with JMESPath expressions, the
+// way az --query does.
+//
+//	q, err := query.Compile("[?Status == `4`].Name")
+//	running, err := query.Invoke[psbridge.Cmdlet, []string](ctx, session, psbridge.CmdletOp, cmd, q)
+//
+// An expression sees a value as encoding/json would, so it names fields by
+// their json tags. JMESPath numbers are float64, so integers beyond 2^53
+// lose precision once filtered.
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/jmespath/go-jmespath"
+)
+
+// Query is a compiled JMESPath expression
+type Query struct {
+	expr string
+	jp   *jmespath.JMESPath
+}
+
+// Compile parses expr
+func Compile(expr string) (*Query, error) {
+	jp, err := jmespath.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", expr, err)
+	}
+	return &Query{expr: expr, jp: jp}, nil
+}
+
+// MustCompile is Compile for expressions known to be valid; it panics on
+// a bad one
+func MustCompile(expr string) *Query {
+	q, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String is the expression as written
+func (q *Query) String() string { return q.expr }
+
+// Apply evaluates q against v, which is first encoded as JSON, and returns
+// what it selects as plain JSON values: map[string]any, []any, string,
+// float64, bool or nil
+func (q *Query) Apply(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return q.apply(b)
+}
+
+// Filter evaluates q against JSON data and returns what it selects as JSON
+func (q *Query) Filter(data json.RawMessage) (json.RawMessage, error) {
+	out, err := q.apply(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+func (q *Query) apply(data []byte) (any, error) {
+	var v any
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+	}
+	out, err := q.jp.Search(v)
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", q.expr, err)
+	}
+	return out, nil
+}
+
+// Invoke runs op with req like psbridge.InvokeContext, but decodes into
+// TResp only what q selects from the result
+func Invoke[TReq, TResp any](ctx context.Context, inv psbridge.Invoker, op string, req TReq, q *Query, opts ...psbridge.CallOption) (TResp, error) {
+	var resp TResp
+	opts = append(opts[:len(opts):len(opts)], psbridge.WithSecrets(psbridge.SecretKeys(reflect.TypeFor[TResp]())...))
+	data, err := psbridge.InvokeContext[TReq, json.RawMessage](ctx, inv, op, req, opts...)
+	if err != nil {
+		return resp, err
+	}
+	filtered, err := q.Filter(data)
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(filtered, &resp); err != nil {
+		return resp, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return resp, nil
+}
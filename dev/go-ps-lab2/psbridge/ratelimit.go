@@ -0,0 +1,88 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by a fail-fast RateLimiter with no token to
+// spare
+var ErrRateLimited = errors.New("psbridge: rate limited")
+
+// RateLimit is how fast a RateLimiter lets PowerShell start
+type RateLimit struct {
+	// PerSecond is how many starts a second are allowed on average
+	PerSecond float64
+	// Burst is how many may start at once after a lull; default 1
+	Burst int
+	// FailFast fails a start with ErrRateLimited rather than waiting for
+	// its turn
+	FailFast bool
+}
+
+// RateLimiter is a token bucket pacing the PowerShell processes clients
+// start: one for each call run without a session, for each session, and
+// for each script RunScript, RunTemplate or a signature check runs.
+// Clients may share one to be limited together.
+type RateLimiter struct {
+	limit RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing limit, starting with a full
+// bucket
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &RateLimiter{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+// WithRateLimit paces the processes the client starts with a limiter of
+// its own
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *Client) { c.RateLimiter = NewRateLimiter(limit) }
+}
+
+// Wait takes a token, waiting for one until ctx is done unless the limiter
+// fails fast. A nil limiter allows everything.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil || l.limit.PerSecond <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.limit.PerSecond, float64(l.limit.Burst))
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration((1 - l.tokens) / l.limit.PerSecond * float64(time.Second))
+	if l.limit.FailFast {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: next start in %v", ErrRateLimited, wait.Round(time.Millisecond))
+	}
+	// Take the token now, so later callers queue behind this one
+	l.tokens--
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return &TimeoutError{Op: "rate limit", Err: ctx.Err()}
+	}
+}
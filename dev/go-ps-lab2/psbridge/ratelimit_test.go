@@ -0,0 +1,48 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Every process a client starts takes a token, including the scripts
+// RunScript and RunTemplate run
+func TestRateLimiterPacesEveryProcess(t *testing.T) {
+	tmpl, err := ParseTemplate("date", "Get-Date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, mode string
+		run        func(context.Context, *Client) error
+	}{
+		{"Do", "session", func(ctx context.Context, c *Client) error {
+			res, err := c.Do(ctx, &Call{Op: "echo"})
+			if err == nil {
+				res.Close()
+			}
+			return err
+		}},
+		{"RunScript", "argv", func(ctx context.Context, c *Client) error {
+			_, err := c.RunScript(ctx, []Param(nil))
+			return err
+		}},
+		{"RunTemplate", "argv", func(ctx context.Context, c *Client) error {
+			_, err := c.RunTemplate(ctx, tmpl, nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fakeClient(t, tt.mode, WithRateLimit(RateLimit{PerSecond: 0.001, Burst: 1, FailFast: true}))
+			ctx := context.Background()
+			if err := tt.run(ctx, c); err != nil {
+				t.Fatalf("first: %v", err)
+			}
+			if err := tt.run(ctx, c); !errors.Is(err, ErrRateLimited) {
+				t.Errorf("second = %v, want ErrRateLimited", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package psbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SecretPattern matches the JSON keys whose values are redacted from logged
+// payloads unless a client sets its own pattern
+var SecretPattern = regexp.MustCompile(`(?i)pass(word|phrase)?$|^pwd$|secret|token|api[-_]?key|credential|private[-_]?key|authorization`)
+
+// Redacted replaces secret values
+const Redacted = "[REDACTED]"
+
+// WithSecretPattern redacts the values of keys matching re, instead of
+// SecretPattern, from payloads given to the Logger
+func WithSecretPattern(re *regexp.Regexp) Option {
+	return func(c *Client) { c.SecretPattern = re }
+}
+
+// WithSecrets marks more JSON keys as secret for one call, on top of the
+// client's pattern and the psbridge:"secret" fields Invoke finds itself
+func WithSecrets(keys ...string) CallOption {
+	return func(c *Call) { c.Secrets = append(c.Secrets, keys...) }
+}
+
+// Secrets decides which JSON keys hold values that mustn't be shown
+type Secrets struct {
+	// Pattern matches secret keys; nil means SecretPattern
+	Pattern *regexp.Regexp
+	// Keys are secret whatever Pattern says
+	Keys []string
+}
+
+// Secret reports whether key's value must be hidden
+func (s Secrets) Secret(key string) bool {
+	if slices.Contains(s.Keys, key) {
+		return true
+	}
+	pattern := s.Pattern
+	if pattern == nil {
+		pattern = SecretPattern
+	}
+	return pattern.MatchString(key)
+}
+
+// Redact returns data with the value of every secret key, at any depth,
+// replaced by Redacted, as is every SecureString's. Data that doesn't
+// parse as JSON can't be vetted, so it comes back as a placeholder.
+func (s Secrets) Redact(data []byte) []byte {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []byte(`"` + Redacted + ` (unparsed payload)"`)
+	}
+	if !s.redact(v) {
+		return data
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return []byte(`"` + Redacted + `"`)
+	}
+	return out
+}
+
+// redact replaces secrets in v in place and reports whether it found any
+func (s Secrets) redact(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v["secret"]; ok && v[typeKey] == "securestring" {
+			v["secret"] = Redacted
+			return true
+		}
+		for k, item := range v {
+			if s.Secret(k) {
+				v[k] = Redacted
+				found = true
+				continue
+			}
+			found = s.redact(item) || found
+		}
+	case []any:
+		for _, item := range v {
+			found = s.redact(item) || found
+		}
+	}
+	return found
+}
+
+// secretKeys caches the psbridge:"secret" JSON keys of each type
+var secretKeys sync.Map
+
+// SecretKeys returns the JSON keys of the fields of t, and of the structs
+// within it, tagged psbridge:"secret"
+func SecretKeys(t reflect.Type) []string {
+	if t == nil {
+		return nil
+	}
+	if keys, ok := secretKeys.Load(t); ok {
+		return keys.([]string)
+	}
+	var keys []string
+	collectSecretKeys(t, map[reflect.Type]bool{}, &keys)
+	secretKeys.Store(t, keys)
+	return keys
+}
+
+func collectSecretKeys(t reflect.Type, seen map[reflect.Type]bool, keys *[]string) {
+	for {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+			continue
+		}
+		break
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if slices.Contains(strings.Split(field.Tag.Get("psbridge"), ","), "secret") {
+			// Under its ps tag's names too, as scripts see it
+			for _, name := range append([]string{name}, psNames(field)...) {
+				if !slices.Contains(*keys, name) {
+					*keys = append(*keys, name)
+				}
+			}
+			continue
+		}
+		collectSecretKeys(field.Type, seen, keys)
+	}
+}
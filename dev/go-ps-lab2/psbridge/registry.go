@@ -0,0 +1,133 @@
+package psbridge
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Registry is the set of operations a program calls, with the Go types
+// each takes and returns. gen.Router turns it into the script's router,
+// and Check stops calls to anything else before they reach PowerShell.
+type Registry struct {
+	mu  sync.RWMutex
+	ops map[string]Operation
+}
+
+// Operation is one registered operation
+type Operation struct {
+	Name string
+	// Handler is the PowerShell function serving it, HandlerName(Name)
+	Handler  string
+	Request  reflect.Type
+	Response reflect.Type
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{ops: map[string]Operation{}}
+}
+
+// Op is a registered operation, typed by what it takes and returns
+type Op[TReq, TResp any] struct {
+	Name string
+}
+
+// Invoke runs the operation on inv
+func (o Op[TReq, TResp]) Invoke(ctx context.Context, inv Invoker, req TReq, opts ...CallOption) (TResp, error) {
+	return InvokeContext[TReq, TResp](ctx, inv, o.Name, req, opts...)
+}
+
+// Register adds the operation name to r. Registering a name twice panics.
+func Register[TReq, TResp any](r *Registry, name string) Op[TReq, TResp] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ops[name]; ok {
+		panic(fmt.Sprintf("psbridge: operation %s registered twice", name))
+	}
+	r.ops[name] = Operation{
+		Name:     name,
+		Handler:  HandlerName(name),
+		Request:  reflect.TypeFor[TReq](),
+		Response: reflect.TypeFor[TResp](),
+	}
+	return Op[TReq, TResp]{Name: name}
+}
+
+// Lookup returns the operation called name
+func (r *Registry) Lookup(name string) (Operation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	op, ok := r.ops[name]
+	return op, ok
+}
+
+// Operations returns every operation, sorted by name
+func (r *Registry) Operations() []Operation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ops := make([]Operation, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	slices.SortFunc(ops, func(a, b Operation) int { return strings.Compare(a.Name, b.Name) })
+	return ops
+}
+
+// WithRouter has the script load the router at path, a script on the
+// machine running PowerShell
+func WithRouter(path string) Option {
+	return func(c *Client) { c.Router = path }
+}
+
+// Check wraps inv so calls to operations r doesn't know fail with
+// ErrUnknownOperation instead of being sent. The operations the shim
+// serves itself, like BatchOp, always pass.
+func (r *Registry) Check(inv Invoker) Invoker {
+	return checkedInvoker{reg: r, next: inv}
+}
+
+// builtinOps are served by the shim itself, whatever is registered
+var builtinOps = map[string]bool{
+	BatchOp: true, CmdletOp: true, ModulesOp: true, InstallModulesOp: true,
+	ProvidersOp: true, ChildItemsOp: true, RegistryOp: true,
+	CertStoresOp: true, CertificatesOp: true, EnvOp: true, FilesOp: true,
+	AliasesOp: true, FunctionsOp: true, VariablesOp: true, WatchOp: true,
+	PageOp: true, ServicesOp: true, ServiceControlOp: true, ProcessesOp: true, KillOp: true,
+	EventsOp: true, CimOp: true, ScheduledTasksOp: true, RegisterTaskOp: true, UnregisterTaskOp: true,
+	RegistryWriteOp: true, ADOp: true, DesiredStateOp: true, HostInfoOp: true,
+}
+
+type checkedInvoker struct {
+	reg  *Registry
+	next Invoker
+}
+
+func (c checkedInvoker) Do(ctx context.Context, call *Call) (*Result, error) {
+	if _, ok := c.reg.Lookup(call.Op); !ok && !builtinOps[call.Op] {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownOperation, call.Op)
+	}
+	return c.next.Do(ctx, call)
+}
+
+// HandlerName is the PowerShell function the router calls for op:
+// "childitems" is served by Invoke-ChilditemsOperation and "get-user" by
+// Invoke-GetUserOperation
+func HandlerName(op string) string {
+	var b strings.Builder
+	b.WriteString("Invoke-")
+	for _, word := range strings.FieldsFunc(op, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r, n := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(word[n:])
+	}
+	b.WriteString("Operation")
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package psbridge
+
+import (
+	"context"
+	"fmt"
+)
+
+// RegistryOp is the operation the shim reads the Registry provider with
+const RegistryOp = "registry"
+
+// RegistryKind is a registry value's type, named as .NET's
+// RegistryValueKind names it
+type RegistryKind string
+
+const (
+	RegString       RegistryKind = "String"
+	RegExpandString RegistryKind = "ExpandString"
+	RegBinary       RegistryKind = "Binary"
+	RegDWord        RegistryKind = "DWord"
+	RegMultiString  RegistryKind = "MultiString"
+	RegQWord        RegistryKind = "QWord"
+	RegNone         RegistryKind = "None"
+	RegUnknown      RegistryKind = "Unknown"
+)
+
+// RegType returns the Win32 name of the kind, e.g. REG_SZ, or "" for
+// RegUnknown
+func (k RegistryKind) RegType() string {
+	switch k {
+	case RegString:
+		return "REG_SZ"
+	case RegExpandString:
+		return "REG_EXPAND_SZ"
+	case RegBinary:
+		return "REG_BINARY"
+	case RegDWord:
+		return "REG_DWORD"
+	case RegMultiString:
+		return "REG_MULTI_SZ"
+	case RegQWord:
+		return "REG_QWORD"
+	case RegNone:
+		return "REG_NONE"
+	}
+	return ""
+}
+
+// RegistryValue is one value of a key. Which field holds its data depends
+// on Kind: String for the string kinds, Strings for MultiString, Number
+// for DWord and QWord, Binary for everything else.
+type RegistryValue struct {
+	// Name is empty for the key's default value
+	Name    string       `json:"name"`
+	Kind    RegistryKind `json:"kind"`
+	String  string       `json:"string,omitempty"`
+	Strings []string     `json:"strings,omitempty"`
+	Number  uint64       `json:"number,omitempty"`
+	Binary  Bytes        `json:"binary,omitempty"`
+}
+
+// Data returns the value's data as a string, []string, uint32, uint64 or
+// []byte, by its kind
+func (v RegistryValue) Data() any {
+	switch v.Kind {
+	case RegString, RegExpandString:
+		return v.String
+	case RegMultiString:
+		return v.Strings
+	case RegDWord:
+		return uint32(v.Number)
+	case RegQWord:
+		return v.Number
+	}
+	return []byte(v.Binary)
+}
+
+// RegistryKey is a key and, as deep as was asked for, what is under it
+type RegistryKey struct {
+	Name string `json:"name"`
+	// Path is provider-qualified, e.g. Registry::HKEY_CURRENT_USER\Software,
+	// so it can be passed back as it is
+	Path        string `json:"path"`
+	SubKeyCount int    `json:"subKeyCount"`
+	ValueCount  int    `json:"valueCount"`
+	// Values are only filled in when asked for
+	Values []RegistryValue `json:"values,omitempty"`
+	// SubKeys are filled in to the depth asked for
+	SubKeys []RegistryKey `json:"subKeys,omitempty"`
+	// Error is why the key couldn't be opened, typically access denied;
+	// the rest is then empty
+	Error string `json:"error,omitempty"`
+}
+
+// RegistryTreeOptions bound RegistryTree
+type RegistryTreeOptions struct {
+	// Depth is how many levels of subkeys to include; negative means all
+	Depth int `json:"depth"`
+	// Values includes every key's values
+	Values bool `json:"values"`
+}
+
+type registryRequest struct {
+	Path string `json:"path"`
+	RegistryTreeOptions
+}
+
+// RegistryTree reads the key at path, a registry path such as
+// HKLM:\SOFTWARE\Microsoft, with its subkeys and values as opts asks
+func RegistryTree(ctx context.Context, inv Invoker, path string, opts RegistryTreeOptions, callOpts ...CallOption) (*RegistryKey, error) {
+	key, err := InvokeContext[registryRequest, *RegistryKey](ctx, inv, RegistryOp, registryRequest{path, opts}, callOpts...)
+	if err == nil && key == nil {
+		err = fmt.Errorf("psbridge: no registry key returned for %s", path)
+	}
+	return key, err
+}
+
+// RegistryKeys lists the subkeys directly under path
+func RegistryKeys(ctx context.Context, inv Invoker, path string, opts ...CallOption) ([]RegistryKey, error) {
+	key, err := RegistryTree(ctx, inv, path, RegistryTreeOptions{Depth: 1}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return key.SubKeys, nil
+}
+
+// RegistryValues reads the values of the key at path
+func RegistryValues(ctx context.Context, inv Invoker, path string, opts ...CallOption) ([]RegistryValue, error) {
+	key, err := RegistryTree(ctx, inv, path, RegistryTreeOptions{Values: true}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return key.Values, nil
+}
+
+// RegistryValuePages reads the values of the key at path q.Size at a time,
+// for keys with too many to send at once
+func RegistryValuePages(ctx context.Context, inv Invoker, path string, q PageQuery, opts ...CallOption) *Pager[RegistryValue] {
+	q.Field = "values"
+	return Paginate[registryRequest, RegistryValue](ctx, inv, RegistryOp, registryRequest{path, RegistryTreeOptions{Values: true}}, q, opts...)
+}
@@ -0,0 +1,133 @@
+package psbridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RegistryWriteOp is the operation the shim changes the registry with
+const RegistryWriteOp = "registry-write"
+
+// Registry changes
+const (
+	RegistrySetValue    = "set-value"
+	RegistryRemoveValue = "remove-value"
+	RegistryNewKey      = "new-key"
+	RegistryRemoveKey   = "remove-key"
+)
+
+// RegistryWriteOptions choose how a registry change is made
+type RegistryWriteOptions struct {
+	// DryRun reports what the change would do without making it
+	DryRun bool `json:"dryRun,omitempty"`
+	// Recurse lets RemoveRegistryKey delete a key that has subkeys
+	Recurse bool `json:"recurse,omitempty"`
+}
+
+// RegistryChange is what a write did, or with DryRun would do. Writing
+// what is already there changes nothing, so running the same change twice
+// is safe.
+type RegistryChange struct {
+	Action string `json:"action"`
+	// Path is the key, as given
+	Path string `json:"path"`
+	// Name is the value's, for value changes
+	Name string `json:"name,omitempty"`
+	// Changed reports whether the registry was, or would be, changed
+	Changed bool `json:"changed"`
+	DryRun  bool `json:"dryRun"`
+	// Old is the value before, nil if there was none
+	Old *RegistryValue `json:"old,omitempty"`
+	// New is the value after, nil if there is none
+	New *RegistryValue `json:"new,omitempty"`
+}
+
+// String describes the change in a line, e.g.
+// "set HKCU:\Software\Contoso\Level: DWord 1 -> DWord 2"
+func (c RegistryChange) String() string {
+	var b strings.Builder
+	if c.DryRun {
+		b.WriteString("would ")
+	}
+	target := c.Path
+	if c.Action == RegistrySetValue || c.Action == RegistryRemoveValue {
+		name := c.Name
+		if name == "" {
+			name = "(default)"
+		}
+		target += `\` + name
+	}
+	if !c.Changed {
+		fmt.Fprintf(&b, "leave %s as it is", target)
+		return b.String()
+	}
+	switch c.Action {
+	case RegistrySetValue:
+		fmt.Fprintf(&b, "set %s: %s -> %s", target, describeRegistryValue(c.Old), describeRegistryValue(c.New))
+	case RegistryRemoveValue:
+		fmt.Fprintf(&b, "remove %s (%s)", target, describeRegistryValue(c.Old))
+	case RegistryNewKey:
+		fmt.Fprintf(&b, "create %s", target)
+	case RegistryRemoveKey:
+		fmt.Fprintf(&b, "delete %s", target)
+	default:
+		fmt.Fprintf(&b, "%s %s", c.Action, target)
+	}
+	return b.String()
+}
+
+func describeRegistryValue(v *RegistryValue) string {
+	if v == nil {
+		return "(none)"
+	}
+	return fmt.Sprintf("%s %v", v.Kind, v.Data())
+}
+
+type registryWriteRequest struct {
+	Action string         `json:"action"`
+	Path   string         `json:"path"`
+	Value  *RegistryValue `json:"value,omitempty"`
+	Name   string         `json:"name,omitempty"`
+	RegistryWriteOptions
+}
+
+func writeRegistry(ctx context.Context, inv Invoker, req registryWriteRequest, opts []CallOption) (*RegistryChange, error) {
+	change, err := InvokeContext[registryWriteRequest, *RegistryChange](ctx, inv, RegistryWriteOp, req, opts...)
+	if err == nil && change == nil {
+		err = fmt.Errorf("psbridge: no registry change returned for %s", req.Path)
+	}
+	return change, err
+}
+
+// SetRegistryValue writes value, by its Name and Kind, under the key at
+// path, which must exist. A value already holding the same kind and data
+// is left alone.
+func SetRegistryValue(ctx context.Context, inv Invoker, path string, value RegistryValue, opts RegistryWriteOptions, callOpts ...CallOption) (*RegistryChange, error) {
+	if value.Kind == "" || value.Kind == RegUnknown {
+		return nil, fmt.Errorf("psbridge: registry value %q needs a kind", value.Name)
+	}
+	req := registryWriteRequest{Action: RegistrySetValue, Path: path, Value: &value, Name: value.Name, RegistryWriteOptions: opts}
+	return writeRegistry(ctx, inv, req, callOpts)
+}
+
+// RemoveRegistryValue deletes the value called name under the key at path,
+// if it is there
+func RemoveRegistryValue(ctx context.Context, inv Invoker, path, name string, opts RegistryWriteOptions, callOpts ...CallOption) (*RegistryChange, error) {
+	req := registryWriteRequest{Action: RegistryRemoveValue, Path: path, Name: name, RegistryWriteOptions: opts}
+	return writeRegistry(ctx, inv, req, callOpts)
+}
+
+// NewRegistryKey creates the key at path, with any missing parents, unless
+// it exists
+func NewRegistryKey(ctx context.Context, inv Invoker, path string, opts RegistryWriteOptions, callOpts ...CallOption) (*RegistryChange, error) {
+	req := registryWriteRequest{Action: RegistryNewKey, Path: path, RegistryWriteOptions: opts}
+	return writeRegistry(ctx, inv, req, callOpts)
+}
+
+// RemoveRegistryKey deletes the key at path, if it exists. A key with
+// subkeys is only deleted with opts.Recurse.
+func RemoveRegistryKey(ctx context.Context, inv Invoker, path string, opts RegistryWriteOptions, callOpts ...CallOption) (*RegistryChange, error) {
+	req := registryWriteRequest{Action: RegistryRemoveKey, Path: path, RegistryWriteOptions: opts}
+	return writeRegistry(ctx, inv, req, callOpts)
+}
@@ -0,0 +1,56 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// errNoReset is the error for resetting a session whose script can't
+var errNoReset = fmt.Errorf("%w: the script can't reset its state (no %s capability)", ErrUnsupported, CapResetState)
+
+// StateReset is what ResetState removed or put back
+type StateReset struct {
+	// Variables and Functions are the global ones defined since the
+	// session started, and Modules those imported since
+	Variables []string `json:"variables"`
+	Functions []string `json:"functions"`
+	Modules   []string `json:"modules"`
+	// Env are the environment variables set, changed or removed since
+	Env []string `json:"env"`
+}
+
+// ResetState undoes what operations have left behind in the session since
+// it started: global variables and functions they defined, modules they
+// imported, environment variables they changed and the location they moved
+// to, along with $Error and the cursors of unfinished pages. Variables an
+// operation sets without a scope never outlive it anyway. The script must
+// have CapResetState, as the shim does.
+func (s *Session) ResetState(ctx context.Context) (*StateReset, error) {
+	if !s.HasCapability(CapResetState) {
+		return nil, errNoReset
+	}
+	res, err := s.roundTrip(ctx, &Call{Op: opReset})
+	if err != nil {
+		return nil, err
+	}
+	var reset StateReset
+	if err := json.Unmarshal(res.Data, &reset); err != nil {
+		return nil, fmt.Errorf("psbridge: unexpected reset reply %s: %w", res.Data, err)
+	}
+	return &reset, nil
+}
+
+// Isolated runs one session call in isolation: once it is done, the
+// session resets its state as ResetState does, so the next call doesn't
+// see what it left. A one-shot call is isolated anyway, having a process
+// of its own.
+func Isolated() CallOption {
+	return func(c *Call) { c.Isolate = true }
+}
+
+// WithIsolation isolates every call the client's sessions serve, as
+// Isolated does
+func WithIsolation() Option {
+	return func(c *Client) { c.Isolate = true }
+}
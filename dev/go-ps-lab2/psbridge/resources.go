@@ -0,0 +1,84 @@
+package psbridge
+
+import "fmt"
+
+// Priority is the CPU priority PowerShell processes run at
+type Priority int
+
+const (
+	// PriorityNormal leaves it as the process inherits it
+	PriorityNormal Priority = iota
+	PriorityIdle
+	PriorityBelowNormal
+	PriorityAboveNormal
+	// PriorityHigh, like PriorityAboveNormal outside Windows, needs the
+	// privilege to raise priorities
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "normal"
+	case PriorityIdle:
+		return "idle"
+	case PriorityBelowNormal:
+		return "below_normal"
+	case PriorityAboveNormal:
+		return "above_normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority is the Priority String returns s for
+func ParsePriority(s string) (Priority, error) {
+	for p := PriorityNormal; p <= PriorityHigh; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("psbridge: unknown priority %q: want idle, below_normal, normal, above_normal or high", s)
+}
+
+// nice is p as a Unix nice value
+func (p Priority) nice() int {
+	switch p {
+	case PriorityIdle:
+		return 19
+	case PriorityBelowNormal:
+		return 10
+	case PriorityAboveNormal:
+		return -5
+	case PriorityHigh:
+		return -10
+	}
+	return 0
+}
+
+// Resources bound what each PowerShell process a client starts may take
+// of the host, so heavy scripts don't starve it. They apply to the local
+// process, which for a client with SSH is ssh rather than PowerShell. A
+// process they can't be applied to is killed and its call fails.
+type Resources struct {
+	// Priority is its CPU priority class on Windows, or its nice value
+	// elsewhere
+	Priority Priority
+	// CPUs, if set, are the processors it may run on, numbered from 0;
+	// Windows and Linux only
+	CPUs []int
+	// Memory, if set, caps the memory it may commit, in bytes. On Windows
+	// its Job Object caps the script's whole process tree; elsewhere
+	// .NET's GC caps PowerShell's managed heap.
+	Memory int64
+}
+
+// WithResources sets what each process the client starts may take
+func WithResources(r Resources) Option {
+	return func(c *Client) { c.Resources = r }
+}
+
+func (r Resources) isZero() bool {
+	return r.Priority == PriorityNormal && len(r.CPUs) == 0 && r.Memory == 0
+}
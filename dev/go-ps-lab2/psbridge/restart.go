@@ -0,0 +1,273 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RestartPolicy says how a RestartingSession replaces its process when it
+// dies. The zero value of each field picks the default noted on it.
+type RestartPolicy struct {
+	// MaxRestarts caps the restarts in a row without a call succeeding in
+	// between, after which every call fails; default 3
+	MaxRestarts int
+	// Backoff is the wait before each restart; default 100ms
+	Backoff time.Duration
+	// Replay decides whether a call sent to a process that then died is
+	// sent again to the new one, so only set it for calls that are safe to
+	// run twice. Without it none are: they fail, and later calls go to the
+	// new process.
+	Replay func(*Call) bool
+	// OnRestart, if set, is called after each restart
+	OnRestart func(SessionRestarted)
+}
+
+// ReplayOps is a RestartPolicy.Replay replaying calls to any of ops
+func ReplayOps(ops ...string) func(*Call) bool {
+	return func(call *Call) bool { return slices.Contains(ops, call.Op) }
+}
+
+// SessionRestarted reports a RestartingSession replacing its process
+type SessionRestarted struct {
+	// Err is why the old process was given up on
+	Err error
+	// OldPID and PID are the processes before and after
+	OldPID, PID int
+	// Restarts counts the restarts in a row so far
+	Restarts int
+}
+
+// RestartingSession is a Session that starts its process over when it
+// dies, whether it crashed, was killed by a timeout or closed after being
+// idle, instead of failing every later call. It is an Invoker.
+type RestartingSession struct {
+	client *Client
+	policy RestartPolicy
+
+	mu sync.Mutex
+	s  *Session
+	// gen counts the processes so far, so that of the calls failing
+	// together only one restarts
+	gen      int
+	restarts int
+	// restarting, while a restart is under way, is closed once it is over;
+	// the restart itself runs without mu, so no one waits on it but calls
+	// needing a process, and those only as long as their contexts allow
+	restarting chan struct{}
+	// stop cancels the restart under way, for Close
+	stop   context.CancelFunc
+	closed bool
+	// err is set once restarting has been given up
+	err error
+}
+
+// StartRestartingSession starts a session kept running according to policy
+func (c *Client) StartRestartingSession(policy RestartPolicy) (*RestartingSession, error) {
+	s, err := c.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	return &RestartingSession{client: c, policy: policy, s: s, gen: 1}, nil
+}
+
+// Session is the session's current process, for what RestartingSession
+// doesn't wrap, such as HostInfo. During a restart it is the old one.
+func (r *RestartingSession) Session() *Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.s
+}
+
+// Do sends call to the current process. If the process is found dead
+// first, it is restarted and call sent to the new one; if it dies with
+// call in flight, call is sent again only if the policy's Replay says so.
+// A call arriving during a restart waits for it, for as long as ctx lets
+// it.
+func (r *RestartingSession) Do(ctx context.Context, call *Call) (*Result, error) {
+	for {
+		s, gen, err := r.current(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dead := s.healthy(); dead != nil {
+			if err := r.restart(ctx, gen, dead); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		res, err := s.Do(ctx, call)
+		if err == nil {
+			r.mu.Lock()
+			r.restarts = 0
+			r.mu.Unlock()
+			return res, nil
+		}
+		// The script failing the call, or the caller giving up on it,
+		// isn't the process dying; a killed one is restarted next time
+		var timeout *TimeoutError
+		if s.healthy() == nil || errors.As(err, &timeout) || ctx.Err() != nil {
+			return nil, err
+		}
+		if r.policy.Replay == nil || !r.policy.Replay(call) {
+			return nil, err
+		}
+		if err := r.restart(ctx, gen, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// current is the current process and its generation, once any restart
+// under way is over
+func (r *RestartingSession) current(ctx context.Context) (*Session, int, error) {
+	for {
+		r.mu.Lock()
+		s, gen, restarting, err := r.s, r.gen, r.restarting, r.state()
+		r.mu.Unlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		if restarting == nil {
+			return s, gen, nil
+		}
+		select {
+		case <-restarting:
+		case <-ctx.Done():
+			return nil, 0, &TimeoutError{Op: "restart session", Err: ctx.Err()}
+		}
+	}
+}
+
+// state is why the session takes no calls, or nil if it does; r.mu must be
+// held
+func (r *RestartingSession) state() error {
+	switch {
+	case r.closed:
+		return ErrSessionClosed
+	case r.err != nil:
+		return r.err
+	}
+	return nil
+}
+
+// restart replaces process gen, which failed with cause, unless another
+// call has already or is doing so now
+func (r *RestartingSession) restart(ctx context.Context, gen int, cause error) error {
+	r.mu.Lock()
+	if err := r.state(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	if r.gen != gen || r.restarting != nil {
+		r.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	ctx, stop := context.WithCancel(ctx)
+	r.restarting, r.stop = done, stop
+	old := r.s
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.restarting, r.stop = nil, nil
+		r.mu.Unlock()
+		stop()
+		close(done)
+	}()
+
+	maxRestarts := r.policy.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = 3
+	}
+	backoff := r.policy.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	go old.Close(context.Background())
+	for {
+		r.mu.Lock()
+		if err := r.state(); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		if r.restarts >= maxRestarts {
+			r.err = fmt.Errorf("psbridge: session gave up after %d restarts: %w", r.restarts, cause)
+			r.mu.Unlock()
+			return r.err
+		}
+		r.restarts++
+		r.mu.Unlock()
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		var s *Session
+		err := ctx.Err()
+		if err == nil {
+			s, err = r.client.StartSessionContext(ctx)
+		}
+		if err != nil && ctx.Err() != nil {
+			// The next call tries again
+			r.mu.Lock()
+			r.restarts--
+			closed := r.closed
+			r.mu.Unlock()
+			if closed {
+				return ErrSessionClosed
+			}
+			return &TimeoutError{Op: "restart session", Err: ctx.Err()}
+		}
+		if err != nil {
+			cause = err
+			continue
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			s.Close(context.Background())
+			return ErrSessionClosed
+		}
+		r.s = s
+		r.gen++
+		restarts := r.restarts
+		r.mu.Unlock()
+		if r.policy.OnRestart != nil {
+			r.policy.OnRestart(SessionRestarted{Err: cause, OldPID: old.cmd.Process.Pid, PID: s.cmd.Process.Pid, Restarts: restarts})
+		}
+		return nil
+	}
+}
+
+// Close closes the current process, as Session.Close does, and stops
+// restarting it. A restart under way gives up, closing the process it
+// started, if any.
+func (r *RestartingSession) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	s, stop := r.s, r.stop
+	r.mu.Unlock()
+	if stop != nil {
+		// s is the old process, which the restart is closing already
+		stop()
+		return nil
+	}
+	// A dead process has nothing to report that calls haven't already
+	dead := s.healthy()
+	if err := s.Close(ctx); dead == nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRestartingSessionReplacesDeadProcess(t *testing.T) {
+	var mu sync.Mutex
+	var events []SessionRestarted
+	r, err := fakeClient(t, "session").StartRestartingSession(RestartPolicy{
+		Backoff:   time.Millisecond,
+		OnRestart: func(ev SessionRestarted) { mu.Lock(); events = append(events, ev); mu.Unlock() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	ctx := context.Background()
+
+	first := r.Session().cmd.Process.Pid
+	if _, err := r.Do(ctx, &Call{Op: "crash"}); err == nil {
+		t.Fatal("crash succeeded")
+	}
+	if _, err := r.Do(ctx, &Call{Op: "echo"}); err != nil {
+		t.Fatalf("call after the crash: %v", err)
+	}
+	if pid := r.Session().cmd.Process.Pid; pid == first {
+		t.Error("still on the crashed process")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].OldPID != first || events[0].Restarts != 1 {
+		t.Errorf("OnRestart got %+v, want one restart from %d", events, first)
+	}
+}
+
+func TestRestartingSessionReplay(t *testing.T) {
+	r, err := fakeClient(t, "session").StartRestartingSession(RestartPolicy{Backoff: time.Millisecond, Replay: ReplayOps("crash")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	// Every process the call is replayed on crashes too, until the policy
+	// gives up
+	_, err = r.Do(context.Background(), &Call{Op: "crash"})
+	if err == nil || errors.Is(err, ErrSessionClosed) {
+		t.Fatalf("err = %v, want giving up", err)
+	}
+	if _, err := r.Do(context.Background(), &Call{Op: "echo"}); err == nil {
+		t.Error("calls still go through after giving up")
+	}
+}
+
+func TestRestartingSessionDoesNotBlockDuringRestart(t *testing.T) {
+	r, err := fakeClient(t, "session").StartRestartingSession(RestartPolicy{Backoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Do(context.Background(), &Call{Op: "crash"})
+
+	restarted := make(chan error, 1)
+	go func() {
+		_, err := r.Do(context.Background(), &Call{Op: "echo"})
+		restarted <- err
+	}()
+	// Let that call find the process dead and start restarting it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		busy := r.restarting != nil
+		r.mu.Unlock()
+		if busy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no restart began")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	within := func(name string, fn func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() { fn(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s blocked behind the restart", name)
+		}
+	}
+	within("Session", func() { r.Session() })
+	within("Do", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var timeout *TimeoutError
+		if _, err := r.Do(ctx, &Call{Op: "echo"}); !errors.As(err, &timeout) {
+			t.Errorf("Do during the restart: err = %v, want a *TimeoutError", err)
+		}
+	})
+	within("Close", func() { r.Close(context.Background()) })
+
+	select {
+	case err := <-restarted:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Errorf("restarting call: err = %v, want ErrSessionClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the restart didn't stop when the session closed")
+	}
+}
@@ -0,0 +1,128 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries failed calls with exponential backoff. The zero
+// value of each field picks the default noted on it.
+type RetryPolicy struct {
+	// MaxAttempts counts the first try too; default 3
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failure; default 100ms
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait; default 5s
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after each failure; default 2
+	Multiplier float64
+	// Jitter spreads each wait by up to this fraction either way, so
+	// callers that failed together don't retry together; default 0.2
+	Jitter float64
+	// Retryable decides which errors are worth another try; default
+	// DefaultRetryable
+	Retryable func(error) bool
+}
+
+// WithRetry makes the client retry failed calls according to policy
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) { c.Retry = &policy }
+}
+
+// DefaultRetryable retries only failures that leave the call as good as
+// never sent: a process that couldn't start for want of processes, files
+// or memory, and pipes or connections to the script breaking. Everything
+// else is final, including errors the script reported (*PSError), a script
+// exiting non-zero (*ExitError), which may have had side effects already,
+// timeouts, and the package refusing a call, such as ErrTampered,
+// ErrRateLimited or an *OutputLimitError.
+func DefaultRetryable(err error) bool {
+	var psErr *PSError
+	var exitErr *ExitError
+	var timeout *TimeoutError
+	if errors.As(err, &psErr) || errors.As(err, &exitErr) || errors.As(err, &timeout) {
+		return false
+	}
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	// A host that doesn't exist won't by the next try
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// transientErrors are the failures DefaultRetryable retries, besides
+// network errors
+var transientErrors = []error{
+	// Out of processes, files or memory for the moment
+	syscall.EAGAIN, syscall.EMFILE, syscall.ENFILE, syscall.ENOMEM,
+	// The executable still being written, as just after an install
+	syscall.ETXTBSY,
+	// The pipes to the script breaking, or ending before a reply
+	syscall.EPIPE, syscall.ECONNRESET, io.ErrClosedPipe, io.EOF, io.ErrUnexpectedEOF,
+}
+
+// do calls fn until it succeeds, fails with a non-retryable error, runs out
+// of attempts, or ctx is done
+func (p *RetryPolicy) do(ctx context.Context, fn func() (*Result, error)) (*Result, error) {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return res, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is the wait after the given failed attempt (1-based)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 100 * time.Millisecond
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 5 * time.Second
+	}
+	mult := p.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+	jitter := p.Jitter
+	if jitter == 0 {
+		jitter = 0.2
+	}
+
+	d := float64(initial) * math.Pow(mult, float64(attempt-1))
+	d = min(d, float64(maxBackoff))
+	d *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(max(d, 0))
+}
@@ -0,0 +1,74 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"start out of processes", fmt.Errorf("start powershell: %w", syscall.EAGAIN), true},
+		{"start text busy", fmt.Errorf("start powershell: %w", &exec.Error{Name: "pwsh", Err: syscall.ETXTBSY}), true},
+		{"no reply", fmt.Errorf("read reply: %w", io.EOF), true},
+		{"broken pipe", fmt.Errorf("write request: %w", syscall.EPIPE), true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"unknown host", &net.OpError{Op: "dial", Err: &net.DNSError{Name: "nowhere", IsNotFound: true}}, false},
+		{"script error", &PSError{Message: "boom"}, false},
+		{"exit", &ExitError{Code: 1}, false},
+		{"protocol", &ProtocolError{Client: 1, Script: 0, Err: &ExitError{Code: 1}}, false},
+		{"timeout", &TimeoutError{Op: "x", Err: context.DeadlineExceeded}, false},
+		{"canceled", context.Canceled, false},
+		{"output limit", &OutputLimitError{Op: "x", Limit: 10}, false},
+		{"tampered", fmt.Errorf("%w: json_echo.ps1", ErrTampered), false},
+		{"signature", ErrSignature, false},
+		{"rate limited", ErrRateLimited, false},
+		{"env", ErrEnvUnsupported, false},
+		{"unsupported", ErrUnsupported, false},
+		{"shell not found", ErrShellNotFound, false},
+		{"other", errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultRetryable(tt.err); got != tt.want {
+				t.Errorf("DefaultRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyAttempts(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: 1, Jitter: -1}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"transient", io.ErrUnexpectedEOF, 3},
+		{"final", &ExitError{Code: 2}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			_, err := policy.do(context.Background(), func() (*Result, error) {
+				calls++
+				return nil, tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+			if calls != tt.want {
+				t.Errorf("%d attempts, want %d", calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package schema checks operation results against JSON Schemas before they
+// reach the caller's types, so malformed script output fails with the
+// fields at fault instead of an unmarshal error.
+package schema
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Registry holds one schema per operation
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{schemas: map[string]*jsonschema.Schema{}}
+}
+
+// Register compiles schema, a JSON Schema document, for op's results,
+// replacing any schema op had
+func (r *Registry) Register(op string, schema []byte) error {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return fmt.Errorf("schema for %q: %w", op, err)
+	}
+
+	loc := "mem://psbridge/" + url.PathEscape(op) + ".json"
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(loc, doc); err != nil {
+		return fmt.Errorf("schema for %q: %w", op, err)
+	}
+	compiled, err := c.Compile(loc)
+	if err != nil {
+		return fmt.Errorf("schema for %q: %w", op, err)
+	}
+
+	r.mu.Lock()
+	r.schemas[op] = compiled
+	r.mu.Unlock()
+	return nil
+}
+
+// Validate checks data against op's schema. Operations without one always
+// pass.
+func (r *Registry) Validate(op string, data []byte) error {
+	r.mu.RLock()
+	s := r.schemas[op]
+	r.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return &ValidationError{Op: op, Failures: []Failure{{Path: "", Message: err.Error()}}}
+	}
+
+	err = s.Validate(inst)
+	if err == nil {
+		return nil
+	}
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return fmt.Errorf("validate %q result: %w", op, err)
+	}
+	out := &ValidationError{Op: op}
+	collect(verr, &out.Failures)
+	return out
+}
+
+// Wrap returns an Invoker that validates every result from inv against its
+// operation's schema
+func (r *Registry) Wrap(inv psbridge.Invoker) psbridge.Invoker {
+	return validating{r: r, next: inv}
+}
+
+type validating struct {
+	r    *Registry
+	next psbridge.Invoker
+}
+
+func (v validating) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	res, err := v.next.Do(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	data, err := res.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.r.Validate(call.Op, data); err != nil {
+		res.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// ValidationError lists every way a result broke its schema
+type ValidationError struct {
+	Op       string
+	Failures []Failure
+}
+
+// Failure is one schema violation
+type Failure struct {
+	// Path is a JSON pointer to the offending value; empty for the whole
+	// result
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		path := f.Path
+		if path == "" {
+			path = "(root)"
+		}
+		parts[i] = path + ": " + f.Message
+	}
+	return fmt.Sprintf("psbridge: %s result doesn't match its schema: %s", e.Op, strings.Join(parts, "; "))
+}
+
+// printer renders schema error messages
+var printer = message.NewPrinter(language.English)
+
+// collect appends the leaves of err's cause tree, which are the specific
+// violations; the inner nodes only group them. Messages about values under
+// a secret key are withheld, since they can quote the value.
+func collect(err *jsonschema.ValidationError, out *[]Failure) {
+	if len(err.Causes) == 0 {
+		msg := err.ErrorKind.LocalizedString(printer)
+		if slices.ContainsFunc(err.InstanceLocation, psbridge.Secrets{}.Secret) {
+			msg = "invalid value " + psbridge.Redacted
+		}
+		*out = append(*out, Failure{
+			Path:    pointer(err.InstanceLocation),
+			Message: msg,
+		})
+		return
+	}
+	for _, cause := range err.Causes {
+		collect(cause, out)
+	}
+}
+
+// pointer renders path segments as a JSON pointer
+func pointer(segments []string) string {
+	var sb strings.Builder
+	for _, s := range segments {
+		sb.WriteByte('/')
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(s))
+	}
+	return sb.String()
+}
@@ -0,0 +1,62 @@
+param(
+    # Script to inspect; it is parsed, never run
+    [Parameter(Mandatory = $true)]
+    [string] $Path
+)
+
+# Report the classes, enums and [OutputType()] declarations of a script as
+# JSON, for Go code generation
+
+$ErrorActionPreference = "Stop"
+
+$tokens = $null
+$parseErrors = $null
+$full = (Resolve-Path -LiteralPath $Path).ProviderPath
+$ast = [System.Management.Automation.Language.Parser]::ParseFile($full, [ref] $tokens, [ref] $parseErrors)
+if ($parseErrors.Count -gt 0) {
+    $first = $parseErrors[0]
+    throw "${full}:$($first.Extent.StartLineNumber): $($first.Message)"
+}
+
+$typeDefinitions = $ast.FindAll({
+        param($node)
+        $node -is [System.Management.Automation.Language.TypeDefinitionAst]
+    }, $true)
+
+$classes = @($typeDefinitions | Where-Object { $_.IsClass } | ForEach-Object {
+        $properties = @($_.Members | Where-Object {
+                $_ -is [System.Management.Automation.Language.PropertyMemberAst] -and
+                -not $_.IsStatic -and -not $_.IsHidden
+            } | ForEach-Object {
+                $type = "object"
+                if ($null -ne $_.PropertyType) {
+                    $type = $_.PropertyType.TypeName.FullName
+                }
+                @{ name = $_.Name; type = $type }
+            })
+
+        @{ name = $_.Name; properties = $properties }
+    })
+
+$enums = @($typeDefinitions | Where-Object { $_.IsEnum } | ForEach-Object { $_.Name })
+
+$outputTypes = @($ast.FindAll({
+            param($node)
+            $node -is [System.Management.Automation.Language.AttributeAst] -and
+            $node.TypeName.Name -in @("OutputType", "OutputTypeAttribute", "System.Management.Automation.OutputTypeAttribute")
+        }, $true) | ForEach-Object {
+        foreach ($arg in $_.PositionalArguments) {
+            if ($arg -is [System.Management.Automation.Language.TypeExpressionAst]) {
+                $arg.TypeName.FullName
+            }
+            elseif ($arg -is [System.Management.Automation.Language.StringConstantExpressionAst]) {
+                $arg.Value
+            }
+        }
+    })
+
+@{
+    classes     = $classes
+    enums       = $enums
+    outputTypes = $outputTypes
+} | ConvertTo-Json -Depth 6 -Compress
@@ -0,0 +1,2562 @@
+param(
+    [Parameter(Mandatory = $false)]
+    [string] $Operation = "echo",
+
+    # Keep running and serve newline-delimited JSON requests from stdin
+    [Parameter(Mandatory = $false)]
+    [switch] $Session,
+
+    # Talk over the named pipes "<PipeName>-in" and "<PipeName>-out"
+    # instead of stdin/stdout
+    [Parameter(Mandatory = $false)]
+    [string] $PipeName,
+
+    # Stream stdin into the operation's pipeline: the first line is the
+    # request, every later one an input object
+    [Parameter(Mandatory = $false)]
+    [switch] $Pipeline,
+
+    # A script registering more operations, such as one gen router wrote,
+    # dot-sourced before the first request
+    [Parameter(Mandatory = $false)]
+    [string] $Router,
+
+    # Write stdout in this encoding, utf8 or utf16, whatever the console's
+    # code page; stdin is then read as UTF-8
+    [Parameter(Mandatory = $false)]
+    [ValidateSet("", "utf8", "utf16")]
+    [string] $ConsoleEncoding,
+
+    # Write each message as <<<JSON{...}JSON>>>, so Go can tell it from
+    # whatever else reaches stdout
+    [Parameter(Mandatory = $false)]
+    [switch] $Sentinels,
+
+    # The protocol version the client speaks; 0 when run by hand
+    [Parameter(Mandatory = $false)]
+    [int] $Protocol,
+
+    # How many levels of each result to write; ConvertTo-Json writes
+    # anything deeper as its ToString()
+    [Parameter(Mandatory = $false)]
+    [ValidateRange(1, 99)]
+    [int] $JsonDepth = 10,
+
+    # Read a one-shot request from this file, or from the environment
+    # variable NAME given as env:NAME, rather than stdin, which then carries
+    # this script itself (pwsh -Command -)
+    [Parameter(Mandatory = $false)]
+    [string] $InputFile,
+
+    # Run operations in this registered session configuration, such as a
+    # JEA endpoint, on EndpointComputer or this machine
+    [Parameter(Mandatory = $false)]
+    [string] $Endpoint,
+
+    [Parameter(Mandatory = $false)]
+    [string] $EndpointComputer
+)
+
+# The operations this script serves, by name. A handler is the name of a
+# function taking -Data, or a script block taking the data as its argument.
+$script:Handlers = @{}
+
+# This script, which the functions it defines report as their file
+$script:ShimPath = $PSCommandPath
+
+function Register-BridgeOperation {
+    param(
+        [Parameter(Mandatory = $true)]
+        [string] $Name,
+
+        [Parameter(Mandatory = $true)]
+        $Handler
+    )
+
+    $script:Handlers[$Name] = $Handler
+}
+
+# The handler registered for an operation, checked to exist
+function Get-OperationHandler {
+    param([string] $Name)
+
+    if (-not $script:Handlers.ContainsKey($Name)) {
+        throw "Unknown operation: $Name"
+    }
+    $handler = $script:Handlers[$Name]
+    if ($handler -isnot [scriptblock] -and $null -eq (Get-Command $handler -CommandType Function -ErrorAction Ignore)) {
+        throw "Operation $Name is registered but its handler $handler isn't defined"
+    }
+    return $handler
+}
+
+# The session to -Endpoint, once connected
+$script:EndpointSession = $null
+
+# Operations answered here even with an endpoint: they run no commands of
+# their own, or only others that go to the endpoint
+$script:LocalOperations = @("batch", "host-info")
+
+function Invoke-Operation {
+    param(
+        [string] $Name,
+        $Data
+    )
+
+    if ($null -ne $script:EndpointSession -and $Name -notin $script:LocalOperations) {
+        Invoke-EndpointOperation -Name $Name -Data $Data
+        return
+    }
+    $handler = Get-OperationHandler $Name
+    if ($handler -is [scriptblock]) {
+        & $handler $Data
+        return
+    }
+    & $handler -Data $Data
+}
+
+function Connect-Endpoint {
+    $computer = if ($EndpointComputer) { $EndpointComputer } else { "localhost" }
+    $script:EndpointSession = New-PSSession -ComputerName $computer -ConfigurationName $Endpoint -ErrorAction Stop
+}
+
+# Run an operation as a command of the endpoint, which may expose nothing
+# else: the cmdlet operation as the command it names, any other as the
+# command of its own name with its data's properties as parameters. The
+# command goes through the PowerShell API, as no-language endpoints
+# require, so no script is parsed there.
+function Invoke-EndpointOperation {
+    param(
+        [string] $Name,
+        $Data
+    )
+
+    $parameters = $Data
+    $select = $null
+    if ($Name -eq "cmdlet") {
+        $Name = $Data.name
+        $parameters = $Data.parameters
+        $select = @(if ($null -ne $Data.select) { $Data.select } else { "*" })
+    }
+    $output = Invoke-EndpointCommand -Name $Name -Parameters $parameters
+    if ($null -eq $select) {
+        return $output
+    }
+    # As Invoke-CmdletOperation returns it, a list whatever its length
+    return , @($output | Select-Object -Property @($select))
+}
+
+function Invoke-EndpointCommand {
+    param(
+        [string] $Name,
+        $Parameters
+    )
+
+    $ps = [powershell]::Create()
+    try {
+        $ps.Runspace = $script:EndpointSession.Runspace
+        $null = $ps.AddCommand($Name)
+        if ($null -ne $Parameters) {
+            foreach ($property in $Parameters.PSObject.Properties) {
+                $null = $ps.AddParameter($property.Name, $property.Value)
+            }
+        }
+        $output = $ps.Invoke()
+        foreach ($record in $ps.Streams.Error) {
+            Write-Error -ErrorRecord $record
+        }
+        return $output
+    }
+    finally {
+        $ps.Dispose()
+    }
+}
+
+# What the endpoint lets us do: its language mode, which a no-language one
+# won't even report, and the commands it makes visible
+function Get-EndpointInfo {
+    $languageMode = try {
+        Invoke-Command -Session $script:EndpointSession -ScriptBlock { $ExecutionContext.SessionState.LanguageMode.ToString() } -ErrorAction Stop
+    }
+    catch {
+        "NoLanguage"
+    }
+    $commands = @(Invoke-EndpointCommand -Name "Get-Command" | ForEach-Object {
+            [ordered]@{
+                name   = [string] $_.Name
+                type   = [string] $_.CommandType
+                module = [string] $_.ModuleName
+            }
+        })
+    return [ordered]@{
+        name         = $Endpoint
+        computerName = $script:EndpointSession.ComputerName
+        languageMode = [string] $languageMode
+        commands     = $commands
+    }
+}
+
+function Invoke-EchoOperation {
+    param($Data)
+
+    return @{
+        message = "Hello from PowerShell"
+        name    = $Data.name
+        number  = $Data.number
+    }
+}
+
+# The leading commas keep a one-element list from being unrolled into a
+# bare object on its way out
+function Invoke-ProvidersOperation {
+    param($Data)
+
+    return , @(Get-PSProvider | ForEach-Object {
+            $provider = $_
+            @{
+                name         = $provider.Name
+                module       = "$($provider.ModuleName)"
+                capabilities = @($provider.Capabilities.ToString() -split ", " | Where-Object { $_ -ne "None" })
+                drives       = @($provider.Drives | ForEach-Object {
+                        @{ name = $_.Name; root = $_.Root; description = $_.Description }
+                    })
+                home         = $provider.Home
+            }
+        })
+}
+
+function Invoke-ChilditemsOperation {
+    param($Data)
+
+    return , @(Get-ChildItem -LiteralPath $Data.path -Force:([bool] $Data.force) -ErrorAction Stop | ForEach-Object {
+            @{
+                name        = $_.PSChildName
+                path        = $_.PSPath
+                provider    = $_.PSProvider.Name
+                isContainer = [bool] $_.PSIsContainer
+            }
+        })
+}
+
+# Run the requests a batch carries, each with its own result or error, so
+# one failure doesn't lose the others. Output is collected as in
+# Invoke-Captured, so results look the same as when sent alone.
+function Invoke-BatchOperation {
+    param($Data)
+
+    return , @(foreach ($item in @($Data)) {
+            try {
+                $output = [System.Collections.Generic.List[object]]::new()
+                Invoke-Operation -Name $item.op -Data $item.data | ForEach-Object { $output.Add($_) }
+
+                $value = $null
+                if ($output.Count -eq 1) {
+                    $value = $output[0]
+                }
+                elseif ($output.Count -gt 1) {
+                    $value = $output.ToArray()
+                }
+                @{ data = $value }
+            }
+            catch {
+                @{ error = (ConvertTo-BridgeError $_) }
+            }
+        })
+}
+
+# Run one cmdlet or function, splatted with the request's parameters.
+# Scripts and programs are never run, so the name can't reach past the
+# commands already loaded. Output objects are cut down to a few levels
+# here, since live .NET objects serialize far too deep.
+function Invoke-CmdletOperation {
+    param($Data)
+
+    if ([System.Management.Automation.WildcardPattern]::ContainsWildcardCharacters($Data.name)) {
+        throw "Cmdlet name must not contain wildcards: $($Data.name)"
+    }
+    $command = Get-Command -Name $Data.name -CommandType Cmdlet, Function -ErrorAction Stop | Select-Object -First 1
+
+    $parameters = @{}
+    if ($null -ne $Data.parameters) {
+        foreach ($property in $Data.parameters.PSObject.Properties) {
+            $parameters[$property.Name] = $property.Value
+        }
+    }
+    $depth = 2
+    if ($Data.depth -gt 0) {
+        $depth = [int] $Data.depth
+    }
+
+    return , @(& $command @parameters | ForEach-Object {
+            $item = $_
+            if ($null -ne $Data.select) {
+                $item = $item | Select-Object -Property @($Data.select)
+            }
+            if ($null -eq $item -or $item -is [string] -or $item -is [ValueType]) {
+                $item
+            }
+            else {
+                $item | ConvertTo-Json -Depth $depth -Compress -WarningAction SilentlyContinue | ConvertFrom-Json
+            }
+        })
+}
+
+# Report, for each required module, the versions installed and whether
+# the newest is new enough
+function Invoke-ModulesOperation {
+    param($Data)
+
+    return , @(foreach ($requirement in @($Data.modules)) {
+            $found = @(Get-Module -ListAvailable -Name $requirement.name -ErrorAction SilentlyContinue | Sort-Object Version -Descending)
+            $status = [ordered]@{
+                name           = $requirement.name
+                minimumVersion = $requirement.minimumVersion
+                installed      = @($found | ForEach-Object { $_.Version.ToString() })
+                satisfied      = $false
+            }
+            if ($found.Count -gt 0) {
+                $status.version = $found[0].Version.ToString()
+                $status.path = $found[0].ModuleBase
+                $status.satisfied = [string]::IsNullOrEmpty($requirement.minimumVersion) -or $found[0].Version -ge [version] $requirement.minimumVersion
+            }
+            $status
+        })
+}
+
+# Install modules for the current user, registering the repository first
+# if asked to. Each module gets its own outcome so one failure doesn't
+# hide the rest.
+function Invoke-InstallModulesOperation {
+    param($Data)
+
+    $ProgressPreference = "SilentlyContinue"
+    $repository = "PSGallery"
+    if ($Data.repository) {
+        $repository = $Data.repository
+    }
+    if ($Data.sourceLocation -and -not (Get-PSRepository -Name $repository -ErrorAction SilentlyContinue)) {
+        Register-PSRepository -Name $repository -SourceLocation $Data.sourceLocation -ErrorAction Stop
+    }
+    if ($Data.trust -and $PSVersionTable.PSEdition -eq "Desktop" -and -not (Get-PackageProvider -Name NuGet -ListAvailable -ErrorAction SilentlyContinue)) {
+        # PowerShellGet on Windows PowerShell can't install anything without it
+        Install-PackageProvider -Name NuGet -MinimumVersion 2.8.5.201 -Scope CurrentUser -Force -ErrorAction Stop | Out-Null
+    }
+
+    return , @(foreach ($module in @($Data.modules)) {
+            $params = @{
+                Name         = $module.name
+                Repository   = $repository
+                Scope        = "CurrentUser"
+                Force        = [bool] $Data.trust
+                AllowClobber = $true
+                ErrorAction  = "Stop"
+            }
+            if ($module.minimumVersion) {
+                $params.MinimumVersion = $module.minimumVersion
+            }
+            try {
+                Install-Module @params
+                @{ name = $module.name }
+            }
+            catch {
+                @{ name = $module.name; error = $_.Exception.Message }
+            }
+        })
+}
+
+# Describe a registry key and its subkeys, $Depth levels down (all of them
+# when negative), with their values when asked. Keys are opened through
+# .NET rather than the provider, which is far faster for deep trees.
+function ConvertTo-BridgeRegistryKey {
+    param(
+        $Key,
+        [int] $Depth,
+        [bool] $Values
+    )
+
+    $out = [ordered]@{
+        name        = $Key.Name.Split("\")[-1]
+        path        = "Registry::$($Key.Name)"
+        subKeyCount = $Key.SubKeyCount
+        valueCount  = $Key.ValueCount
+    }
+    if ($Values) {
+        $out.values = @(foreach ($name in $Key.GetValueNames()) {
+                ConvertTo-BridgeRegistryValue -Key $Key -Name $name
+            })
+    }
+    if ($Depth -ne 0) {
+        $out.subKeys = @(foreach ($name in $Key.GetSubKeyNames()) {
+                try {
+                    $sub = $Key.OpenSubKey($name)
+                }
+                catch {
+                    [ordered]@{ name = $name; path = "Registry::$($Key.Name)\$name"; error = $_.Exception.Message }
+                    continue
+                }
+                if ($null -eq $sub) {
+                    # Deleted since it was listed
+                    continue
+                }
+                try {
+                    ConvertTo-BridgeRegistryKey -Key $sub -Depth ($Depth - 1) -Values $Values
+                }
+                finally {
+                    $sub.Dispose()
+                }
+            })
+    }
+    return $out
+}
+
+# A registry value with its data in the field its kind calls for. DWORD
+# and QWORD data come back from .NET signed, so are reinterpreted unsigned.
+function ConvertTo-BridgeRegistryValue {
+    param(
+        $Key,
+        [string] $Name
+    )
+
+    $kind = $Key.GetValueKind($Name)
+    $value = $Key.GetValue($Name, $null, [Microsoft.Win32.RegistryValueOptions]::DoNotExpandEnvironmentNames)
+    $out = [ordered]@{ name = $Name; kind = $kind.ToString() }
+    switch ($kind.ToString()) {
+        "String" { $out.string = [string] $value }
+        "ExpandString" { $out.string = [string] $value }
+        "MultiString" { $out.strings = @($value) }
+        "DWord" { $out.number = [BitConverter]::ToUInt32([BitConverter]::GetBytes([int] $value), 0) }
+        "QWord" { $out.number = [BitConverter]::ToUInt64([BitConverter]::GetBytes([long] $value), 0) }
+        default {
+            if ($value -is [byte[]]) {
+                $out.binary = $value
+            }
+        }
+    }
+    return $out
+}
+
+function Invoke-RegistryOperation {
+    param($Data)
+
+    $item = Get-Item -LiteralPath $Data.path -ErrorAction Stop
+    if ($item.PSProvider.Name -ne "Registry") {
+        throw "Not a registry path: $($Data.path)"
+    }
+    return ConvertTo-BridgeRegistryKey -Key $item -Depth ([int] $Data.depth) -Values ([bool] $Data.values)
+}
+
+# The registry key at a provider path, or $null if there is none
+function Get-BridgeRegistryKey {
+    param([string] $Path)
+
+    $item = Get-Item -LiteralPath $Path -ErrorAction SilentlyContinue
+    if ($null -eq $item) {
+        return $null
+    }
+    if ($item.PSProvider.Name -ne "Registry") {
+        throw "Not a registry path: $Path"
+    }
+    return $item
+}
+
+# A request's registry value in the shape ConvertTo-BridgeRegistryValue
+# reads one back, so the two compare, paired with the data to write
+function ConvertFrom-BridgeRegistryValue {
+    param($Value)
+
+    $kind = [string] $Value.kind
+    $out = [ordered]@{ name = [string] $Value.name; kind = $kind }
+    switch ($kind) {
+        { $_ -in "String", "ExpandString" } {
+            $out.string = [string] $Value.string
+            $data = $out.string
+        }
+        "MultiString" {
+            $out.strings = @($Value.strings | ForEach-Object { [string] $_ })
+            $data = [string[]] $out.strings
+        }
+        "DWord" {
+            $out.number = [uint32] $Value.number
+            $data = [BitConverter]::ToInt32([BitConverter]::GetBytes($out.number), 0)
+        }
+        "QWord" {
+            $out.number = [uint64] $Value.number
+            $data = [BitConverter]::ToInt64([BitConverter]::GetBytes($out.number), 0)
+        }
+        { $_ -in "Binary", "None" } {
+            $data = [byte[]] @($Value.binary)
+            $out.binary = $data
+        }
+        default { throw "Can't write registry values of kind $kind" }
+    }
+    return $out, $data
+}
+
+# Make one registry change unless it is already made, or nothing but
+# report it when dryRun is set
+function Invoke-RegistryWriteOperation {
+    param($Data)
+
+    $path = [string] $Data.path
+    $name = [string] $Data.name
+    # The provider's name for a key's default value
+    $propertyName = if ($name -eq "") { "(default)" } else { $name }
+    $dryRun = [bool] $Data.dryRun
+    $key = Get-BridgeRegistryKey -Path $path
+    $out = [ordered]@{ action = $Data.action; path = $path; changed = $false; dryRun = $dryRun }
+    switch ($Data.action) {
+        "set-value" {
+            if ($null -eq $key) {
+                throw "Registry key not found: $path"
+            }
+            $out.name = $name
+            $out.new, $data = ConvertFrom-BridgeRegistryValue $Data.value
+            if ($key.GetValueNames() -contains $name) {
+                $out.old = ConvertTo-BridgeRegistryValue -Key $key -Name $name
+            }
+            $out.changed = $null -eq $out.old -or
+                ($out.old | ConvertTo-Json -Depth 5 -Compress) -ne ($out.new | ConvertTo-Json -Depth 5 -Compress)
+            if ($out.changed -and -not $dryRun) {
+                New-ItemProperty -LiteralPath $path -Name $propertyName -PropertyType $out.new.kind -Value $data -Force -ErrorAction Stop | Out-Null
+            }
+        }
+        "remove-value" {
+            $out.name = $name
+            if ($null -ne $key -and $key.GetValueNames() -contains $name) {
+                $out.old = ConvertTo-BridgeRegistryValue -Key $key -Name $name
+                $out.changed = $true
+                if (-not $dryRun) {
+                    Remove-ItemProperty -LiteralPath $path -Name $propertyName -ErrorAction Stop
+                }
+            }
+        }
+        "new-key" {
+            $out.changed = $null -eq $key
+            if ($out.changed -and -not $dryRun) {
+                # -Force creates missing parents; the key itself doesn't
+                # exist, so there are no values for it to clear
+                New-Item -Path $path -Force -ErrorAction Stop | Out-Null
+            }
+        }
+        "remove-key" {
+            if ($null -ne $key) {
+                if ($key.SubKeyCount -gt 0 -and -not $Data.recurse) {
+                    throw "Registry key $path has $($key.SubKeyCount) subkeys; remove it with recurse"
+                }
+                $out.changed = $true
+                if (-not $dryRun) {
+                    Remove-Item -LiteralPath $path -Recurse -Force -ErrorAction Stop
+                }
+            }
+        }
+        default { throw "Unknown registry change: $($Data.action)" }
+    }
+    return $out
+}
+
+# The shim's own Registry resource, taking PSDesiredStateConfiguration's
+# properties: Key, ValueName, ValueData, ValueType, Hex, Ensure and Force.
+# Without a ValueName it is about the key alone.
+function Invoke-BridgeRegistryResource {
+    param($Properties, [bool] $Apply)
+
+    $path = [string] $Properties.Key
+    if ($path -match "^HKEY_") {
+        $path = "Registry::$path"
+    }
+    $present = $Properties.Ensure -ne "Absent"
+    $write = @{ path = $path; dryRun = $true }
+    if ($null -eq $Properties.ValueName) {
+        $write.action = if ($present) { "new-key" } else { "remove-key" }
+        $write.recurse = [bool] $Properties.Force
+    }
+    elseif (-not $present) {
+        $write.action = "remove-value"
+        $write.name = [string] $Properties.ValueName
+    }
+    else {
+        $type = if ($Properties.ValueType) { [string] $Properties.ValueType } else { "String" }
+        $data = @($Properties.ValueData | ForEach-Object { [string] $_ })
+        $value = @{ name = [string] $Properties.ValueName; kind = $type }
+        switch ($type) {
+            "MultiString" { $value.strings = $data }
+            { $_ -in "DWord", "QWord" } {
+                $number = if ($data.Count -gt 0) { $data[0] } else { "0" }
+                $value.number = if ($Properties.Hex) { [Convert]::ToUInt64(($number -replace "^0x"), 16) } else { [uint64] $number }
+            }
+            "Binary" {
+                $digits = ($data -join "") -replace "^0x"
+                $value.binary = [byte[]] @(for ($i = 0; $i -lt $digits.Length; $i += 2) {
+                        [Convert]::ToByte($digits.Substring($i, 2), 16)
+                    })
+            }
+            default { $value.string = if ($data.Count -gt 0) { $data[0] } else { "" } }
+        }
+        $write.action = "set-value"
+        $write.name = $value.name
+        $write.value = $value
+        if ($null -eq (Get-BridgeRegistryKey -Path $path)) {
+            if (-not $Apply) {
+                return @{ inDesiredState = $false }
+            }
+            Invoke-RegistryWriteOperation @{ action = "new-key"; path = $path } | Out-Null
+        }
+    }
+
+    $test = Invoke-RegistryWriteOperation $write
+    if (-not $test.changed -or -not $Apply) {
+        return @{ inDesiredState = -not $test.changed }
+    }
+    $write.dryRun = $false
+    Invoke-RegistryWriteOperation $write | Out-Null
+    return @{ inDesiredState = $true; changed = $true }
+}
+
+# The shim's own Service resource, taking PSDesiredStateConfiguration's
+# Name, State and StartupType properties
+function Invoke-BridgeServiceResource {
+    param($Properties, [bool] $Apply)
+
+    foreach ($property in $Properties.PSObject.Properties) {
+        if ($property.Name -notin "Name", "State", "StartupType") {
+            throw "The Service resource without a module takes Name, State and StartupType, not $($property.Name)"
+        }
+    }
+    $services = @(Get-Service -Name $Properties.Name -ErrorAction Stop)
+    if ($services.Count -ne 1) {
+        throw "$($services.Count) services match $($Properties.Name)"
+    }
+    $service = $services[0]
+    $current = ConvertTo-BridgeService -Service $service -Details (Get-BridgeServiceDetails @($service))
+    $startType = $Properties.StartupType -and $current.startType -ne $Properties.StartupType
+    $state = $Properties.State -and $current.status -ne $Properties.State
+    if (-not ($startType -or $state) -or -not $Apply) {
+        return @{ inDesiredState = -not ($startType -or $state) }
+    }
+    if ($startType) {
+        Set-Service -InputObject $service -StartupType $Properties.StartupType -ErrorAction Stop
+    }
+    if ($state) {
+        switch ($Properties.State) {
+            "Running" { Start-Service -InputObject $service -ErrorAction Stop }
+            "Stopped" { Stop-Service -InputObject $service -ErrorAction Stop }
+            default { throw "Can't bring a service to state $($Properties.State)" }
+        }
+    }
+    return @{ inDesiredState = $true; changed = $true }
+}
+
+# A resource from a DSC module, through Invoke-DscResource
+function Invoke-BridgeDscResource {
+    param($Resource, [bool] $Apply)
+
+    $module = [string] $Resource.module
+    if ($Resource.moduleVersion) {
+        $module = @{ ModuleName = $module; ModuleVersion = [string] $Resource.moduleVersion }
+    }
+    $properties = @{}
+    foreach ($property in @($Resource.properties.PSObject.Properties)) {
+        $properties[$property.Name] = $property.Value
+    }
+    $params = @{ Name = $Resource.name; ModuleName = $module; Property = $properties; ErrorAction = "Stop"; Verbose = $false }
+    if ((Invoke-DscResource @params -Method Test).InDesiredState) {
+        return @{ inDesiredState = $true }
+    }
+    if (-not $Apply) {
+        return @{ inDesiredState = $false }
+    }
+    $set = Invoke-DscResource @params -Method Set
+    return @{
+        inDesiredState = [bool] (Invoke-DscResource @params -Method Test).InDesiredState
+        changed        = $true
+        rebootRequired = [bool] $set.RebootRequired
+    }
+}
+
+# Test each resource in turn and, with apply, set those that have drifted.
+# A resource that fails is reported as such and the rest carry on.
+function Invoke-DesiredStateOperation {
+    param($Data)
+
+    $apply = [bool] $Data.apply
+    return , @(foreach ($resource in @($Data.resources)) {
+            $out = [ordered]@{
+                id             = $resource.id
+                name           = $resource.name
+                inDesiredState = $false
+                changed        = $false
+                rebootRequired = $false
+            }
+            try {
+                $properties = if ($null -ne $resource.properties) { $resource.properties } else { [pscustomobject] @{} }
+                $state = if ($resource.module) {
+                    Invoke-BridgeDscResource -Resource $resource -Apply $apply
+                }
+                elseif ($resource.name -eq "Registry") {
+                    Invoke-BridgeRegistryResource -Properties $properties -Apply $apply
+                }
+                elseif ($resource.name -eq "Service") {
+                    Invoke-BridgeServiceResource -Properties $properties -Apply $apply
+                }
+                else {
+                    throw "Resource $($resource.name) needs the DSC module it is from"
+                }
+                foreach ($key in $state.Keys) {
+                    $out[$key] = $state[$key]
+                }
+            }
+            catch {
+                $out.error = $_.Exception.Message
+            }
+            $out
+        })
+}
+
+function Invoke-CertStoresOperation {
+    param($Data)
+
+    return , @(foreach ($location in Get-ChildItem -LiteralPath "Cert:\") {
+            foreach ($store in Get-ChildItem -LiteralPath $location.PSPath) {
+                $path = "Cert:\$($location.Location)\$($store.Name)"
+                [ordered]@{
+                    location = $location.Location.ToString()
+                    name     = $store.Name
+                    path     = $path
+                    count    = @(Get-ChildItem -LiteralPath $path -ErrorAction SilentlyContinue).Count
+                }
+            }
+        })
+}
+
+# Describe a certificate; dates go out as UTC round-trip strings so both
+# editions of PowerShell write them the same way
+function ConvertTo-BridgeCertificate {
+    param(
+        [System.Security.Cryptography.X509Certificates.X509Certificate2] $Certificate,
+        [string] $Path,
+        [bool] $Export
+    )
+
+    $keyUsage = @()
+    $enhanced = @()
+    foreach ($extension in $Certificate.Extensions) {
+        if ($extension -is [System.Security.Cryptography.X509Certificates.X509KeyUsageExtension]) {
+            $keyUsage = @($extension.KeyUsages.ToString() -split ", " | Where-Object { $_ -ne "None" })
+        }
+        elseif ($extension -is [System.Security.Cryptography.X509Certificates.X509EnhancedKeyUsageExtension]) {
+            $enhanced = @(foreach ($oid in $extension.EnhancedKeyUsages) {
+                    if ($oid.FriendlyName) { $oid.FriendlyName } else { $oid.Value }
+                })
+        }
+    }
+    $out = [ordered]@{
+        subject          = $Certificate.Subject
+        issuer           = $Certificate.Issuer
+        thumbprint       = $Certificate.Thumbprint
+        serialNumber     = $Certificate.SerialNumber
+        friendlyName     = $Certificate.FriendlyName
+        notBefore        = $Certificate.NotBefore.ToUniversalTime().ToString("o")
+        notAfter         = $Certificate.NotAfter.ToUniversalTime().ToString("o")
+        keyUsage         = $keyUsage
+        enhancedKeyUsage = $enhanced
+        hasPrivateKey    = $Certificate.HasPrivateKey
+        path             = $Path
+    }
+    if ($Export) {
+        # The public part only; private keys never leave the store
+        $out.raw = $Certificate.Export([System.Security.Cryptography.X509Certificates.X509ContentType]::Cert)
+    }
+    return $out
+}
+
+function Invoke-CertificatesOperation {
+    param($Data)
+
+    $item = Get-Item -LiteralPath $Data.path -ErrorAction Stop
+    if ($item.PSProvider.Name -ne "Certificate") {
+        throw "Not a certificate store path: $($Data.path)"
+    }
+    return , @(Get-ChildItem -LiteralPath $Data.path -Recurse:([bool] $Data.recurse) -ErrorAction Stop |
+            Where-Object { $_ -is [System.Security.Cryptography.X509Certificates.X509Certificate2] } |
+            Where-Object { -not $Data.thumbprint -or $_.Thumbprint -eq $Data.thumbprint } |
+            ForEach-Object { ConvertTo-BridgeCertificate -Certificate $_ -Path $_.PSPath -Export ([bool] $Data.export) })
+}
+
+# The process environment as the script sees it; the seal key is already
+# gone from it
+function Invoke-EnvOperation {
+    param($Data)
+
+    $vars = [ordered]@{}
+    foreach ($item in Get-ChildItem -LiteralPath "Env:\") {
+        $vars[$item.Name] = $item.Value
+    }
+    return $vars
+}
+
+function ConvertTo-BridgeFile {
+    param([System.IO.FileSystemInfo] $Entry)
+
+    $attributes = $Entry.Attributes
+    $isDirectory = $Entry -is [System.IO.DirectoryInfo]
+    return [ordered]@{
+        name           = $Entry.Name
+        path           = $Entry.FullName
+        isDirectory    = $isDirectory
+        size           = $(if ($isDirectory) { 0 } else { $Entry.Length })
+        creationTime   = $Entry.CreationTimeUtc.ToString("o")
+        lastWriteTime  = $Entry.LastWriteTimeUtc.ToString("o")
+        lastAccessTime = $Entry.LastAccessTimeUtc.ToString("o")
+        attributes     = @($attributes.ToString() -split ", ")
+        hidden         = ($attributes -band [System.IO.FileAttributes]::Hidden) -ne 0
+        system         = ($attributes -band [System.IO.FileAttributes]::System) -ne 0
+        readOnly       = ($attributes -band [System.IO.FileAttributes]::ReadOnly) -ne 0
+    }
+}
+
+function Test-BridgeWildcard {
+    param(
+        [string] $Name,
+        [System.Management.Automation.WildcardPattern[]] $Patterns
+    )
+
+    foreach ($pattern in $Patterns) {
+        if ($pattern.IsMatch($Name)) {
+            return $true
+        }
+    }
+    return $false
+}
+
+# Write the entries under $Directory as they are enumerated, rather than
+# collecting them, so the tree is never held whole. A directory that can't
+# be read is skipped with a warning.
+function Get-BridgeFileTree {
+    param(
+        [System.IO.DirectoryInfo] $Directory,
+        [int] $Depth,
+        $Include,
+        $Exclude,
+        [bool] $Force
+    )
+
+    $hiddenOrSystem = [System.IO.FileAttributes]::Hidden -bor [System.IO.FileAttributes]::System
+    try {
+        foreach ($entry in $Directory.EnumerateFileSystemInfos()) {
+            if (-not $Force -and ($entry.Attributes -band $hiddenOrSystem) -ne 0) {
+                continue
+            }
+            if (Test-BridgeWildcard $entry.Name $Exclude) {
+                continue
+            }
+            if ($Include.Count -eq 0 -or (Test-BridgeWildcard $entry.Name $Include)) {
+                ConvertTo-BridgeFile $entry
+            }
+            $link = ($entry.Attributes -band [System.IO.FileAttributes]::ReparsePoint) -ne 0
+            if ($entry -is [System.IO.DirectoryInfo] -and $Depth -ne 0 -and -not $link) {
+                Get-BridgeFileTree -Directory $entry -Depth ($Depth - 1) -Include $Include -Exclude $Exclude -Force $Force
+            }
+        }
+    }
+    catch {
+        Write-Warning "$($Directory.FullName): $($_.Exception.Message)"
+    }
+}
+
+function Invoke-FilesOperation {
+    param($Data)
+
+    $item = Get-Item -LiteralPath $Data.path -Force -ErrorAction Stop
+    if ($item.PSProvider.Name -ne "FileSystem") {
+        throw "Not a file system path: $($Data.path)"
+    }
+    if (-not $item.PSIsContainer) {
+        return ConvertTo-BridgeFile $item
+    }
+    $include = @(foreach ($glob in @($Data.include)) { if ($glob) { [WildcardPattern]::new($glob, "IgnoreCase") } })
+    $exclude = @(foreach ($glob in @($Data.exclude)) { if ($glob) { [WildcardPattern]::new($glob, "IgnoreCase") } })
+    Get-BridgeFileTree -Directory $item -Depth ([int] $Data.depth) -Include $include -Exclude $exclude -Force ([bool] $Data.force)
+}
+
+# ScopedItemOptions flags as names, without None
+function ConvertTo-BridgeOptions {
+    param($Options)
+
+    return , @($Options.ToString() -split ", " | Where-Object { $_ -ne "None" })
+}
+
+function Invoke-AliasesOperation {
+    param($Data)
+
+    return , @(Get-ChildItem -LiteralPath "Alias:\" | ForEach-Object {
+            [ordered]@{
+                name        = $_.Name
+                definition  = $_.Definition
+                module      = $_.ModuleName
+                description = $_.Description
+                options     = ConvertTo-BridgeOptions $_.Options
+            }
+        })
+}
+
+function Invoke-FunctionsOperation {
+    param($Data)
+
+    return , @(Get-ChildItem -LiteralPath "Function:\" | ForEach-Object {
+            $file = $_.ScriptBlock.File
+            $out = [ordered]@{
+                name        = $_.Name
+                commandType = $_.CommandType.ToString()
+                module      = $_.ModuleName
+                file        = $file
+                options     = ConvertTo-BridgeOptions $_.Options
+                shim        = $null -ne $file -and $file -eq $script:ShimPath
+            }
+            if ($Data.definitions) {
+                $out.definition = $_.Definition
+            }
+            $out
+        })
+}
+
+function Invoke-VariablesOperation {
+    param($Data)
+
+    return , @(Get-Variable -Scope Global | ForEach-Object {
+            $value = "$($_.Value)"
+            if ($value.Length -gt 1024) {
+                $value = $value.Substring(0, 1024)
+            }
+            [ordered]@{
+                name        = $_.Name
+                type        = $(if ($null -ne $_.Value) { $_.Value.GetType().FullName })
+                value       = $value
+                module      = $_.ModuleName
+                description = $_.Description
+                options     = ConvertTo-BridgeOptions $_.Options
+            }
+        })
+}
+
+function ConvertTo-BridgeChangeEvent {
+    param([System.Management.Automation.PSEventArgs] $Record)
+
+    $eventArgs = $Record.SourceEventArgs
+    $out = [ordered]@{ time = $Record.TimeGenerated.ToUniversalTime().ToString("o") }
+    if ($eventArgs -is [System.IO.FileSystemEventArgs]) {
+        $out.source = "file"
+        $out.path = $eventArgs.FullPath
+        $out.changeType = $eventArgs.ChangeType.ToString()
+        if ($eventArgs -is [System.IO.RenamedEventArgs]) {
+            $out.oldPath = $eventArgs.OldFullPath
+        }
+        return $out
+    }
+
+    $instance = $eventArgs.NewEvent
+    $target = $instance.CimInstanceProperties["TargetInstance"].Value
+    $subject = $(if ($null -ne $target) { $target } else { $instance })
+    $out.source = "wmi"
+    $out.changeType = $instance.CimClass.CimClassName
+    $out.path = $subject.CimClass.CimClassName
+    $properties = [ordered]@{}
+    foreach ($property in $subject.CimInstanceProperties) {
+        $value = $property.Value
+        if ($null -eq $value -or $value -is [string] -or $value -is [ValueType]) {
+            $properties[$property.Name] = $value
+        }
+    }
+    if ($properties["Name"]) {
+        $out.path += ".$($properties["Name"])"
+    }
+    $out.properties = $properties
+    return $out
+}
+
+# Subscribe to file system and WMI events and write each one as it comes,
+# until the process is stopped
+function Invoke-WatchOperation {
+    param($Data)
+
+    $sourceIds = [System.Collections.Generic.List[string]]::new()
+    $watchers = [System.Collections.Generic.List[System.IO.FileSystemWatcher]]::new()
+    try {
+        foreach ($spec in @($Data.paths)) {
+            if ($null -eq $spec) {
+                continue
+            }
+            $full = (Resolve-Path -LiteralPath $spec.path -ErrorAction Stop).ProviderPath
+            $watcher = [System.IO.FileSystemWatcher]::new()
+            $watchers.Add($watcher)
+            if (Test-Path -LiteralPath $full -PathType Leaf) {
+                $watcher.Path = Split-Path -Parent $full
+                $watcher.Filter = Split-Path -Leaf $full
+            }
+            else {
+                $watcher.Path = $full
+                if ($spec.filter) {
+                    $watcher.Filter = $spec.filter
+                }
+            }
+            $watcher.IncludeSubdirectories = [bool] $spec.recurse
+            foreach ($eventName in "Created", "Changed", "Deleted", "Renamed") {
+                $id = "psbridge.watch.$($sourceIds.Count)"
+                Register-ObjectEvent -InputObject $watcher -EventName $eventName -SourceIdentifier $id | Out-Null
+                $sourceIds.Add($id)
+            }
+            $watcher.EnableRaisingEvents = $true
+        }
+        foreach ($query in @($Data.queries)) {
+            if ($null -eq $query) {
+                continue
+            }
+            $namespace = $(if ($query.namespace) { $query.namespace } else { "root\cimv2" })
+            $id = "psbridge.watch.$($sourceIds.Count)"
+            Register-CimIndicationEvent -Namespace $namespace -Query $query.query -SourceIdentifier $id -ErrorAction Stop | Out-Null
+            $sourceIds.Add($id)
+        }
+        if ($sourceIds.Count -eq 0) {
+            throw "Nothing to watch: give paths or queries."
+        }
+
+        while ($true) {
+            $record = Wait-Event
+            Remove-Event -EventIdentifier $record.EventIdentifier
+            if ($sourceIds.Contains($record.SourceIdentifier)) {
+                ConvertTo-BridgeChangeEvent $record
+            }
+        }
+    }
+    finally {
+        foreach ($id in $sourceIds) {
+            Unregister-Event -SourceIdentifier $id -ErrorAction SilentlyContinue
+        }
+        foreach ($watcher in $watchers) {
+            $watcher.Dispose()
+        }
+    }
+}
+
+# Results being paged, by cursor id, so a session serves each page from
+# memory instead of running the operation again. Only the newest few are
+# kept; a token whose cursor is gone, as in one-shot mode, reruns the
+# operation and skips to its offset.
+$script:PageCursors = [ordered]@{}
+$script:MaxPageCursors = 16
+
+# One page of another operation's result. A token is "<cursor>/<offset>".
+function Invoke-PageOperation {
+    param($Data)
+
+    $size = [int] $Data.size
+    if ($size -le 0) {
+        throw "Page size must be positive, not $($Data.size)"
+    }
+    $cursor = $null
+    $offset = 0
+    if ($Data.token) {
+        $cursor, $at = ([string] $Data.token).Split("/", 2)
+        if (-not [int]::TryParse($at, [ref] $offset) -or $offset -lt 0) {
+            throw "Bad page token: $($Data.token)"
+        }
+    }
+
+    if ($cursor -and $script:PageCursors.Contains($cursor)) {
+        $items = $script:PageCursors[$cursor]
+    }
+    else {
+        $output = [System.Collections.Generic.List[object]]::new()
+        Invoke-Operation -Name $Data.op -Data $Data.data | ForEach-Object { $output.Add($_) }
+        $value = $output.ToArray()
+        if ($output.Count -eq 1) {
+            $value = $output[0]
+        }
+        if ($Data.field) {
+            # A dictionary's own properties, like Values, would shadow its keys
+            if ($value -is [System.Collections.IDictionary]) {
+                $value = $value[$Data.field]
+            }
+            else {
+                $value = $value.($Data.field)
+            }
+        }
+        $items = @($value)
+        $cursor = [guid]::NewGuid().ToString("N")
+    }
+
+    $end = [Math]::Min($offset + $size, $items.Count)
+    $page = @()
+    if ($offset -lt $end) {
+        $page = @($items[$offset..($end - 1)])
+    }
+    $next = $null
+    if ($end -lt $items.Count) {
+        $next = "$cursor/$end"
+        if (-not $script:PageCursors.Contains($cursor)) {
+            $script:PageCursors[$cursor] = $items
+            while ($script:PageCursors.Count -gt $script:MaxPageCursors) {
+                $script:PageCursors.RemoveAt(0)
+            }
+        }
+    }
+    else {
+        $script:PageCursors.Remove($cursor)
+    }
+    return [ordered]@{ items = $page; next = $next; total = $items.Count }
+}
+
+# What Windows PowerShell's Get-Service leaves out, from Win32_Service by
+# name. PowerShell 7 has it on the service objects, so this is empty there.
+function Get-BridgeServiceDetails {
+    param($Services)
+
+    $details = @{}
+    if ($Services.Count -eq 0 -or $null -ne $Services[0].PSObject.Properties["UserName"]) {
+        return $details
+    }
+    foreach ($info in Get-CimInstance -ClassName Win32_Service -ErrorAction SilentlyContinue) {
+        $details[$info.Name] = $info
+    }
+    return $details
+}
+
+function ConvertTo-BridgeService {
+    param(
+        $Service,
+        [hashtable] $Details
+    )
+
+    $info = $Details[$Service.Name]
+    if ($null -ne $info) {
+        $account = $info.StartName
+        $path = $info.PathName
+        $description = $info.Description
+        $startType = "$($Service.StartType)"
+        if ($startType -eq "Automatic" -and $info.DelayedAutoStart) {
+            $startType = "AutomaticDelayedStart"
+        }
+    }
+    else {
+        $account = $Service.UserName
+        $path = $Service.BinaryPathName
+        $description = $Service.Description
+        $startType = "$($Service.StartupType)"
+        if (-not $startType) {
+            $startType = "$($Service.StartType)"
+        }
+    }
+    return [ordered]@{
+        name                = $Service.Name
+        displayName         = $Service.DisplayName
+        description         = $description
+        status              = "$($Service.Status)"
+        startType           = $startType
+        account             = $account
+        path                = $path
+        dependsOn           = @($Service.ServicesDependedOn | ForEach-Object Name)
+        dependents          = @($Service.DependentServices | ForEach-Object Name)
+        canStop             = [bool] $Service.CanStop
+        canPauseAndContinue = [bool] $Service.CanPauseAndContinue
+    }
+}
+
+function Invoke-ServicesOperation {
+    param($Data)
+
+    $names = @($Data.names | Where-Object { $_ })
+    if ($names.Count -gt 0) {
+        $services = @(Get-Service -Name $names -ErrorAction Stop)
+    }
+    else {
+        $services = @(Get-Service)
+    }
+    $details = Get-BridgeServiceDetails $services
+    return , @(foreach ($service in $services) {
+            ConvertTo-BridgeService -Service $service -Details $details
+        })
+}
+
+# Start, stop, restart, reconfigure or wait for one service, then describe
+# it as it is afterwards. A wait that times out isn't an error here; Go
+# tells from the status.
+function Invoke-ServiceControlOperation {
+    param($Data)
+
+    $services = @(Get-Service -Name $Data.name -ErrorAction Stop)
+    if ($services.Count -ne 1) {
+        throw "$($services.Count) services match $($Data.name)"
+    }
+    $service = $services[0]
+
+    switch ($Data.action) {
+        "start" {
+            Start-Service -InputObject $service -ErrorAction Stop
+        }
+        "stop" {
+            Stop-Service -InputObject $service -Force:([bool] $Data.force) -ErrorAction Stop
+        }
+        "restart" {
+            Restart-Service -InputObject $service -Force:([bool] $Data.force) -ErrorAction Stop
+        }
+        "set" {
+            $params = @{}
+            if ($Data.config.displayName) {
+                $params.DisplayName = $Data.config.displayName
+            }
+            if ($Data.config.description) {
+                $params.Description = $Data.config.description
+            }
+            if ($Data.config.startType) {
+                $params.StartupType = $Data.config.startType
+            }
+            Set-Service -InputObject $service @params -ErrorAction Stop
+        }
+        "wait" {
+            $status = [System.ServiceProcess.ServiceControllerStatus] $Data.status
+            try {
+                if ([long] $Data.timeoutMs -gt 0) {
+                    $service.WaitForStatus($status, [TimeSpan]::FromMilliseconds([double] $Data.timeoutMs))
+                }
+                else {
+                    $service.WaitForStatus($status)
+                }
+            }
+            catch {
+                if ($_.Exception.InnerException -isnot [System.ServiceProcess.TimeoutException]) {
+                    throw
+                }
+            }
+        }
+        default {
+            throw "Unknown service action: $($Data.action)"
+        }
+    }
+
+    $service.Refresh()
+    return ConvertTo-BridgeService -Service $service -Details (Get-BridgeServiceDetails @($service))
+}
+
+# A process property's value, or $null where reading it is denied, as it
+# is for other users' processes without elevation
+function Get-BridgeProcessProperty {
+    param($Process, [string] $Name)
+
+    try {
+        return $Process.$Name
+    }
+    catch {
+        return $null
+    }
+}
+
+function ConvertTo-BridgeProcess {
+    param($Process, [string] $Owner)
+
+    $start = Get-BridgeProcessProperty $Process StartTime
+    if ($null -ne $start) {
+        $start = $start.ToUniversalTime().ToString("o")
+    }
+    return [ordered]@{
+        pid        = $Process.Id
+        name       = $Process.ProcessName
+        cpu        = [double] (Get-BridgeProcessProperty $Process CPU)
+        workingSet = $Process.WorkingSet64
+        startTime  = $start
+        path       = Get-BridgeProcessProperty $Process Path
+        owner      = $Owner
+    }
+}
+
+# Owners come from -IncludeUserName where it is allowed, which on Windows
+# takes elevation, and otherwise from Win32_Process there
+function Invoke-ProcessesOperation {
+    param($Data)
+
+    $params = @{}
+    $names = @($Data.names | Where-Object { $_ })
+    if ($names.Count -gt 0) {
+        $params.Name = $names
+    }
+    $owners = @{}
+    try {
+        $processes = @(Get-Process @params -IncludeUserName -ErrorAction Stop)
+        foreach ($process in $processes) {
+            $owners[$process.Id] = $process.UserName
+        }
+    }
+    catch {
+        $processes = @(Get-Process @params -ErrorAction Stop)
+        if ($env:OS -eq "Windows_NT") {
+            $ids = [System.Collections.Generic.HashSet[int]]::new()
+            foreach ($process in $processes) {
+                [void] $ids.Add($process.Id)
+            }
+            foreach ($cim in Get-CimInstance -ClassName Win32_Process -ErrorAction SilentlyContinue) {
+                if (-not $ids.Contains([int] $cim.ProcessId)) {
+                    continue
+                }
+                $owner = Invoke-CimMethod -InputObject $cim -MethodName GetOwner -ErrorAction SilentlyContinue
+                if ($null -ne $owner -and $owner.ReturnValue -eq 0) {
+                    $owners[[int] $cim.ProcessId] = if ($owner.Domain) { "$($owner.Domain)\$($owner.User)" } else { $owner.User }
+                }
+            }
+        }
+    }
+    return , @(foreach ($process in $processes) {
+            ConvertTo-BridgeProcess -Process $process -Owner $owners[$process.Id]
+        })
+}
+
+function Invoke-KillOperation {
+    param($Data)
+
+    Stop-Process -Id ([int] $Data.pid) -Force -ErrorAction Stop
+}
+
+# A time from a request. PowerShell 7's ConvertFrom-Json already turns
+# ISO 8601 strings into dates, Windows PowerShell's doesn't.
+function ConvertFrom-BridgeTime {
+    param($Value)
+
+    if ($Value -is [datetime]) {
+        return $Value
+    }
+    return [datetime]::Parse([string] $Value, [cultureinfo]::InvariantCulture, [System.Globalization.DateTimeStyles]::RoundtripKind)
+}
+
+function ConvertTo-BridgeEvent {
+    param($Record)
+
+    $message = $null
+    try {
+        $message = $Record.Message
+    }
+    catch {
+        # The provider's message files aren't installed
+    }
+    return [ordered]@{
+        id         = $Record.Id
+        level      = [int] $Record.Level
+        logName    = $Record.LogName
+        provider   = $Record.ProviderName
+        recordId   = $Record.RecordId
+        machine    = $Record.MachineName
+        time       = $Record.TimeCreated.ToUniversalTime().ToString("o")
+        message    = $message
+        userId     = "$($Record.UserId)"
+        properties = @(foreach ($property in $Record.Properties) {
+                $value = $property.Value
+                if ($null -eq $value -or $value -is [string] -or $value -is [byte[]] -or $value.GetType().IsPrimitive) {
+                    , $value
+                }
+                else {
+                    "$value"
+                }
+            })
+    }
+}
+
+# Events as Get-WinEvent reads them. A query matching nothing is an empty
+# result rather than an error. With stream set, each goes out as soon as
+# it is read, for pipeline mode.
+function Invoke-EventsOperation {
+    param($Data)
+
+    $params = @{ ErrorAction = "Stop" }
+    if ($Data.max) {
+        $params.MaxEvents = [long] $Data.max
+    }
+    if ($Data.oldest) {
+        $params.Oldest = $true
+    }
+    if ($Data.xpath) {
+        $params.LogName = @($Data.logName)
+        $params.FilterXPath = $Data.xpath
+    }
+    else {
+        $filter = @{}
+        if ($Data.logName) {
+            $filter.LogName = @($Data.logName)
+        }
+        if ($Data.provider) {
+            $filter.ProviderName = @($Data.provider)
+        }
+        if ($Data.level) {
+            $filter.Level = @(foreach ($level in $Data.level) { [int] $level })
+        }
+        if ($Data.ids) {
+            $filter.Id = @(foreach ($id in $Data.ids) { [int] $id })
+        }
+        if ($Data.since) {
+            $filter.StartTime = ConvertFrom-BridgeTime $Data.since
+        }
+        if ($Data.until) {
+            $filter.EndTime = ConvertFrom-BridgeTime $Data.until
+        }
+        $params.FilterHashtable = $filter
+    }
+
+    try {
+        if ($Data.stream) {
+            Get-WinEvent @params | ForEach-Object { ConvertTo-BridgeEvent $_ }
+            return
+        }
+        return , @(Get-WinEvent @params | ForEach-Object { ConvertTo-BridgeEvent $_ })
+    }
+    catch {
+        if ($_.FullyQualifiedErrorId -notlike "NoMatchingEventsFound*") {
+            throw
+        }
+        if (-not $Data.stream) {
+            return , @()
+        }
+    }
+}
+
+# A CIM property value as JSON can carry it: dates as ISO 8601, embedded
+# instances as their properties, a few levels deep
+function ConvertTo-BridgeCimValue {
+    param($Value, [int] $Depth = 4)
+
+    if ($null -eq $Value -or $Value -is [string] -or $Value -is [byte[]]) {
+        return , $Value
+    }
+    if ($Value -is [datetime]) {
+        return $Value.ToUniversalTime().ToString("o")
+    }
+    if ($Value -is [timespan]) {
+        return $Value.ToString("c")
+    }
+    if ($Value -is [Microsoft.Management.Infrastructure.CimInstance]) {
+        if ($Depth -le 0) {
+            return "$Value"
+        }
+        return ConvertTo-BridgeCimInstance $Value ($Depth - 1)
+    }
+    if ($Value -is [array]) {
+        return , @(foreach ($item in $Value) { ConvertTo-BridgeCimValue $item $Depth })
+    }
+    if ($Value.GetType().IsPrimitive) {
+        return $Value
+    }
+    return "$Value"
+}
+
+function ConvertTo-BridgeCimInstance {
+    param($Instance, [int] $Depth = 4)
+
+    $out = [ordered]@{}
+    foreach ($property in $Instance.CimInstanceProperties) {
+        $out[$property.Name] = ConvertTo-BridgeCimValue $property.Value $Depth
+    }
+    return $out
+}
+
+function Invoke-CimOperation {
+    param($Data)
+
+    $params = @{ ClassName = $Data.class; ErrorAction = "Stop" }
+    if ($Data.namespace) {
+        $params.Namespace = $Data.namespace
+    }
+    if ($Data.filter) {
+        $params.Filter = $Data.filter
+    }
+    if ($Data.properties) {
+        $params.Property = @($Data.properties)
+    }
+    return , @(Get-CimInstance @params | ForEach-Object { ConvertTo-BridgeCimInstance $_ })
+}
+
+# An AD attribute's value in a form that survives JSON. Multi-valued
+# attributes come through as ADPropertyValueCollection.
+function ConvertTo-BridgeADValue {
+    param($Value)
+
+    if ($null -eq $Value -or $Value -is [string]) {
+        return $Value
+    }
+    if ($Value -is [byte[]]) {
+        return [Convert]::ToBase64String($Value)
+    }
+    if ($Value -is [datetime]) {
+        return $Value.ToUniversalTime().ToString("o")
+    }
+    if ($Value.GetType().IsPrimitive) {
+        return $Value
+    }
+    if ($Value -is [System.Collections.IEnumerable]) {
+        return , @(foreach ($item in $Value) { ConvertTo-BridgeADValue $item })
+    }
+    return "$Value"
+}
+
+# Read AD users, groups or computers through the ActiveDirectory module,
+# with the attributes each Go type has and the ones asked for
+function Invoke-AdOperation {
+    param($Data)
+
+    Import-Module ActiveDirectory -ErrorAction Stop -Verbose:$false
+    $extra = @{
+        user     = @("mail", "description", "lockedOut", "passwordLastSet", "lastLogonDate", "memberOf")
+        group    = @("description", "members", "memberOf")
+        computer = @("operatingSystem", "operatingSystemVersion", "description", "lastLogonDate")
+    }
+    $command = @{ user = "Get-ADUser"; group = "Get-ADGroup"; computer = "Get-ADComputer" }[[string] $Data.class]
+    if ($null -eq $command) {
+        throw "Unknown AD object class: $($Data.class)"
+    }
+    $requested = @($Data.properties | Where-Object { $_ })
+    $params = @{ Properties = @($extra[$Data.class] + $requested); ErrorAction = "Stop" }
+    if ($Data.identity) {
+        $params.Identity = $Data.identity
+    }
+    elseif ($Data.ldapFilter) {
+        $params.LDAPFilter = $Data.ldapFilter
+    }
+    else {
+        $params.Filter = if ($Data.filter) { $Data.filter } else { "*" }
+    }
+    foreach ($name in "searchBase", "searchScope", "server", "credential") {
+        if ($Data.$name) {
+            $params[$name] = $Data.$name
+        }
+    }
+    if ($Data.max -gt 0) {
+        $params.ResultSetSize = [int] $Data.max
+    }
+
+    return , @(foreach ($object in & $command @params) {
+            $out = [ordered]@{
+                distinguishedName = $object.DistinguishedName
+                name              = $object.Name
+                objectClass       = $object.ObjectClass
+                objectGuid        = "$($object.ObjectGUID)"
+                sid               = "$($object.SID)"
+                samAccountName    = $object.SamAccountName
+            }
+            switch ($Data.class) {
+                "user" {
+                    $out.userPrincipalName = $object.UserPrincipalName
+                    $out.givenName = $object.GivenName
+                    $out.surname = $object.Surname
+                    $out.mail = $object.mail
+                    $out.description = $object.Description
+                    $out.enabled = [bool] $object.Enabled
+                    $out.lockedOut = [bool] $object.LockedOut
+                    $out.passwordLastSet = ConvertTo-BridgeADValue $object.PasswordLastSet
+                    $out.lastLogon = ConvertTo-BridgeADValue $object.LastLogonDate
+                    $out.memberOf = @($object.MemberOf | ForEach-Object { [string] $_ })
+                }
+                "group" {
+                    $out.description = $object.Description
+                    $out.scope = "$($object.GroupScope)"
+                    $out.category = "$($object.GroupCategory)"
+                    $out.members = @($object.Members | ForEach-Object { [string] $_ })
+                    $out.memberOf = @($object.MemberOf | ForEach-Object { [string] $_ })
+                }
+                "computer" {
+                    $out.dnsHostName = $object.DNSHostName
+                    $out.operatingSystem = $object.OperatingSystem
+                    $out.operatingSystemVersion = $object.OperatingSystemVersion
+                    $out.description = $object.Description
+                    $out.enabled = [bool] $object.Enabled
+                    $out.lastLogon = ConvertTo-BridgeADValue $object.LastLogonDate
+                }
+            }
+            if ($requested.Count -gt 0) {
+                $names = if ($requested -contains "*") { $object.PropertyNames } else { $requested }
+                $out.properties = [ordered]@{}
+                foreach ($name in $names) {
+                    $out.properties[$name] = ConvertTo-BridgeADValue $object.$name
+                }
+            }
+            $out
+        })
+}
+
+# Trigger kinds by the CIM class New-ScheduledTaskTrigger makes them with
+$script:TriggerKinds = @{
+    MSFT_TaskTimeTrigger   = "Once"
+    MSFT_TaskDailyTrigger  = "Daily"
+    MSFT_TaskWeeklyTrigger = "Weekly"
+    MSFT_TaskBootTrigger   = "AtStartup"
+    MSFT_TaskLogonTrigger  = "AtLogOn"
+}
+
+# The bits of a weekly trigger's DaysOfWeek, from Sunday
+$script:WeekDays = @("Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday")
+
+function ConvertTo-BridgeTaskTrigger {
+    param($Trigger)
+
+    $class = $Trigger.CimClass.CimClassName
+    $kind = $script:TriggerKinds[$class]
+    if ($null -eq $kind) {
+        $kind = $class
+    }
+    $out = [ordered]@{ kind = $kind; disabled = -not $Trigger.Enabled }
+    if ($Trigger.StartBoundary) {
+        $out.at = [datetimeoffset]::Parse($Trigger.StartBoundary, [cultureinfo]::InvariantCulture).ToString("o")
+    }
+    switch ($kind) {
+        "Daily" {
+            $out.daysInterval = [int] $Trigger.DaysInterval
+        }
+        "Weekly" {
+            $out.weeksInterval = [int] $Trigger.WeeksInterval
+            $out.daysOfWeek = @(for ($i = 0; $i -lt 7; $i++) {
+                    if ([int] $Trigger.DaysOfWeek -band (1 -shl $i)) {
+                        $script:WeekDays[$i]
+                    }
+                })
+        }
+        "AtLogOn" {
+            if ($Trigger.UserId) {
+                $out.user = $Trigger.UserId
+            }
+        }
+    }
+    return $out
+}
+
+# A task with what Get-ScheduledTaskInfo knows of its runs. Never-run
+# tasks report a last run in 1999, so that and unscheduled runs are left
+# out.
+function ConvertTo-BridgeScheduledTask {
+    param($Task)
+
+    $info = Get-ScheduledTaskInfo -InputObject $Task -ErrorAction SilentlyContinue
+    $out = [ordered]@{
+        name        = $Task.TaskName
+        path        = $Task.TaskPath
+        description = $Task.Description
+        author      = $Task.Author
+        state       = "$($Task.State)"
+        triggers    = @(foreach ($trigger in $Task.Triggers) { ConvertTo-BridgeTaskTrigger $trigger })
+        actions     = @(foreach ($action in $Task.Actions) {
+                if ($null -ne $action.Execute) {
+                    [ordered]@{ execute = $action.Execute; arguments = $action.Arguments; workingDirectory = $action.WorkingDirectory }
+                }
+            })
+        principal   = [ordered]@{
+            userId    = $Task.Principal.UserId
+            logonType = "$($Task.Principal.LogonType)"
+            runLevel  = "$($Task.Principal.RunLevel)"
+        }
+        lastResult  = [long] $info.LastTaskResult
+    }
+    if ($info.LastRunTime -and $info.LastRunTime.Year -ge 2000) {
+        $out.lastRunTime = $info.LastRunTime.ToUniversalTime().ToString("o")
+    }
+    if ($info.NextRunTime) {
+        $out.nextRunTime = $info.NextRunTime.ToUniversalTime().ToString("o")
+    }
+    return $out
+}
+
+function Invoke-ScheduledTasksOperation {
+    param($Data)
+
+    $params = @{ ErrorAction = "Stop" }
+    if ($Data.path) {
+        $params.TaskPath = $Data.path
+    }
+    if ($Data.name) {
+        $params.TaskName = $Data.name
+    }
+    return , @(Get-ScheduledTask @params | ForEach-Object { ConvertTo-BridgeScheduledTask $_ })
+}
+
+function New-BridgeTaskTrigger {
+    param($Trigger)
+
+    $params = @{}
+    switch ($Trigger.kind) {
+        "Once" {
+            $params.Once = $true
+        }
+        "Daily" {
+            $params.Daily = $true
+            if ($Trigger.daysInterval) {
+                $params.DaysInterval = [int] $Trigger.daysInterval
+            }
+        }
+        "Weekly" {
+            $params.Weekly = $true
+            $params.DaysOfWeek = @($Trigger.daysOfWeek)
+            if ($Trigger.weeksInterval) {
+                $params.WeeksInterval = [int] $Trigger.weeksInterval
+            }
+        }
+        "AtStartup" {
+            $params.AtStartup = $true
+        }
+        "AtLogOn" {
+            $params.AtLogOn = $true
+            if ($Trigger.user) {
+                $params.User = $Trigger.user
+            }
+        }
+        default {
+            throw "Can't register a trigger of kind $($Trigger.kind)"
+        }
+    }
+    if ($Trigger.kind -in @("Once", "Daily", "Weekly")) {
+        if (-not $Trigger.at) {
+            throw "A $($Trigger.kind) trigger needs a time"
+        }
+        $params.At = ConvertFrom-BridgeTime $Trigger.at
+    }
+    $out = New-ScheduledTaskTrigger @params
+    if ($Trigger.disabled) {
+        $out.Enabled = $false
+    }
+    return $out
+}
+
+function Invoke-RegisterTaskOperation {
+    param($Data)
+
+    $actions = @(foreach ($action in $Data.actions) {
+            $params = @{ Execute = $action.execute }
+            if ($action.arguments) {
+                $params.Argument = $action.arguments
+            }
+            if ($action.workingDirectory) {
+                $params.WorkingDirectory = $action.workingDirectory
+            }
+            New-ScheduledTaskAction @params
+        })
+    $params = @{
+        TaskName    = $Data.name
+        Action      = $actions
+        Force       = [bool] $Data.replace
+        ErrorAction = "Stop"
+    }
+    $triggers = @(foreach ($trigger in $Data.triggers) { New-BridgeTaskTrigger $trigger })
+    if ($triggers.Count -gt 0) {
+        $params.Trigger = $triggers
+    }
+    if ($Data.path) {
+        $params.TaskPath = $Data.path
+    }
+    if ($Data.description) {
+        $params.Description = $Data.description
+    }
+    if ($null -ne $Data.credential) {
+        # -User and -Password can't go with -Principal
+        $params.User = $Data.credential.UserName
+        $params.Password = $Data.credential.GetNetworkCredential().Password
+        if ($Data.principal.runLevel) {
+            $params.RunLevel = $Data.principal.runLevel
+        }
+    }
+    elseif ($null -ne $Data.principal) {
+        $principal = @{}
+        if ($Data.principal.userId) {
+            $principal.UserId = $Data.principal.userId
+        }
+        if ($Data.principal.logonType) {
+            $principal.LogonType = $Data.principal.logonType
+        }
+        if ($Data.principal.runLevel) {
+            $principal.RunLevel = $Data.principal.runLevel
+        }
+        $params.Principal = New-ScheduledTaskPrincipal @principal
+    }
+    $task = Register-ScheduledTask @params
+    return ConvertTo-BridgeScheduledTask $task
+}
+
+function Invoke-UnregisterTaskOperation {
+    param($Data)
+
+    $params = @{ TaskName = $Data.name; Confirm = $false; ErrorAction = "Stop" }
+    if ($Data.path) {
+        $params.TaskPath = $Data.path
+    }
+    Unregister-ScheduledTask @params
+}
+
+# Describe this PowerShell. Syntax features are probed by parsing a
+# sample, so a script using them can be chosen before it would fail to
+# parse; cmdlet features by looking for the parameter.
+function Invoke-HostInfoOperation {
+    param($Data)
+
+    $syntax = [ordered]@{
+        "ternary"         = '$true ? 1 : 2'
+        "pipeline-chain"  = 'a && b'
+        "null-coalescing" = '$a ?? 1'
+        "clean-block"     = 'function f { clean { } }'
+    }
+    $parameters = [ordered]@{
+        "json-as-array"     = "ConvertTo-Json", "AsArray"
+        "json-as-hashtable" = "ConvertFrom-Json", "AsHashtable"
+        "foreach-parallel"  = "ForEach-Object", "Parallel"
+    }
+    $features = @(foreach ($feature in $syntax.Keys) {
+            $errors = $null
+            [System.Management.Automation.Language.Parser]::ParseInput($syntax[$feature], [ref] $null, [ref] $errors) | Out-Null
+            if ($errors.Count -eq 0) {
+                $feature
+            }
+        }
+        foreach ($feature in $parameters.Keys) {
+            $command, $parameter = $parameters[$feature]
+            $info = Get-Command -Name $command -ErrorAction SilentlyContinue
+            if ($null -ne $info -and $info.Parameters.ContainsKey($parameter)) {
+                $feature
+            }
+        })
+
+    # Windows PowerShell has no OS or Platform, and 4.0 no PSEdition
+    $edition = if ($PSVersionTable.PSEdition) { $PSVersionTable.PSEdition } else { "Desktop" }
+    $os = if ($PSVersionTable.OS) { $PSVersionTable.OS } else { [Environment]::OSVersion.VersionString }
+    $platform = if ($PSVersionTable.Platform) { $PSVersionTable.Platform } else { "Win32NT" }
+    return [ordered]@{
+        edition      = [string] $edition
+        version      = $PSVersionTable.PSVersion.ToString()
+        os           = [string] $os
+        platform     = [string] $platform
+        clrVersion   = [Environment]::Version.ToString()
+        is64Bit      = [Environment]::Is64BitProcess
+        languageMode = $ExecutionContext.SessionState.LanguageMode.ToString()
+        features     = $features
+        endpoint     = if ($null -ne $script:EndpointSession) { Get-EndpointInfo }
+    }
+}
+
+Register-BridgeOperation -Name "ad" -Handler "Invoke-AdOperation"
+Register-BridgeOperation -Name "aliases" -Handler "Invoke-AliasesOperation"
+Register-BridgeOperation -Name "batch" -Handler "Invoke-BatchOperation"
+Register-BridgeOperation -Name "cert-stores" -Handler "Invoke-CertStoresOperation"
+Register-BridgeOperation -Name "certificates" -Handler "Invoke-CertificatesOperation"
+Register-BridgeOperation -Name "childitems" -Handler "Invoke-ChilditemsOperation"
+Register-BridgeOperation -Name "cim" -Handler "Invoke-CimOperation"
+Register-BridgeOperation -Name "cmdlet" -Handler "Invoke-CmdletOperation"
+Register-BridgeOperation -Name "desired-state" -Handler "Invoke-DesiredStateOperation"
+Register-BridgeOperation -Name "echo" -Handler "Invoke-EchoOperation"
+Register-BridgeOperation -Name "env" -Handler "Invoke-EnvOperation"
+Register-BridgeOperation -Name "events" -Handler "Invoke-EventsOperation"
+Register-BridgeOperation -Name "files" -Handler "Invoke-FilesOperation"
+Register-BridgeOperation -Name "functions" -Handler "Invoke-FunctionsOperation"
+Register-BridgeOperation -Name "host-info" -Handler "Invoke-HostInfoOperation"
+Register-BridgeOperation -Name "install-modules" -Handler "Invoke-InstallModulesOperation"
+Register-BridgeOperation -Name "kill" -Handler "Invoke-KillOperation"
+Register-BridgeOperation -Name "modules" -Handler "Invoke-ModulesOperation"
+Register-BridgeOperation -Name "page" -Handler "Invoke-PageOperation"
+Register-BridgeOperation -Name "processes" -Handler "Invoke-ProcessesOperation"
+Register-BridgeOperation -Name "providers" -Handler "Invoke-ProvidersOperation"
+Register-BridgeOperation -Name "register-task" -Handler "Invoke-RegisterTaskOperation"
+Register-BridgeOperation -Name "registry" -Handler "Invoke-RegistryOperation"
+Register-BridgeOperation -Name "registry-write" -Handler "Invoke-RegistryWriteOperation"
+Register-BridgeOperation -Name "scheduled-tasks" -Handler "Invoke-ScheduledTasksOperation"
+Register-BridgeOperation -Name "service-control" -Handler "Invoke-ServiceControlOperation"
+Register-BridgeOperation -Name "services" -Handler "Invoke-ServicesOperation"
+Register-BridgeOperation -Name "unregister-task" -Handler "Invoke-UnregisterTaskOperation"
+Register-BridgeOperation -Name "variables" -Handler "Invoke-VariablesOperation"
+Register-BridgeOperation -Name "watch" -Handler "Invoke-WatchOperation"
+
+# Flatten an ErrorRecord into the error envelope the Go side decodes as PSError
+function ConvertTo-BridgeError {
+    param([System.Management.Automation.ErrorRecord] $Record)
+
+    $envelope = @{
+        type             = $Record.Exception.GetType().FullName
+        message          = $Record.Exception.Message
+        category         = $Record.CategoryInfo.Category.ToString()
+        errorId          = $Record.FullyQualifiedErrorId
+        scriptStackTrace = $Record.ScriptStackTrace
+    }
+
+    if ($null -ne $Record.TargetObject) {
+        $envelope.targetObject = "$($Record.TargetObject)"
+    }
+
+    $info = $Record.InvocationInfo
+    if ($null -ne $info) {
+        $envelope.scriptName = $info.ScriptName
+        $envelope.line = $info.ScriptLineNumber
+        $envelope.column = $info.OffsetInLine
+        $envelope.positionMessage = $info.PositionMessage
+    }
+
+    return $envelope
+}
+
+# Setting the encodings replaces [Console]::In and Out, so this comes
+# before they are taken as the protocol channel. Neither encoding writes a
+# BOM.
+if ($ConsoleEncoding) {
+    [Console]::InputEncoding = [System.Text.UTF8Encoding]::new($false)
+    [Console]::OutputEncoding = switch ($ConsoleEncoding) {
+        "utf8" { [System.Text.UTF8Encoding]::new($false) }
+        "utf16" { [System.Text.UnicodeEncoding]::new($false, $false) }
+    }
+}
+
+# Session message framing: "ndjson" lines, or "length" prefixed frames on
+# the raw streams once negotiated. Reader/Writer are the text side of the
+# protocol channel; the raw streams are opened lazily for the console.
+$script:Framing = "ndjson"
+$script:Reader = [Console]::In
+$script:Writer = [Console]::Out
+$script:InStream = $null
+$script:OutStream = $null
+
+# Correlation ID of the request being served; every message carries it so
+# the Go side can route replies when several requests are in flight
+$script:CurrentId = $null
+
+function Write-Message {
+    param($Message)
+
+    if ($null -ne $script:CurrentId) {
+        $Message.id = $script:CurrentId
+    }
+    # One level more for the message around the data
+    $json = $Message | ConvertTo-Json -Depth ($JsonDepth + 1) -Compress
+
+    if ($script:Framing -eq "length") {
+        Write-Frame $json
+        return
+    }
+    if ($Sentinels) {
+        $json = "<<<JSON$($json)JSON>>>"
+    }
+    $script:Writer.WriteLine($json)
+    $script:Writer.Flush()
+}
+
+# Read exactly $Count bytes, or $null if the stream ends first
+function Read-Exact {
+    param(
+        [System.IO.Stream] $Stream,
+        [int] $Count
+    )
+
+    $buffer = [byte[]]::new($Count)
+    $offset = 0
+    while ($offset -lt $Count) {
+        $n = $Stream.Read($buffer, $offset, $Count - $offset)
+        if ($n -eq 0) {
+            return $null
+        }
+        $offset += $n
+    }
+    return , $buffer
+}
+
+# A frame is a 4-byte big-endian length followed by that many bytes of UTF-8 JSON
+function Read-Frame {
+    $header = Read-Exact $script:InStream 4
+    if ($null -eq $header) {
+        return $null
+    }
+
+    $length = ([int] $header[0] -shl 24) -bor ([int] $header[1] -shl 16) -bor ([int] $header[2] -shl 8) -bor [int] $header[3]
+    $body = Read-Exact $script:InStream $length
+    if ($null -eq $body) {
+        return $null
+    }
+    return [System.Text.Encoding]::UTF8.GetString($body)
+}
+
+function Write-Frame {
+    param([string] $Text)
+
+    $body = [System.Text.Encoding]::UTF8.GetBytes($Text)
+    $length = $body.Length
+    $header = [byte[]] @(
+        (($length -shr 24) -band 0xFF),
+        (($length -shr 16) -band 0xFF),
+        (($length -shr 8) -band 0xFF),
+        ($length -band 0xFF)
+    )
+    $script:OutStream.Write($header, 0, 4)
+    $script:OutStream.Write($body, 0, $length)
+    $script:OutStream.Flush()
+}
+
+# Next request in the session's framing, or $null at end of input
+function Read-Request {
+    if ($script:Framing -eq "length") {
+        return Read-Frame
+    }
+    return $script:Reader.ReadLine()
+}
+
+# Switch the protocol channel to the named pipes Go created
+function Connect-Pipes {
+    param([string] $Name)
+
+    $in = [System.IO.Pipes.NamedPipeClientStream]::new(".", "$Name-in", [System.IO.Pipes.PipeDirection]::In)
+    $out = [System.IO.Pipes.NamedPipeClientStream]::new(".", "$Name-out", [System.IO.Pipes.PipeDirection]::Out)
+    $in.Connect(10000)
+    $out.Connect(10000)
+
+    $utf8 = [System.Text.UTF8Encoding]::new($false)
+    $script:InStream = $in
+    $script:OutStream = $out
+    $script:Reader = [System.IO.StreamReader]::new($in, $utf8)
+    $script:Writer = [System.IO.StreamWriter]::new($out, $utf8)
+}
+
+# Write-Progress can't be redirected, so shadow it for the operations and
+# forward each update as a progress message instead
+function Write-Progress {
+    [CmdletBinding()]
+    param(
+        [Parameter(Position = 0)]
+        [string] $Activity,
+
+        [Parameter(Position = 1)]
+        [string] $Status = "Processing",
+
+        [Parameter(Position = 2)]
+        [int] $Id = 0,
+
+        [int] $PercentComplete = -1,
+        [int] $SecondsRemaining = -1,
+        [string] $CurrentOperation,
+        [int] $ParentId = -1,
+        [switch] $Completed,
+        [int] $SourceId
+    )
+
+    Write-Message @{
+        type     = "stream"
+        stream   = "progress"
+        progress = @{
+            activityId       = $Id
+            parentActivityId = $ParentId
+            activity         = $Activity
+            status           = $Status
+            currentOperation = $CurrentOperation
+            percentComplete  = $PercentComplete
+            secondsRemaining = $SecondsRemaining
+            completed        = [bool] $Completed
+        }
+    }
+}
+
+# Key for the secure strings Go seals, given to this process alone. It
+# leaves the environment at once so nothing an operation starts inherits it.
+$script:SealKey = $null
+if ($env:PSBRIDGE_SEAL_KEY) {
+    $script:SealKey = [Convert]::FromBase64String($env:PSBRIDGE_SEAL_KEY)
+    Remove-Item Env:PSBRIDGE_SEAL_KEY
+}
+
+# Open a sealed secret: base64 of an IV, AES-256-CBC ciphertext and the
+# HMAC-SHA256 of both, under the two halves of the seal key
+function Unprotect-Sealed {
+    param([string] $Sealed)
+
+    if ($null -eq $script:SealKey) {
+        throw "Received a sealed secret without a seal key"
+    }
+    $blob = [Convert]::FromBase64String($Sealed)
+    if ($blob.Length -lt 64) {
+        throw "Sealed secret is truncated"
+    }
+
+    $hmac = [System.Security.Cryptography.HMACSHA256]::new([byte[]] $script:SealKey[32..63])
+    try {
+        $mac = $hmac.ComputeHash($blob, 0, $blob.Length - 32)
+    }
+    finally {
+        $hmac.Dispose()
+    }
+    $diff = 0
+    for ($i = 0; $i -lt 32; $i++) {
+        $diff = $diff -bor ($mac[$i] -bxor $blob[$blob.Length - 32 + $i])
+    }
+    if ($diff -ne 0) {
+        throw "Sealed secret failed verification"
+    }
+
+    $aes = [System.Security.Cryptography.Aes]::Create()
+    try {
+        $aes.Mode = [System.Security.Cryptography.CipherMode]::CBC
+        $aes.Padding = [System.Security.Cryptography.PaddingMode]::PKCS7
+        $aes.Key = [byte[]] $script:SealKey[0..31]
+        $aes.IV = [byte[]] $blob[0..15]
+        $decryptor = $aes.CreateDecryptor()
+        return , $decryptor.TransformFinalBlock($blob, 16, $blob.Length - 48)
+    }
+    finally {
+        $aes.Dispose()
+    }
+}
+
+# Build a SecureString from its message form, without leaving the plain
+# text in a string
+function ConvertTo-BridgeSecureString {
+    param($Value)
+
+    if ($null -ne $Value.sealed) {
+        $bytes = Unprotect-Sealed $Value.sealed
+        $chars = [System.Text.Encoding]::UTF8.GetChars($bytes)
+        [Array]::Clear($bytes, 0, $bytes.Length)
+    }
+    else {
+        $chars = ([string] $Value.secret).ToCharArray()
+    }
+
+    $secure = [System.Security.SecureString]::new()
+    foreach ($c in $chars) {
+        $secure.AppendChar($c)
+    }
+    [Array]::Clear($chars, 0, $chars.Length)
+    $secure.MakeReadOnly()
+    return $secure
+}
+
+# Rebuild the typed values Go marks with a "$psbridge" property, at any
+# depth of a request's data
+function ConvertFrom-BridgeValue {
+    param($Value)
+
+    if ($Value -is [System.Management.Automation.PSCustomObject]) {
+        $kind = $Value.'$psbridge'
+        if ($kind -eq "securestring") {
+            return ConvertTo-BridgeSecureString $Value
+        }
+        if ($kind -eq "credential") {
+            return [pscredential]::new([string] $Value.userName, (ConvertTo-BridgeSecureString $Value.password))
+        }
+        if ($kind -eq "bytes") {
+            return , [Convert]::FromBase64String($Value.base64)
+        }
+        if ($kind -eq "as") {
+            $inner = ConvertFrom-BridgeValue $Value.value
+            return [System.Management.Automation.LanguagePrimitives]::ConvertTo($inner, [type] $Value.type, [cultureinfo]::InvariantCulture)
+        }
+        if ($kind -eq "decimal") {
+            return [decimal]::Parse($Value.value, [System.Globalization.NumberStyles]::Float, [cultureinfo]::InvariantCulture)
+        }
+        if ($null -ne $kind) {
+            throw "Unknown value type: $kind"
+        }
+        foreach ($property in $Value.PSObject.Properties) {
+            $property.Value = ConvertFrom-BridgeValue $property.Value
+        }
+        return $Value
+    }
+    if ($Value -is [array]) {
+        for ($i = 0; $i -lt $Value.Count; $i++) {
+            $Value[$i] = ConvertFrom-BridgeValue $Value[$i]
+        }
+        return , $Value
+    }
+    return $Value
+}
+
+# Mark the byte arrays in an operation's output the way Go marks them, so
+# they don't go out as arrays of numbers. Only the containers an operation
+# builds itself are searched, not the properties of arbitrary objects.
+function ConvertTo-BridgeValue {
+    param($Value)
+
+    if ($Value -is [byte[]]) {
+        return [ordered]@{ '$psbridge' = "bytes"; base64 = [Convert]::ToBase64String($Value) }
+    }
+    if ($Value -is [System.Collections.IDictionary]) {
+        foreach ($key in @($Value.Keys)) {
+            $Value[$key] = ConvertTo-BridgeValue $Value[$key]
+        }
+        return $Value
+    }
+    if ($Value -is [System.Management.Automation.PSCustomObject]) {
+        foreach ($property in $Value.PSObject.Properties) {
+            $property.Value = ConvertTo-BridgeValue $property.Value
+        }
+        return $Value
+    }
+    if ($Value -is [array]) {
+        for ($i = 0; $i -lt $Value.Count; $i++) {
+            $Value[$i] = ConvertTo-BridgeValue $Value[$i]
+        }
+        return , $Value
+    }
+    return $Value
+}
+
+# Forward a record from one of the non-output streams as a stream message,
+# reporting whether it was one
+function Write-StreamRecord {
+    param($Item)
+
+    if ($Item -is [System.Management.Automation.VerboseRecord]) {
+        Write-Message @{ type = "stream"; stream = "verbose"; message = $Item.Message }
+    }
+    elseif ($Item -is [System.Management.Automation.WarningRecord]) {
+        Write-Message @{ type = "stream"; stream = "warning"; message = $Item.Message }
+    }
+    elseif ($Item -is [System.Management.Automation.DebugRecord]) {
+        Write-Message @{ type = "stream"; stream = "debug"; message = $Item.Message }
+    }
+    elseif ($Item -is [System.Management.Automation.InformationRecord]) {
+        Write-Message @{ type = "stream"; stream = "information"; message = "$($Item.MessageData)" }
+    }
+    elseif ($Item -is [System.Management.Automation.ErrorRecord]) {
+        Write-Message @{ type = "stream"; stream = "error"; message = $Item.Exception.Message; error = (ConvertTo-BridgeError $Item) }
+    }
+    else {
+        return $false
+    }
+    return $true
+}
+
+# Run an operation, forwarding every non-output stream record as a tagged
+# stream message while it happens, and return the collected output
+function Invoke-Captured {
+    param(
+        [string] $Name,
+        $Data
+    )
+
+    # Records only reach the redirection below when their preference is Continue
+    $VerbosePreference = "Continue"
+    $DebugPreference = "Continue"
+    $InformationPreference = "Continue"
+
+    $Data = ConvertFrom-BridgeValue $Data
+    $output = [System.Collections.Generic.List[object]]::new()
+
+    Invoke-Operation -Name $Name -Data $Data *>&1 | ForEach-Object {
+        if (-not (Write-StreamRecord $_)) {
+            $output.Add($_)
+        }
+    }
+
+    for ($i = 0; $i -lt $output.Count; $i++) {
+        $output[$i] = ConvertTo-BridgeValue $output[$i]
+    }
+
+    if ($output.Count -eq 0) {
+        return $null
+    }
+    if ($output.Count -eq 1) {
+        if ($output[0] -is [array]) {
+            # An operation that returned one array: keep it an array
+            return , $output[0]
+        }
+        return $output[0]
+    }
+    return , $output.ToArray()
+}
+
+# Apply a request's environment variables, returning the previous values
+# for Restore-RequestEnv
+function Set-RequestEnv {
+    param($Variables)
+
+    $saved = @{}
+    if ($null -eq $Variables) {
+        return $saved
+    }
+    foreach ($property in $Variables.PSObject.Properties) {
+        $saved[$property.Name] = [Environment]::GetEnvironmentVariable($property.Name)
+        [Environment]::SetEnvironmentVariable($property.Name, [string] $property.Value)
+    }
+    return $saved
+}
+
+function Restore-RequestEnv {
+    param([hashtable] $Saved)
+
+    foreach ($name in $Saved.Keys) {
+        # $null removes variables the request introduced
+        [Environment]::SetEnvironmentVariable($name, $Saved[$name])
+    }
+}
+
+# Move to a request's working directory, if it has one. The .NET current
+# directory follows, so relative paths mean the same to cmdlets and to
+# .NET methods. Returns whether Pop-Location is due.
+function Enter-RequestDir {
+    param([string] $Dir)
+
+    if ([string]::IsNullOrEmpty($Dir)) {
+        return $false
+    }
+    Push-Location -LiteralPath $Dir -StackName Bridge -ErrorAction Stop
+    [Environment]::CurrentDirectory = $PWD.ProviderPath
+    return $true
+}
+
+# What the session looked like before its first request, for
+# Reset-BridgeState to return it to
+$script:Baseline = $null
+
+function Save-BridgeBaseline {
+    $script:Baseline = @{
+        Variables = @(Get-Variable -Scope Global | ForEach-Object Name)
+        Functions = @(Get-ChildItem Function: | ForEach-Object Name)
+        Modules   = @(Get-Module | ForEach-Object Name)
+        Env       = [Environment]::GetEnvironmentVariables()
+        Location  = $PWD.Path
+    }
+}
+
+# Undo what operations left behind since Save-BridgeBaseline: global
+# variables and functions they defined, modules they imported, environment
+# variables they set and the location they moved to. The errors they
+# recorded and the cursors of paged results go too. Returns what was
+# removed or put back.
+function Reset-BridgeState {
+    $base = $script:Baseline
+    $variables = @(Get-Variable -Scope Global | Where-Object Name -NotIn $base.Variables | ForEach-Object Name)
+    foreach ($name in $variables) {
+        Remove-Variable -Name $name -Scope Global -Force -ErrorAction Ignore
+    }
+    $functions = @(Get-ChildItem Function: | Where-Object Name -NotIn $base.Functions | ForEach-Object Name)
+    foreach ($name in $functions) {
+        Remove-Item -LiteralPath "Function:\$name" -Force -ErrorAction Ignore
+    }
+    $modules = @(Get-Module | Where-Object Name -NotIn $base.Modules)
+    $modules | Remove-Module -Force -ErrorAction Ignore
+
+    $changed = [System.Collections.Generic.List[string]]::new()
+    $current = [Environment]::GetEnvironmentVariables()
+    foreach ($name in @($current.Keys)) {
+        if (-not $base.Env.Contains($name)) {
+            [Environment]::SetEnvironmentVariable($name, $null)
+            $changed.Add($name)
+        }
+    }
+    foreach ($name in $base.Env.Keys) {
+        if ($current[$name] -cne $base.Env[$name]) {
+            [Environment]::SetEnvironmentVariable($name, $base.Env[$name])
+            $changed.Add($name)
+        }
+    }
+
+    Set-Location -LiteralPath $base.Location
+    [Environment]::CurrentDirectory = $PWD.ProviderPath
+    $global:Error.Clear()
+    $script:PageCursors.Clear()
+
+    return [ordered]@{
+        variables = $variables
+        functions = $functions
+        modules   = @($modules | ForEach-Object Name)
+        env       = $changed.ToArray()
+    }
+}
+
+# The protocol versions this script serves, and what it can do beyond the
+# base protocol. A client outside the range is refused before anything
+# else, so neither side misreads the other's messages.
+$script:ProtocolVersion = 1
+$script:MinProtocolVersion = 1
+$script:Capabilities = @("length-framing", "sentinels", "console-encoding", "pipeline", "sealed-secrets", "host-info", "reset-state", "session-variables")
+$script:ClientCapabilities = @()
+
+if ($Protocol -ne 0 -and ($Protocol -lt $script:MinProtocolVersion -or $Protocol -gt $script:ProtocolVersion)) {
+    Write-Message @{
+        type  = "error"
+        error = @{
+            type     = "System.NotSupportedException"
+            message  = "protocol version mismatch: client speaks $Protocol, script speaks $($script:MinProtocolVersion) to $($script:ProtocolVersion)"
+            category = "InvalidArgument"
+            errorId  = "ProtocolVersionMismatch"
+        }
+    }
+    exit 1
+}
+
+if ($Router) {
+    . $Router
+}
+
+if ($Endpoint) {
+    try {
+        Connect-Endpoint
+    }
+    catch {
+        Write-Message @{ type = "error"; error = (ConvertTo-BridgeError $_) }
+        exit 1
+    }
+}
+
+if ($Session) {
+    if ($PipeName) {
+        Connect-Pipes $PipeName
+    }
+    Save-BridgeBaseline
+
+    # One request per message in; its stream and result messages out, tagged
+    # with the request's id
+    while ($null -ne ($line = Read-Request)) {
+        if ([string]::IsNullOrWhiteSpace($line)) {
+            continue
+        }
+        $script:CurrentId = $null
+
+        try {
+            $request = $line | ConvertFrom-Json
+            $script:CurrentId = $request.id
+
+            switch ($request.op) {
+                # Built-in protocol operations, answered by the loop itself
+                "hello" {
+                    $script:ClientCapabilities = @($request.data.capabilities)
+                    Write-Message @{
+                        type = "result"
+                        data = [ordered]@{
+                            version      = $script:ProtocolVersion
+                            minVersion   = $script:MinProtocolVersion
+                            capabilities = $script:Capabilities
+                        }
+                    }
+                }
+                "ping" {
+                    Write-Message @{ type = "result"; data = @{ pong = $true } }
+                }
+                "reset" {
+                    Write-Message @{ type = "result"; data = (Reset-BridgeState) }
+                }
+                # A global variable for the operations after it; as part of
+                # the baseline, resetting the state keeps it
+                "set-variable" {
+                    $name = [string] $request.data.name
+                    Set-Variable -Name $name -Value (ConvertFrom-BridgeValue $request.data.value) -Scope Global -Force
+                    if ($name -notin $script:Baseline.Variables) {
+                        $script:Baseline.Variables += $name
+                    }
+                    Write-Message @{ type = "result"; data = @{ name = $name } }
+                }
+                "framing" {
+                    $wanted = $request.data.framing
+                    if ($wanted -notin @("ndjson", "length")) {
+                        throw "Unsupported framing: $wanted"
+                    }
+
+                    # Acknowledge in the old framing, then switch. Go waits
+                    # for this reply before sending anything else, so no
+                    # framed bytes can be stuck in the console reader.
+                    Write-Message @{ type = "result"; data = @{ framing = $wanted } }
+                    if ($wanted -eq "length" -and $null -eq $script:InStream) {
+                        $script:InStream = [Console]::OpenStandardInput()
+                        $script:OutStream = [Console]::OpenStandardOutput()
+                    }
+                    $script:Framing = $wanted
+                }
+                # Sent by Close after the last request, so everything before
+                # it has been answered already
+                "quit" {
+                    Write-Message @{ type = "result"; data = @{ quit = $true } }
+                    exit 0
+                }
+                default {
+                    $saved = Set-RequestEnv $request.env
+                    $moved = $false
+                    try {
+                        $moved = Enter-RequestDir $request.dir
+                        $result = Invoke-Captured -Name $request.op -Data $request.data
+                    }
+                    finally {
+                        if ($moved) {
+                            Pop-Location -StackName Bridge
+                            [Environment]::CurrentDirectory = $PWD.ProviderPath
+                        }
+                        Restore-RequestEnv $saved
+                        # Isolated requests leave the next as they found it
+                        if ($request.isolate) {
+                            $null = Reset-BridgeState
+                        }
+                    }
+                    Write-Message @{ type = "result"; data = $result }
+                }
+            }
+        }
+        catch {
+            Write-Message @{ type = "error"; error = (ConvertTo-BridgeError $_) }
+        }
+    }
+    exit 0
+}
+
+# Pipeline mode: each input object is handed on as soon as its line
+# arrives, and each output object goes back as an item message as soon as
+# the operation writes it
+function Read-PipelineInput {
+    while ($null -ne ($line = [Console]::In.ReadLine())) {
+        if ([string]::IsNullOrWhiteSpace($line)) {
+            continue
+        }
+        # The comma keeps an array item whole
+        , (ConvertFrom-BridgeValue (ConvertFrom-Json -InputObject $line))
+    }
+}
+
+if ($Pipeline) {
+    $VerbosePreference = "Continue"
+    $DebugPreference = "Continue"
+    $InformationPreference = "Continue"
+
+    $forward = {
+        if (-not (Write-StreamRecord $_)) {
+            Write-Message @{ type = "item"; data = (ConvertTo-BridgeValue $_) }
+        }
+    }
+    try {
+        $first = [Console]::In.ReadLine()
+        if ([string]::IsNullOrWhiteSpace($first)) {
+            throw "No JSON received on stdin."
+        }
+        $data = ConvertFrom-BridgeValue ($first | ConvertFrom-Json)
+
+        $handler = Get-OperationHandler $Operation
+        if ($handler -is [scriptblock]) {
+            Read-PipelineInput | & $handler $data *>&1 | ForEach-Object -Process $forward
+        }
+        else {
+            Read-PipelineInput | & $handler -Data $data *>&1 | ForEach-Object -Process $forward
+        }
+    }
+    catch {
+        Write-Message @{ type = "error"; error = (ConvertTo-BridgeError $_) }
+        exit 1
+    }
+    Write-Message @{ type = "result"; data = $null }
+    exit 0
+}
+
+# One-shot mode: the same messages as a session, for a single request, then
+# exit 1 if it failed
+try {
+    # Read all stdin as a single string, unless the request is elsewhere
+    if ($InputFile -like "env:*") {
+        $name = $InputFile.Substring(4)
+        $inputJson = [Environment]::GetEnvironmentVariable($name)
+        Remove-Item "Env:$name"
+    }
+    elseif ($InputFile) {
+        $inputJson = [System.IO.File]::ReadAllText($InputFile, [System.Text.UTF8Encoding]::new($false))
+    }
+    else {
+        $inputJson = [Console]::In.ReadToEnd()
+    }
+
+    if ([string]::IsNullOrWhiteSpace($inputJson)) {
+        throw "No JSON received on stdin."
+    }
+
+    # Parse JSON into a PowerShell object
+    $obj = $inputJson | ConvertFrom-Json
+
+    $result = Invoke-Captured -Name $Operation -Data $obj
+}
+catch {
+    Write-Message @{ type = "error"; error = (ConvertTo-BridgeError $_) }
+    exit 1
+}
+
+Write-Message @{ type = "result"; data = $result }
+exit 0
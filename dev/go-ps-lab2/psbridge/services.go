@@ -0,0 +1,167 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operations the shim serves for Windows services
+const (
+	ServicesOp       = "services"
+	ServiceControlOp = "service-control"
+)
+
+// ServiceStatus is a ServiceControllerStatus name
+type ServiceStatus string
+
+// Service statuses
+const (
+	ServiceStopped         ServiceStatus = "Stopped"
+	ServiceStartPending    ServiceStatus = "StartPending"
+	ServiceStopPending     ServiceStatus = "StopPending"
+	ServiceRunning         ServiceStatus = "Running"
+	ServiceContinuePending ServiceStatus = "ContinuePending"
+	ServicePausePending    ServiceStatus = "PausePending"
+	ServicePaused          ServiceStatus = "Paused"
+)
+
+// ServiceStartType is how a service is started, as Set-Service
+// -StartupType names it
+type ServiceStartType string
+
+// Service start types. AutomaticDelayedStart can only be set from
+// PowerShell 7.
+const (
+	StartAutomatic        ServiceStartType = "Automatic"
+	StartAutomaticDelayed ServiceStartType = "AutomaticDelayedStart"
+	StartManual           ServiceStartType = "Manual"
+	StartDisabled         ServiceStartType = "Disabled"
+	StartBoot             ServiceStartType = "Boot"
+	StartSystem           ServiceStartType = "System"
+)
+
+// Service is one Windows service
+type Service struct {
+	Name        string           `json:"name"`
+	DisplayName string           `json:"displayName"`
+	Description string           `json:"description,omitempty"`
+	Status      ServiceStatus    `json:"status"`
+	StartType   ServiceStartType `json:"startType"`
+	// Account is the user the service logs on as, e.g. LocalSystem or
+	// NT AUTHORITY\LocalService
+	Account string `json:"account,omitempty"`
+	// Path is the command line the service runs
+	Path string `json:"path,omitempty"`
+	// DependsOn are the services that must run before this one
+	DependsOn []string `json:"dependsOn"`
+	// Dependents are the services that depend on this one
+	Dependents          []string `json:"dependents"`
+	CanStop             bool     `json:"canStop"`
+	CanPauseAndContinue bool     `json:"canPauseAndContinue"`
+}
+
+// ServiceConfig is what SetService changes; empty fields are left as they
+// are
+type ServiceConfig struct {
+	DisplayName string           `json:"displayName,omitempty"`
+	Description string           `json:"description,omitempty"`
+	StartType   ServiceStartType `json:"startType,omitempty"`
+}
+
+// Actions ServiceControlOp takes
+const (
+	serviceStart   = "start"
+	serviceStop    = "stop"
+	serviceRestart = "restart"
+	serviceSet     = "set"
+	serviceWait    = "wait"
+)
+
+type servicesRequest struct {
+	Names []string `json:"names,omitempty"`
+}
+
+type serviceControlRequest struct {
+	Name   string         `json:"name"`
+	Action string         `json:"action"`
+	Force  bool           `json:"force,omitempty"`
+	Config *ServiceConfig `json:"config,omitempty"`
+	Status ServiceStatus  `json:"status,omitempty"`
+	// TimeoutMs bounds a wait; 0 waits for as long as the call may run
+	TimeoutMs int64 `json:"timeoutMs,omitempty"`
+}
+
+// ErrServiceWait is returned by WaitForService when the service didn't
+// reach the status in time
+var ErrServiceWait = errors.New("psbridge: service didn't reach status")
+
+// Services lists the services whose names match any of names, which may
+// hold wildcards, or every service without any. A name without wildcards
+// that matches nothing fails with an ObjectNotFound *PSError.
+func Services(ctx context.Context, inv Invoker, names []string, opts ...CallOption) ([]Service, error) {
+	return InvokeContext[servicesRequest, []Service](ctx, inv, ServicesOp, servicesRequest{Names: names}, opts...)
+}
+
+// GetService reads the service called name
+func GetService(ctx context.Context, inv Invoker, name string, opts ...CallOption) (*Service, error) {
+	services, err := Services(ctx, inv, []string{name}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) != 1 {
+		return nil, fmt.Errorf("psbridge: %d services match %s", len(services), name)
+	}
+	return &services[0], nil
+}
+
+// controlService runs one action against name and returns the service
+// as it is afterwards
+func controlService(ctx context.Context, inv Invoker, req serviceControlRequest, opts []CallOption) (*Service, error) {
+	svc, err := InvokeContext[serviceControlRequest, *Service](ctx, inv, ServiceControlOp, req, opts...)
+	if err == nil && svc == nil {
+		err = fmt.Errorf("psbridge: no service returned for %s", req.Name)
+	}
+	return svc, err
+}
+
+// StartService starts name and waits until it runs
+func StartService(ctx context.Context, inv Invoker, name string, opts ...CallOption) (*Service, error) {
+	return controlService(ctx, inv, serviceControlRequest{Name: name, Action: serviceStart}, opts)
+}
+
+// StopService stops name and waits until it has. Services depending on it
+// are only stopped too with force; otherwise it fails if any run.
+func StopService(ctx context.Context, inv Invoker, name string, force bool, opts ...CallOption) (*Service, error) {
+	return controlService(ctx, inv, serviceControlRequest{Name: name, Action: serviceStop, Force: force}, opts)
+}
+
+// RestartService stops name and starts it again, force as for StopService
+func RestartService(ctx context.Context, inv Invoker, name string, force bool, opts ...CallOption) (*Service, error) {
+	return controlService(ctx, inv, serviceControlRequest{Name: name, Action: serviceRestart, Force: force}, opts)
+}
+
+// SetService changes how name is configured
+func SetService(ctx context.Context, inv Invoker, name string, cfg ServiceConfig, opts ...CallOption) (*Service, error) {
+	return controlService(ctx, inv, serviceControlRequest{Name: name, Action: serviceSet, Config: &cfg}, opts)
+}
+
+// WaitForService waits up to timeout for name to reach status, or for as
+// long as ctx allows with no timeout. If it doesn't, the service is
+// returned as it was with an error wrapping ErrServiceWait.
+func WaitForService(ctx context.Context, inv Invoker, name string, status ServiceStatus, timeout time.Duration, opts ...CallOption) (*Service, error) {
+	svc, err := controlService(ctx, inv, serviceControlRequest{
+		Name:      name,
+		Action:    serviceWait,
+		Status:    status,
+		TimeoutMs: (timeout + time.Millisecond - 1).Milliseconds(),
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if svc.Status != status {
+		return svc, fmt.Errorf("%w: %s is %s after %v, not %s", ErrServiceWait, name, svc.Status, timeout, status)
+	}
+	return svc, nil
+}
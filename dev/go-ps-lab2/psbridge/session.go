@@ -0,0 +1,636 @@
+package psbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is one long-lived PowerShell process serving requests as
+// newline-delimited JSON, or length-prefixed frames (see WithFraming).
+// Requests carry a correlation ID, so several goroutines can have calls in
+// flight at once; the script works through them in order and a reader
+// goroutine routes each reply to its caller.
+type Session struct {
+	operation string
+	hooks     hooks
+	timeouts  Timeouts
+	numbers   NumberMode
+	strict    bool
+	// depth and checkDepth are the client's JSONDepth and CheckTruncation
+	depth      int
+	checkDepth bool
+	// isolate is the client's Isolate
+	isolate bool
+
+	cmd *exec.Cmd
+	// stdin and stdout carry the protocol; with TransportNamedPipe they are
+	// the pipes rather than the process's own streams
+	stdin  io.WriteCloser
+	stdout *msgReader
+	stderr *syncBuffer
+
+	// framing is fixed before the reader starts
+	framing Framing
+	// sealKey encrypts SecureStrings for this process; nil over SSH
+	sealKey []byte
+	// host is what the script reported at start
+	host *HostInfo
+	// capabilities are what the script said it has in the handshake
+	capabilities []string
+
+	// writeMu keeps request messages from interleaving
+	writeMu sync.Mutex
+	nextID  atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]*pendingCall
+	closed  bool
+	// err is set once the process is unusable, e.g. killed by a timeout
+	err error
+	// idle ends the session after Timeouts.SessionIdle with nothing
+	// pending; nil without one
+	idle *time.Timer
+
+	// exited is closed once the process is gone; waitErr is then its exit
+	// status
+	exited  chan struct{}
+	waitErr error
+	// unwatch stops the start's context killing the process, reporting
+	// whether it was in time
+	unwatch func() bool
+}
+
+// pendingCall is a request waiting for its result
+type pendingCall struct {
+	op      string
+	secrets []string
+	start   time.Time
+	b       replyBuilder
+	done    chan error
+}
+
+// StartSession launches the client's script with -Session and keeps it
+// running until Close. The script is passed the same way as for Invoke, and
+// messages travel over the client's Transport. Only Timeouts.SessionStart
+// bounds it; see StartSessionContext.
+func (c *Client) StartSession() (*Session, error) {
+	return c.StartSessionContext(context.Background())
+}
+
+// StartSessionContext is StartSession bounded by ctx, and by
+// Timeouts.SessionStart if it is set. A script that hasn't shaken hands
+// and passed the start checks by then, say one stuck before answering, is
+// killed and a *TimeoutError returned. ctx only covers the start: ending it
+// later leaves the session running.
+func (c *Client) StartSessionContext(ctx context.Context) (*Session, error) {
+	if d := c.Timeouts.SessionStart; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	s, err := c.startSession(ctx)
+	if err == nil && !s.unwatch() {
+		// ctx ended just as the start finished
+		s.abort()
+		err = ctx.Err()
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, &TimeoutError{Op: "start session", Err: ctx.Err()}
+	}
+	return s, err
+}
+
+// startSession is StartSessionContext, with ctx killing the process
+func (c *Client) startSession(ctx context.Context) (*Session, error) {
+	if c.SSH != nil && c.Transport != TransportStdio {
+		return nil, errSSHTransport
+	}
+	var s *Session
+	var err error
+	switch c.Transport {
+	case TransportStdio:
+		s, err = c.startStdioSession(ctx)
+	case TransportNamedPipe:
+		s, err = c.startPipeSession(ctx)
+	default:
+		return nil, fmt.Errorf("psbridge: unknown transport %v", c.Transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Isolate && !s.HasCapability(CapResetState) {
+		s.abort()
+		return nil, errNoReset
+	}
+	if s.HasCapability(CapHostInfo) {
+		if s.host, err = GetHostInfo(ctx, s); err != nil {
+			s.abort()
+			return nil, err
+		}
+	}
+	if len(c.RequiredModules) > 0 {
+		var err error
+		if c.ModuleInstall != nil {
+			_, err = InstallModules(ctx, s, c.RequiredModules, *c.ModuleInstall)
+		} else {
+			_, err = EnsureModules(ctx, s, c.RequiredModules)
+		}
+		if err != nil {
+			s.abort()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// startStdioSession runs the protocol over the process's stdin and stdout
+func (c *Client) startStdioSession(ctx context.Context) (*Session, error) {
+	if c.Mode == ExecStdin {
+		return nil, fmt.Errorf("%w; sessions need TransportNamedPipe", errStdinTaken)
+	}
+	cmd, err := c.sessionCommand(ctx, Param{Name: "Session", Switch: true})
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+
+	s, err := c.startProcess(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.serve(c, stdin, newTextDecoder(stdout, c.Encoding)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// startProcess starts cmd, capturing its stderr, and watches for it to exit,
+// and for ctx to end before the session's start is done
+func (c *Client) startProcess(ctx context.Context, cmd *exec.Cmd) (*Session, error) {
+	h := c.hooks()
+	stderr := &syncBuffer{}
+	var flushStderr func()
+	cmd.Stderr, flushStderr = h.stderr(cmd, stderr)
+
+	var key []byte
+	if c.SSH == nil {
+		var err error
+		if key, err = newSealKey(); err != nil {
+			return nil, err
+		}
+		cmd.Env = withSealKey(cmd.Env, key)
+	}
+
+	if err := c.startCommand(cmd); err != nil {
+		return nil, fmt.Errorf("start powershell: %w", err)
+	}
+	h.started(cmd, true)
+
+	s := &Session{
+		operation:  c.Operation,
+		hooks:      h,
+		timeouts:   c.Timeouts,
+		numbers:    c.Numbers,
+		strict:     c.Strict,
+		depth:      c.JSONDepth,
+		checkDepth: c.CheckTruncation,
+		isolate:    c.Isolate,
+		cmd:        cmd,
+		stderr:     stderr,
+		sealKey:    key,
+		pending:    map[string]*pendingCall{},
+		exited:     make(chan struct{}),
+	}
+	go func() {
+		s.waitErr = waitCommand(cmd)
+		flushStderr()
+		h.exited(cmd, true, s.waitErr)
+		close(s.exited)
+	}()
+	// Killing the process is what unblocks reading a handshake that never
+	// comes
+	s.unwatch = context.AfterFunc(ctx, func() { killTree(cmd) })
+	return s, nil
+}
+
+// serve attaches the protocol streams, shakes hands with the script, agrees
+// on framing and starts routing replies. On failure the process is killed.
+func (s *Session) serve(c *Client, w io.WriteCloser, r io.Reader) error {
+	s.stdin = w
+	s.stdout = &msgReader{r: bufio.NewReader(r), limits: c.Limits, sentinels: c.Sentinels}
+
+	if err := s.handshake(); err != nil {
+		s.abort()
+		return err
+	}
+	if c.Framing != FramingNDJSON && s.HasCapability(CapLengthFraming) {
+		if err := s.negotiateFraming(c.Framing); err != nil {
+			s.abort()
+			return err
+		}
+		// Frames are written to the raw stream, not as text
+		if d, ok := r.(*textDecoder); ok && s.framing != FramingNDJSON {
+			d.passthrough()
+		}
+	}
+
+	go s.readLoop()
+	if d := s.timeouts.SessionIdle; d > 0 {
+		s.idle = time.AfterFunc(d, s.idleOut)
+	}
+	return nil
+}
+
+// abort kills a session that never got going
+func (s *Session) abort() {
+	s.unwatch()
+	if s.stdin != nil {
+		s.stdin.Close()
+	}
+	killTree(s.cmd)
+	<-s.exited
+}
+
+// Framing reports the message framing the session agreed on
+func (s *Session) Framing() Framing { return s.framing }
+
+// HostInfo describes the session's PowerShell as it reported at start, so
+// callers can check versions and features without a round trip. It is nil
+// for a script without CapHostInfo.
+func (s *Session) HostInfo() *HostInfo { return s.host }
+
+// Invoke sends req to the session's operation and decodes the reply
+func (s *Session) Invoke(req Request, opts ...CallOption) (Response, error) {
+	return s.InvokeContext(context.Background(), req, opts...)
+}
+
+// InvokeContext is Invoke bounded by ctx. There is no way to interrupt a
+// single request inside the process, so if ctx is done first the whole
+// session is killed: calls in flight and every later call fail with the
+// same *TimeoutError.
+func (s *Session) InvokeContext(ctx context.Context, req Request, opts ...CallOption) (Response, error) {
+	return InvokeContext[Request, Response](ctx, s, s.operation, req, opts...)
+}
+
+// InvokeBatch sends all of reqs to the session's operation in one message
+func (s *Session) InvokeBatch(ctx context.Context, reqs []Request, opts ...CallOption) ([]BatchResult[Response], error) {
+	return InvokeBatch[Request, Response](ctx, s, s.operation, reqs, opts...)
+}
+
+// Do sends call to the session and waits for its reply, bounded by the
+// client's Timeouts
+func (s *Session) Do(ctx context.Context, call *Call) (*Result, error) {
+	return s.timeouts.run(ctx, call, func(ctx context.Context) (*Result, error) {
+		return s.roundTrip(ctx, call)
+	})
+}
+
+// roundTrip writes one request and waits for the reader to deliver
+// its result
+func (s *Session) roundTrip(ctx context.Context, call *Call) (*Result, error) {
+	op := call.Op
+	id := strconv.FormatUint(s.nextID.Add(1), 10)
+	if call.ReplaceEnv {
+		return nil, fmt.Errorf("%w: a session can only add variables", ErrEnvUnsupported)
+	}
+	if call.Isolate && !s.HasCapability(CapResetState) {
+		return nil, errNoReset
+	}
+	data, err := seal(call.Data, s.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := json.Marshal(wireRequest{ID: id, Op: op, Data: data, Env: call.Env, Dir: call.Dir, Isolate: call.Isolate || s.isolate})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, &TimeoutError{Op: op, Err: err}
+	}
+
+	p := &pendingCall{
+		op:      op,
+		secrets: call.Secrets,
+		start:   time.Now(),
+		b:       *newReplyBuilder(call),
+		done:    make(chan error, 1),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.pending[id] = p
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.mu.Unlock()
+
+	if err := s.writeMessage(msg); err != nil {
+		s.forget(id)
+		err = s.fail(s.processError("write request", s.exitCause(err)))
+		s.responded(id, p, nil, err)
+		return nil, err
+	}
+	s.hooks.RequestSent(RequestEvent{
+		Op:      op,
+		ID:      id,
+		PID:     s.cmd.Process.Pid,
+		Size:    len(call.Data),
+		Payload: s.hooks.payload(call.Data, call.Secrets),
+	})
+
+	select {
+	case err := <-p.done:
+		if err != nil {
+			s.responded(id, p, nil, err)
+			return nil, err
+		}
+		p.b.res.numbers = s.numbers
+		p.b.res.strict = s.strict
+		s.responded(id, p, &p.b.res, nil)
+		if err := checkTruncated(op, &p.b.res, s.checkDepth, s.depth); err != nil {
+			return nil, err
+		}
+		return &p.b.res, nil
+	case <-ctx.Done():
+		s.forget(id)
+		err := s.fail(&TimeoutError{Op: op, Err: ctx.Err()})
+		killTree(s.cmd)
+		s.responded(id, p, nil, err)
+		return nil, err
+	}
+}
+
+// responded reports how call id ended
+func (s *Session) responded(id string, p *pendingCall, res *Result, err error) {
+	ev := ResponseEvent{Op: p.op, ID: id, PID: s.cmd.Process.Pid, Duration: time.Since(p.start), Err: err}
+	if res != nil {
+		ev.Size = int(res.Size())
+		ev.Payload = s.hooks.resultPayload(res, p.secrets)
+	}
+	s.hooks.ResponseReceived(ev)
+}
+
+// readLoop routes replies to their pending calls until stdout ends, then
+// fails whatever is still waiting
+func (s *Session) readLoop() {
+	for {
+		msg, err := s.readMessage()
+		var limitErr *OutputLimitError
+		if errors.As(err, &limitErr) {
+			// The rest of the message is still on the wire, so nothing
+			// after it can be read
+			s.failPending(s.fail(err))
+			killTree(s.cmd)
+			return
+		}
+		if err != nil {
+			s.failPending(s.fail(s.processError("read reply", s.exitCause(err))))
+			return
+		}
+
+		reply, err := msg.reply()
+		if err != nil {
+			s.failPending(s.fail(err))
+			killTree(s.cmd)
+			return
+		}
+
+		s.mu.Lock()
+		p := s.pending[reply.ID]
+		s.mu.Unlock()
+		if p == nil {
+			// The caller gave up on this call already
+			continue
+		}
+
+		if done, err := p.b.add(reply); done {
+			s.forget(reply.ID)
+			p.done <- err
+		}
+	}
+}
+
+// forget drops a pending call, starting the idle timeout over if it was
+// the last one
+func (s *Session) forget(id string) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	if len(s.pending) == 0 && s.idle != nil {
+		s.idle.Reset(s.timeouts.SessionIdle)
+	}
+	s.mu.Unlock()
+}
+
+// idleOut ends the session once it has been idle for SessionIdle, by
+// closing stdin as Close does; Close still has to be called
+func (s *Session) idleOut() {
+	s.mu.Lock()
+	if len(s.pending) > 0 || s.closed || s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = fmt.Errorf("%w for %v", ErrSessionIdle, s.timeouts.SessionIdle)
+	s.mu.Unlock()
+
+	s.writeMu.Lock()
+	s.stdin.Close()
+	s.writeMu.Unlock()
+}
+
+// fail marks the session unusable, keeping the first reason, and returns
+// that reason
+func (s *Session) fail(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		if s.closed {
+			s.err = ErrSessionClosed
+		} else {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// failPending wakes every pending call with err
+func (s *Session) failPending(err error) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = map[string]*pendingCall{}
+	s.mu.Unlock()
+
+	for _, p := range pending {
+		p.done <- err
+	}
+}
+
+// exitCause swaps an I/O error for the exit status when the process is on
+// its way out, since that says more than a broken pipe
+func (s *Session) exitCause(err error) error {
+	select {
+	case <-s.exited:
+		if s.waitErr != nil {
+			return s.waitErr
+		}
+	case <-time.After(time.Second):
+	}
+	return err
+}
+
+// processError decorates an I/O failure with whatever the process left on
+// stderr, or turns an exit status into an *ExitError
+func (s *Session) processError(what string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return newExitError(s.cmd, err, s.stderr.Bytes())
+	}
+	if stderr := StderrText(s.stderr.Bytes()); stderr != "" {
+		return fmt.Errorf("%s: %w: %s", what, err, stderr)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// terminateGrace is how long a session gets to exit after SIGTERM before
+// it is killed
+const terminateGrace = 2 * time.Second
+
+// Close shuts the session down. New calls fail with ErrSessionClosed at
+// once, while calls already sent are left to finish: the script is sent a
+// quit request, which it reaches after them, and its stdin is closed. If
+// it hasn't exited by the time ctx is done, it is sent SIGTERM where there
+// is one and killed with its children shortly after; calls still in
+// flight then fail, and Close returns a *TimeoutError.
+func (s *Session) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	failed := s.err != nil
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.mu.Unlock()
+
+	// A hung script may not be reading stdin, so don't let the writes hold
+	// up the deadline
+	go func() {
+		if !failed {
+			id := strconv.FormatUint(s.nextID.Add(1), 10)
+			if msg, err := json.Marshal(wireRequest{ID: id, Op: opQuit}); err == nil {
+				s.writeMessage(msg)
+			}
+		}
+		s.writeMu.Lock()
+		s.stdin.Close()
+		s.writeMu.Unlock()
+	}()
+
+	select {
+	case <-s.exited:
+	case <-ctx.Done():
+		s.stop()
+		return &TimeoutError{Op: "close", Err: ctx.Err()}
+	}
+	if s.waitErr != nil && !failed {
+		return s.processError("wait for powershell", s.waitErr)
+	}
+	return nil
+}
+
+// stop asks the process to exit with SIGTERM, then kills its tree if that
+// isn't possible or it's still running after terminateGrace
+func (s *Session) stop() {
+	if terminate(s.cmd) == nil {
+		select {
+		case <-s.exited:
+			return
+		case <-time.After(terminateGrace):
+		}
+	}
+	killTree(s.cmd)
+	<-s.exited
+}
+
+// Ping round-trips a no-op through the session and reports how long it
+// took. Give ctx a deadline: a hung session never answers, and timing out
+// kills it.
+func (s *Session) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	res, err := s.roundTrip(ctx, &Call{Op: opPing})
+	if err != nil {
+		return 0, err
+	}
+
+	var pong struct {
+		Pong bool `json:"pong"`
+	}
+	if err := json.Unmarshal(res.Data, &pong); err != nil || !pong.Pong {
+		return 0, fmt.Errorf("psbridge: unexpected ping reply %s", res.Data)
+	}
+	return time.Since(start), nil
+}
+
+// healthy returns why the session can't take calls, or nil if it can
+func (s *Session) healthy() error {
+	select {
+	case <-s.exited:
+		if s.waitErr != nil {
+			return s.processError("powershell exited", s.waitErr)
+		}
+		return s.processError("powershell", errors.New("exited"))
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+	return s.err
+}
+
+// syncBuffer is a bytes.Buffer that exec can write to while we read it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of everything written so far
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
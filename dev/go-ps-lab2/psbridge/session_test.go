@@ -0,0 +1,99 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	s, err := fakeClient(t, "session").StartSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+
+	ctx := context.Background()
+	if _, err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	res, err := s.Do(ctx, &Call{Op: "echo", Data: json.RawMessage(`{"n":1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Data) != `{"n":1}` {
+		t.Errorf("Data = %s", res.Data)
+	}
+	var psErr *PSError
+	if _, err := s.Do(ctx, &Call{Op: "fail"}); !errors.As(err, &psErr) || psErr.ErrorID != "Fake" {
+		t.Errorf("fail: err = %v, want the script's PSError", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestStartSessionBounded(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(c *Client) (*Session, error)
+		opts  []Option
+	}{
+		{
+			name:  "SessionStart timeout",
+			start: (*Client).StartSession,
+			opts:  []Option{WithTimeouts(Timeouts{SessionStart: 200 * time.Millisecond})},
+		},
+		{
+			name: "context deadline",
+			start: func(c *Client) (*Session, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				defer cancel()
+				return c.StartSessionContext(ctx)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fakeClient(t, "mute", tt.opts...)
+			done := make(chan error, 1)
+			go func() {
+				_, err := tt.start(c)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				var timeout *TimeoutError
+				if !errors.As(err, &timeout) || !timeout.Timeout() {
+					t.Errorf("err = %v, want a *TimeoutError", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("start didn't give up on a script that never shakes hands")
+			}
+		})
+	}
+}
+
+func TestStartSessionContextOutlivesStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := fakeClient(t, "session").StartSessionContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(context.Background())
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := s.Ping(context.Background()); err != nil {
+		t.Errorf("session died with its start's context: %v", err)
+	}
+}
+
+func TestStartSessionExit(t *testing.T) {
+	_, err := fakeClient(t, "exit").StartSession()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("err = %v, want an *ExitError with code 3", err)
+	}
+}
@@ -0,0 +1,75 @@
+package psbridge
+
+import "context"
+
+// Operations the shim serves for reading the Alias:, Function: and
+// Variable: drives
+const (
+	AliasesOp   = "aliases"
+	FunctionsOp = "functions"
+	VariablesOp = "variables"
+)
+
+// Alias is one entry of the Alias: drive
+type Alias struct {
+	Name string `json:"name"`
+	// Definition is the command the alias runs
+	Definition string `json:"definition"`
+	// Module is the module that exported the alias, empty if one wasn't
+	Module      string `json:"module,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Options are its ScopedItemOptions flags, e.g. ReadOnly and AllScope
+	Options []string `json:"options"`
+}
+
+// Function is one entry of the Function: drive
+type Function struct {
+	Name string `json:"name"`
+	// CommandType is Function or Filter
+	CommandType string `json:"commandType"`
+	Module      string `json:"module,omitempty"`
+	// File is the script the function was defined in, empty for one
+	// defined at a prompt or from a string. A profile's path here is what
+	// gives away a function it added or replaced.
+	File    string   `json:"file,omitempty"`
+	Options []string `json:"options"`
+	// Definition is the function's body, only filled in when asked for
+	Definition string `json:"definition,omitempty"`
+	// Shim marks psbridge's own functions
+	Shim bool `json:"shim"`
+}
+
+// Variable is one variable of the global scope
+type Variable struct {
+	Name string `json:"name"`
+	// Type is the .NET type of the value, empty for $null
+	Type string `json:"type,omitempty"`
+	// Value is the value as PowerShell converts it to a string, cut to
+	// 1024 characters
+	Value       string   `json:"value"`
+	Module      string   `json:"module,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Options     []string `json:"options"`
+}
+
+type functionsRequest struct {
+	Definitions bool `json:"definitions"`
+}
+
+// Aliases lists the aliases inv's script can see
+func Aliases(ctx context.Context, inv Invoker, opts ...CallOption) ([]Alias, error) {
+	return InvokeContext[struct{}, []Alias](ctx, inv, AliasesOp, struct{}{}, opts...)
+}
+
+// Functions lists the functions inv's script can see, psbridge's own among
+// them, marked Shim. Definitions fills in each one's body.
+func Functions(ctx context.Context, inv Invoker, definitions bool, opts ...CallOption) ([]Function, error) {
+	return InvokeContext[functionsRequest, []Function](ctx, inv, FunctionsOp, functionsRequest{definitions}, opts...)
+}
+
+// Variables lists the variables of the global scope, where automatic and
+// preference variables live and where profiles and earlier calls on a
+// Session leave theirs
+func Variables(ctx context.Context, inv Invoker, opts ...CallOption) ([]Variable, error) {
+	return InvokeContext[struct{}, []Variable](ctx, inv, VariablesOp, struct{}{}, opts...)
+}
@@ -0,0 +1,41 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// psVariableName is a variable name that needs no braces in PowerShell
+var psVariableName = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// SetSessionVariable sets the global variable name in the session to
+// value, marshalled as Invoke marshals requests, so that context costly to
+// build, such as connection details or lookup tables, is sent once and
+// read by every later operation as $name. The variable is part of the
+// session's baseline: ResetState and isolated calls leave it, while a new
+// process, such as one a RestartingSession starts, doesn't have it.
+func (s *Session) SetSessionVariable(ctx context.Context, name string, value any) error {
+	if !psVariableName.MatchString(name) {
+		return fmt.Errorf("psbridge: %q isn't a PowerShell variable name", name)
+	}
+	if !s.HasCapability(CapSessionVars) {
+		return fmt.Errorf("%w: the script can't set variables (no %s capability)", ErrUnsupported, CapSessionVars)
+	}
+	v, err := MarshalPS(value)
+	if err != nil {
+		return fmt.Errorf("marshal variable %s: %w", name, err)
+	}
+	data, err := json.Marshal(struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	}{name, v})
+	if err != nil {
+		return fmt.Errorf("marshal variable %s: %w", name, err)
+	}
+	call := &Call{Op: opSetVariable, Data: data, Secrets: SecretKeys(reflect.TypeOf(value))}
+	_, err = s.roundTrip(ctx, call)
+	return err
+}
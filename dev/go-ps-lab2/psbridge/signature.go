@@ -0,0 +1,178 @@
+package psbridge
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrSignature is wrapped by the errors for scripts a SignaturePolicy
+// refuses
+var ErrSignature = errors.New("psbridge: script signature refused")
+
+// SignaturePolicy requires the scripts a client runs, its Script or
+// ScriptText and its Router, to carry a valid Authenticode signature by one
+// of a set of certificates. PowerShell checks each with
+// Get-AuthenticodeSignature before the first process runs it, which takes
+// Windows; a script's contents are checked once, and again whenever they
+// change. A policy may be shared by clients.
+//
+// The check is of the contents as they were read, so a file changed after
+// it and before PowerShell opens it isn't caught; ExecEncodedCommand and
+// ExecStdin run exactly the text that was checked.
+type SignaturePolicy struct {
+	// Thumbprints are the SHA-1 thumbprints of the certificates allowed to
+	// sign, as Windows shows them; case and spaces don't matter
+	Thumbprints []string
+	// Certificates are allowed to sign too
+	Certificates []*x509.Certificate
+
+	mu sync.Mutex
+	// verified holds the SHA-256 of contents that passed
+	verified map[[sha256.Size]byte]bool
+}
+
+// WithSignaturePolicy refuses to run scripts that p doesn't allow
+func WithSignaturePolicy(p *SignaturePolicy) Option {
+	return func(c *Client) { c.Signatures = p }
+}
+
+// RequireSigned refuses to run scripts not signed by one of the
+// certificates with these thumbprints
+func RequireSigned(thumbprints ...string) Option {
+	return WithSignaturePolicy(&SignaturePolicy{Thumbprints: thumbprints})
+}
+
+// allows reports whether the certificate with thumbprint may sign
+func (p *SignaturePolicy) allows(thumbprint string) bool {
+	thumbprint = normalizeThumbprint(thumbprint)
+	if thumbprint == "" {
+		return false
+	}
+	for _, t := range p.Thumbprints {
+		if normalizeThumbprint(t) == thumbprint {
+			return true
+		}
+	}
+	return slices.ContainsFunc(p.Certificates, func(cert *x509.Certificate) bool {
+		sum := sha1.Sum(cert.Raw)
+		return strings.ToUpper(hex.EncodeToString(sum[:])) == thumbprint
+	})
+}
+
+func normalizeThumbprint(t string) string {
+	return strings.ToUpper(strings.ReplaceAll(t, " ", ""))
+}
+
+// authenticodeStatus is what Get-AuthenticodeSignature says of a script
+type authenticodeStatus struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Thumbprint string `json:"thumbprint"`
+}
+
+// verifyScripts checks the client's scripts against its SignaturePolicy
+func (c *Client) verifyScripts(ctx context.Context) error {
+	p := c.Signatures
+	if p == nil {
+		return nil
+	}
+	// -File runs the script where it is, which over ssh is out of reach
+	// from here
+	if c.Mode == ExecFile && c.SSH != nil {
+		if err := c.verifyRemote(ctx, c.Script); err != nil {
+			return err
+		}
+	} else {
+		name := c.Script
+		var content []byte
+		if c.Mode == ExecFile || c.ScriptText == "" {
+			var err error
+			if content, err = os.ReadFile(c.Script); err != nil {
+				return fmt.Errorf("read script: %w", err)
+			}
+		} else {
+			name, content = "inline script", []byte(c.ScriptText)
+		}
+		if err := c.verifyContent(ctx, name, content); err != nil {
+			return err
+		}
+	}
+	if c.Router == "" {
+		return nil
+	}
+	if c.SSH != nil {
+		return c.verifyRemote(ctx, c.Router)
+	}
+	content, err := os.ReadFile(c.Router)
+	if err != nil {
+		return fmt.Errorf("read router: %w", err)
+	}
+	return c.verifyContent(ctx, c.Router, content)
+}
+
+// verifyContent checks a script's contents, unless they passed before
+func (c *Client) verifyContent(ctx context.Context, name string, content []byte) error {
+	p := c.Signatures
+	sum := sha256.Sum256(content)
+	p.mu.Lock()
+	ok := p.verified[sum]
+	p.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	ext := filepath.Ext(name)
+	if ext == "" || name == "inline script" {
+		ext = ".ps1"
+	}
+	script := "Get-AuthenticodeSignature -Content ([Convert]::FromBase64String(" + quotePS(base64.StdEncoding.EncodeToString(content)) + ")) -SourcePathOrExtension " + quotePS(ext)
+	if err := c.checkSignature(ctx, name, script); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.verified == nil {
+		p.verified = map[[sha256.Size]byte]bool{}
+	}
+	p.verified[sum] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// verifyRemote checks the script at path on the client's host, every time
+// since there is no telling whether it changed
+func (c *Client) verifyRemote(ctx context.Context, path string) error {
+	return c.checkSignature(ctx, path, "Get-AuthenticodeSignature -LiteralPath "+quotePS(path))
+}
+
+// checkSignature runs get, a Get-AuthenticodeSignature command, and holds
+// its answer against the policy
+func (c *Client) checkSignature(ctx context.Context, name, get string) error {
+	// The contents can be far past the command-line limit
+	out, err := c.runCode(ctx, get+" | ForEach-Object { [ordered]@{ status = [string] $_.Status; message = [string] $_.StatusMessage; thumbprint = [string] $_.SignerCertificate.Thumbprint } } | ConvertTo-Json -Compress", true)
+	if err != nil {
+		return fmt.Errorf("psbridge: check signature of %s: %w", name, err)
+	}
+	var st authenticodeStatus
+	if err := json.Unmarshal(out, &st); err != nil {
+		return fmt.Errorf("psbridge: check signature of %s: %w", name, err)
+	}
+	if st.Status != "Valid" {
+		return fmt.Errorf("%w: %s: %s (%s)", ErrSignature, name, st.Status, strings.TrimSpace(st.Message))
+	}
+	if !c.Signatures.allows(st.Thumbprint) {
+		return fmt.Errorf("%w: %s: signed by %s, which isn't an allowed signer", ErrSignature, name, st.Thumbprint)
+	}
+	return nil
+}
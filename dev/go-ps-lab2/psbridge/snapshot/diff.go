@@ -0,0 +1,144 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Kinds of Change
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is one item that differs between two snapshots
+type Change struct {
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	// Kind is Added, Removed or Changed
+	Kind string `json:"kind"`
+	// Old is the item before, nil if it was added
+	Old Item `json:"old,omitempty"`
+	// New is the item after, nil if it was removed
+	New Item `json:"new,omitempty"`
+	// Fields are the properties that changed, for a Changed item
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is one property of a changed item. Old is nil for a
+// property that was added, New for one that was removed.
+type FieldChange struct {
+	Name string `json:"name"`
+	Old  any    `json:"old"`
+	New  any    `json:"new"`
+}
+
+// Diff is every change from one snapshot to another, sorted by section and
+// key
+type Diff struct {
+	Changes []Change `json:"changes"`
+}
+
+// Compare diffs snapshot a with the later b. Sections only one of them has
+// are compared too, so narrowing a Spec shows as removals.
+func Compare(a, b *Snapshot) Diff {
+	d := Diff{Changes: []Change{}}
+	sections := map[string]bool{}
+	for name := range a.Sections {
+		sections[name] = true
+	}
+	for name := range b.Sections {
+		sections[name] = true
+	}
+	for _, section := range slices.Sorted(maps.Keys(sections)) {
+		old, cur := a.Sections[section], b.Sections[section]
+		keys := map[string]bool{}
+		for key := range old {
+			keys[key] = true
+		}
+		for key := range cur {
+			keys[key] = true
+		}
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			before, inA := old[key]
+			after, inB := cur[key]
+			switch {
+			case !inA:
+				d.Changes = append(d.Changes, Change{Section: section, Key: key, Kind: Added, New: after})
+			case !inB:
+				d.Changes = append(d.Changes, Change{Section: section, Key: key, Kind: Removed, Old: before})
+			default:
+				if fields := compareItems(before, after); len(fields) > 0 {
+					d.Changes = append(d.Changes, Change{Section: section, Key: key, Kind: Changed, Old: before, New: after, Fields: fields})
+				}
+			}
+		}
+	}
+	return d
+}
+
+func compareItems(a, b Item) []FieldChange {
+	names := map[string]bool{}
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	var fields []FieldChange
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		old, inA := a[name]
+		cur, inB := b[name]
+		if inA && inB && equal(old, cur) {
+			continue
+		}
+		fields = append(fields, FieldChange{Name: name, Old: old, New: cur})
+	}
+	return fields
+}
+
+// equal compares JSON values by their encoding, which sorts map keys
+func equal(a, b any) bool {
+	ea, errA := json.Marshal(a)
+	eb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ea) == string(eb)
+}
+
+// Empty reports whether the snapshots recorded the same state
+func (d Diff) Empty() bool { return len(d.Changes) == 0 }
+
+// String lists the changes a line each, + for added items, - for removed
+// ones and ~ for changed ones followed by their changed fields
+func (d Diff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		switch c.Kind {
+		case Added:
+			fmt.Fprintf(&b, "+ %s %s\n", c.Section, c.Key)
+		case Removed:
+			fmt.Fprintf(&b, "- %s %s\n", c.Section, c.Key)
+		case Changed:
+			fmt.Fprintf(&b, "~ %s %s\n", c.Section, c.Key)
+			for _, f := range c.Fields {
+				fmt.Fprintf(&b, "    %s: %s -> %s\n", f.Name, text(f.Old), text(f.New))
+			}
+		}
+	}
+	return b.String()
+}
+
+// text is v as compact JSON, or (none) for a missing property
+func text(v any) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
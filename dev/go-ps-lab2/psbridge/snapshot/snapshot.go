@@ -0,0 +1,215 @@
+// Package snapshot records provider state, such as a registry subtree,
+// services, certificates and the environment, in a canonical form, and
+// diffs two recordings for drift detection.
+//
+//	before, _ := snapshot.Take(ctx, session, spec)
+//	// ... run the provisioning script ...
+//	after, _ := snapshot.Take(ctx, session, spec)
+//	fmt.Print(snapshot.Compare(before, after))
+//
+// A snapshot is a set of sections, each a map from an item's key, such as
+// a registry key's path or a service's name, to its properties. Written
+// with Save, maps come out sorted, so equal state gives equal files.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+)
+
+// Section names
+const (
+	SectionRegistry     = "registry"
+	SectionServices     = "services"
+	SectionCertificates = "certificates"
+	SectionEnv          = "env"
+)
+
+// Spec chooses what Take records
+type Spec struct {
+	// Registry are subtrees to record, each key with its values
+	Registry []RegistryTree `json:"registry,omitempty"`
+	// Services records every service's status, start type and account
+	Services bool `json:"services,omitempty"`
+	// Certificates are store paths, e.g. Cert:\LocalMachine\My
+	Certificates []string `json:"certificates,omitempty"`
+	// Env records the environment variables
+	Env bool `json:"env,omitempty"`
+}
+
+// RegistryTree is a registry key and how many levels below it to record;
+// a negative Depth records all of them
+type RegistryTree struct {
+	Path  string `json:"path"`
+	Depth int    `json:"depth"`
+}
+
+// Item is one recorded thing's properties, as JSON values
+type Item map[string]any
+
+// Section is the items of one kind, by key
+type Section map[string]Item
+
+// Snapshot is recorded provider state
+type Snapshot struct {
+	Taken    time.Time          `json:"taken"`
+	Spec     Spec               `json:"spec"`
+	Sections map[string]Section `json:"sections"`
+}
+
+// Take records what spec names through inv
+func Take(ctx context.Context, inv psbridge.Invoker, spec Spec) (*Snapshot, error) {
+	s := &Snapshot{Taken: time.Now().UTC(), Spec: spec, Sections: map[string]Section{}}
+	for _, tree := range spec.Registry {
+		if err := s.addRegistry(ctx, inv, tree); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", tree.Path, err)
+		}
+	}
+	if spec.Services {
+		if err := s.addServices(ctx, inv); err != nil {
+			return nil, fmt.Errorf("snapshot services: %w", err)
+		}
+	}
+	for _, store := range spec.Certificates {
+		if err := s.addCertificates(ctx, inv, store); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", store, err)
+		}
+	}
+	if spec.Env {
+		env, err := psbridge.EnvSnapshot(ctx, inv)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot env: %w", err)
+		}
+		for name, value := range env {
+			if err := s.add(SectionEnv, name, map[string]string{"value": value}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// add records v, as its JSON properties, under key in section
+func (s *Snapshot) add(section, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	item, err := decodeItem(b)
+	if err != nil {
+		return err
+	}
+	if s.Sections[section] == nil {
+		s.Sections[section] = Section{}
+	}
+	s.Sections[section][key] = item
+	return nil
+}
+
+// decodeItem keeps numbers as json.Number, so they compare exactly
+func decodeItem(b []byte) (Item, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var item Item
+	if err := dec.Decode(&item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// registryValue is how a value is recorded as a property of its key
+type registryValue struct {
+	Kind psbridge.RegistryKind `json:"kind"`
+	Data any                   `json:"data"`
+}
+
+func (s *Snapshot) addRegistry(ctx context.Context, inv psbridge.Invoker, tree RegistryTree) error {
+	root, err := psbridge.RegistryTree(ctx, inv, tree.Path, psbridge.RegistryTreeOptions{Depth: tree.Depth, Values: true})
+	if err != nil {
+		return err
+	}
+	var walk func(k *psbridge.RegistryKey) error
+	walk = func(k *psbridge.RegistryKey) error {
+		if k.Error != "" {
+			return s.add(SectionRegistry, k.Path, map[string]string{"error": k.Error})
+		}
+		values := map[string]registryValue{}
+		for _, v := range k.Values {
+			name := v.Name
+			if name == "" {
+				name = "(default)"
+			}
+			values[name] = registryValue{Kind: v.Kind, Data: v.Data()}
+		}
+		if err := s.add(SectionRegistry, k.Path, values); err != nil {
+			return err
+		}
+		for i := range k.SubKeys {
+			if err := walk(&k.SubKeys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+func (s *Snapshot) addServices(ctx context.Context, inv psbridge.Invoker) error {
+	services, err := psbridge.Services(ctx, inv, nil)
+	if err != nil {
+		return err
+	}
+	for _, svc := range services {
+		if err := s.add(SectionServices, svc.Name, svc); err != nil {
+			return err
+		}
+		// It is the key already
+		delete(s.Sections[SectionServices][svc.Name], "name")
+	}
+	return nil
+}
+
+func (s *Snapshot) addCertificates(ctx context.Context, inv psbridge.Invoker, store string) error {
+	certs, err := psbridge.Certificates(ctx, inv, store, psbridge.CertificateOptions{})
+	if err != nil {
+		return err
+	}
+	for _, c := range certs {
+		if err := s.add(SectionCertificates, c.Path, c); err != nil {
+			return err
+		}
+		// It is the key already
+		delete(s.Sections[SectionCertificates][c.Path], "path")
+	}
+	return nil
+}
+
+// Save writes s as indented JSON with every map's keys sorted
+func (s *Snapshot) Save(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Load reads a snapshot Save wrote
+func Load(r io.Reader) (*Snapshot, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var s Snapshot
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	if s.Sections == nil {
+		s.Sections = map[string]Section{}
+	}
+	return &s, nil
+}
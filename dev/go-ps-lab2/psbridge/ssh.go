@@ -0,0 +1,98 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SSHHost is a remote machine running pwsh, reached with the system ssh
+// client. The script runs there exactly as it would locally, talking the
+// same protocol over the ssh connection's stdin and stdout.
+//
+// This is plain `ssh host pwsh …`, not PowerShell's own SSH remoting
+// (pwsh -sshs), so the host needs nothing beyond sshd and pwsh. The remote
+// login shell must be POSIX: the PowerShell command line is quoted for sh.
+type SSHHost struct {
+	// Host is the host name, or user@host
+	Host string
+	User string
+	// Port is the sshd port; zero leaves it to ssh's own configuration
+	Port int
+	// IdentityFile is the private key to log on with
+	IdentityFile string
+	// Options are extra arguments for ssh, e.g. "-o", "ConnectTimeout=5"
+	Options []string
+	// Binary is the ssh executable; defaults to ssh on PATH
+	Binary string
+}
+
+// WithSSH runs the client's script on host instead of locally. Shell then
+// names the remote executable, pwsh unless set. ExecEncodedCommand is the
+// mode to use, since it needs no copy of the script on the host; in
+// ExecFile mode Script is a path on the host.
+func WithSSH(host SSHHost) Option {
+	return func(c *Client) { c.SSH = &host }
+}
+
+// errSSHTransport is returned for sessions that ask for a transport ssh
+// can't carry
+var errSSHTransport = errors.New("psbridge: ssh hosts only support the stdio transport")
+
+// process creates the PowerShell process for shell and args in dir,
+// locally or through ssh
+func (c *Client) process(ctx context.Context, dir, shell string, args ...string) *exec.Cmd {
+	if c.SSH != nil {
+		return c.SSH.command(ctx, dir, shell, args)
+	}
+	cmd := newCommand(ctx, shell, args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// command wraps a PowerShell command line, run from dir on the host, in an
+// ssh invocation. Killing the ssh process drops the connection, which ends
+// the remote pwsh once it next touches its streams.
+func (h *SSHHost) command(ctx context.Context, dir, shell string, args []string) *exec.Cmd {
+	binary := h.Binary
+	if binary == "" {
+		binary = "ssh"
+	}
+
+	// -T: stdin is the protocol, so no tty; BatchMode: fail rather than
+	// prompt for a password nobody can type
+	sshArgs := []string{"-T", "-o", "BatchMode=yes"}
+	if h.User != "" {
+		sshArgs = append(sshArgs, "-l", h.User)
+	}
+	if h.Port != 0 {
+		sshArgs = append(sshArgs, "-p", strconv.Itoa(h.Port))
+	}
+	if h.IdentityFile != "" {
+		sshArgs = append(sshArgs, "-i", h.IdentityFile)
+	}
+	sshArgs = append(sshArgs, h.Options...)
+	sshArgs = append(sshArgs, "--", h.Host)
+
+	// ssh joins everything after the host into one string for the remote
+	// shell, so quote each word ourselves
+	remote := make([]string, 0, len(args)+1)
+	remote = append(remote, quoteSh(shell))
+	for _, arg := range args {
+		remote = append(remote, quoteSh(arg))
+	}
+	line := strings.Join(remote, " ")
+	if dir != "" {
+		line = "cd " + quoteSh(dir) + " && " + line
+	}
+	sshArgs = append(sshArgs, line)
+
+	return newCommand(ctx, binary, sshArgs...)
+}
+
+// quoteSh renders s as a single-quoted POSIX shell word
+func quoteSh(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
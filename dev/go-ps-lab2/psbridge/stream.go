@@ -0,0 +1,184 @@
+package psbridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// EventKind says what an Event carries
+type EventKind int
+
+const (
+	// EventOutput is the operation's output, in Event.Output
+	EventOutput EventKind = iota
+	// EventVerbose, EventWarning, EventDebug and EventInformation are a
+	// line written to that stream, in Event.Message
+	EventVerbose
+	EventWarning
+	EventDebug
+	EventInformation
+	// EventProgress is a Write-Progress record, in Event.Progress
+	EventProgress
+	// EventError is a non-terminating error, in Event.Error
+	EventError
+	// EventDone ends the events of a call, with Event.Err its error if it
+	// failed
+	EventDone
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventOutput:
+		return "output"
+	case EventVerbose:
+		return "verbose"
+	case EventWarning:
+		return "warning"
+	case EventDebug:
+		return "debug"
+	case EventInformation:
+		return "information"
+	case EventProgress:
+		return "progress"
+	case EventError:
+		return "error"
+	case EventDone:
+		return "done"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is one thing a running operation produced. Kind says which of the
+// other fields is set.
+type Event struct {
+	Kind EventKind
+	// Output is the operation's result as JSON
+	Output json.RawMessage
+	// Message is the line of a verbose, warning, debug or information
+	// record
+	Message  string
+	Progress *ProgressRecord
+	// Error is a non-terminating error the operation wrote
+	Error *PSError
+	// Err is why the call failed, nil if it didn't
+	Err error
+}
+
+// streamEvent is the Event for a stream reply other than progress
+func streamEvent(reply *wireReply) (Event, bool) {
+	switch reply.Stream {
+	case streamVerbose:
+		return Event{Kind: EventVerbose, Message: reply.Message}, true
+	case streamWarning:
+		return Event{Kind: EventWarning, Message: reply.Message}, true
+	case streamDebug:
+		return Event{Kind: EventDebug, Message: reply.Message}, true
+	case streamInformation:
+		return Event{Kind: EventInformation, Message: reply.Message}, true
+	case streamError:
+		err := reply.Error
+		if err == nil {
+			err = &PSError{Message: reply.Message}
+		}
+		return Event{Kind: EventError, Error: err}, true
+	}
+	return Event{}, false
+}
+
+// InvokeStream runs op with req as its payload and sends everything it
+// produces on the returned channel as it happens: stream records and
+// progress while it runs, then its output if it succeeded, then an
+// EventDone before the channel is closed. Read the channel to the end;
+// until an event is taken, the call waits, and on a Session so do all of
+// its other calls. Ending ctx unblocks the call and fails it.
+//
+// From an Invoker that doesn't report stream records as they arrive, such
+// as a Cache answering from memory, they all come just before the output.
+func InvokeStream[TReq any](ctx context.Context, inv Invoker, op string, req TReq, opts ...CallOption) (<-chan Event, error) {
+	data, err := MarshalPS(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	call := newCall(op, data, opts)
+	events := make(chan Event, 16)
+	// The call's own hooks still run
+	progress, stream := call.Progress, call.Stream
+	live := false
+	send := func(ev Event) {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	}
+	call.Progress = func(p ProgressRecord) {
+		if progress != nil {
+			progress(p)
+		}
+		send(Event{Kind: EventProgress, Progress: &p})
+	}
+	call.Stream = func(ev Event) {
+		if stream != nil {
+			stream(ev)
+		}
+		live = true
+		send(ev)
+	}
+
+	go func() {
+		defer close(events)
+		res, err := inv.Do(ctx, call)
+		if err != nil {
+			events <- Event{Kind: EventDone, Err: err}
+			return
+		}
+		defer res.Close()
+		if !live {
+			for _, ev := range res.Streams.events() {
+				send(ev)
+			}
+		}
+		out, err := res.Bytes()
+		if err == nil {
+			out, err = unwrapBytes(out)
+		}
+		if err != nil {
+			events <- Event{Kind: EventDone, Err: fmt.Errorf("read result: %w", err)}
+			return
+		}
+		send(Event{Kind: EventOutput, Output: out})
+		events <- Event{Kind: EventDone}
+	}()
+	return events, nil
+}
+
+// events are the records in s as Events, stream by stream
+func (s *Streams) events() []Event {
+	var evs []Event
+	for _, stream := range []struct {
+		kind  EventKind
+		lines []string
+	}{
+		{EventVerbose, s.Verbose}, {EventWarning, s.Warning}, {EventDebug, s.Debug}, {EventInformation, s.Information},
+	} {
+		for _, line := range stream.lines {
+			evs = append(evs, Event{Kind: stream.kind, Message: line})
+		}
+	}
+	for _, err := range s.Errors {
+		evs = append(evs, Event{Kind: EventError, Error: err})
+	}
+	return evs
+}
+
+// InvokeStream sends req to the client's operation, reporting what it
+// produces as events; see the package function InvokeStream
+func (c *Client) InvokeStream(ctx context.Context, req Request, opts ...CallOption) (<-chan Event, error) {
+	return InvokeStream(ctx, c, c.Operation, req, opts...)
+}
+
+// InvokeStream sends req to the session's operation, reporting what it
+// produces as events; see the package function InvokeStream
+func (s *Session) InvokeStream(ctx context.Context, req Request, opts ...CallOption) (<-chan Event, error) {
+	return InvokeStream(ctx, s, s.operation, req, opts...)
+}
@@ -0,0 +1,129 @@
+package psbridge
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// StrictError is a result that doesn't fit its Go type exactly, from a
+// client or call decoding strictly. Fields are paths such as
+// items[0].Name.
+type StrictError struct {
+	// Type is the Go type decoded into
+	Type string
+	// Unknown are the properties the type has no field for, as a renamed
+	// property shows up
+	Unknown []string
+	// Missing are the fields tagged psbridge:"required" that the result
+	// lacks or has null
+	Missing []string
+}
+
+func (e *StrictError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unexpected fields "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing fields "+strings.Join(e.Missing, ", "))
+	}
+	return fmt.Sprintf("psbridge: result doesn't match %s: %s", e.Type, strings.Join(parts, "; "))
+}
+
+// WithStrict decodes every result strictly, as Strict does one call's
+func WithStrict() Option {
+	return func(c *Client) { c.Strict = true }
+}
+
+// Strict decodes one call's result strictly: a property its Go type has no
+// field for, matched by JSON key or ps tag ignoring case as encoding/json
+// does, or a field tagged psbridge:"required" that is missing or null
+// fails the call with a *StrictError listing them all, instead of being
+// dropped or left zero. Maps, anys and types with their own UnmarshalJSON
+// take whatever they are given.
+func Strict() CallOption {
+	return func(c *Call) { c.Strict = true }
+}
+
+// checkStrict checks data, JSON from a script, against t
+func checkStrict(data []byte, t reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	tree, err := decodeTree(data)
+	if err != nil {
+		return err
+	}
+	e := &StrictError{Type: derefType(t).String()}
+	e.check(tree, t, "")
+	if len(e.Unknown) == 0 && len(e.Missing) == 0 {
+		return nil
+	}
+	return e
+}
+
+// check adds what of v, a value at path, doesn't fit t
+func (e *StrictError) check(v any, t reflect.Type, path string) {
+	if isCustom(t) {
+		return
+	}
+	t = derefType(t)
+	if isCustom(t) || t == timeType {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		items, _ := v.([]any)
+		for i, item := range items {
+			e.check(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	case reflect.Map:
+		m, _ := v.(map[string]any)
+		for k, item := range m {
+			e.check(item, t.Elem(), joinPath(path, k))
+		}
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := psFieldsOf(t)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			i := slices.IndexFunc(fields, func(f psField) bool { return f.matches(k) })
+			if i < 0 {
+				e.Unknown = append(e.Unknown, joinPath(path, k))
+				continue
+			}
+			e.check(m[k], fields[i].typ, joinPath(path, k))
+		}
+		for _, f := range fields {
+			if !f.required {
+				continue
+			}
+			found := slices.ContainsFunc(keys, func(k string) bool { return f.matches(k) && m[k] != nil })
+			if !found {
+				e.Missing = append(e.Missing, joinPath(path, f.key))
+			}
+		}
+	}
+}
+
+// matches reports whether a property named k decodes into f
+func (f psField) matches(k string) bool {
+	return strings.EqualFold(k, f.key) || slices.ContainsFunc(f.names, func(name string) bool { return strings.EqualFold(k, name) })
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,73 @@
+package psbridge
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type strictItem struct {
+	Name  string `json:"name" psbridge:"required"`
+	Count int    `json:"count"`
+}
+
+type strictResult struct {
+	Host  string                `json:"host" ps:"MachineName"`
+	Items []strictItem          `json:"items"`
+	Tags  map[string]strictItem `json:"tags"`
+	Raw   json.RawMessage       `json:"raw"`
+	Extra map[string]any        `json:"extra"`
+}
+
+func TestCheckStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		unknown []string
+		missing []string
+	}{
+		{"fits", `{"host":"a","items":[{"name":"x","count":1}]}`, nil, nil},
+		{"ps name ignoring case", `{"MACHINENAME":"a"}`, nil, nil},
+		{"json key ignoring case", `{"Host":"a"}`, nil, nil},
+		{"unknown", `{"host":"a","hostname":"b","zone":"c"}`, []string{"hostname", "zone"}, nil},
+		{"nested unknown", `{"items":[{"name":"x"},{"name":"y","size":2}]}`, []string{"items[1].size"}, nil},
+		{"missing", `{"items":[{"count":1}]}`, nil, []string{"items[0].name"}},
+		{"null is missing", `{"tags":{"t":{"name":null}}}`, nil, []string{"tags.t.name"}},
+		{"both", `{"items":[{"nmae":"x"}]}`, []string{"items[0].nmae"}, []string{"items[0].name"}},
+		{"raw and any take anything", `{"raw":{"a":1},"extra":{"b":{"c":2}}}`, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStrict([]byte(tt.in), reflect.TypeFor[*strictResult]())
+			if tt.unknown == nil && tt.missing == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var strictErr *StrictError
+			if !errors.As(err, &strictErr) {
+				t.Fatalf("err = %v, want a *StrictError", err)
+			}
+			if strictErr.Type != "psbridge.strictResult" {
+				t.Errorf("Type = %q", strictErr.Type)
+			}
+			if !reflect.DeepEqual(strictErr.Unknown, tt.unknown) || !reflect.DeepEqual(strictErr.Missing, tt.missing) {
+				t.Errorf("unknown %q missing %q, want %q and %q", strictErr.Unknown, strictErr.Missing, tt.unknown, tt.missing)
+			}
+		})
+	}
+
+	if err := checkStrict([]byte(`{"x":1}`), nil); err != nil {
+		t.Errorf("no type: %v", err)
+	}
+}
+
+func TestStrictErrorMessage(t *testing.T) {
+	err := &StrictError{Type: "T", Unknown: []string{"a", "b"}, Missing: []string{"c"}}
+	const want = "psbridge: result doesn't match T: unexpected fields a, b; missing fields c"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,142 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operations the shim serves for the Task Scheduler
+const (
+	ScheduledTasksOp = "scheduled-tasks"
+	RegisterTaskOp   = "register-task"
+	UnregisterTaskOp = "unregister-task"
+)
+
+// Trigger kinds
+const (
+	TriggerOnce      = "Once"
+	TriggerDaily     = "Daily"
+	TriggerWeekly    = "Weekly"
+	TriggerAtStartup = "AtStartup"
+	TriggerAtLogOn   = "AtLogOn"
+)
+
+// TaskTrigger is when a task runs. Kinds other than the Trigger constants,
+// like event triggers, are listed by their CIM class name but can't be
+// registered.
+type TaskTrigger struct {
+	Kind string `json:"kind"`
+	// At is when a Once, Daily or Weekly trigger first fires. The Task
+	// Scheduler keeps it as a time on the machine's clock, so it is read
+	// back in the machine's zone.
+	At time.Time `json:"at,omitzero"`
+	// DaysInterval is every how many days a Daily trigger fires
+	DaysInterval int `json:"daysInterval,omitempty"`
+	// WeeksInterval is every how many weeks a Weekly trigger fires
+	WeeksInterval int `json:"weeksInterval,omitempty"`
+	// DaysOfWeek are the days a Weekly trigger fires, e.g. Monday
+	DaysOfWeek []string `json:"daysOfWeek,omitempty"`
+	// User limits an AtLogOn trigger to one user's logons
+	User     string `json:"user,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// TaskAction is a program a task runs
+type TaskAction struct {
+	Execute          string `json:"execute"`
+	Arguments        string `json:"arguments,omitempty"`
+	WorkingDirectory string `json:"workingDirectory,omitempty"`
+}
+
+// TaskPrincipal is who a task runs as
+type TaskPrincipal struct {
+	// UserID is the account, e.g. SYSTEM or CONTOSO\alice
+	UserID string `json:"userId,omitempty"`
+	// LogonType is e.g. Interactive, ServiceAccount or Password
+	LogonType string `json:"logonType,omitempty"`
+	// RunLevel is Limited or Highest
+	RunLevel string `json:"runLevel,omitempty"`
+}
+
+// ScheduledTask is one Task Scheduler entry
+type ScheduledTask struct {
+	Name string `json:"name"`
+	// Path is the folder, e.g. \Microsoft\Windows\Defrag\
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+	Author      string `json:"author,omitempty"`
+	// State is Ready, Running, Queued, Disabled or Unknown
+	State     string        `json:"state"`
+	Triggers  []TaskTrigger `json:"triggers"`
+	Actions   []TaskAction  `json:"actions"`
+	Principal TaskPrincipal `json:"principal"`
+	// LastRunTime and NextRunTime are zero for never and not scheduled
+	LastRunTime time.Time `json:"lastRunTime"`
+	NextRunTime time.Time `json:"nextRunTime"`
+	// LastResult is the last run's exit code or HRESULT; 0 is success and
+	// 0x41303 (267011) means it hasn't run yet
+	LastResult int64 `json:"lastResult"`
+}
+
+// TaskDefinition is a task to register
+type TaskDefinition struct {
+	Name string `json:"name"`
+	// Path is the folder, default the root folder \
+	Path        string        `json:"path,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Triggers    []TaskTrigger `json:"triggers"`
+	Actions     []TaskAction  `json:"actions"`
+	// Principal, if set, is who the task runs as; default the caller,
+	// only while logged on
+	Principal *TaskPrincipal `json:"principal,omitempty"`
+	// Credential runs the task as its user whether or not they are logged
+	// on, and takes the place of Principal's UserID and LogonType
+	Credential *Credential `json:"credential,omitempty"`
+	// Replace overwrites a task already registered under the name
+	Replace bool `json:"replace,omitempty"`
+}
+
+type tasksRequest struct {
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// ScheduledTasks lists the tasks in the folder path, or in every folder
+// with path empty, whose names match name, which may hold wildcards, or
+// all of them with name empty. The path must end in \.
+func ScheduledTasks(ctx context.Context, inv Invoker, path, name string, opts ...CallOption) ([]ScheduledTask, error) {
+	return InvokeContext[tasksRequest, []ScheduledTask](ctx, inv, ScheduledTasksOp, tasksRequest{Path: path, Name: name}, opts...)
+}
+
+// GetScheduledTask reads the task called name in the folder path
+func GetScheduledTask(ctx context.Context, inv Invoker, path, name string, opts ...CallOption) (*ScheduledTask, error) {
+	tasks, err := ScheduledTasks(ctx, inv, path, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) != 1 {
+		return nil, fmt.Errorf("psbridge: %d scheduled tasks match %s%s", len(tasks), path, name)
+	}
+	return &tasks[0], nil
+}
+
+// RegisterScheduledTask creates the task def describes and returns it as
+// registered
+func RegisterScheduledTask(ctx context.Context, inv Invoker, def TaskDefinition, opts ...CallOption) (*ScheduledTask, error) {
+	if def.Name == "" || len(def.Actions) == 0 {
+		return nil, errors.New("psbridge: a scheduled task needs a name and an action")
+	}
+	task, err := InvokeContext[TaskDefinition, *ScheduledTask](ctx, inv, RegisterTaskOp, def, opts...)
+	if err == nil && task == nil {
+		err = fmt.Errorf("psbridge: no scheduled task returned for %s", def.Name)
+	}
+	return task, err
+}
+
+// UnregisterScheduledTask deletes the task called name in the folder path
+func UnregisterScheduledTask(ctx context.Context, inv Invoker, path, name string, opts ...CallOption) error {
+	_, err := InvokeContext[tasksRequest, struct{}](ctx, inv, UnregisterTaskOp, tasksRequest{Path: path, Name: name}, opts...)
+	return err
+}
@@ -0,0 +1,146 @@
+package psbridge
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Template is a PowerShell snippet written as a text/template, for
+// scripts that differ only by a few values. Every value must reach the
+// output through one of the functions escaping it for PowerShell:
+//
+//	{{ps .Path}}      a literal of the value, as Param values are written:
+//	                  'C:\it''s', 42, $true, @('a', 'b'), @{'k' = 1}
+//	{{quote .Name}}   the value's text as a single-quoted string
+//	{{ident .Cmd}}    a bare command, parameter or property name such as
+//	                  Get-Item or Microsoft.PowerShell.Management\Get-Item,
+//	                  refused if it holds anything else
+//	{{var .Name}}     the variable of that name, as ${name}
+//	{{raw .Snippet}}  the text as it is, for code the program trusts
+//
+// ParseTemplate refuses templates writing a value any other way, so a
+// forgotten escape fails early rather than running what a value holds.
+type Template struct {
+	tmpl *template.Template
+}
+
+// templateFuncs are the escaping functions templates write values with
+var templateFuncs = template.FuncMap{
+	"ps": func(v any) (string, error) {
+		return psLiteral(reflect.ValueOf(v))
+	},
+	"quote": func(v any) string {
+		return quotePS(fmt.Sprint(v))
+	},
+	"ident": func(v any) (string, error) {
+		s := fmt.Sprint(v)
+		if !psIdent.MatchString(s) {
+			return "", fmt.Errorf("%q isn't a PowerShell name", s)
+		}
+		return s, nil
+	},
+	"var": func(v any) string {
+		return "${" + strings.NewReplacer("`", "``", "}", "`}").Replace(fmt.Sprint(v)) + "}"
+	},
+	"raw": func(v any) string {
+		return fmt.Sprint(v)
+	},
+}
+
+// psIdent is a name that can stand bare in PowerShell code, optionally
+// qualified by a module
+var psIdent = regexp.MustCompile(`^([A-Za-z_][\w.-]*\\)?[A-Za-z_][\w.-]*$`)
+
+// ParseTemplate parses text as a Template called name
+func ParseTemplate(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("psbridge: parse template: %w", err)
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkEscaped(t.Tree, t.Tree.Root); err != nil {
+			return nil, fmt.Errorf("psbridge: template %s: %w", t.Name(), err)
+		}
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// MustParseTemplate is ParseTemplate, panicking on error, for templates
+// in package variables
+func MustParseTemplate(name, text string) *Template {
+	t, err := ParseTemplate(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// checkEscaped fails the first action within node writing a value that no
+// escaping function has seen
+func checkEscaped(tree *parse.Tree, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, item := range n.Nodes {
+			if err := checkEscaped(tree, item); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		// Assignments write nothing
+		if len(n.Pipe.Decl) > 0 {
+			return nil
+		}
+		last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+		if id, ok := last.Args[0].(*parse.IdentifierNode); ok && templateFuncs[id.Ident] != nil {
+			return nil
+		}
+		location, _ := tree.ErrorContext(n)
+		return fmt.Errorf("%s: %s writes a value unescaped; pipe it to ps, quote, ident, var or raw", location, n)
+	case *parse.IfNode:
+		return checkBranches(tree, n.List, n.ElseList)
+	case *parse.RangeNode:
+		return checkBranches(tree, n.List, n.ElseList)
+	case *parse.WithNode:
+		return checkBranches(tree, n.List, n.ElseList)
+	}
+	return nil
+}
+
+func checkBranches(tree *parse.Tree, list, elseList *parse.ListNode) error {
+	if err := checkEscaped(tree, list); err != nil {
+		return err
+	}
+	return checkEscaped(tree, elseList)
+}
+
+// Render is the script t makes of data
+func (t *Template) Render(data any) (string, error) {
+	var sb strings.Builder
+	if err := t.tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("psbridge: render template: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// RunTemplate renders t with data and runs the script with -EncodedCommand,
+// or on stdin in ExecStdin mode or if it holds a SecureString, as RunScript
+// does, returning whatever it printed. The snippet is the program's own
+// code, so the client's Integrity and Signatures don't apply to it.
+func (c *Client) RunTemplate(ctx context.Context, t *Template, data any) ([]byte, error) {
+	script, err := t.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.runCommand(ctx, script)
+}
@@ -0,0 +1,106 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSessionIdle is why calls fail on a session Timeouts.SessionIdle closed
+var ErrSessionIdle = errors.New("psbridge: session closed after being idle")
+
+// Timeouts bound calls in layers, each set independently. A call gets the
+// first of its own WithTimeout, its operation's entry in Ops and Default
+// that is set, cut shorter by any deadline on its context, and a
+// *TimeoutError says which of them it ran into.
+type Timeouts struct {
+	// Default bounds every call; zero for no limit
+	Default time.Duration
+	// Ops override Default for the operations they name
+	Ops map[string]time.Duration
+	// SessionIdle ends a session that has had no call in flight for this
+	// long, and its later calls fail with ErrSessionIdle; zero keeps it
+	// until Close. A Pool replaces the sessions it ends, while its own
+	// IdleTimeout retires surplus ones.
+	SessionIdle time.Duration
+	// SessionStart bounds starting a session, from launching PowerShell
+	// through the handshake to the checks after it, killing a process that
+	// takes longer; zero for no limit but the caller's context
+	SessionStart time.Duration
+}
+
+// WithTimeouts sets the client's timeouts
+func WithTimeouts(t Timeouts) Option {
+	return func(c *Client) { c.Timeouts = t }
+}
+
+// WithTimeout bounds one call, overriding the client's timeouts for its
+// operation
+func WithTimeout(d time.Duration) CallOption {
+	return func(c *Call) { c.Timeout = d }
+}
+
+// Where a TimeoutError's deadline came from
+const (
+	// TimeoutContext is a deadline on the caller's context
+	TimeoutContext = "context"
+	// TimeoutCall is the call's WithTimeout
+	TimeoutCall = "call"
+	// TimeoutOperation is the operation's entry in Timeouts.Ops
+	TimeoutOperation = "operation"
+	// TimeoutDefault is Timeouts.Default
+	TimeoutDefault = "default"
+)
+
+// deadline is the effective deadline of one call and where it came from
+type deadline struct {
+	at     time.Time
+	limit  time.Duration
+	source string
+}
+
+// bound returns ctx limited by whichever of t applies to call, with the
+// deadline that results
+func (t *Timeouts) bound(ctx context.Context, call *Call) (context.Context, context.CancelFunc, deadline) {
+	limit, source := call.Timeout, TimeoutCall
+	if limit <= 0 {
+		limit, source = t.Ops[call.Op], TimeoutOperation
+	}
+	if limit <= 0 {
+		limit, source = t.Default, TimeoutDefault
+	}
+
+	var d deadline
+	if at, ok := ctx.Deadline(); ok {
+		d = deadline{at: at, source: TimeoutContext}
+	}
+	if limit > 0 {
+		if at := time.Now().Add(limit); d.at.IsZero() || at.Before(d.at) {
+			d = deadline{at: at, limit: limit, source: source}
+			ctx, cancel := context.WithDeadline(ctx, at)
+			return ctx, cancel, d
+		}
+	}
+	return ctx, func() {}, d
+}
+
+// report fills the deadline into err if it is this call running out of
+// time, ctx being the bounded context. A session's later calls fail with
+// the error of the call that timed out, which keeps its own deadline.
+func (d deadline) report(ctx context.Context, err error) error {
+	timeout, ok := err.(*TimeoutError)
+	if !ok || !timeout.Timeout() || !timeout.Deadline.IsZero() || d.at.IsZero() || ctx.Err() == nil {
+		return err
+	}
+	reported := *timeout
+	reported.Deadline, reported.Limit, reported.Source = d.at, d.limit, d.source
+	return &reported
+}
+
+// run calls fn bounded by t, reporting the deadline in its timeout
+func (t *Timeouts) run(ctx context.Context, call *Call, fn func(context.Context) (*Result, error)) (*Result, error) {
+	ctx, cancel, d := t.bound(ctx, call)
+	defer cancel()
+	res, err := fn(ctx)
+	return res, d.report(ctx, err)
+}
@@ -0,0 +1,140 @@
+// Package tracing wraps psbridge calls in OpenTelemetry spans.
+//
+// Each call gets a client span named "psbridge <op>", and the span's context
+// is passed to the script as the TRACEPARENT and TRACESTATE environment
+// variables (W3C trace context), so PowerShell-side instrumentation can join
+// the same trace.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+
+	"example.com/go-ps-lab2/psbridge"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer
+const instrumentationName = "example.com/go-ps-lab2/psbridge/tracing"
+
+// Span attributes
+const (
+	AttrOp           = attribute.Key("psbridge.op")
+	AttrScript       = attribute.Key("psbridge.script")
+	AttrRequestSize  = attribute.Key("psbridge.request.size")
+	AttrResponseSize = attribute.Key("psbridge.response.size")
+	AttrExitCode     = attribute.Key("process.exit.code")
+)
+
+// Option configures Wrap
+type Option func(*invoker)
+
+// WithTracerProvider uses tp instead of the global provider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(i *invoker) { i.tracer = tp.Tracer(instrumentationName) }
+}
+
+// WithScript sets the psbridge.script attribute. Wrap fills it in itself
+// for a *psbridge.Client.
+func WithScript(path string) Option {
+	return func(i *invoker) { i.script = path }
+}
+
+// WithoutPropagation stops Wrap setting TRACEPARENT for the script, for
+// backends that reject per-call environment variables such as WinRM
+func WithoutPropagation() Option {
+	return func(i *invoker) { i.propagate = false }
+}
+
+// Wrap returns an Invoker that traces every call made through inv
+func Wrap(inv psbridge.Invoker, opts ...Option) psbridge.Invoker {
+	i := &invoker{
+		next:      inv,
+		tracer:    otel.GetTracerProvider().Tracer(instrumentationName),
+		propagate: true,
+	}
+	if c, ok := inv.(*psbridge.Client); ok {
+		i.oneShot = true
+		i.script = c.Script
+		if c.Mode != psbridge.ExecFile && c.ScriptText != "" {
+			i.script = "inline"
+		}
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+type invoker struct {
+	next      psbridge.Invoker
+	tracer    trace.Tracer
+	script    string
+	propagate bool
+	// oneShot is set when every call is its own process, so has an exit code
+	oneShot bool
+}
+
+// Do runs call inside a span
+func (i *invoker) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	attrs := []attribute.KeyValue{AttrOp.String(call.Op), AttrRequestSize.Int(len(call.Data))}
+	if i.script != "" {
+		attrs = append(attrs, AttrScript.String(i.script))
+	}
+	ctx, span := i.tracer.Start(ctx, "psbridge "+call.Op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	if i.propagate {
+		call = withTraceEnv(ctx, call)
+	}
+
+	res, err := i.next.Do(ctx, call)
+	if err != nil {
+		var exitErr *psbridge.ExitError
+		var psErr *psbridge.PSError
+		switch {
+		case errors.As(err, &exitErr):
+			span.SetAttributes(AttrExitCode.Int(exitErr.Code))
+		case i.oneShot && errors.As(err, &psErr):
+			span.SetAttributes(AttrExitCode.Int(psbridge.ExitFailure))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(AttrResponseSize.Int64(res.Size()))
+	if i.oneShot {
+		span.SetAttributes(AttrExitCode.Int(0))
+	}
+	return res, nil
+}
+
+// withTraceEnv returns a copy of call that also sets the trace context
+// variables, leaving the caller's Env alone
+func withTraceEnv(ctx context.Context, call *psbridge.Call) *psbridge.Call {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return call
+	}
+
+	c := *call
+	c.Env = maps.Clone(call.Env)
+	if c.Env == nil {
+		c.Env = map[string]string{}
+	}
+	for k, v := range carrier {
+		c.Env[strings.ToUpper(k)] = v
+	}
+	return &c
+}
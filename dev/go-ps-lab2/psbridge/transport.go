@@ -0,0 +1,83 @@
+package psbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Transport is the channel a Session's protocol messages travel over
+type Transport int
+
+const (
+	// TransportStdio uses the process's stdin and stdout
+	TransportStdio Transport = iota
+	// TransportNamedPipe uses a pair of Windows named pipes created by Go,
+	// whose base name is passed to the script as -PipeName. The console is
+	// left to the script: anything it prints goes to Client.Console.
+	TransportNamedPipe
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportStdio:
+		return "stdio"
+	case TransportNamedPipe:
+		return "named-pipe"
+	}
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// WithTransport selects how sessions exchange messages
+func WithTransport(t Transport) Option {
+	return func(c *Client) { c.Transport = t }
+}
+
+// WithConsole receives whatever the script prints to its console while the
+// protocol runs over another transport
+func WithConsole(w io.Writer) Option {
+	return func(c *Client) { c.Console = w }
+}
+
+// errNamedPipeUnsupported is returned by TransportNamedPipe off Windows
+var errNamedPipeUnsupported = errors.New("psbridge: named pipe transport is only available on Windows")
+
+// pipeConnectTimeout bounds how long the script has to open the pipes
+const pipeConnectTimeout = 30 * time.Second
+
+// startPipeSession runs the protocol over named pipes; the process's stdout
+// goes to c.Console
+func (c *Client) startPipeSession(ctx context.Context) (*Session, error) {
+	pipes, err := listenPipes()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := c.sessionCommand(ctx,
+		Param{Name: "Session", Switch: true},
+		Param{Name: "PipeName", Value: pipes.name},
+	)
+	if err != nil {
+		pipes.Close()
+		return nil, err
+	}
+	cmd.Stdout = c.Console
+
+	s, err := c.startProcess(ctx, cmd)
+	if err != nil {
+		pipes.Close()
+		return nil, err
+	}
+
+	w, r, err := pipes.accept(s.exited, pipeConnectTimeout)
+	if err != nil {
+		s.abort()
+		return nil, s.processError("connect pipes", s.exitCause(err))
+	}
+	if err := s.serve(c, w, r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package psbridge
+
+import (
+	"io"
+	"time"
+)
+
+type pipePair struct {
+	name string
+}
+
+func listenPipes() (*pipePair, error) {
+	return nil, errNamedPipeUnsupported
+}
+
+func (p *pipePair) accept(exited <-chan struct{}, timeout time.Duration) (io.WriteCloser, io.Reader, error) {
+	return nil, nil, errNamedPipeUnsupported
+}
+
+func (p *pipePair) Close() error { return nil }
@@ -0,0 +1,145 @@
+package psbridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipePair is two one-way pipes: "<name>-in" carries requests to the script
+// and "<name>-out" carries replies back. Separate pipes avoid synchronous
+// reads and writes on one handle blocking each other.
+type pipePair struct {
+	name    string
+	in, out windows.Handle
+}
+
+// listenPipes creates the server ends, reachable only by the current user
+// and only from this machine
+func listenPipes() (*pipePair, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	p := &pipePair{
+		name: fmt.Sprintf("psbridge-%d-%s", os.Getpid(), hex.EncodeToString(suffix[:])),
+		in:   windows.InvalidHandle,
+		out:  windows.InvalidHandle,
+	}
+
+	sa, err := currentUserOnly()
+	if err != nil {
+		return nil, err
+	}
+	if p.in, err = createPipe(p.path("in"), windows.PIPE_ACCESS_OUTBOUND, sa); err != nil {
+		return nil, err
+	}
+	if p.out, err = createPipe(p.path("out"), windows.PIPE_ACCESS_INBOUND, sa); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pipePair) path(dir string) string {
+	return `\\.\pipe\` + p.name + "-" + dir
+}
+
+func createPipe(path string, access uint32, sa *windows.SecurityAttributes) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	h, err := windows.CreateNamedPipe(name,
+		access|windows.FILE_FLAG_FIRST_PIPE_INSTANCE,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		1, 64<<10, 64<<10, 0, sa)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("create pipe %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// currentUserOnly builds security attributes granting access to the current
+// user alone
+func currentUserOnly() (*windows.SecurityAttributes, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("current user: %w", err)
+	}
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;GA;;;" + user.User.Sid.String() + ")")
+	if err != nil {
+		return nil, fmt.Errorf("pipe security descriptor: %w", err)
+	}
+	return &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+// accept waits for the script to open both pipes, giving up if it exits or
+// takes longer than timeout
+func (p *pipePair) accept(exited <-chan struct{}, timeout time.Duration) (io.WriteCloser, io.Reader, error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- errors.Join(connectPipe(p.in), connectPipe(p.out))
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-exited:
+		err = errors.New("powershell exited before connecting")
+	case <-time.After(timeout):
+		err = fmt.Errorf("powershell didn't connect within %v", timeout)
+	}
+	if err != nil {
+		// ConnectNamedPipe is blocking; connecting ourselves is the way to
+		// release it before closing the handles
+		p.unblock()
+		<-done
+		p.Close()
+		return nil, nil, err
+	}
+
+	w := os.NewFile(uintptr(p.in), p.path("in"))
+	r := os.NewFile(uintptr(p.out), p.path("out"))
+	return w, r, nil
+}
+
+func connectPipe(h windows.Handle) error {
+	err := windows.ConnectNamedPipe(h, nil)
+	if errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		return nil
+	}
+	return err
+}
+
+// unblock opens and closes the client ends of both pipes, each in the only
+// direction it allows
+func (p *pipePair) unblock() {
+	if f, err := os.OpenFile(p.path("in"), os.O_RDONLY, 0); err == nil {
+		f.Close()
+	}
+	if f, err := os.OpenFile(p.path("out"), os.O_WRONLY, 0); err == nil {
+		f.Close()
+	}
+}
+
+// Close releases pipes that were never handed out
+func (p *pipePair) Close() error {
+	for _, h := range []*windows.Handle{&p.in, &p.out} {
+		if *h != windows.InvalidHandle {
+			windows.CloseHandle(*h)
+			*h = windows.InvalidHandle
+		}
+	}
+	return nil
+}
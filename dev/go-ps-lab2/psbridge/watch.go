@@ -0,0 +1,137 @@
+package psbridge
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// WatchOp is the operation the shim watches for changes with
+const WatchOp = "watch"
+
+// WatchSpec is what Watch subscribes to
+type WatchSpec struct {
+	Paths   []WatchPath  `json:"paths,omitempty"`
+	Queries []WatchQuery `json:"queries,omitempty"`
+}
+
+// WatchPath is a directory, or a single file, watched with a
+// FileSystemWatcher
+type WatchPath struct {
+	Path string `json:"path"`
+	// Filter is a wildcard for the file names reported in a directory,
+	// e.g. *.log; empty reports all
+	Filter string `json:"filter,omitempty"`
+	// Recurse watches subdirectories too
+	Recurse bool `json:"recurse"`
+}
+
+// WatchQuery is a WMI event subscription, such as
+//
+//	SELECT * FROM __InstanceModificationEvent WITHIN 5 WHERE TargetInstance ISA 'Win32_Service'
+//
+// or, in namespace root\default, a RegistryKeyChangeEvent
+type WatchQuery struct {
+	Query string `json:"query"`
+	// Namespace defaults to root\cimv2
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Sources of a ChangeEvent
+const (
+	ChangeFile = "file"
+	ChangeWMI  = "wmi"
+)
+
+// ChangeEvent is one change a Watch saw
+type ChangeEvent struct {
+	// Source is ChangeFile or ChangeWMI
+	Source string `json:"source"`
+	// Path is the file's full path; for WMI events it is the changed
+	// instance's class, and Name if it has one, e.g. Win32_Service.Spooler
+	Path string `json:"path"`
+	// OldPath is a renamed file's previous path
+	OldPath string `json:"oldPath,omitempty"`
+	// ChangeType is Created, Changed, Deleted or Renamed for files, and the
+	// event class for WMI, e.g. __InstanceModificationEvent
+	ChangeType string    `json:"changeType"`
+	Time       time.Time `json:"time"`
+	// Properties are a WMI event's scalar properties, those of its
+	// TargetInstance when it has one
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// Watcher delivers the changes a Watch sees until it is closed or fails.
+// It runs in a process of its own, which also ends with ctx.
+type Watcher struct {
+	p      *Pipeline[struct{}, ChangeEvent]
+	cancel context.CancelFunc
+	events chan ChangeEvent
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// Watch subscribes to what spec names and streams the changes it sees
+func Watch(ctx context.Context, c *Client, spec WatchSpec, opts ...CallOption) (*Watcher, error) {
+	// Close stops the process by canceling, since Recv may be blocked in
+	// another goroutine
+	ctx, cancel := context.WithCancel(ctx)
+	p, err := StartPipeline[struct{}, ChangeEvent](ctx, c, WatchOp, spec, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The handler only starts once its input has ended
+	if err := p.CloseSend(); err != nil {
+		p.Close()
+		cancel()
+		return nil, err
+	}
+	w := &Watcher{p: p, cancel: cancel, events: make(chan ChangeEvent), done: make(chan struct{})}
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	for {
+		ev, err := w.p.Recv()
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				if err != io.EOF {
+					w.err = err
+				}
+			}
+			return
+		}
+		select {
+		case w.events <- ev:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Events returns the channel changes arrive on. It is closed when the
+// watch ends, after which Err says why.
+func (w *Watcher) Events() <-chan ChangeEvent { return w.events }
+
+// Err returns what ended the watch, once Events is closed: nil after Close
+func (w *Watcher) Err() error { return w.err }
+
+// Close ends the watch and waits for its process to exit
+func (w *Watcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		w.cancel()
+	})
+	for range w.events {
+	}
+	// Ending it by canceling isn't a failure
+	w.p.Close()
+	return nil
+}
@@ -0,0 +1,338 @@
+// Package winrm runs the psbridge script on a remote Windows host over
+// WinRM. Each call gets its own remote shell speaking the same reply
+// protocol as a local one-shot run, so a *Client works anywhere a
+// psbridge.Invoker does.
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/masterzen/winrm"
+)
+
+// Auth is how the client authenticates to the WinRM service
+type Auth int
+
+const (
+	// AuthBasic sends the user name and password with every request. The
+	// service only accepts it over HTTPS unless AllowUnencrypted is set.
+	AuthBasic Auth = iota
+	// AuthNTLM negotiates NTLM, which works over plain HTTP on a default
+	// WinRM listener
+	AuthNTLM
+	// AuthKerberos gets a ticket for the host from the configured realm
+	AuthKerberos
+)
+
+func (a Auth) String() string {
+	switch a {
+	case AuthBasic:
+		return "basic"
+	case AuthNTLM:
+		return "ntlm"
+	case AuthKerberos:
+		return "kerberos"
+	}
+	return fmt.Sprintf("Auth(%d)", int(a))
+}
+
+// Default WinRM listener ports
+const (
+	PortHTTP  = 5985
+	PortHTTPS = 5986
+)
+
+// Client runs the bridge script on a remote host, one WinRM shell per call
+type Client struct {
+	Host string
+	// Port defaults to PortHTTP, or PortHTTPS with HTTPS
+	Port  int
+	HTTPS bool
+	// Insecure skips verifying the host's certificate
+	Insecure bool
+	// CACert is a PEM bundle to verify the host's certificate against
+	CACert []byte
+
+	Auth     Auth
+	User     string
+	Password string
+	// Realm, KrbConfig, SPN and CCache configure AuthKerberos. KrbConfig
+	// defaults to /etc/krb5.conf; with CCache set the cached ticket is
+	// used instead of the password.
+	Realm     string
+	KrbConfig string
+	SPN       string
+	CCache    string
+
+	// Timeout bounds each HTTP request to the service
+	Timeout time.Duration
+	// Shell is the remote PowerShell executable
+	Shell string
+	// ScriptText is the bridge script sent to the host; it defaults to the
+	// bundled json_echo.ps1
+	ScriptText string
+	// Dir is the remote working directory calls start in
+	Dir string
+	// Operation is passed to the script by Invoke
+	Operation string
+
+	client *winrm.Client
+	// script is ScriptText, base64-encoded for the first line of stdin
+	script string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithCredentials sets the user name and password to log on with
+func WithCredentials(user, password string) Option {
+	return func(c *Client) {
+		c.User = user
+		c.Password = password
+	}
+}
+
+// WithAuth picks the authentication scheme
+func WithAuth(auth Auth) Option {
+	return func(c *Client) { c.Auth = auth }
+}
+
+// WithKerberos selects AuthKerberos in realm, reading the Kerberos
+// configuration from krbConfig
+func WithKerberos(realm, krbConfig string) Option {
+	return func(c *Client) {
+		c.Auth = AuthKerberos
+		c.Realm = realm
+		c.KrbConfig = krbConfig
+	}
+}
+
+// WithPort overrides the listener port
+func WithPort(port int) Option {
+	return func(c *Client) { c.Port = port }
+}
+
+// WithHTTPS connects to the HTTPS listener. With insecure set the host's
+// certificate isn't verified.
+func WithHTTPS(insecure bool) Option {
+	return func(c *Client) {
+		c.HTTPS = true
+		c.Insecure = insecure
+	}
+}
+
+// WithCACert verifies the host's certificate against a PEM bundle
+func WithCACert(pem []byte) Option {
+	return func(c *Client) { c.CACert = pem }
+}
+
+// WithTimeout bounds each HTTP request to the service
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.Timeout = d }
+}
+
+// WithShell sets the remote PowerShell executable, e.g. pwsh.exe
+func WithShell(path string) Option {
+	return func(c *Client) { c.Shell = path }
+}
+
+// WithScriptText sends text as the bridge script instead of the bundled one
+func WithScriptText(text string) Option {
+	return func(c *Client) { c.ScriptText = text }
+}
+
+// WithWorkingDir starts every call in dir on the host
+func WithWorkingDir(dir string) Option {
+	return func(c *Client) { c.Dir = dir }
+}
+
+// New returns a Client for host that runs the echo operation. It doesn't
+// connect until the first call.
+func New(host string, opts ...Option) (*Client, error) {
+	c := &Client{
+		Host:      host,
+		Timeout:   60 * time.Second,
+		Shell:     "powershell.exe",
+		Operation: "echo",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.Port == 0 {
+		c.Port = PortHTTP
+		if c.HTTPS {
+			c.Port = PortHTTPS
+		}
+	}
+	if c.ScriptText == "" {
+		text, err := fs.ReadFile(psbridge.Scripts(), "json_echo.ps1")
+		if err != nil {
+			return nil, fmt.Errorf("winrm: read bundled script: %w", err)
+		}
+		c.ScriptText = string(text)
+	}
+	c.script = base64.StdEncoding.EncodeToString([]byte(c.ScriptText))
+
+	params := *winrm.DefaultParameters
+	switch c.Auth {
+	case AuthBasic:
+	case AuthNTLM:
+		params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
+	case AuthKerberos:
+		settings := c.kerberosSettings()
+		params.TransportDecorator = func() winrm.Transporter { return winrm.NewClientKerberos(settings) }
+	default:
+		return nil, fmt.Errorf("winrm: unknown auth %v", c.Auth)
+	}
+
+	endpoint := winrm.NewEndpoint(c.Host, c.Port, c.HTTPS, c.Insecure, c.CACert, nil, nil, c.Timeout)
+	client, err := winrm.NewClientWithParameters(endpoint, c.User, c.Password, &params)
+	if err != nil {
+		return nil, fmt.Errorf("winrm: %w", err)
+	}
+	c.client = client
+	return c, nil
+}
+
+// kerberosSettings is the client's configuration in the form the Kerberos
+// transport takes it
+func (c *Client) kerberosSettings() *winrm.Settings {
+	proto := "http"
+	if c.HTTPS {
+		proto = "https"
+	}
+	krbConfig := c.KrbConfig
+	if krbConfig == "" {
+		krbConfig = "/etc/krb5.conf"
+	}
+	return &winrm.Settings{
+		WinRMUsername: c.User,
+		WinRMPassword: c.Password,
+		WinRMHost:     c.Host,
+		WinRMPort:     c.Port,
+		WinRMProto:    proto,
+		WinRMInsecure: c.Insecure,
+		KrbRealm:      c.Realm,
+		KrbConfig:     krbConfig,
+		KrbSpn:        c.SPN,
+		KrbCCache:     c.CCache,
+	}
+}
+
+// Invoke sends req to the script's operation and decodes the reply
+func (c *Client) Invoke(req psbridge.Request, opts ...psbridge.CallOption) (psbridge.Response, error) {
+	return c.InvokeContext(context.Background(), req, opts...)
+}
+
+// InvokeContext is Invoke bounded by ctx. If ctx is done first the remote
+// shell is torn down and a *psbridge.TimeoutError is returned.
+func (c *Client) InvokeContext(ctx context.Context, req psbridge.Request, opts ...psbridge.CallOption) (psbridge.Response, error) {
+	return psbridge.InvokeContext[psbridge.Request, psbridge.Response](ctx, c, c.Operation, req, opts...)
+}
+
+// bootstrap is the -EncodedCommand each remote shell runs. The script,
+// operation and working directory arrive as the first three lines of
+// stdin, which keeps the command line short whatever the script's size;
+// the script then reads the request from the rest.
+const bootstrap = `$text = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String([Console]::In.ReadLine()))
+$op = [Console]::In.ReadLine()
+$dir = [Console]::In.ReadLine()
+if ($dir) {
+    Set-Location -LiteralPath $dir -ErrorAction Stop
+    [Environment]::CurrentDirectory = $PWD.ProviderPath
+}
+& ([scriptblock]::Create($text)) -Operation $op
+exit $LASTEXITCODE`
+
+// Do runs the script once on the host with call.Op as -Operation and
+// call.Data on stdin
+func (c *Client) Do(ctx context.Context, call *psbridge.Call) (*psbridge.Result, error) {
+	if len(call.Env) > 0 || call.ReplaceEnv {
+		return nil, psbridge.ErrEnvUnsupported
+	}
+	args := c.args()
+	stdin := io.MultiReader(
+		strings.NewReader(c.script+"\n"+call.Op+"\n"+c.dir(call)+"\n"),
+		bytes.NewReader(call.Data),
+	)
+
+	type exit struct {
+		code int
+		err  error
+	}
+	stdout, w := io.Pipe()
+	var stderr bytes.Buffer
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := c.client.RunWithContextWithInput(ctx, strings.Join(args, " "), w, &stderr, stdin)
+		w.Close()
+		exited <- exit{code, err}
+	}()
+
+	res, readErr := psbridge.ReadReplies(stdout, call)
+	io.Copy(io.Discard, stdout)
+	ex := <-exited
+
+	if ctx.Err() != nil {
+		return nil, &psbridge.TimeoutError{Op: call.Op, Err: ctx.Err()}
+	}
+	// The script exits 1 after reporting a PSError, so it wins over the
+	// exit code
+	var psErr *psbridge.PSError
+	if errors.As(readErr, &psErr) {
+		return nil, psErr
+	}
+	if ex.err != nil {
+		return nil, fmt.Errorf("winrm: run on %s: %w", c.Host, ex.err)
+	}
+	if ex.code != 0 {
+		return nil, &psbridge.ExitError{
+			Code:        ex.code,
+			Stderr:      psbridge.StderrText(stderr.Bytes()),
+			CommandLine: shorten(args),
+		}
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("read reply: %w", readErr)
+	}
+	return res, nil
+}
+
+// dir is where call runs on the host
+func (c *Client) dir(call *psbridge.Call) string {
+	if call.Dir != "" {
+		return call.Dir
+	}
+	return c.Dir
+}
+
+// args is the remote command line
+func (c *Client) args() []string {
+	return []string{c.Shell, "-NoProfile", "-NonInteractive", "-EncodedCommand", psbridge.EncodeCommand(bootstrap)}
+}
+
+// maxShownArg matches how much of each argument psbridge's own ExitErrors
+// keep
+const maxShownArg = 80
+
+// shorten trims long arguments for ExitError.CommandLine
+func shorten(args []string) []string {
+	line := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > maxShownArg {
+			arg = fmt.Sprintf("%s…(%d bytes)", arg[:maxShownArg], len(arg))
+		}
+		line[i] = arg
+	}
+	return line
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+const replHelp = `Type an operation, optionally followed by its JSON payload:
+
+  echo {"name": "Tibi", "number": 42}
+
+Commands:
+  .ping   round-trip a no-op and show the latency
+  .help   show this help
+  .quit   close the session and exit (also Ctrl-D)
+`
+
+func newREPLCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "repl",
+		Short: "Type operations into a session interactively",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session, err := g.startSession()
+			if err != nil {
+				return err
+			}
+			defer g.closeSession(session)
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Session started (framing %s). Type .help for help.\n", session.Framing())
+			return repl(session, cmd.InOrStdin(), out)
+		},
+	}
+}
+
+// repl reads one command per line from in until .quit or end of input
+func repl(session *psbridge.Session, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+
+	for {
+		fmt.Fprint(out, "ps> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+
+		switch line {
+		case "":
+			continue
+		case ".quit", ".exit":
+			return nil
+		case ".help":
+			fmt.Fprint(out, replHelp)
+			continue
+		case ".ping":
+			d, err := session.Ping(context.Background())
+			if err != nil {
+				printError(out, err)
+				continue
+			}
+			fmt.Fprintf(out, "pong in %v\n", d.Round(time.Microsecond))
+			continue
+		}
+
+		op, payload, _ := strings.Cut(line, " ")
+		data := json.RawMessage("{}")
+		if payload = strings.TrimSpace(payload); payload != "" {
+			if !json.Valid([]byte(payload)) {
+				fmt.Fprintln(out, "error: payload is not valid JSON")
+				continue
+			}
+			data = json.RawMessage(payload)
+		}
+
+		call := &psbridge.Call{
+			Op:   op,
+			Data: data,
+			Progress: func(p psbridge.ProgressRecord) {
+				fmt.Fprintf(out, "  progress: %s %s (%d%%)\n", p.Activity, p.Status, p.PercentComplete)
+			},
+		}
+		start := time.Now()
+		res, err := session.Do(context.Background(), call)
+		if err != nil {
+			printError(out, err)
+			continue
+		}
+		printResult(out, res, time.Since(start))
+	}
+}
+
+// printResult shows a call's streams, then its data indented
+func printResult(out io.Writer, res *psbridge.Result, took time.Duration) {
+	s := res.Streams
+	for _, m := range s.Verbose {
+		fmt.Fprintf(out, "  verbose: %s\n", m)
+	}
+	for _, m := range s.Debug {
+		fmt.Fprintf(out, "  debug: %s\n", m)
+	}
+	for _, m := range s.Information {
+		fmt.Fprintf(out, "  information: %s\n", m)
+	}
+	for _, m := range s.Warning {
+		fmt.Fprintf(out, "  warning: %s\n", m)
+	}
+	for _, e := range s.Errors {
+		fmt.Fprintf(out, "  error: %s\n", e.Message)
+	}
+
+	data, err := res.Bytes()
+	if err != nil {
+		fmt.Fprintf(out, "  error: %v\n", err)
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, data, "", "  "); err != nil {
+		pretty.Reset()
+		pretty.Write(data)
+	}
+	if pretty.Len() == 0 {
+		pretty.WriteString("null")
+	}
+	fmt.Fprintf(out, "%s\n(%v)\n", pretty.Bytes(), took.Round(time.Millisecond))
+}
+
+// printError shows err, with the script position for PowerShell errors
+func printError(out io.Writer, err error) {
+	var psErr *psbridge.PSError
+	if errors.As(err, &psErr) {
+		fmt.Fprintf(out, "error: %s\n", psErr.Message)
+		if psErr.PositionMessage != "" {
+			fmt.Fprintf(out, "%s\n", psErr.PositionMessage)
+		}
+		return
+	}
+	fmt.Fprintf(out, "error: %v\n", err)
+}
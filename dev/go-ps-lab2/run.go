@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/schema"
+	"github.com/spf13/cobra"
+)
+
+// errFailed is returned once a command has already reported its failure
+// on stdout
+var errFailed = errors.New("call failed")
+
+func newRunCmd(g *globals) *cobra.Command {
+	var input, data, schemaFile string
+	var liveStderr bool
+	cmd := &cobra.Command{
+		Use:   "run [operation]",
+		Short: "Run one operation in a fresh PowerShell process",
+		Long: `Run one operation (default echo) with a JSON payload read from --input,
+or given inline with --data, and print the result.`,
+		Example: `  go-ps-lab2 run echo --data '{"name": "Tibi", "number": 42}'
+  go-ps-lab2 run echo -i request.json -o text`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			op := "echo"
+			if len(args) == 1 {
+				op = args[0]
+			}
+
+			payload, err := readPayload(cmd.InOrStdin(), input, data)
+			if err != nil {
+				return err
+			}
+
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			if liveStderr {
+				client.Stderr = cmd.ErrOrStderr()
+			}
+
+			var inv psbridge.Invoker = client
+			if schemaFile != "" {
+				doc, err := os.ReadFile(schemaFile)
+				if err != nil {
+					return err
+				}
+				reg := schema.NewRegistry()
+				if err := reg.Register(op, doc); err != nil {
+					return err
+				}
+				inv = reg.Wrap(client)
+			}
+
+			ctx, cancel := g.context()
+			defer cancel()
+			start := time.Now()
+			res, err := inv.Do(ctx, &psbridge.Call{Op: op, Data: payload})
+			return g.printResult(cmd.OutOrStdout(), op, res, err, time.Since(start))
+		},
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", "-", `file holding the JSON payload ("-" for stdin)`)
+	cmd.Flags().StringVarP(&data, "data", "d", "", "JSON payload given inline, instead of --input")
+	cmd.Flags().StringVar(&schemaFile, "schema", "", "JSON Schema file the result must match")
+	cmd.Flags().BoolVar(&liveStderr, "stderr", false, "copy what the script writes to stderr to ours as it runs")
+	return cmd
+}
+
+// readPayload returns the inline payload if there is one, else the
+// contents of the input file or stdin
+func readPayload(stdin io.Reader, input, data string) (json.RawMessage, error) {
+	var b []byte
+	switch {
+	case data != "":
+		b = []byte(data)
+	case input == "-":
+		var err error
+		if b, err = io.ReadAll(stdin); err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+	default:
+		var err error
+		if b, err = os.ReadFile(input); err != nil {
+			return nil, err
+		}
+	}
+
+	if !json.Valid(b) {
+		return nil, errors.New("payload is not valid JSON")
+	}
+	return b, nil
+}
+
+// printResult reports one call. A failed call is printed like a result,
+// so JSON consumers see its details, and then fails the command.
+func (g *globals) printResult(w io.Writer, op string, res *psbridge.Result, err error, took time.Duration) error {
+	if err != nil && g.output == outputText {
+		return err
+	}
+
+	out := newResult(op, res, err)
+	out.TookMs = millis(took)
+	if perr := g.print(w, out, func(w io.Writer) { printResult(w, res, took) }); perr != nil {
+		return perr
+	}
+	if err != nil {
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"example.com/go-ps-lab2/psbridge/grpcserver"
+	"github.com/spf13/cobra"
+)
+
+// serveTokenEnv supplies serve's token without putting it on the command line
+const serveTokenEnv = "PSBRIDGE_SERVE_TOKEN"
+
+func newServeCmd(g *globals) *cobra.Command {
+	var addr, cert, key, grpcAddr, grpcCert, grpcKey, token string
+	var sessions, breaker int
+	var cacheTTL time.Duration
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve provider views as JSON over HTTP",
+		Long: `Run an HTTP server answering from a pool of warm sessions:
+
+  GET /providers                    providers and their drives
+  GET /registry/{hive}/{path...}    a registry key, e.g. /registry/HKLM/SOFTWARE;
+                                    ?depth=N for subkeys, ?values=false to skip values
+  GET /services                     services and their status
+  GET /certs                        certificate stores
+  GET /certs/{location}/{store}     a store's certificates; ?export=true adds the DER
+
+With a token, from --token or ` + serveTokenEnv + `, every request must send
+"Authorization: Bearer <token>". Without one the server only listens on a
+loopback address, and with one it needs TLS anywhere else: --cert and
+--key.
+
+With --grpc it also serves the invoke API, any operation, as the gRPC
+service ` + grpcserver.Service + ` on that address; see
+psbridge/grpcserver/bridge.proto. The same token applies, and sending it
+off loopback needs TLS: --grpc-cert and --grpc-key.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if token == "" {
+				token = os.Getenv(serveTokenEnv)
+			}
+			for _, a := range []string{addr, grpcAddr} {
+				if a != "" && token == "" && !loopback(a) {
+					return fmt.Errorf("serving %s needs a token: set --token or %s", a, serveTokenEnv)
+				}
+			}
+			httpTLS, err := tlsConfig(cert, key, "--cert", "--key")
+			if err != nil {
+				return err
+			}
+			if httpTLS == nil && token != "" && !loopback(addr) {
+				return fmt.Errorf("serving %s with a token needs TLS: set --cert and --key", addr)
+			}
+			grpcTLS, err := tlsConfig(grpcCert, grpcKey, "--grpc-cert", "--grpc-key")
+			if err != nil {
+				return err
+			}
+			if grpcTLS == nil && grpcAddr != "" && token != "" && !loopback(grpcAddr) {
+				return fmt.Errorf("serving gRPC on %s with a token needs TLS: set --grpc-cert and --grpc-key", grpcAddr)
+			}
+
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			pc := g.poolConfig(cmd, sessions)
+			pc.Min = max(pc.Min, 1)
+			if pc.IdleTimeout == 0 {
+				pc.IdleTimeout = 5 * time.Minute
+			}
+			pool, err := client.NewPool(pc)
+			if err != nil {
+				return err
+			}
+			defer pool.Close()
+			var backend psbridge.Invoker = pool
+			if breaker > 0 {
+				backend = psbridge.NewBreaker(pool, psbridge.BreakerConfig{
+					Failures: breaker,
+					OnStateChange: func(from, to psbridge.BreakerState) {
+						slog.Warn("serve: circuit "+to.String(), "was", from)
+					},
+				})
+			}
+			inv := backend
+			if cacheTTL > 0 {
+				inv = psbridge.NewCache(backend, psbridge.CacheConfig{TTLs: psbridge.ProviderTTLs(cacheTTL)})
+			}
+
+			srv := &http.Server{
+				Addr:              addr,
+				Handler:           newServer(inv, token, g.timeout).routes(),
+				ReadHeaderTimeout: 10 * time.Second,
+				TLSConfig:         httpTLS,
+			}
+			var rpc *grpcserver.Server
+			var rpcListener net.Listener
+			if grpcAddr != "" {
+				rpc = grpcserver.NewServer(backend, grpcserver.Config{Client: client, Token: token, Timeout: g.timeout, TLS: grpcTLS})
+				if rpcListener, err = net.Listen("tcp", grpcAddr); err != nil {
+					return err
+				}
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdown, cancel := context.WithTimeout(context.Background(), sessionCloseTimeout)
+				defer cancel()
+				srv.Shutdown(shutdown)
+				if rpc != nil {
+					rpc.Shutdown(shutdown)
+				}
+			}()
+
+			errs := make(chan error, 2)
+			scheme, listen := "http", srv.ListenAndServe
+			if httpTLS != nil {
+				// The key pair is in TLSConfig already
+				scheme, listen = "https", func() error { return srv.ListenAndServeTLS("", "") }
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Serving on %s://%s\n", scheme, srv.Addr)
+			go func() {
+				if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+					errs <- err
+					return
+				}
+				errs <- nil
+			}()
+			if rpc != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Serving gRPC on %s\n", grpcAddr)
+				go func() { errs <- rpc.Serve(rpcListener) }()
+			}
+			// The first server to stop takes the other with it
+			err = <-errs
+			stop()
+			if rpc != nil {
+				err = cmp.Or(err, <-errs)
+			}
+			return err
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&addr, "addr", "127.0.0.1:8080", "address to listen on")
+	flags.StringVar(&cert, "cert", "", "TLS certificate file, to serve HTTPS")
+	flags.StringVar(&key, "key", "", "TLS key file for --cert")
+	flags.StringVar(&grpcAddr, "grpc", "", "also serve gRPC on this address, e.g. 127.0.0.1:50051")
+	flags.StringVar(&grpcCert, "grpc-cert", "", "TLS certificate file for --grpc")
+	flags.StringVar(&grpcKey, "grpc-key", "", "TLS key file for --grpc")
+	flags.StringVar(&token, "token", "", "bearer token requests must send (default: $"+serveTokenEnv+")")
+	flags.IntVar(&sessions, "sessions", 4, "most sessions to run at once")
+	flags.DurationVar(&cacheTTL, "cache", 0, "answer repeated queries from memory for this long (0: off)")
+	flags.IntVar(&breaker, "breaker", 0, "fail fast for 30s after this many failures of PowerShell in a row (0: off)")
+	return cmd
+}
+
+// tlsConfig serves the key pair in the files certFile and keyFile, given by
+// the flags certFlag and keyFlag, or is nil if neither is set
+func tlsConfig(certFile, keyFile, certFlag, keyFlag string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s and %s: %w", certFlag, keyFlag, err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// loopback reports whether addr only accepts local connections
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// server answers serve's endpoints from inv
+type server struct {
+	inv     psbridge.Invoker
+	token   string
+	timeout time.Duration
+}
+
+func newServer(inv psbridge.Invoker, token string, timeout time.Duration) *server {
+	return &server{inv: inv, token: token, timeout: timeout}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /providers", s.handle(s.providers))
+	mux.HandleFunc("GET /registry/{path...}", s.handle(s.registry))
+	mux.HandleFunc("GET /services", s.handle(s.services))
+	mux.HandleFunc("GET /certs", s.handle(s.certStores))
+	mux.HandleFunc("GET /certs/{location}/{store}", s.handle(s.certificates))
+	return s.authorize(mux)
+}
+
+// authorize rejects requests without the bearer token, when there is one
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSON(w, http.StatusUnauthorized, errorBody{Error: &psbridge.PSError{Message: "missing or wrong bearer token"}})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errorBody is the response to a failed request
+type errorBody struct {
+	Error *psbridge.PSError `json:"error"`
+}
+
+// badRequest is an error in the request itself rather than the call
+type badRequest struct{ error }
+
+// handle runs fn bounded by --timeout and writes what it returns
+func (s *server) handle(fn func(context.Context, *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if s.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+		v, err := fn(ctx, r)
+		if err != nil {
+			writeJSON(w, errorStatus(err), errorBody{Error: errorOutput(err)})
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// errorStatus maps a failed call to an HTTP status
+func errorStatus(err error) int {
+	var bad badRequest
+	var timeout *psbridge.TimeoutError
+	var psErr *psbridge.PSError
+	switch {
+	case errors.As(err, &bad):
+		return http.StatusBadRequest
+	case errors.As(err, &timeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, psbridge.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, psbridge.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.As(err, &psErr) && psErr.Category == "ObjectNotFound":
+		return http.StatusNotFound
+	case errors.As(err, &psErr) && psErr.Category == "PermissionDenied":
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("serve: encode response", "err", err)
+		status = http.StatusInternalServerError
+		b = []byte(`{"error":{"message":"encode response"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+// queryBool reads a boolean query parameter, def if it is absent
+func queryBool(r *http.Request, name string, def bool) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, badRequest{fmt.Errorf("bad %s %q: want true or false", name, v)}
+	}
+	return b, nil
+}
+
+func (s *server) providers(ctx context.Context, r *http.Request) (any, error) {
+	return psbridge.Providers(ctx, s.inv)
+}
+
+// registryPath turns the URL path HKLM/SOFTWARE/Microsoft into the
+// registry path HKLM:\SOFTWARE\Microsoft
+func registryPath(p string) (string, error) {
+	hive, rest, _ := strings.Cut(strings.Trim(p, "/"), "/")
+	hive = strings.TrimSuffix(hive, ":")
+	if hive == "" {
+		return "", badRequest{errors.New("no registry hive, e.g. /registry/HKLM/SOFTWARE")}
+	}
+	return hive + `:\` + strings.ReplaceAll(rest, "/", `\`), nil
+}
+
+func (s *server) registry(ctx context.Context, r *http.Request) (any, error) {
+	path, err := registryPath(r.PathValue("path"))
+	if err != nil {
+		return nil, err
+	}
+	opts := psbridge.RegistryTreeOptions{}
+	if v := r.URL.Query().Get("depth"); v != "" {
+		if opts.Depth, err = strconv.Atoi(v); err != nil {
+			return nil, badRequest{fmt.Errorf("bad depth %q", v)}
+		}
+	}
+	if opts.Values, err = queryBool(r, "values", true); err != nil {
+		return nil, err
+	}
+	return psbridge.RegistryTree(ctx, s.inv, path, opts)
+}
+
+func (s *server) services(ctx context.Context, r *http.Request) (any, error) {
+	return psbridge.Services(ctx, s.inv, nil)
+}
+
+func (s *server) certStores(ctx context.Context, r *http.Request) (any, error) {
+	return psbridge.CertStores(ctx, s.inv)
+}
+
+func (s *server) certificates(ctx context.Context, r *http.Request) (any, error) {
+	export, err := queryBool(r, "export", false)
+	if err != nil {
+		return nil, err
+	}
+	location, store := r.PathValue("location"), r.PathValue("store")
+	if strings.ContainsAny(location+store, `\:`) {
+		return nil, badRequest{fmt.Errorf("bad store %s/%s", location, store)}
+	}
+	path := `Cert:\` + location + `\` + store
+	return psbridge.Certificates(ctx, s.inv, path, psbridge.CertificateOptions{Export: export})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newServicesCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "services [NAME...]",
+		Short: "List and control Windows services",
+		Example: `  go-ps-lab2 services -o text
+  go-ps-lab2 services 'win*' --query '[?status==` + "`Running`" + `].name'
+  go-ps-lab2 services restart Spooler
+  go-ps-lab2 services wait Spooler --status Stopped --for 1m`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			services, err := psbridge.Services(ctx, client, args)
+			if err != nil {
+				return err
+			}
+			return g.print(cmd.OutOrStdout(), services, func(w io.Writer) {
+				tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tSTATUS\tSTART\tACCOUNT\tDISPLAY NAME")
+				for _, s := range services {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Status, s.StartType, s.Account, s.DisplayName)
+				}
+				tw.Flush()
+			})
+		},
+	}
+
+	control := func(use, short string, force bool, fn func(ctx context.Context, inv psbridge.Invoker, name string, force bool) (*psbridge.Service, error)) *cobra.Command {
+		var forced bool
+		cmd := &cobra.Command{
+			Use:   use + " NAME",
+			Short: short,
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return g.controlService(cmd, func(ctx context.Context, inv psbridge.Invoker) (*psbridge.Service, error) {
+					return fn(ctx, inv, args[0], forced)
+				})
+			},
+		}
+		if force {
+			cmd.Flags().BoolVar(&forced, "force", false, "stop the services that depend on it too")
+		}
+		return cmd
+	}
+	cmd.AddCommand(
+		control("start", "Start a service", false, func(ctx context.Context, inv psbridge.Invoker, name string, _ bool) (*psbridge.Service, error) {
+			return psbridge.StartService(ctx, inv, name)
+		}),
+		control("stop", "Stop a service", true, func(ctx context.Context, inv psbridge.Invoker, name string, force bool) (*psbridge.Service, error) {
+			return psbridge.StopService(ctx, inv, name, force)
+		}),
+		control("restart", "Restart a service", true, func(ctx context.Context, inv psbridge.Invoker, name string, force bool) (*psbridge.Service, error) {
+			return psbridge.RestartService(ctx, inv, name, force)
+		}),
+		newServicesSetCmd(g),
+		newServicesWaitCmd(g),
+	)
+	return cmd
+}
+
+func newServicesSetCmd(g *globals) *cobra.Command {
+	var cfg psbridge.ServiceConfig
+	var startType string
+	cmd := &cobra.Command{
+		Use:   "set NAME",
+		Short: "Change a service's display name, description or start type",
+		Example: `  go-ps-lab2 services set Spooler --start-type Manual
+  go-ps-lab2 services set MyService --description "Does things" --start-type AutomaticDelayedStart`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.StartType = psbridge.ServiceStartType(startType)
+			return g.controlService(cmd, func(ctx context.Context, inv psbridge.Invoker) (*psbridge.Service, error) {
+				return psbridge.SetService(ctx, inv, args[0], cfg)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&cfg.DisplayName, "display-name", "", "new display name")
+	cmd.Flags().StringVar(&cfg.Description, "description", "", "new description")
+	cmd.Flags().StringVar(&startType, "start-type", "", "Automatic, AutomaticDelayedStart, Manual or Disabled")
+	return cmd
+}
+
+func newServicesWaitCmd(g *globals) *cobra.Command {
+	var status string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "wait NAME",
+		Short: "Wait for a service to reach a status",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return g.controlService(cmd, func(ctx context.Context, inv psbridge.Invoker) (*psbridge.Service, error) {
+				return psbridge.WaitForService(ctx, inv, args[0], psbridge.ServiceStatus(status), timeout)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&status, "status", string(psbridge.ServiceRunning), "status to wait for, e.g. Running or Stopped")
+	cmd.Flags().DurationVar(&timeout, "for", 30*time.Second, "longest to wait (0: no limit)")
+	return cmd
+}
+
+// controlService runs fn and prints the service it leaves behind
+func (g *globals) controlService(cmd *cobra.Command, fn func(context.Context, psbridge.Invoker) (*psbridge.Service, error)) error {
+	client, err := g.client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.context()
+	defer cancel()
+	svc, err := fn(ctx, client)
+	if err != nil {
+		return err
+	}
+	return g.print(cmd.OutOrStdout(), svc, func(w io.Writer) {
+		fmt.Fprintf(w, "%s: %s (%s)\n", svc.Name, svc.Status, svc.StartType)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/spf13/cobra"
+)
+
+func newSessionCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Work with a long-lived PowerShell session",
+	}
+	cmd.AddCommand(newSessionServeCmd(g), newSessionPingCmd(g), newSessionInfoCmd(g))
+	return cmd
+}
+
+// sessionRequest is one line of input to session serve
+type sessionRequest struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newSessionServeCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Serve requests from stdin through one warm session",
+		Long: `Start one session and send it each line of stdin, a request of the form
+{"op": "echo", "data": {...}}. Each gets one line of output with its result
+or error, in order; the session closes at end of input.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session, err := g.startSession()
+			if err != nil {
+				return err
+			}
+			defer g.closeSession(session)
+
+			out := cmd.OutOrStdout()
+			scanner := bufio.NewScanner(cmd.InOrStdin())
+			scanner.Buffer(nil, 64<<20)
+			for scanner.Scan() {
+				line := scanner.Bytes()
+				if len(line) == 0 {
+					continue
+				}
+
+				var req sessionRequest
+				if err := json.Unmarshal(line, &req); err != nil {
+					if err := g.print(out, newResult("", nil, fmt.Errorf("bad request: %w", err)), func(w io.Writer) {
+						fmt.Fprintf(w, "error: bad request: %v\n", err)
+					}); err != nil {
+						return err
+					}
+					continue
+				}
+
+				ctx, cancel := g.context()
+				start := time.Now()
+				res, err := session.Do(ctx, &psbridge.Call{Op: req.Op, Data: req.Data})
+				cancel()
+
+				// Keep going after a failed call: one bad request shouldn't
+				// cost the rest of the batch
+				took := time.Since(start)
+				result := newResult(req.Op, res, err)
+				result.TookMs = millis(took)
+				if err := g.print(out, result, func(w io.Writer) {
+					if err != nil {
+						printError(w, err)
+						return
+					}
+					printResult(w, res, took)
+				}); err != nil {
+					return err
+				}
+			}
+			return scanner.Err()
+		},
+	}
+}
+
+// pingReport is the output of session ping
+type pingReport struct {
+	StartMs float64   `json:"startMs"`
+	PingsMs []float64 `json:"pingsMs"`
+}
+
+func newSessionPingCmd(g *globals) *cobra.Command {
+	var count int
+	cmd := &cobra.Command{
+		Use:   "ping",
+		Short: "Time session startup and round-trips",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			start := time.Now()
+			session, err := g.startSession()
+			if err != nil {
+				return err
+			}
+			defer g.closeSession(session)
+
+			report := pingReport{StartMs: millis(time.Since(start))}
+			for range count {
+				ctx, cancel := g.context()
+				d, err := session.Ping(ctx)
+				cancel()
+				if err != nil {
+					return err
+				}
+				report.PingsMs = append(report.PingsMs, millis(d))
+			}
+
+			return g.print(cmd.OutOrStdout(), report, func(w io.Writer) {
+				fmt.Fprintf(w, "started in %.1fms\n", report.StartMs)
+				for i, ms := range report.PingsMs {
+					fmt.Fprintf(w, "ping %d: %.3fms\n", i+1, ms)
+				}
+			})
+		},
+	}
+	cmd.Flags().IntVarP(&count, "count", "n", 3, "number of pings")
+	return cmd
+}
+
+func newSessionInfoCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "info",
+		Short: "Show the PowerShell version, platform and features a session runs on",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session, err := g.startSession()
+			if err != nil {
+				return err
+			}
+			defer g.closeSession(session)
+			info := session.HostInfo()
+			if info == nil {
+				return fmt.Errorf("the script doesn't report host info")
+			}
+			return g.print(cmd.OutOrStdout(), info, func(w io.Writer) {
+				fmt.Fprintln(w, info)
+				fmt.Fprintf(w, "platform: %s, .NET %s, language mode %s\n", info.Platform, info.CLRVersion, info.LanguageMode)
+				fmt.Fprintf(w, "protocol: %d, capabilities: %s\n", psbridge.ProtocolVersion, strings.Join(session.Capabilities(), ", "))
+				for _, f := range info.Features {
+					fmt.Fprintf(w, "feature: %s\n", f)
+				}
+				if e := info.Endpoint; e != nil {
+					fmt.Fprintf(w, "endpoint: %s on %s, language mode %s\n", e.Name, e.ComputerName, e.LanguageMode)
+					for _, c := range e.Commands {
+						fmt.Fprintf(w, "command: %s (%s)\n", c.Name, c.Type)
+					}
+				}
+			})
+		},
+	}
+}
+
+// sessionCloseTimeout is how long a session gets to finish when a command
+// ends before it is killed
+const sessionCloseTimeout = 10 * time.Second
+
+// closeSession shuts session down gracefully, killing it if it overruns
+func (g *globals) closeSession(session *psbridge.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionCloseTimeout)
+	defer cancel()
+	session.Close(ctx)
+}
+
+// startSession starts a session of the command's client, bounded by
+// --timeout and Ctrl+C as a call is
+func (g *globals) startSession() (*psbridge.Session, error) {
+	client, err := g.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.context()
+	defer cancel()
+	return client.StartSessionContext(ctx)
+}
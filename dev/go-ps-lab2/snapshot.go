@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"example.com/go-ps-lab2/psbridge/snapshot"
+	"github.com/spf13/cobra"
+)
+
+func newSnapshotCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Record provider state and diff recordings for drift",
+	}
+	cmd.AddCommand(newSnapshotTakeCmd(g), newSnapshotDiffCmd(g))
+	return cmd
+}
+
+func newSnapshotTakeCmd(g *globals) *cobra.Command {
+	var spec snapshot.Spec
+	var registry []string
+	var depth int
+	var out string
+	cmd := &cobra.Command{
+		Use:   "take",
+		Short: "Record registry subtrees, services, certificates and the environment",
+		Example: `  go-ps-lab2 snapshot take --registry HKLM:\SOFTWARE\Contoso --depth -1 --services --env --out before.json
+  go-ps-lab2 snapshot take --certs Cert:\LocalMachine\My --out certs.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, path := range registry {
+				spec.Registry = append(spec.Registry, snapshot.RegistryTree{Path: path, Depth: depth})
+			}
+			client, err := g.client()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := g.context()
+			defer cancel()
+			s, err := snapshot.Take(ctx, client, spec)
+			if err != nil {
+				return err
+			}
+
+			if out == "" {
+				return s.Save(cmd.OutOrStdout())
+			}
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			if err := s.Save(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringArrayVar(&registry, "registry", nil, "registry key to record with its subkeys (repeatable)")
+	flags.IntVar(&depth, "depth", 0, "levels of subkeys to record under each --registry key (-1: all)")
+	flags.BoolVar(&spec.Services, "services", false, "record services")
+	flags.StringArrayVar(&spec.Certificates, "certs", nil, "certificate store to record (repeatable)")
+	flags.BoolVar(&spec.Env, "env", false, "record environment variables")
+	flags.StringVar(&out, "out", "", "file to write (default: stdout)")
+	return cmd
+}
+
+func newSnapshotDiffCmd(g *globals) *cobra.Command {
+	var exitCode bool
+	cmd := &cobra.Command{
+		Use:   "diff BEFORE AFTER",
+		Short: "Show what changed between two snapshots",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			before, err := loadSnapshot(args[0])
+			if err != nil {
+				return err
+			}
+			after, err := loadSnapshot(args[1])
+			if err != nil {
+				return err
+			}
+
+			d := snapshot.Compare(before, after)
+			if err := g.print(cmd.OutOrStdout(), d, func(w io.Writer) { fmt.Fprint(w, d) }); err != nil {
+				return err
+			}
+			if exitCode && !d.Empty() {
+				return errFailed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "exit 1 if the snapshots differ")
+	return cmd
+}
+
+func loadSnapshot(path string) (*snapshot.Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := snapshot.Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"example.com/go-ps-lab2/psbridge"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/spf13/cobra"
+)
+
+const tuiHelp = "Enter open/close  Tab switch pane  / search  n next match  q quit"
+
+func newTUICmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Browse providers, the registry, certificates, services and files interactively",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session, err := g.startSession()
+			if err != nil {
+				return err
+			}
+			defer g.closeSession(session)
+			return newBrowser(session, g.timeout).run()
+		},
+	}
+}
+
+// browser is the tui: a tree of provider data, each branch loaded the
+// first time it is opened, and a pane showing the selected node
+type browser struct {
+	inv     psbridge.Invoker
+	timeout time.Duration
+
+	app    *tview.Application
+	tree   *tview.TreeView
+	detail *tview.TextView
+	status *tview.TextView
+	search *tview.InputField
+	query  string
+}
+
+// node is what a tree node refers to
+type node struct {
+	// detail is shown when the node is selected: a string as it is,
+	// anything else as indented JSON
+	detail any
+	// load fetches the node's children, nil for a leaf
+	load   func(ctx context.Context) ([]*tview.TreeNode, error)
+	loaded bool
+}
+
+func newBrowser(inv psbridge.Invoker, timeout time.Duration) *browser {
+	b := &browser{inv: inv, timeout: timeout, app: tview.NewApplication()}
+
+	root := tview.NewTreeNode("PowerShell").SetColor(tcell.ColorYellow)
+	root.AddChild(b.branch("Providers", "Get-PSProvider, with each provider's drives", b.loadProviders))
+	root.AddChild(b.branch("Registry", "The registry hives PowerShell maps to drives", b.loadHives))
+	root.AddChild(b.branch("Certificates", "The certificate stores of both store locations", b.loadCertStores))
+	root.AddChild(b.branch("Services", "Get-Service", b.loadServices))
+	root.AddChild(b.branch("Files", "The FileSystem provider's drives", b.loadFileDrives))
+	root.SetReference(&node{detail: "Select a branch and press Enter to open it.", loaded: true})
+
+	b.tree = tview.NewTreeView().SetRoot(root).SetCurrentNode(root)
+	b.tree.SetBorder(true).SetTitle(" Providers ")
+	b.tree.SetChangedFunc(b.show)
+	b.tree.SetSelectedFunc(b.toggle)
+
+	b.detail = tview.NewTextView().SetWrap(false)
+	b.detail.SetBorder(true).SetTitle(" Detail ")
+	b.status = tview.NewTextView().SetText(tuiHelp)
+	b.search = tview.NewInputField().SetLabel("/")
+	b.search.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			b.query = b.search.GetText()
+			b.next()
+		}
+		b.app.SetFocus(b.tree)
+	})
+
+	panes := tview.NewFlex().
+		AddItem(b.tree, 0, 1, true).
+		AddItem(b.detail, 0, 2, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(b.status, 1, 0, false).
+		AddItem(b.search, 1, 0, false)
+	b.app.SetRoot(layout, true).SetInputCapture(b.keys)
+	b.show(root)
+	return b
+}
+
+func (b *browser) run() error { return b.app.Run() }
+
+// keys handles the shortcuts that aren't typed into the search field
+func (b *browser) keys(ev *tcell.EventKey) *tcell.EventKey {
+	if b.app.GetFocus() == b.search {
+		return ev
+	}
+	switch {
+	case ev.Key() == tcell.KeyTab:
+		if b.app.GetFocus() == b.tree {
+			b.app.SetFocus(b.detail)
+		} else {
+			b.app.SetFocus(b.tree)
+		}
+		return nil
+	case ev.Rune() == '/':
+		b.search.SetText("")
+		b.app.SetFocus(b.search)
+		return nil
+	case ev.Rune() == 'n':
+		b.next()
+		return nil
+	case ev.Rune() == 'q', ev.Key() == tcell.KeyEscape:
+		b.app.Stop()
+		return nil
+	}
+	return ev
+}
+
+// branch is a node whose children come from load
+func (b *browser) branch(text string, detail any, load func(context.Context) ([]*tview.TreeNode, error)) *tview.TreeNode {
+	return tview.NewTreeNode(text).
+		SetColor(tcell.ColorGreen).
+		SetExpanded(false).
+		SetReference(&node{detail: detail, load: load})
+}
+
+// leaf is a node with only a detail
+func leaf(text string, detail any) *tview.TreeNode {
+	return tview.NewTreeNode(text).SetReference(&node{detail: detail, loaded: true})
+}
+
+// show puts n's detail in the detail pane
+func (b *browser) show(n *tview.TreeNode) {
+	ref, _ := n.GetReference().(*node)
+	if ref == nil {
+		return
+	}
+	text, ok := ref.detail.(string)
+	if !ok {
+		out, err := json.MarshalIndent(ref.detail, "", "  ")
+		if err != nil {
+			out = []byte(err.Error())
+		}
+		text = string(out)
+	}
+	b.detail.SetText(text).ScrollToBeginning()
+}
+
+// toggle opens or closes n, loading its children in the background the
+// first time
+func (b *browser) toggle(n *tview.TreeNode) {
+	ref, _ := n.GetReference().(*node)
+	if ref == nil {
+		return
+	}
+	if ref.loaded || ref.load == nil {
+		n.SetExpanded(!n.IsExpanded())
+		return
+	}
+
+	b.status.SetText("Loading " + n.GetText() + "...")
+	go func() {
+		ctx := context.Background()
+		if b.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, b.timeout)
+			defer cancel()
+		}
+		children, err := ref.load(ctx)
+		b.app.QueueUpdateDraw(func() {
+			if err != nil {
+				b.status.SetText("[" + n.GetText() + "] " + err.Error())
+				return
+			}
+			ref.loaded = true
+			n.SetChildren(children).SetExpanded(true)
+			b.status.SetText(tuiHelp)
+			b.show(n)
+		})
+	}()
+}
+
+// next selects the next loaded node after the current one whose text
+// contains the query, ignoring case, opening its parents
+func (b *browser) next() {
+	query := strings.ToLower(b.query)
+	if query == "" {
+		return
+	}
+	var order []*tview.TreeNode
+	parents := map[*tview.TreeNode]*tview.TreeNode{}
+	b.tree.GetRoot().Walk(func(n, parent *tview.TreeNode) bool {
+		order = append(order, n)
+		parents[n] = parent
+		return true
+	})
+
+	start := 0
+	for i, n := range order {
+		if n == b.tree.GetCurrentNode() {
+			start = i + 1
+		}
+	}
+	for i := range order {
+		n := order[(start+i)%len(order)]
+		if !strings.Contains(strings.ToLower(n.GetText()), query) {
+			continue
+		}
+		for p := parents[n]; p != nil; p = parents[p] {
+			p.SetExpanded(true)
+		}
+		b.tree.SetCurrentNode(n)
+		b.show(n)
+		b.status.SetText(tuiHelp)
+		return
+	}
+	b.status.SetText("No match for " + b.query)
+}
+
+// table renders rows under header as aligned columns
+func table(header string, rows [][]string) string {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, header)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+	return sb.String()
+}
+
+func (b *browser) loadProviders(ctx context.Context) ([]*tview.TreeNode, error) {
+	providers, err := psbridge.Providers(ctx, b.inv)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*tview.TreeNode
+	for _, p := range providers {
+		n := tview.NewTreeNode(p.Name).SetReference(&node{detail: p, loaded: true})
+		for _, d := range p.Drives {
+			n.AddChild(b.itemsBranch(d.Name+":", d.Name+`:\`, d))
+		}
+		nodes = append(nodes, n.SetExpanded(false))
+	}
+	return nodes, nil
+}
+
+// itemsBranch is a provider path whose children Get-ChildItem lists
+func (b *browser) itemsBranch(text, path string, detail any) *tview.TreeNode {
+	return b.branch(text, detail, func(ctx context.Context) ([]*tview.TreeNode, error) {
+		items, err := psbridge.ChildItems(ctx, b.inv, path, false)
+		if err != nil {
+			return nil, err
+		}
+		var nodes []*tview.TreeNode
+		for _, it := range items {
+			if it.IsContainer {
+				nodes = append(nodes, b.itemsBranch(it.Name, it.Path, it))
+			} else {
+				nodes = append(nodes, leaf(it.Name, it))
+			}
+		}
+		return nodes, nil
+	})
+}
+
+func (b *browser) loadHives(ctx context.Context) ([]*tview.TreeNode, error) {
+	return []*tview.TreeNode{
+		b.registryBranch("HKEY_CURRENT_USER", `HKCU:\`),
+		b.registryBranch("HKEY_LOCAL_MACHINE", `HKLM:\`),
+	}, nil
+}
+
+// registryBranch is a registry key, whose detail becomes its values once
+// it is opened
+func (b *browser) registryBranch(text, path string) *tview.TreeNode {
+	ref := &node{detail: path}
+	ref.load = func(ctx context.Context) ([]*tview.TreeNode, error) {
+		key, err := psbridge.RegistryTree(ctx, b.inv, path, psbridge.RegistryTreeOptions{Depth: 1, Values: true})
+		if err != nil {
+			return nil, err
+		}
+		var rows [][]string
+		for _, v := range key.Values {
+			name := v.Name
+			if name == "" {
+				name = "(Default)"
+			}
+			rows = append(rows, []string{name, v.Kind.RegType(), fmt.Sprint(v.Data())})
+		}
+		ref.detail = key.Path + "\n\n" + table("NAME\tTYPE\tDATA", rows)
+
+		var nodes []*tview.TreeNode
+		for _, sub := range key.SubKeys {
+			if sub.Error != "" {
+				nodes = append(nodes, leaf(sub.Name, sub).SetColor(tcell.ColorRed))
+				continue
+			}
+			nodes = append(nodes, b.registryBranch(sub.Name, sub.Path))
+		}
+		return nodes, nil
+	}
+	return tview.NewTreeNode(text).SetColor(tcell.ColorGreen).SetExpanded(false).SetReference(ref)
+}
+
+func (b *browser) loadCertStores(ctx context.Context) ([]*tview.TreeNode, error) {
+	stores, err := psbridge.CertStores(ctx, b.inv)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*tview.TreeNode
+	for _, s := range stores {
+		nodes = append(nodes, b.certStoreBranch(s))
+	}
+	return nodes, nil
+}
+
+// certStoreBranch is a store, whose detail becomes a table of its
+// certificates once it is opened
+func (b *browser) certStoreBranch(s psbridge.CertStore) *tview.TreeNode {
+	ref := &node{detail: s}
+	ref.load = func(ctx context.Context) ([]*tview.TreeNode, error) {
+		certs, err := psbridge.Certificates(ctx, b.inv, s.Path, psbridge.CertificateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		var rows [][]string
+		var nodes []*tview.TreeNode
+		for _, c := range certs {
+			rows = append(rows, []string{c.Thumbprint, c.NotAfter.Format(time.DateOnly), c.Subject})
+			n := leaf(c.Subject, c)
+			switch {
+			case c.Expired(now):
+				n.SetColor(tcell.ColorRed)
+			case c.ExpiresWithin(30*24*time.Hour, now):
+				n.SetColor(tcell.ColorYellow)
+			}
+			nodes = append(nodes, n)
+		}
+		ref.detail = s.Path + "\n\n" + table("THUMBPRINT\tEXPIRES\tSUBJECT", rows)
+		return nodes, nil
+	}
+	return tview.NewTreeNode(fmt.Sprintf(`%s\%s (%d)`, s.Location, s.Name, s.Count)).
+		SetColor(tcell.ColorGreen).SetExpanded(false).SetReference(ref)
+}
+
+func (b *browser) loadServices(ctx context.Context) ([]*tview.TreeNode, error) {
+	services, err := psbridge.Services(ctx, b.inv, nil)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*tview.TreeNode
+	for _, s := range services {
+		n := leaf(s.Name, s)
+		if s.Status != psbridge.ServiceRunning {
+			n.SetColor(tcell.ColorGray)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (b *browser) loadFileDrives(ctx context.Context) ([]*tview.TreeNode, error) {
+	providers, err := psbridge.Providers(ctx, b.inv)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*tview.TreeNode
+	for _, p := range providers {
+		if p.Name != "FileSystem" {
+			continue
+		}
+		for _, d := range p.Drives {
+			nodes = append(nodes, b.itemsBranch(d.Root, d.Root, d))
+		}
+	}
+	return nodes, nil
+}